@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+)
+
+// isABPRule reports whether line uses AdBlock Plus filter syntax rather than
+// hosts or plain-domain syntax.
+func isABPRule(line string) bool {
+	return strings.HasPrefix(line, "||") || strings.HasPrefix(line, "|") ||
+		strings.HasPrefix(line, "@@") || strings.HasPrefix(line, "!") ||
+		strings.HasPrefix(line, "[") || strings.Contains(line, "^") ||
+		strings.Contains(line, "##") || strings.Contains(line, "#@#") ||
+		strings.Contains(line, "#?#") || strings.Contains(line, "#$#")
+}
+
+// convertABPRule converts an AdBlock Plus network rule into blocklist patterns.
+// "||example.com^" blocks the domain and its subdomains, so it becomes
+// "example.com" and "*.example.com"; "|https://example.com/^" anchors to the
+// exact host. ok is false for comments, headers, exceptions, cosmetic rules,
+// rules with options and rules that depend on a URL path, none of which can be
+// enforced at the DNS level.
+func convertABPRule(line string) (patterns []string, ok bool) {
+	line = strings.TrimSpace(line)
+	switch {
+	case line == "", strings.HasPrefix(line, "!"), strings.HasPrefix(line, "["):
+		return nil, false
+	case strings.HasPrefix(line, "@@"):
+		return nil, false
+	case strings.Contains(line, "##"), strings.Contains(line, "#@#"),
+		strings.Contains(line, "#?#"), strings.Contains(line, "#$#"):
+		return nil, false
+	case strings.Contains(line, "$"):
+		// options ($third-party, $domain=...) narrow the rule in ways DNS can't see
+		return nil, false
+	}
+
+	subdomains := false
+	switch {
+	case strings.HasPrefix(line, "||"):
+		subdomains = true
+		line = line[2:]
+	case strings.HasPrefix(line, "|"):
+		u, err := url.Parse(strings.TrimSuffix(strings.TrimPrefix(line, "|"), "^"))
+		if err != nil || u.Host == "" || (u.Path != "" && u.Path != "/") || u.RawQuery != "" {
+			return nil, false
+		}
+		line = u.Hostname()
+	}
+
+	// the domain ends at the first separator; anything after it must be empty
+	host := line
+	if i := strings.IndexAny(line, "^/|"); i >= 0 {
+		host = line[:i]
+		if rest := strings.Trim(line[i:], "^|"); rest != "" && rest != "/" {
+			return nil, false
+		}
+	}
+	host = normalizePattern(host)
+	if !isABPHost(host) {
+		return nil, false
+	}
+	if subdomains && !strings.HasPrefix(host, "*.") {
+		return []string{host, "*." + host}, true
+	}
+	return []string{host}, true
+}
+
+// isABPHost reports whether h is a usable hostname (optionally with '*' wildcards)
+func isABPHost(h string) bool {
+	if h == "" || strings.Trim(h, "*.") == "" {
+		return false
+	}
+	for _, r := range h {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '-', r == '.', r == '*', r == '_':
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestConvertABPRule(t *testing.T) {
+	tests := []struct {
+		rule string
+		want []string // nil means skipped
+	}{
+		{"||ads.example.com^", []string{"ads.example.com", "*.ads.example.com"}},
+		{"||Ads.Example.com^|", []string{"ads.example.com", "*.ads.example.com"}},
+		{"||ads.example.com", []string{"ads.example.com", "*.ads.example.com"}},
+		{"||ads.example.com/", []string{"ads.example.com", "*.ads.example.com"}},
+		{"||*.cdn.example.com^", []string{"*.cdn.example.com"}},
+		{"|https://track.example.com/^", []string{"track.example.com"}},
+		{"|http://track.example.com:8080^", []string{"track.example.com"}},
+		{"||ads.example.com^$third-party", nil},
+		{"||ads.example.com/banner.js", nil},
+		{"|https://example.com/ads/^", nil},
+		{"@@||good.example.com^", nil},
+		{"example.com##.banner", nil},
+		{"example.com#@#.banner", nil},
+		{"! comment", nil},
+		{"[Adblock Plus 2.0]", nil},
+		{"||^", nil},
+		{"||*^", nil},
+		{"||bad host.example^", nil},
+	}
+	for _, tt := range tests {
+		got, ok := convertABPRule(tt.rule)
+		if ok != (tt.want != nil) || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("convertABPRule(%q) = %q, %v; want %q", tt.rule, got, ok, tt.want)
+		}
+	}
+}
+
+func TestABPRulesMatch(t *testing.T) {
+	list := strings.Join([]string{
+		"[Adblock Plus 2.0]",
+		"! Title: test list",
+		"||ads.example.com^",
+		"|https://track.example.net/^",
+		"||cosmetic.example^$third-party",
+		"@@||allowed.example.com^",
+		"example.org##.banner",
+		"plain.example",
+	}, "\n")
+	domains, skipped, err := parseListLines(strings.NewReader(list))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the option rule, the exception and the cosmetic rule
+	if skipped != 3 {
+		t.Errorf("skipped %d rules, want 3", skipped)
+	}
+	bm := newTestBlocklistManager(t, map[string][]string{"abp": domains})
+
+	tests := []struct {
+		domain  string
+		blocked bool
+	}{
+		{"ads.example.com", true},
+		{"x.ads.example.com", true},
+		{"a.b.ads.example.com", true},
+		{"badads.example.com", false},
+		{"example.com", false},
+		{"track.example.net", true},
+		{"sub.track.example.net", false},
+		{"cosmetic.example", false},
+		{"allowed.example.com", false},
+		{"example.org", false},
+		{"plain.example", true},
+	}
+	for _, tt := range tests {
+		if got := bm.IsBlocked(tt.domain); got != tt.blocked {
+			t.Errorf("IsBlocked(%q) = %v, want %v", tt.domain, got, tt.blocked)
+		}
+	}
+}
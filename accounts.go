@@ -2,15 +2,20 @@ package main
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"database/sql"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"log"
+	"log/slog"
 	"os"
 	"path/filepath"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
+	"unicode"
 
 	"golang.org/x/crypto/bcrypt"
 	_ "modernc.org/sqlite"
@@ -21,6 +26,8 @@ type AccountManager struct {
 	db       *sql.DB
 	mu       sync.RWMutex
 	sessions map[string]*Session // sessionID -> Session
+
+	localRecords atomic.Pointer[localRecordSet] // snapshot for the DNS handler
 }
 
 // Account represents a user account identified by MAC address
@@ -32,6 +39,15 @@ type Account struct {
 	UpdatedAt    time.Time
 }
 
+// AccountSummary is an account as shown to administrators (no passcode hash)
+type AccountSummary struct {
+	MACAddress     string    `json:"mac_address"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+	BlocklistCount int       `json:"blocklist_count"`
+	ActiveSession  bool      `json:"active_session"`
+}
+
 // Session represents an active user session
 type Session struct {
 	ID         string
@@ -41,6 +57,16 @@ type Session struct {
 	ExpiresAt  time.Time
 }
 
+// ErrSessionNotFound is returned when a session to revoke doesn't exist or
+// belongs to another device
+var ErrSessionNotFound = errors.New("session not found")
+
+// ErrWeakPasscode is returned when a new passcode doesn't meet the configured strength rules
+var ErrWeakPasscode = errors.New("passcode does not meet strength requirements")
+
+// defaultSessionTTL is the session lifetime used when none is configured
+const defaultSessionTTL = 24 * time.Hour
+
 // NewAccountManager initializes the account database and manager
 func NewAccountManager(dataDir string) (*AccountManager, error) {
 	// Ensure the data directory exists so SQLite can create the DB file
@@ -49,11 +75,23 @@ func NewAccountManager(dataDir string) (*AccountManager, error) {
 	}
 
 	dbPath := filepath.Join(dataDir, "accounts.db")
-	db, err := sql.Open("sqlite", dbPath)
+	// busy_timeout makes SQLite wait for locks instead of failing immediately and
+	// WAL lets readers proceed while a write is in progress. foreign_keys is off by
+	// default in SQLite and is per-connection, so it must be set here for the
+	// ON DELETE CASCADE clauses to fire. Pragmas in the DSN apply to every pooled
+	// connection.
+	dsn := dbPath + "?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_pragma=foreign_keys(1)"
+	db, err := sql.Open("sqlite", dsn)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open accounts database: %w", err)
 	}
 
+	var fk int
+	if err := db.QueryRow("PRAGMA foreign_keys").Scan(&fk); err != nil || fk != 1 {
+		db.Close()
+		return nil, fmt.Errorf("failed to enable foreign key enforcement (foreign_keys=%d): %v", fk, err)
+	}
+
 	// Create tables
 	schema := `
 	CREATE TABLE IF NOT EXISTS accounts (
@@ -76,9 +114,31 @@ func NewAccountManager(dataDir string) (*AccountManager, error) {
 	);
 	
 	CREATE INDEX IF NOT EXISTS idx_user_blocklists_mac ON user_blocklists(mac_address);
+	
+	CREATE TABLE IF NOT EXISTS user_settings (
+		mac_address TEXT PRIMARY KEY,
+		safe_search INTEGER,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (mac_address) REFERENCES accounts(mac_address) ON DELETE CASCADE
+	);
+	
+	CREATE TABLE IF NOT EXISTS device_names (
+		mac_address TEXT PRIMARY KEY,
+		label TEXT NOT NULL,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS local_records (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		type TEXT NOT NULL,
+		value TEXT NOT NULL,
+		ttl INTEGER NOT NULL,
+		UNIQUE(name, type, value)
+	);
 	`
 
-	if _, err := db.Exec(schema); err != nil {
+	if err := retryBusy(func() error { _, err := db.Exec(schema); return err }); err != nil {
 		db.Close()
 		return nil, fmt.Errorf("failed to create schema: %w", err)
 	}
@@ -88,6 +148,11 @@ func NewAccountManager(dataDir string) (*AccountManager, error) {
 		sessions: make(map[string]*Session),
 	}
 
+	if err := am.refreshLocalRecords(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to load local records: %w", err)
+	}
+
 	// Clean up expired sessions periodically
 	go am.cleanupSessions()
 
@@ -106,14 +171,18 @@ func (am *AccountManager) CreateAccount(macAddress, passcode string) error {
 		return errors.New("MAC address and passcode are required")
 	}
 
+	if err := validatePasscode(passcode); err != nil {
+		return err
+	}
+
 	// Hash the passcode
-	hash, err := bcrypt.GenerateFromPassword([]byte(passcode), bcrypt.DefaultCost)
+	hash, err := bcrypt.GenerateFromPassword([]byte(passcode), bcryptCost())
 	if err != nil {
 		return fmt.Errorf("failed to hash passcode: %w", err)
 	}
 
 	// Insert into database
-	_, err = am.db.Exec(
+	_, err = am.exec(
 		"INSERT INTO accounts (mac_address, passcode_hash) VALUES (?, ?)",
 		macAddress, string(hash),
 	)
@@ -132,7 +201,7 @@ func (am *AccountManager) Authenticate(macAddress, passcode string) (*Session, e
 	}
 
 	var passcodeHash string
-	err := am.db.QueryRow(
+	err := am.queryRow(
 		"SELECT passcode_hash FROM accounts WHERE mac_address = ?",
 		macAddress,
 	).Scan(&passcodeHash)
@@ -170,16 +239,84 @@ func (am *AccountManager) createSession(macAddress string, isGuest bool) *Sessio
 		MACAddress: macAddress,
 		IsGuest:    isGuest,
 		CreatedAt:  time.Now(),
-		ExpiresAt:  time.Now().Add(24 * time.Hour), // 24 hour sessions
+		ExpiresAt:  time.Now().Add(AppConfig().SessionDuration(isGuest)),
 	}
 
 	am.mu.Lock()
+	if !isGuest && AppConfig().SingleSessionPerMAC {
+		// guest sessions need no passcode, so only a login may end the others
+		for id, s := range am.sessions {
+			if s.MACAddress == macAddress {
+				delete(am.sessions, id)
+			}
+		}
+	}
 	am.sessions[sessionID] = session
 	am.mu.Unlock()
 
 	return session
 }
 
+// SessionInfo describes a session to its owner. Ref identifies it for
+// revocation without revealing the session ID itself.
+type SessionInfo struct {
+	Ref       string    `json:"ref"`
+	IsGuest   bool      `json:"is_guest"`
+	Current   bool      `json:"current"` // the session making the request
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// sessionRef is the public handle of a session ID
+func sessionRef(sessionID string) string {
+	sum := sha256.Sum256([]byte(sessionID))
+	return hex.EncodeToString(sum[:8])
+}
+
+// SessionsFor lists the unexpired sessions of macAddress, oldest first.
+// currentID marks the caller's own session.
+func (am *AccountManager) SessionsFor(macAddress, currentID string) []SessionInfo {
+	am.mu.RLock()
+	defer am.mu.RUnlock()
+
+	now := time.Now()
+	out := make([]SessionInfo, 0)
+	for id, s := range am.sessions {
+		if s.MACAddress != macAddress || now.After(s.ExpiresAt) {
+			continue
+		}
+		out = append(out, SessionInfo{
+			Ref:       sessionRef(id),
+			IsGuest:   s.IsGuest,
+			Current:   id == currentID,
+			CreatedAt: s.CreatedAt,
+			ExpiresAt: s.ExpiresAt,
+		})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if !out[i].CreatedAt.Equal(out[j].CreatedAt) {
+			return out[i].CreatedAt.Before(out[j].CreatedAt)
+		}
+		return out[i].Ref < out[j].Ref
+	})
+	return out
+}
+
+// RevokeSession ends the session of macAddress with the given ref
+func (am *AccountManager) RevokeSession(macAddress, ref string) error {
+	am.mu.Lock()
+	defer am.mu.Unlock()
+
+	for id, s := range am.sessions {
+		if s.MACAddress == macAddress && sessionRef(id) == ref {
+			delete(am.sessions, id)
+			log.Printf("Revoked session %s for MAC: %s", ref, macAddress)
+			return nil
+		}
+	}
+	return ErrSessionNotFound
+}
+
 // GetSession retrieves a session by ID
 func (am *AccountManager) GetSession(sessionID string) (*Session, error) {
 	am.mu.RLock()
@@ -197,6 +334,35 @@ func (am *AccountManager) GetSession(sessionID string) (*Session, error) {
 	return session, nil
 }
 
+// RefreshSession extends a still-valid session by the session lifetime. When
+// rotate is true the session is re-issued under a new ID and the old ID stops working.
+func (am *AccountManager) RefreshSession(sessionID string, rotate bool) (*Session, error) {
+	am.mu.Lock()
+	defer am.mu.Unlock()
+
+	session, ok := am.sessions[sessionID]
+	if !ok {
+		return nil, errors.New("session not found")
+	}
+
+	now := time.Now()
+	if now.After(session.ExpiresAt) {
+		delete(am.sessions, sessionID)
+		return nil, errors.New("session expired")
+	}
+
+	refreshed := *session
+	refreshed.ExpiresAt = now.Add(AppConfig().SessionDuration(refreshed.IsGuest))
+	if rotate {
+		refreshed.ID = generateSessionID()
+		delete(am.sessions, sessionID)
+	}
+	am.sessions[refreshed.ID] = &refreshed
+
+	log.Printf("Refreshed session for MAC: %s (rotated=%t)", refreshed.MACAddress, rotate)
+	return &refreshed, nil
+}
+
 // InvalidateSession removes a session
 func (am *AccountManager) InvalidateSession(sessionID string) {
 	am.mu.Lock()
@@ -205,10 +371,66 @@ func (am *AccountManager) InvalidateSession(sessionID string) {
 	log.Printf("Invalidated session: %s", sessionID)
 }
 
+// IsAdmin reports whether a session belongs to a configured administrator
+func (am *AccountManager) IsAdmin(session *Session) bool {
+	return session != nil && !session.IsGuest && AppConfig().IsAdminMAC(session.MACAddress)
+}
+
+// ListAccounts returns a page of accounts ordered by MAC address, along with
+// the total number of accounts
+func (am *AccountManager) ListAccounts(offset, limit int) ([]AccountSummary, int, error) {
+	var total int
+	if err := am.queryRow("SELECT COUNT(*) FROM accounts").Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("failed to count accounts: %w", err)
+	}
+
+	rows, err := am.query(
+		`SELECT a.mac_address, a.created_at, a.updated_at, COUNT(ub.id)
+		FROM accounts a
+		LEFT JOIN user_blocklists ub ON ub.mac_address = a.mac_address
+		GROUP BY a.id
+		ORDER BY a.mac_address
+		LIMIT ? OFFSET ?`,
+		limit, offset,
+	)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to list accounts: %w", err)
+	}
+	defer rows.Close()
+
+	accounts := []AccountSummary{}
+	for rows.Next() {
+		var a AccountSummary
+		if err := rows.Scan(&a.MACAddress, &a.CreatedAt, &a.UpdatedAt, &a.BlocklistCount); err != nil {
+			return nil, 0, err
+		}
+		accounts = append(accounts, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	// mark accounts that currently hold an unexpired session
+	now := time.Now()
+	active := make(map[string]bool)
+	am.mu.RLock()
+	for _, session := range am.sessions {
+		if !session.IsGuest && now.Before(session.ExpiresAt) {
+			active[session.MACAddress] = true
+		}
+	}
+	am.mu.RUnlock()
+	for i := range accounts {
+		accounts[i].ActiveSession = active[accounts[i].MACAddress]
+	}
+
+	return accounts, total, nil
+}
+
 // AccountExists checks if an account exists for a MAC address
 func (am *AccountManager) AccountExists(macAddress string) (bool, error) {
 	var exists int
-	err := am.db.QueryRow(
+	err := am.queryRow(
 		"SELECT COUNT(*) FROM accounts WHERE mac_address = ?",
 		macAddress,
 	).Scan(&exists)
@@ -220,7 +442,7 @@ func (am *AccountManager) AccountExists(macAddress string) (bool, error) {
 
 // AddUserBlocklist associates a blocklist with a user
 func (am *AccountManager) AddUserBlocklist(macAddress, listName string) error {
-	_, err := am.db.Exec(
+	_, err := am.exec(
 		"INSERT OR IGNORE INTO user_blocklists (mac_address, list_name) VALUES (?, ?)",
 		macAddress, listName,
 	)
@@ -233,7 +455,7 @@ func (am *AccountManager) AddUserBlocklist(macAddress, listName string) error {
 
 // RemoveUserBlocklist removes a blocklist association from a user
 func (am *AccountManager) RemoveUserBlocklist(macAddress, listName string) error {
-	_, err := am.db.Exec(
+	_, err := am.exec(
 		"DELETE FROM user_blocklists WHERE mac_address = ? AND list_name = ?",
 		macAddress, listName,
 	)
@@ -244,9 +466,22 @@ func (am *AccountManager) RemoveUserBlocklist(macAddress, listName string) error
 	return nil
 }
 
+// RenameUserBlocklist points a user's blocklist association at a renamed list
+func (am *AccountManager) RenameUserBlocklist(macAddress, oldName, newName string) error {
+	_, err := am.exec(
+		"UPDATE user_blocklists SET list_name = ? WHERE mac_address = ? AND list_name = ?",
+		newName, macAddress, oldName,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to rename user blocklist: %w", err)
+	}
+	log.Printf("Renamed blocklist %s to %s for user %s", oldName, newName, macAddress)
+	return nil
+}
+
 // GetUserBlocklists returns all blocklists for a user
 func (am *AccountManager) GetUserBlocklists(macAddress string) ([]string, error) {
-	rows, err := am.db.Query(
+	rows, err := am.query(
 		"SELECT list_name FROM user_blocklists WHERE mac_address = ? ORDER BY list_name",
 		macAddress,
 	)
@@ -267,6 +502,69 @@ func (am *AccountManager) GetUserBlocklists(macAddress string) ([]string, error)
 	return lists, rows.Err()
 }
 
+// SetSafeSearch stores a user's safe-search preference
+func (am *AccountManager) SetSafeSearch(macAddress string, enabled bool) error {
+	_, err := am.exec(
+		`INSERT INTO user_settings (mac_address, safe_search, updated_at) VALUES (?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(mac_address) DO UPDATE SET safe_search = excluded.safe_search, updated_at = CURRENT_TIMESTAMP`,
+		macAddress, enabled,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to set safe search: %w", err)
+	}
+	log.Printf("Set safe search=%t for user %s", enabled, macAddress)
+	return nil
+}
+
+// GetSafeSearch returns a user's safe-search preference. ok is false when the
+// user has never set one, so callers can fall back to the configured default.
+func (am *AccountManager) GetSafeSearch(macAddress string) (enabled bool, ok bool, err error) {
+	var v sql.NullBool
+	err = am.queryRow(
+		"SELECT safe_search FROM user_settings WHERE mac_address = ?",
+		macAddress,
+	).Scan(&v)
+	if err == sql.ErrNoRows {
+		return false, false, nil
+	}
+	if err != nil {
+		return false, false, fmt.Errorf("database error: %w", err)
+	}
+	return v.Bool, v.Valid, nil
+}
+
+// UserBlocklist is a single user-to-blocklist association
+type UserBlocklist struct {
+	MACAddress string `json:"mac_address"`
+	ListName   string `json:"list_name"`
+	HasAccount bool   `json:"has_account"`
+}
+
+// AllUserBlocklists returns every user-to-blocklist association, noting whether
+// the owning account still exists
+func (am *AccountManager) AllUserBlocklists() ([]UserBlocklist, error) {
+	rows, err := am.query(
+		`SELECT ub.mac_address, ub.list_name, a.id IS NOT NULL
+		FROM user_blocklists ub
+		LEFT JOIN accounts a ON a.mac_address = ub.mac_address
+		ORDER BY ub.mac_address, ub.list_name`,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user blocklists: %w", err)
+	}
+	defer rows.Close()
+
+	var assocs []UserBlocklist
+	for rows.Next() {
+		var ub UserBlocklist
+		if err := rows.Scan(&ub.MACAddress, &ub.ListName, &ub.HasAccount); err != nil {
+			return nil, err
+		}
+		assocs = append(assocs, ub)
+	}
+	return assocs, rows.Err()
+}
+
 // cleanupSessions periodically removes expired sessions
 func (am *AccountManager) cleanupSessions() {
 	ticker := time.NewTicker(1 * time.Hour)
@@ -290,7 +588,7 @@ func generateSessionID() string {
 	b := make([]byte, 32)
 	if _, err := rand.Read(b); err != nil {
 		// If random generation fails, use a combination of timestamp and fallback random
-		log.Printf("Warning: crypto/rand failed, using fallback: %v", err)
+		slog.Warn("crypto/rand failed, using fallback session ID", "err", err)
 		timestamp := time.Now().UnixNano()
 		return fmt.Sprintf("%d%d", timestamp, timestamp%1000000)
 	}
@@ -303,9 +601,13 @@ func (am *AccountManager) ChangePasscode(macAddress, oldPasscode, newPasscode st
 		return errors.New("all fields are required")
 	}
 
+	if err := validatePasscode(newPasscode); err != nil {
+		return err
+	}
+
 	// Verify old passcode first
 	var currentHash string
-	err := am.db.QueryRow(
+	err := am.queryRow(
 		"SELECT passcode_hash FROM accounts WHERE mac_address = ?",
 		macAddress,
 	).Scan(&currentHash)
@@ -322,13 +624,13 @@ func (am *AccountManager) ChangePasscode(macAddress, oldPasscode, newPasscode st
 	}
 
 	// Hash new passcode
-	newHash, err := bcrypt.GenerateFromPassword([]byte(newPasscode), bcrypt.DefaultCost)
+	newHash, err := bcrypt.GenerateFromPassword([]byte(newPasscode), bcryptCost())
 	if err != nil {
 		return fmt.Errorf("failed to hash new passcode: %w", err)
 	}
 
 	// Update database
-	_, err = am.db.Exec(
+	_, err = am.exec(
 		"UPDATE accounts SET passcode_hash = ?, updated_at = CURRENT_TIMESTAMP WHERE mac_address = ?",
 		string(newHash), macAddress,
 	)
@@ -339,3 +641,43 @@ func (am *AccountManager) ChangePasscode(macAddress, oldPasscode, newPasscode st
 	log.Printf("Changed passcode for MAC: %s", macAddress)
 	return nil
 }
+
+// validatePasscode checks a new passcode against the configured length and
+// character-class requirements
+func validatePasscode(passcode string) error {
+	if n := AppConfig().PasscodeMinLength; len([]rune(passcode)) < n {
+		return fmt.Errorf("%w: must be at least %d characters", ErrWeakPasscode, n)
+	}
+
+	var lower, upper, digit, symbol bool
+	for _, r := range passcode {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+	classes := 0
+	for _, ok := range []bool{lower, upper, digit, symbol} {
+		if ok {
+			classes++
+		}
+	}
+	if n := AppConfig().PasscodeMinClasses; classes < n {
+		return fmt.Errorf("%w: must mix at least %d of lowercase, uppercase, digits and symbols", ErrWeakPasscode, n)
+	}
+	return nil
+}
+
+// bcryptCost returns the configured bcrypt cost, or the library default when unset
+func bcryptCost() int {
+	if c := AppConfig().BcryptCost; c >= bcrypt.MinCost && c <= bcrypt.MaxCost {
+		return c
+	}
+	return bcrypt.DefaultCost
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testPasscode passes the default strength rules
+const testPasscode = "Correct-Horse-42"
+
+// newTestAccountManager opens a throwaway account database holding an account
+// for each of macs
+func newTestAccountManager(t *testing.T, macs ...string) *AccountManager {
+	t.Helper()
+	am, err := NewAccountManager(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { am.Close() })
+	for _, mac := range macs {
+		if err := am.CreateAccount(mac, testPasscode); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return am
+}
+
+func TestRefreshSession(t *testing.T) {
+	const mac = "aa:bb:cc:00:00:01"
+	tests := []struct {
+		name    string
+		guest   bool
+		expired bool
+		rotate  bool
+		wantErr bool
+	}{
+		{"extends a live session", false, false, false, false},
+		{"extends a guest session", true, false, false, false},
+		{"rotates the ID", false, false, true, false},
+		{"refuses an expired session", false, true, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withConfig(t, func(c *Config) { c.SessionTTL, c.GuestSessionTTL = "2h", "30m" })
+			am := newTestAccountManager(t)
+			s := am.createSession(mac, tt.guest)
+			old := s.ID
+			am.mu.Lock()
+			if tt.expired {
+				s.ExpiresAt = time.Now().Add(-time.Minute)
+			} else {
+				s.ExpiresAt = time.Now().Add(time.Minute)
+			}
+			am.mu.Unlock()
+
+			got, err := am.RefreshSession(old, tt.rotate)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("refreshed an expired session")
+				}
+				if _, err := am.GetSession(old); err == nil {
+					t.Error("expired session still usable")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			ttl := AppConfig().SessionDuration(tt.guest)
+			if left := time.Until(got.ExpiresAt); left < ttl-time.Minute || left > ttl {
+				t.Errorf("session now expires in %v, want about %v", left, ttl)
+			}
+			if _, err := am.GetSession(got.ID); err != nil {
+				t.Errorf("refreshed session not usable: %v", err)
+			}
+			if rotated := got.ID != old; rotated != tt.rotate {
+				t.Errorf("ID rotated = %t, want %t", rotated, tt.rotate)
+			}
+			if _, err := am.GetSession(old); tt.rotate && err == nil {
+				t.Error("old ID still works after rotation")
+			}
+		})
+	}
+}
+
+func TestValidatePasscode(t *testing.T) {
+	tests := []struct {
+		minLen, minClasses int
+		passcode           string
+		ok                 bool
+	}{
+		{4, 1, "1234", true},
+		{4, 1, "123", false},
+		{8, 1, "abcdefgh", true},
+		{8, 3, "abcdefgh", false},
+		{8, 3, "abcdEFGH", false},
+		{8, 3, "abcdEF12", true},
+		{8, 4, "abcdEF12", false},
+		{8, 4, "abcdEF1!", true},
+		{4, 2, "äöü1", true},  // runes, not bytes, count toward the length
+		{5, 1, "äöüß", false}, // 8 bytes but 4 characters
+	}
+	for _, tt := range tests {
+		withConfig(t, func(c *Config) { c.PasscodeMinLength, c.PasscodeMinClasses = tt.minLen, tt.minClasses })
+		err := validatePasscode(tt.passcode)
+		if tt.ok && err != nil {
+			t.Errorf("validatePasscode(%q) with min %d/%d = %v, want ok", tt.passcode, tt.minLen, tt.minClasses, err)
+		}
+		if !tt.ok && !errors.Is(err, ErrWeakPasscode) {
+			t.Errorf("validatePasscode(%q) with min %d/%d = %v, want ErrWeakPasscode", tt.passcode, tt.minLen, tt.minClasses, err)
+		}
+	}
+}
+
+func TestBcryptCostIsConfigurable(t *testing.T) {
+	tests := []struct {
+		cost, want int
+	}{
+		{bcrypt.MinCost, bcrypt.MinCost},
+		{12, 12},
+		{0, bcrypt.DefaultCost},
+	}
+	for _, tt := range tests {
+		withConfig(t, func(c *Config) { c.BcryptCost = tt.cost })
+		am := newTestAccountManager(t)
+		if err := am.CreateAccount("aa:bb:cc:00:00:02", testPasscode); err != nil {
+			t.Fatal(err)
+		}
+		var hash string
+		if err := am.db.QueryRow("SELECT passcode_hash FROM accounts WHERE mac_address = ?", "aa:bb:cc:00:00:02").Scan(&hash); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := bcrypt.Cost([]byte(hash)); err != nil || got != tt.want {
+			t.Errorf("bcrypt_cost %d: hashed at cost %d (%v), want %d", tt.cost, got, err, tt.want)
+		}
+	}
+}
+
+func TestForeignKeysCascade(t *testing.T) {
+	const mac = "aa:bb:cc:00:00:03"
+	am := newTestAccountManager(t, mac)
+
+	// the pragma is per connection, so check several pooled ones at once
+	ctx := context.Background()
+	for i := 0; i < 4; i++ {
+		conn, err := am.db.Conn(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		var fk int
+		if err := conn.QueryRowContext(ctx, "PRAGMA foreign_keys").Scan(&fk); err != nil || fk != 1 {
+			t.Fatalf("connection %d: foreign_keys = %d (%v), want 1", i, fk, err)
+		}
+	}
+
+	if err := am.AddUserBlocklist(mac, mac+"_ads"); err != nil {
+		t.Fatal(err)
+	}
+	if err := am.SetSafeSearch(mac, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := am.AddUserBlocklist("aa:bb:cc:00:00:99", "orphan"); err == nil {
+		t.Error("associated a list with an account that doesn't exist")
+	}
+	if _, err := am.exec("DELETE FROM accounts WHERE mac_address = ?", mac); err != nil {
+		t.Fatal(err)
+	}
+	for _, table := range []string{"user_blocklists", "user_settings"} {
+		var n int
+		if err := am.queryRow("SELECT COUNT(*) FROM "+table+" WHERE mac_address = ?", mac).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		if n != 0 {
+			t.Errorf("%s still has %d rows for the deleted account", table, n)
+		}
+	}
+}
+
+func TestSingleSessionPerMAC(t *testing.T) {
+	const mac, other = "aa:bb:cc:00:11:58", "aa:bb:cc:00:11:59"
+	tests := []struct {
+		name     string
+		single   bool
+		guest    bool // the new session is a guest one
+		wantKept int  // of the device's two earlier sessions
+	}{
+		{"off", false, false, 2},
+		{"login ends the others", true, false, 0},
+		{"guest ends nothing", true, true, 2},
+	}
+	for _, tt := range tests {
+		withConfig(t, func(c *Config) { c.SingleSessionPerMAC = tt.single })
+		am := newTestAccountManager(t, mac, other)
+		earlier := []string{am.createSession(mac, false).ID, am.CreateGuestSession(mac).ID}
+		otherID := am.createSession(other, false).ID
+		current := am.createSession(mac, tt.guest).ID
+
+		kept := 0
+		for _, id := range earlier {
+			if _, err := am.GetSession(id); err == nil {
+				kept++
+			}
+		}
+		if kept != tt.wantKept {
+			t.Errorf("%s: %d earlier sessions kept, want %d", tt.name, kept, tt.wantKept)
+		}
+		for _, id := range []string{current, otherID} {
+			if _, err := am.GetSession(id); err != nil {
+				t.Errorf("%s: session %s ended: %v", tt.name, id, err)
+			}
+		}
+		if got := len(am.SessionsFor(mac, current)); got != tt.wantKept+1 {
+			t.Errorf("%s: SessionsFor lists %d sessions, want %d", tt.name, got, tt.wantKept+1)
+		}
+	}
+}
+
+func TestRevokeSession(t *testing.T) {
+	const mac, other = "aa:bb:cc:00:11:60", "aa:bb:cc:00:11:61"
+	am := newTestAccountManager(t, mac, other)
+	first, second := am.createSession(mac, false).ID, am.createSession(mac, false).ID
+	theirs := am.createSession(other, false).ID
+
+	tests := []struct {
+		name, mac, ref string
+		wantErr        error
+	}{
+		{"unknown ref", mac, "0123456789abcdef", ErrSessionNotFound},
+		{"another device's session", mac, sessionRef(theirs), ErrSessionNotFound},
+		{"own session", mac, sessionRef(first), nil},
+		{"already revoked", mac, sessionRef(first), ErrSessionNotFound},
+	}
+	for _, tt := range tests {
+		if err := am.RevokeSession(tt.mac, tt.ref); !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: RevokeSession = %v, want %v", tt.name, err, tt.wantErr)
+		}
+	}
+	got := am.SessionsFor(mac, second)
+	if len(got) != 1 || got[0].Ref != sessionRef(second) || !got[0].Current {
+		t.Errorf("SessionsFor = %+v, want only the second session, marked current", got)
+	}
+	if _, err := am.GetSession(theirs); err != nil {
+		t.Errorf("the other device's session ended: %v", err)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// defaultAllowedClients are the ranges allowed to query DNS when AllowedClients is unset
+var defaultAllowedClients = []string{
+	"127.0.0.0/8",
+	"10.0.0.0/8",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"169.254.0.0/16",
+	"::1/128",
+	"fc00::/7",
+	"fe80::/10",
+}
+
+// Values of AppConfig.RefusedResponse
+const (
+	refusedReply = "refuse"
+	refusedDrop  = "drop"
+)
+
+// clientACL decides which client addresses may use the resolver
+type clientACL struct {
+	nets []*net.IPNet
+}
+
+// defaultTrustedProxies are trusted when TrustedProxies is unset: the bundled
+// web proxy runs on the same host
+var defaultTrustedProxies = []string{
+	"127.0.0.0/8",
+	"::1/128",
+}
+
+// newClientACL parses cidrs (or the private defaults when empty). Bare IPs are
+// accepted as single-host ranges.
+func newClientACL(cidrs []string) (*clientACL, error) {
+	if len(cidrs) == 0 {
+		cidrs = defaultAllowedClients
+	}
+	return parseIPRanges(cidrs)
+}
+
+// newTrustedProxies parses the proxies whose forwarded headers are believed
+// (or loopback when empty), in the same forms as newClientACL
+func newTrustedProxies(cidrs []string) (*clientACL, error) {
+	if len(cidrs) == 0 {
+		cidrs = defaultTrustedProxies
+	}
+	return parseIPRanges(cidrs)
+}
+
+// parseIPRanges parses CIDRs and bare IPs into a clientACL
+func parseIPRanges(cidrs []string) (*clientACL, error) {
+	acl := &clientACL{}
+	for _, c := range cidrs {
+		c = strings.TrimSpace(c)
+		if !strings.Contains(c, "/") {
+			ip := net.ParseIP(c)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid client range %q", c)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 32
+			}
+			acl.nets = append(acl.nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			return nil, fmt.Errorf("invalid client range %q: %w", c, err)
+		}
+		acl.nets = append(acl.nets, n)
+	}
+	return acl, nil
+}
+
+// Allows reports whether ip (a bare address, no port) is inside an allowed range
+func (a *clientACL) Allows(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, n := range a.nets {
+		if n.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,46 @@
+package main
+
+import "github.com/miekg/dns"
+
+// Values of AppConfig.AnyQueries
+const (
+	anyHINFO   = "hinfo"
+	anyNotImp  = "notimp"
+	anyForward = "forward"
+)
+
+// anyQueryPolicy returns how ANY queries are answered. Unset, it is "hinfo"
+// unless the DNS server only listens on loopback, where amplification isn't a
+// concern and ANY is forwarded as before.
+func anyQueryPolicy(cfg *Config) string {
+	if cfg.AnyQueries != "" {
+		return cfg.AnyQueries
+	}
+	if isLoopbackBind(cfg.DNSBind) {
+		return anyForward
+	}
+	return anyHINFO
+}
+
+// answerANY answers an ANY question without going upstream, which would let
+// the server be used to amplify traffic. Per RFC 8482 it either synthesizes a
+// single small HINFO record or replies NOTIMP. It reports false when ANY
+// queries should be handled like any other type.
+func answerANY(cfg *Config, q dns.Question, msg *dns.Msg) bool {
+	if q.Qtype != dns.TypeANY {
+		return false
+	}
+	switch anyQueryPolicy(cfg) {
+	case anyHINFO:
+		msg.Answer = append(msg.Answer, &dns.HINFO{
+			Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeHINFO, Class: dns.ClassINET, Ttl: 3600},
+			Cpu: "RFC8482",
+			Os:  "",
+		})
+		return true
+	case anyNotImp:
+		msg.Rcode = dns.RcodeNotImplemented
+		return true
+	}
+	return false
+}
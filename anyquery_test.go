@@ -0,0 +1,77 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestAnyQueryPolicy(t *testing.T) {
+	tests := []struct {
+		configured, bind string
+		want             string
+	}{
+		{"", "0.0.0.0:53", anyHINFO},
+		{"", "192.168.1.2:53", anyHINFO},
+		{"", ":53", anyHINFO},
+		{"", "127.0.0.1:5353", anyForward},
+		{"", "[::1]:53", anyForward},
+		{anyNotImp, "127.0.0.1:53", anyNotImp},
+		{anyForward, "0.0.0.0:53", anyForward},
+	}
+	for _, tt := range tests {
+		withConfig(t, func(c *Config) { c.AnyQueries, c.DNSBind = tt.configured, tt.bind })
+		if got := anyQueryPolicy(AppConfig()); got != tt.want {
+			t.Errorf("any_queries %q on %s: policy %q, want %q", tt.configured, tt.bind, got, tt.want)
+		}
+	}
+}
+
+func TestDNSHandlerAnswersANY(t *testing.T) {
+	upstream := startFakeUpstream(t, fakeZone)
+	h := newTestDNSHandler(t, nil)
+	tests := []struct {
+		policy    string
+		qtype     uint16
+		wantRcode int
+		wantType  uint16 // type of the single answer; 0 for none
+	}{
+		{anyHINFO, dns.TypeANY, dns.RcodeSuccess, dns.TypeHINFO},
+		{anyNotImp, dns.TypeANY, dns.RcodeNotImplemented, 0},
+		{anyForward, dns.TypeANY, dns.RcodeSuccess, dns.TypeA},
+		// other types are never answered locally
+		{anyNotImp, dns.TypeA, dns.RcodeSuccess, dns.TypeA},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy+" "+dns.TypeToString[tt.qtype], func(t *testing.T) {
+			withConfig(t, func(c *Config) {
+				c.Upstream, c.UpstreamProtocol = upstream, "tcp"
+				c.AnyQueries = tt.policy
+			})
+			w := serveQuery(h, "127.0.0.1", "www.example", tt.qtype)
+			if w.msg == nil {
+				t.Fatal("got no reply")
+			}
+			if w.msg.Rcode != tt.wantRcode {
+				t.Errorf("rcode = %s, want %s", dns.RcodeToString[w.msg.Rcode], dns.RcodeToString[tt.wantRcode])
+			}
+			var gotType uint16
+			if len(w.msg.Answer) > 0 {
+				gotType = w.msg.Answer[0].Header().Rrtype
+			}
+			if len(w.msg.Answer) > 1 || gotType != tt.wantType {
+				t.Errorf("answers %v, want one %s", w.msg.Answer, dns.TypeToString[tt.wantType])
+			}
+		})
+	}
+}
+
+func TestValidateAnyQueries(t *testing.T) {
+	for policy, ok := range map[string]bool{"": true, anyHINFO: true, anyNotImp: true, anyForward: true, "refuse": false} {
+		c := defaultConfig()
+		c.AnyQueries = policy
+		if err := c.Validate(); (err == nil) != ok {
+			t.Errorf("Validate with any_queries %q = %v, want ok %v", policy, err, ok)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// Codes used in API error responses; they follow the status code so the
+// frontend can branch on them without parsing messages
+const (
+	errCodeBadRequest           = "bad_request"
+	errCodeUnauthorized         = "unauthorized"
+	errCodeForbidden            = "forbidden"
+	errCodeNotFound             = "not_found"
+	errCodeMethodNotAllowed     = "method_not_allowed"
+	errCodeConflict             = "conflict"
+	errCodePayloadTooLarge      = "payload_too_large"
+	errCodeUnsupportedMediaType = "unsupported_media_type"
+	errCodeInternal             = "internal"
+)
+
+// apiError is the body of every API error response:
+// {"error":{"code":"not_found","message":"list not found"}}
+type apiError struct {
+	Error apiErrorBody `json:"error"`
+}
+
+type apiErrorBody struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// writeError replies with status and a JSON error envelope. Like http.Error it
+// discards any Content-Length or content type the handler had already set.
+func writeError(w http.ResponseWriter, status int, code, msg string) {
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Del("Content-Disposition")
+	h.Set("Content-Type", "application/json")
+	h.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(apiError{Error: apiErrorBody{Code: code, Message: strings.TrimSpace(msg)}})
+}
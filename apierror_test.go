@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWriteError(t *testing.T) {
+	w := httptest.NewRecorder()
+	// a handler that fails after preparing a download
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Length", "42")
+	w.Header().Set("Content-Disposition", `attachment; filename="logs.csv"`)
+	writeError(w, http.StatusConflict, errCodeConflict, "list already exists\n")
+
+	if w.Code != http.StatusConflict {
+		t.Errorf("status %d, want %d", w.Code, http.StatusConflict)
+	}
+	for name, want := range map[string]string{
+		"Content-Type":           "application/json",
+		"Content-Length":         "",
+		"Content-Disposition":    "",
+		"X-Content-Type-Options": "nosniff",
+	} {
+		if got := w.Header().Get(name); got != want {
+			t.Errorf("header %s = %q, want %q", name, got, want)
+		}
+	}
+	if got, want := w.Body.String(), `{"error":{"code":"conflict","message":"list already exists"}}`+"\n"; got != want {
+		t.Errorf("body %s, want %s", got, want)
+	}
+}
+
+func TestAPIErrorsUseEnvelope(t *testing.T) {
+	const admin, user = "aa:00:00:00:11:42", "aa:00:00:00:11:43"
+	withConfig(t, func(c *Config) { c.AdminMACs = []string{admin}; c.BcryptCost = 4 })
+	am := newTestAccountManager(t, admin, user)
+	mux := newTestAPI(newTestBlocklistManager(t, nil), am)
+	userSession := am.createSession(user, false).ID
+
+	tests := []struct {
+		method, target, session, body string
+		status                        int
+		code, message                 string
+	}{
+		{http.MethodGet, "/logs", "", "", http.StatusUnauthorized, errCodeUnauthorized, "missing session"},
+		{http.MethodGet, "/logs", "nope", "", http.StatusUnauthorized, errCodeUnauthorized, "invalid or expired session"},
+		{http.MethodGet, "/metrics", userSession, "", http.StatusForbidden, errCodeForbidden, "admin access required"},
+		{http.MethodGet, "/lists/items/missing", userSession, "", http.StatusNotFound, errCodeNotFound, "list not found"},
+		{http.MethodGet, "/auth/login", "", "", http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "method not allowed"},
+		{http.MethodPost, "/lists/create", userSession, `"ads"`, http.StatusBadRequest, errCodeBadRequest, "invalid body: expected a JSON object, got string"},
+	}
+	for _, tt := range tests {
+		w := callAPI(mux, tt.method, tt.target, tt.session, tt.body)
+		var resp apiError
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Errorf("%s %s: body %q is not an error envelope: %v", tt.method, tt.target, w.Body, err)
+			continue
+		}
+		if w.Code != tt.status || resp.Error.Code != tt.code || resp.Error.Message != tt.message {
+			t.Errorf("%s %s: %d %+v, want %d {Code:%s Message:%s}", tt.method, tt.target, w.Code, resp.Error, tt.status, tt.code, tt.message)
+		}
+	}
+}
@@ -1,17 +1,18 @@
 package main
 
 import (
+	"crypto/subtle"
+	"encoding/csv"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"log"
-	"net"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"os"
-	"path"
-	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -20,84 +21,72 @@ import (
 // handleListCreate handles list creation with per-user filtering
 func handleListCreate(w http.ResponseWriter, r *http.Request, bm *BlocklistManager, am *AccountManager) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "method not allowed")
 		return
 	}
 
 	isGuest := r.Header.Get("X-Is-Guest") == "true"
 	if isGuest {
-		http.Error(w, "guests cannot create lists", http.StatusForbidden)
+		writeError(w, http.StatusForbidden, errCodeForbidden, "guests cannot create lists")
 		return
 	}
 
 	userMAC := r.Header.Get("X-User-MAC")
 
 	log.Printf("API /lists/create %s %s (user: %s)", r.Method, r.URL.Path, userMAC)
-	var raw map[string]interface{}
-	if err := json.NewDecoder(r.Body).Decode(&raw); err != nil {
-		http.Error(w, "bad request: "+err.Error(), http.StatusBadRequest)
-		return
-	}
-	req := struct{ Name, URL string; Items []string }{}
-	if v, ok := raw["name"].(string); ok {
-		req.Name = v
-	}
-	if v, ok := raw["url"].(string); ok {
-		req.URL = v
-	}
-	if it, ok := raw["items"]; ok {
-		switch t := it.(type) {
-		case string:
-			req.Items = []string{t}
-		case []interface{}:
-			for _, e := range t {
-				if s, ok := e.(string); ok {
-					req.Items = append(req.Items, s)
-				}
-			}
-		}
-	}
-
-	// Infer list name from URL if not provided
-	if req.Name == "" && req.URL != "" {
-		if u, err := url.Parse(req.URL); err == nil {
-			base := path.Base(u.Path)
-			if ext := path.Ext(base); ext != "" {
-				base = strings.TrimSuffix(base, ext)
-			}
-			if base == "" {
-				base = u.Hostname()
-			}
-			req.Name = base
-		}
+	req, err := decodeListCreate(r)
+	if err != nil {
+		slog.Warn("API /lists/create rejected", "err", err)
+		writeError(w, http.StatusBadRequest, errCodeBadRequest, err.Error())
+		return
 	}
 
-	// Require name and either url or items
-	if req.Name == "" || (req.URL == "" && len(req.Items) == 0) {
-		log.Printf("API /lists/create missing name/url/items: name=%q url=%q items=%d", req.Name, req.URL, len(req.Items))
-		http.Error(w, "missing list name or url/items", http.StatusBadRequest)
+	if req.Name, err = slugifyListName(req.Name); err != nil {
+		writeError(w, http.StatusBadRequest, errCodeBadRequest, err.Error())
 		return
 	}
 
 	// Prefix list name with user's MAC to make it per-user
 	userListName := fmt.Sprintf("%s_%s", userMAC, req.Name)
 
+	if (req.Strict || req.Type == listTypeHosts) && bm.HasList(userListName) {
+		writeError(w, http.StatusConflict, errCodeConflict, "list already exists")
+		return
+	}
+
 	var added int
-	var err error
-	if req.URL != "" {
-		added, err = bm.AddFileToList(userListName, req.URL, true)
-	} else {
+	switch {
+	case req.Type == listTypeHosts:
+		added, err = bm.CreateHostsList(r.Context(), userListName, req.URL, req.Items)
+	case req.URL != "":
+		added, err = bm.AddFileToList(r.Context(), userListName, req.URL, true)
+	default:
 		added, err = bm.AddItemsToList(userListName, req.Items, true)
 	}
+	if errors.Is(err, ErrListExists) {
+		writeError(w, http.StatusConflict, errCodeConflict, "list already exists")
+		return
+	}
 	if err != nil {
-		log.Printf("API /lists/create error: %v", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		slog.Error("API /lists/create failed", "list", userListName, "err", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, err.Error())
 		return
 	}
 
 	// Associate list with user
 	if err := am.AddUserBlocklist(userMAC, userListName); err != nil {
-		log.Printf("Failed to associate list with user: %v", err)
+		slog.Error("failed to associate list with user", "list", userListName, "mac", userMAC, "err", err)
+	}
+
+	if req.Category != "" {
+		meta, err := bm.GetListMeta(userListName)
+		if err == nil {
+			meta.Category = req.Category
+			err = bm.SetListMeta(userListName, meta)
+		}
+		if err != nil {
+			slog.Error("failed to set list category", "list", userListName, "err", err)
+		}
 	}
 
 	log.Printf("API /lists/create wrote %d lines to %s for user %s", added, userListName, userMAC)
@@ -105,11 +94,16 @@ func handleListCreate(w http.ResponseWriter, r *http.Request, bm *BlocklistManag
 	go notifyRustReload()
 }
 
-// handleListItems handles getting/deleting items from a list
+// handleListItems handles getting, adding, editing and deleting items of a list
 func handleListItems(w http.ResponseWriter, r *http.Request, bm *BlocklistManager, am *AccountManager) {
 	listName := strings.TrimPrefix(r.URL.Path, "/lists/items/")
 	if listName == "" {
-		http.Error(w, "missing list name", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, errCodeBadRequest, "missing list name")
+		return
+	}
+	listName, err := slugifyListName(listName)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errCodeBadRequest, err.Error())
 		return
 	}
 
@@ -122,69 +116,148 @@ func handleListItems(w http.ResponseWriter, r *http.Request, bm *BlocklistManage
 	switch r.Method {
 	case http.MethodGet:
 		q := r.URL.Query().Get("q")
-		offStr := r.URL.Query().Get("offset")
-		limStr := r.URL.Query().Get("limit")
-		offset := 0
-		limit := 100
-		if offStr != "" {
-			if v, err := strconv.Atoi(offStr); err == nil {
-				offset = v
-			}
-		}
-		if limStr != "" {
-			if v, err := strconv.Atoi(limStr); err == nil {
-				limit = v
-			}
-		}
+		offset, limit := parsePaging(r.URL.Query(), 100)
 		total, items, err := bm.ListDomains(userListName, offset, limit, q)
 		if err != nil {
 			if errors.Is(err, os.ErrNotExist) {
-				http.Error(w, "list not found", http.StatusNotFound)
+				writeError(w, http.StatusNotFound, errCodeNotFound, "list not found")
 				return
 			}
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			writeError(w, http.StatusInternalServerError, errCodeInternal, err.Error())
 			return
 		}
 		resp := map[string]interface{}{"total": total, "items": items, "offset": offset, "limit": limit}
 		json.NewEncoder(w).Encode(resp)
 		return
 
+	case http.MethodPost:
+		if isGuest {
+			writeError(w, http.StatusForbidden, errCodeForbidden, "guests cannot add items")
+			return
+		}
+		writeAddDomain(w, r, bm, userListName)
+		return
+
 	case http.MethodDelete:
 		if isGuest {
-			http.Error(w, "guests cannot delete items", http.StatusForbidden)
+			writeError(w, http.StatusForbidden, errCodeForbidden, "guests cannot delete items")
 			return
 		}
 
-		var req struct{ Domain string `json:"domain"` }
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			http.Error(w, "invalid json", http.StatusBadRequest)
+		domains, bulk, err := decodeDomains(r)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, errCodeBadRequest, err.Error())
 			return
 		}
-		if req.Domain == "" {
-			http.Error(w, "missing domain", http.StatusBadRequest)
+		writeRemoveDomains(w, bm, userListName, domains, bulk)
+		return
+
+	case http.MethodPatch:
+		if isGuest {
+			writeError(w, http.StatusForbidden, errCodeForbidden, "guests cannot edit items")
 			return
 		}
-		removed, err := bm.RemoveDomain(userListName, req.Domain)
-		if err != nil {
-			if errors.Is(err, os.ErrNotExist) {
-				http.Error(w, "list not found", http.StatusNotFound)
-				return
-			}
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		var req struct {
+			Old string `json:"old"`
+			New string `json:"new"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, errCodeBadRequest, "invalid json")
+			return
+		}
+		if strings.TrimSpace(req.Old) == "" || strings.TrimSpace(req.New) == "" {
+			writeError(w, http.StatusBadRequest, errCodeBadRequest, "missing old or new")
 			return
 		}
-		if !removed {
-			http.Error(w, "domain not found", http.StatusNotFound)
+		if err := bm.EditDomain(userListName, req.Old, req.New); err != nil {
+			switch {
+			case errors.Is(err, os.ErrNotExist):
+				writeError(w, http.StatusNotFound, errCodeNotFound, "list not found")
+			case errors.Is(err, ErrEntryNotFound):
+				writeError(w, http.StatusNotFound, errCodeNotFound, "old entry not found")
+			case errors.Is(err, ErrEntryExists):
+				writeError(w, http.StatusConflict, errCodeConflict, "new entry already in list")
+			case errors.Is(err, ErrHostsList):
+				writeError(w, http.StatusConflict, errCodeConflict, err.Error())
+			case errors.Is(err, ErrInvalidEntry):
+				writeError(w, http.StatusBadRequest, errCodeBadRequest, err.Error())
+			default:
+				slog.Error("API /lists/items edit failed", "list", listName, "err", err)
+				writeError(w, http.StatusInternalServerError, errCodeInternal, err.Error())
+			}
 			return
 		}
-		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(map[string]string{"status": "removed"})
+		log.Printf("API /lists/items/%s edit %q -> %q for user %s", listName, req.Old, req.New, userMAC)
+		_ = json.NewEncoder(w).Encode(map[string]string{"status": "updated", "old": normalizePattern(req.Old), "new": normalizePattern(req.New)})
+		go notifyRustReload()
 		return
 
 	default:
-		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "method not allowed")
+		return
+	}
+}
+
+// writeAddDomain appends the domain in a {"domain": "..."} body to a list and
+// reports the list's new total
+func writeAddDomain(w http.ResponseWriter, r *http.Request, bm *BlocklistManager, listName string) {
+	var req struct {
+		Domain string `json:"domain"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, errCodeBadRequest, "invalid json")
+		return
+	}
+	if strings.TrimSpace(req.Domain) == "" {
+		writeError(w, http.StatusBadRequest, errCodeBadRequest, "missing domain")
+		return
+	}
+	total, err := bm.AddDomain(listName, req.Domain)
+	if err != nil {
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			writeError(w, http.StatusNotFound, errCodeNotFound, "list not found")
+		case errors.Is(err, ErrEntryExists):
+			writeError(w, http.StatusConflict, errCodeConflict, "domain already in list")
+		case errors.Is(err, ErrHostsList):
+			writeError(w, http.StatusConflict, errCodeConflict, err.Error())
+		case errors.Is(err, ErrInvalidEntry):
+			writeError(w, http.StatusBadRequest, errCodeBadRequest, err.Error())
+		default:
+			slog.Error("API /lists/items add failed", "list", listName, "err", err)
+			writeError(w, http.StatusInternalServerError, errCodeInternal, err.Error())
+		}
+		return
+	}
+	log.Printf("API /lists/items/%s added %q", listName, normalizePattern(req.Domain))
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"status": "added", "domain": normalizePattern(req.Domain), "total": total})
+	go notifyRustReload()
+}
+
+// writeRemoveDomains removes domains from a list and reports how many went. A
+// single-domain request that removes nothing is a 404; a bulk request reports 0.
+func writeRemoveDomains(w http.ResponseWriter, bm *BlocklistManager, listName string, domains []string, bulk bool) {
+	removed, err := bm.RemoveDomains(listName, domains)
+	if err != nil {
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			writeError(w, http.StatusNotFound, errCodeNotFound, "list not found")
+		case errors.Is(err, ErrHostsList):
+			writeError(w, http.StatusConflict, errCodeConflict, err.Error())
+		default:
+			writeError(w, http.StatusInternalServerError, errCodeInternal, err.Error())
+		}
 		return
 	}
+	if removed == 0 && !bulk {
+		writeError(w, http.StatusNotFound, errCodeNotFound, "domain not found")
+		return
+	}
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"status": "removed", "removed": removed})
+	if removed > 0 {
+		go notifyRustReload()
+	}
 }
 
 // handleLists handles listing and managing lists
@@ -196,14 +269,14 @@ func handleLists(w http.ResponseWriter, r *http.Request, bm *BlocklistManager, a
 	if p == "" {
 		// List user's lists only
 		if r.Method != http.MethodGet {
-			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			writeError(w, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "method not allowed")
 			return
 		}
 
 		// Get user's blocklists
 		userLists, err := am.GetUserBlocklists(userMAC)
 		if err != nil {
-			log.Printf("Failed to get user blocklists: %v", err)
+			slog.Error("failed to get user blocklists", "mac", userMAC, "err", err)
 			userLists = []string{}
 		}
 
@@ -221,32 +294,193 @@ func handleLists(w http.ResponseWriter, r *http.Request, bm *BlocklistManager, a
 		return
 	}
 
+	if p == "errors" {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "method not allowed")
+			return
+		}
+		// admins see every list; others see their own lists and the global ones
+		isAdmin := r.Header.Get("X-Is-Admin") == "true"
+		prefix := userMAC + "_"
+		keep := func(list string) bool {
+			return isAdmin || strings.HasPrefix(list, prefix) || bm.IsGlobalList(list)
+		}
+		display := func(list string) string {
+			if isAdmin {
+				return list
+			}
+			return strings.TrimPrefix(list, prefix)
+		}
+		_ = json.NewEncoder(w).Encode(bm.LoadErrorsFor(keep, display))
+		return
+	}
+
+	if p == "merge" {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "method not allowed")
+			return
+		}
+		if isGuest {
+			writeError(w, http.StatusForbidden, errCodeForbidden, "guests cannot merge")
+			return
+		}
+		var req struct {
+			Sources []string `json:"sources"`
+			Dest    string   `json:"dest"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, errCodeBadRequest, "invalid json")
+			return
+		}
+		if len(req.Sources) == 0 || strings.TrimSpace(req.Dest) == "" {
+			writeError(w, http.StatusBadRequest, errCodeBadRequest, "missing sources or dest")
+			return
+		}
+		sources := make([]string, 0, len(req.Sources))
+		for _, s := range req.Sources {
+			src, err := slugifyListName(s)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, errCodeBadRequest, err.Error())
+				return
+			}
+			sources = append(sources, fmt.Sprintf("%s_%s", userMAC, src))
+		}
+		writeListCopy(w, bm, am, userMAC, sources, strings.TrimSpace(req.Dest))
+		return
+	}
+
 	// Handle specific list operations
 	parts := strings.SplitN(p, "/", 2)
-	name := parts[0]
+	name, err := slugifyListName(parts[0])
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errCodeBadRequest, err.Error())
+		return
+	}
 	userListName := fmt.Sprintf("%s_%s", userMAC, name)
 
+	if len(parts) == 2 && parts[1] == "stats" {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "method not allowed")
+			return
+		}
+		stats, err := bm.GetListStats(userListName, 10)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				writeError(w, http.StatusNotFound, errCodeNotFound, "list not found")
+				return
+			}
+			writeError(w, http.StatusInternalServerError, errCodeInternal, err.Error())
+			return
+		}
+		_ = json.NewEncoder(w).Encode(stats)
+		return
+	}
+
+	if len(parts) == 2 && parts[1] == "meta" {
+		switch r.Method {
+		case http.MethodGet:
+			meta, err := bm.GetListMeta(userListName)
+			if err != nil {
+				writeError(w, http.StatusNotFound, errCodeNotFound, "list not found")
+				return
+			}
+			_ = json.NewEncoder(w).Encode(meta.Redacted())
+			return
+
+		case http.MethodPost:
+			if isGuest {
+				writeError(w, http.StatusForbidden, errCodeForbidden, "guests cannot change lists")
+				return
+			}
+			// fields are optional so callers can change one without clobbering the other
+			var req struct {
+				Category        *string  `json:"category"`
+				Enabled         *bool    `json:"enabled"`
+				MatchSubdomains *bool    `json:"match_subdomains"`
+				BlockingMode    *string  `json:"blocking_mode"`
+				BlockPageIP     *string  `json:"block_page_ip"`
+				QTypes          []string `json:"qtypes"`
+				// FetchHeaders replaces the list's fetch headers; {} clears them
+				FetchHeaders map[string]string `json:"fetch_headers"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				writeError(w, http.StatusBadRequest, errCodeBadRequest, "invalid json")
+				return
+			}
+			meta, err := bm.GetListMeta(userListName)
+			if err != nil {
+				writeError(w, http.StatusNotFound, errCodeNotFound, "list not found")
+				return
+			}
+			if req.Category != nil {
+				meta.Category = *req.Category
+			}
+			if req.Enabled != nil {
+				meta.Enabled = *req.Enabled
+			}
+			if req.MatchSubdomains != nil {
+				meta.MatchSubdomains = req.MatchSubdomains
+			}
+			if req.BlockingMode != nil {
+				meta.BlockingMode = strings.TrimSpace(*req.BlockingMode)
+			}
+			if req.BlockPageIP != nil {
+				meta.BlockPageIP = strings.TrimSpace(*req.BlockPageIP)
+			}
+			if err := validateBlockingOverride(meta.BlockingMode, meta.BlockPageIP); err != nil {
+				writeError(w, http.StatusBadRequest, errCodeBadRequest, err.Error())
+				return
+			}
+			if req.QTypes != nil {
+				if meta.QTypes, err = normalizeQTypes(req.QTypes); err != nil {
+					writeError(w, http.StatusBadRequest, errCodeBadRequest, err.Error())
+					return
+				}
+			}
+			if req.FetchHeaders != nil {
+				if meta.FetchHeaders, err = normalizeFetchHeaders(req.FetchHeaders); err != nil {
+					writeError(w, http.StatusBadRequest, errCodeBadRequest, err.Error())
+					return
+				}
+			}
+			if err := bm.SetListMeta(userListName, meta); err != nil {
+				slog.Error("API /lists/meta failed", "list", name, "err", err)
+				writeError(w, http.StatusInternalServerError, errCodeInternal, err.Error())
+				return
+			}
+			meta, _ = bm.GetListMeta(userListName)
+			log.Printf("API /lists/%s/meta category=%q enabled=%t for user %s", name, meta.Category, meta.Enabled, userMAC)
+			_ = json.NewEncoder(w).Encode(meta.Redacted())
+			go notifyRustReload()
+			return
+
+		default:
+			writeError(w, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "method not allowed")
+			return
+		}
+	}
+
 	if len(parts) == 2 && parts[1] == "append" {
 		if r.Method != http.MethodPost {
-			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			writeError(w, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "method not allowed")
 			return
 		}
 		if isGuest {
-			http.Error(w, "guests cannot append", http.StatusForbidden)
+			writeError(w, http.StatusForbidden, errCodeForbidden, "guests cannot append")
 			return
 		}
 
-		var raw map[string]interface{}
-		if err := json.NewDecoder(r.Body).Decode(&raw); err != nil {
-			http.Error(w, "bad request: "+err.Error(), http.StatusBadRequest)
+		fetchURL, items, err := decodeListAppend(r)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, errCodeBadRequest, err.Error())
 			return
 		}
 
-		if v, ok := raw["url"].(string); ok && v != "" {
-			added, err := bm.AddFileToList(userListName, v, false)
+		if fetchURL != "" {
+			added, err := bm.AddFileToList(r.Context(), userListName, fetchURL, false)
 			if err != nil {
-				log.Printf("API /lists/%s/append error: %v", name, err)
-				http.Error(w, err.Error(), http.StatusInternalServerError)
+				slog.Error("API /lists/append failed", "list", name, "err", err)
+				writeError(w, http.StatusInternalServerError, errCodeInternal, err.Error())
 				return
 			}
 			log.Printf("API /lists/%s/append added %d lines", name, added)
@@ -255,27 +489,10 @@ func handleLists(w http.ResponseWriter, r *http.Request, bm *BlocklistManager, a
 			return
 		}
 
-		var items []string
-		if it, ok := raw["items"]; ok {
-			switch t := it.(type) {
-			case string:
-				items = []string{t}
-			case []interface{}:
-				for _, e := range t {
-					if s, ok := e.(string); ok {
-						items = append(items, s)
-					}
-				}
-			}
-		}
-		if len(items) == 0 {
-			http.Error(w, "missing url or items", http.StatusBadRequest)
-			return
-		}
 		added, err := bm.AddItemsToList(userListName, items, false)
 		if err != nil {
-			log.Printf("API /lists/%s/append error: %v", name, err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			slog.Error("API /lists/append failed", "list", name, "err", err)
+			writeError(w, http.StatusInternalServerError, errCodeInternal, err.Error())
 			return
 		}
 		log.Printf("API /lists/%s/append added %d lines", name, added)
@@ -284,33 +501,59 @@ func handleLists(w http.ResponseWriter, r *http.Request, bm *BlocklistManager, a
 		return
 	}
 
-	if len(parts) == 2 && parts[1] == "delete" {
-		if r.Method != http.MethodDelete {
-			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	if len(parts) == 2 && parts[1] == "reload" {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "method not allowed")
 			return
 		}
 		if isGuest {
-			http.Error(w, "guests cannot delete", http.StatusForbidden)
+			writeError(w, http.StatusForbidden, errCodeForbidden, "guests cannot reload")
+			return
+		}
+		res, err := bm.ReloadList(userListName)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				writeError(w, http.StatusNotFound, errCodeNotFound, "list not found")
+				return
+			}
+			slog.Error("API reload failed", "list", userListName, "err", err)
+			writeError(w, http.StatusInternalServerError, errCodeInternal, err.Error())
 			return
 		}
+		res.List = name
+		for i := range res.PatternErrors {
+			res.PatternErrors[i].List = name
+		}
+		log.Printf("API reloaded list %s for user %s (%d patterns)", name, userMAC, res.Patterns)
+		_ = json.NewEncoder(w).Encode(res)
+		go notifyRustReload()
+		return
+	}
 
-		// Sanitize list name to prevent path traversal
-		cleanName := filepath.Clean(userListName)
-		if strings.Contains(cleanName, "..") || strings.Contains(cleanName, "/") || strings.Contains(cleanName, "\\") {
-			http.Error(w, "invalid list name", http.StatusBadRequest)
+	if len(parts) == 2 && parts[1] == "delete" {
+		if r.Method != http.MethodDelete {
+			writeError(w, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "method not allowed")
+			return
+		}
+		if isGuest {
+			writeError(w, http.StatusForbidden, errCodeForbidden, "guests cannot delete")
 			return
 		}
 
-		fp := filepath.Join(bm.dir, cleanName+".txt")
-		if err := os.Remove(fp); err != nil {
-			log.Printf("API delete %s error: %v", fp, err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+		if err := bm.DeleteList(userListName); err != nil {
+			if errors.Is(err, errOutsideListDir) {
+				slog.Warn("API delete rejected", "list", userListName, "err", err)
+				writeError(w, http.StatusBadRequest, errCodeBadRequest, "invalid list name")
+				return
+			}
+			slog.Error("API delete failed", "list", userListName, "err", err)
+			writeError(w, http.StatusInternalServerError, errCodeInternal, err.Error())
 			return
 		}
 		
 		// Remove from user's blocklist associations
 		if err := am.RemoveUserBlocklist(userMAC, userListName); err != nil {
-			log.Printf("Failed to remove user blocklist association: %v", err)
+			slog.Error("failed to remove user blocklist association", "list", userListName, "mac", userMAC, "err", err)
 		}
 		
 		_ = bm.LoadAll()
@@ -320,156 +563,1026 @@ func handleLists(w http.ResponseWriter, r *http.Request, bm *BlocklistManager, a
 		return
 	}
 
-	if len(parts) == 2 && parts[1] == "replace" {
+	if len(parts) == 2 && parts[1] == "rename" {
 		if r.Method != http.MethodPost {
-			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			writeError(w, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "method not allowed")
 			return
 		}
 		if isGuest {
-			http.Error(w, "guests cannot replace", http.StatusForbidden)
+			writeError(w, http.StatusForbidden, errCodeForbidden, "guests cannot rename")
 			return
 		}
 
-		var req struct{ URL string `json:"url"` }
+		var req struct{ NewName string `json:"new_name"` }
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			log.Printf("API replace bad request: %v", err)
-			http.Error(w, "bad request: "+err.Error(), http.StatusBadRequest)
+			writeError(w, http.StatusBadRequest, errCodeBadRequest, "invalid json")
 			return
 		}
-		written, err := bm.ReplaceListFromURL(userListName, req.URL)
-		if err != nil {
-			log.Printf("API replace error: %v", err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+		req.NewName = strings.TrimSpace(req.NewName)
+		if req.NewName == "" {
+			writeError(w, http.StatusBadRequest, errCodeBadRequest, "missing new_name")
 			return
 		}
-		log.Printf("API replace wrote %d lines to %s for user %s", written, name, userMAC)
-		fmt.Fprintf(w, "wrote %d lines to %s\n", written, name)
-		go notifyRustReload()
-		return
-	}
-
-	http.NotFound(w, r)
-}
-
-// handleLogs handles log operations
-func handleLogs(w http.ResponseWriter, r *http.Request, bm *BlocklistManager, am *AccountManager) {
-	isGuest := r.Header.Get("X-Is-Guest") == "true"
-
-	switch r.Method {
-	case http.MethodGet:
-		q := r.URL.Query()
-		limit := 100
-		if v := q.Get("limit"); v != "" {
-			if n, err := strconv.Atoi(v); err == nil {
-				limit = n
-			}
+		if req.NewName, err = slugifyListName(req.NewName); err != nil {
+			writeError(w, http.StatusBadRequest, errCodeBadRequest, err.Error())
+			return
 		}
-		logs := bm.GetLogs(limit)
-		_ = json.NewEncoder(w).Encode(logs)
-		return
+		newListName := fmt.Sprintf("%s_%s", userMAC, req.NewName)
 
-	case http.MethodDelete:
-		if isGuest {
-			http.Error(w, "guests cannot delete logs", http.StatusForbidden)
+		if err := bm.RenameList(userListName, newListName); err != nil {
+			switch {
+			case errors.Is(err, os.ErrNotExist):
+				writeError(w, http.StatusNotFound, errCodeNotFound, "list not found")
+			case errors.Is(err, ErrListExists):
+				writeError(w, http.StatusConflict, errCodeConflict, "list already exists")
+			default:
+				slog.Error("API rename failed", "list", name, "err", err)
+				writeError(w, http.StatusInternalServerError, errCodeInternal, err.Error())
+			}
 			return
 		}
-		if err := bm.DeleteLogs(); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
+		if err := am.RenameUserBlocklist(userMAC, userListName, newListName); err != nil {
+			slog.Error("failed to rename user blocklist association", "list", userListName, "mac", userMAC, "err", err)
 		}
-		_ = json.NewEncoder(w).Encode(map[string]string{"status": "deleted"})
-		return
 
-	default:
-		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		log.Printf("API renamed list %s to %s for user %s", name, req.NewName, userMAC)
+		_ = json.NewEncoder(w).Encode(map[string]string{"status": "renamed", "name": req.NewName})
+		go notifyRustReload()
 		return
 	}
-}
 
-// handleValidate validates a remote blocklist URL
-func handleValidate(bm *BlocklistManager) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
+	if len(parts) == 2 && parts[1] == "copy" {
 		if r.Method != http.MethodPost {
-			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			writeError(w, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "method not allowed")
 			return
 		}
-		var req struct{ URL string `json:"url"` }
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			http.Error(w, "bad request: "+err.Error(), http.StatusBadRequest)
+		if isGuest {
+			writeError(w, http.StatusForbidden, errCodeForbidden, "guests cannot copy")
 			return
 		}
-
-		// Validate URL to prevent SSRF
-		parsedURL, err := url.Parse(req.URL)
-		if err != nil {
-			http.Error(w, "invalid URL", http.StatusBadRequest)
+		var req struct{ Dest string `json:"dest"` }
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, errCodeBadRequest, "invalid json")
 			return
 		}
-
-		// Require explicit scheme
-		if parsedURL.Scheme == "" {
-			http.Error(w, "URL must include scheme (http or https)", http.StatusBadRequest)
+		if strings.TrimSpace(req.Dest) == "" {
+			writeError(w, http.StatusBadRequest, errCodeBadRequest, "missing dest")
 			return
 		}
+		writeListCopy(w, bm, am, userMAC, []string{userListName}, strings.TrimSpace(req.Dest))
+		return
+	}
 
-		// Only allow http and https schemes
-		if parsedURL.Scheme != "http" && parsedURL.Scheme != "https" {
-			http.Error(w, "only http and https URLs are allowed", http.StatusBadRequest)
+	if len(parts) == 2 && parts[1] == "replace" {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "method not allowed")
 			return
 		}
-
-		// Block requests to private IP ranges and localhost
-		hostname := parsedURL.Hostname()
-		if isPrivateOrLocalhostIP(hostname) {
-			http.Error(w, "requests to private/localhost addresses are not allowed", http.StatusBadRequest)
+		if isGuest {
+			writeError(w, http.StatusForbidden, errCodeForbidden, "guests cannot replace")
 			return
 		}
 
-		client := &http.Client{Timeout: 15 * time.Second}
-		resp, err := client.Get(req.URL)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
-			return
-		}
-		defer resp.Body.Close()
-		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-			http.Error(w, "fetch failed: "+resp.Status, http.StatusBadRequest)
+		var req struct{ URL string `json:"url"` }
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			slog.Warn("API replace bad request", "err", err)
+			writeError(w, http.StatusBadRequest, errCodeBadRequest, "bad request: "+err.Error())
 			return
 		}
-		lines, err := readLines(resp.Body)
+		written, err := bm.ReplaceListFromURL(r.Context(), userListName, req.URL)
 		if err != nil {
-			http.Error(w, "parse error: "+err.Error(), http.StatusBadRequest)
+			slog.Error("API replace failed", "list", name, "err", err)
+			writeError(w, http.StatusInternalServerError, errCodeInternal, err.Error())
 			return
 		}
-		sample := []string{}
-		for i := 0; i < len(lines) && i < 10; i++ {
-			sample = append(sample, lines[i])
-		}
-		out := map[string]interface{}{"count": len(lines), "sample": sample}
-		_ = json.NewEncoder(w).Encode(out)
+		log.Printf("API replace wrote %d lines to %s for user %s", written, name, userMAC)
+		fmt.Fprintf(w, "wrote %d lines to %s\n", written, name)
+		go notifyRustReload()
+		return
 	}
-}
 
-// isPrivateOrLocalhostIP checks if a hostname resolves to a private or localhost IP
-func isPrivateOrLocalhostIP(hostname string) bool {
-	// Check common localhost names
-	if hostname == "localhost" || hostname == "127.0.0.1" || hostname == "::1" || hostname == "0.0.0.0" {
-		return true
-	}
+	http.NotFound(w, r)
+}
 
-	// Try to resolve the hostname to IPs
-	ips, err := net.LookupIP(hostname)
+// writeListCopy merges the user's source lists into dest (a display name), associates
+// the result with the user and writes the response for /lists/merge and /lists/{name}/copy
+func writeListCopy(w http.ResponseWriter, bm *BlocklistManager, am *AccountManager, userMAC string, sources []string, dest string) {
+	dest, err := slugifyListName(dest)
 	if err != nil {
-		// If lookup fails, be conservative and block
-		return true
+		writeError(w, http.StatusBadRequest, errCodeBadRequest, err.Error())
+		return
 	}
+	destListName := fmt.Sprintf("%s_%s", userMAC, dest)
 
-	// Check if any resolved IP is private or loopback
-	for _, ip := range ips {
-		if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() {
-			return true
+	count, err := bm.MergeLists(sources, destListName)
+	if err != nil {
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			writeError(w, http.StatusNotFound, errCodeNotFound, "list not found")
+		case errors.Is(err, ErrListExists):
+			writeError(w, http.StatusConflict, errCodeConflict, "list already exists")
+		case errors.Is(err, ErrHostsList):
+			writeError(w, http.StatusConflict, errCodeConflict, err.Error())
+		default:
+			slog.Error("API list merge failed", "dest", destListName, "err", err)
+			writeError(w, http.StatusInternalServerError, errCodeInternal, err.Error())
 		}
+		return
 	}
+	if err := am.AddUserBlocklist(userMAC, destListName); err != nil {
+		slog.Error("failed to associate list with user", "list", destListName, "mac", userMAC, "err", err)
+	}
+
+	log.Printf("API merged %d lists into %s (%d entries) for user %s", len(sources), dest, count, userMAC)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"name": dest, "entries": count})
+	go notifyRustReload()
+}
 
-	return false
+// handleCategories lists the user's list categories, or toggles every list in a
+// category with POST /categories/{name} {"enabled": bool}
+func handleCategories(w http.ResponseWriter, r *http.Request, bm *BlocklistManager, am *AccountManager) {
+	userMAC := r.Header.Get("X-User-MAC")
+	isGuest := r.Header.Get("X-Is-Guest") == "true"
+	category := strings.Trim(strings.TrimPrefix(r.URL.Path, "/categories"), "/")
+
+	userLists, err := am.GetUserBlocklists(userMAC)
+	if err != nil {
+		slog.Error("failed to get user blocklists", "mac", userMAC, "err", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, err.Error())
+		return
+	}
+
+	if category == "" {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "method not allowed")
+			return
+		}
+		cats := bm.Categories(userLists)
+		// Strip user prefix for display
+		for i := range cats {
+			for j, fullName := range cats[i].Lists {
+				cats[i].Lists[j] = strings.TrimPrefix(fullName, userMAC+"_")
+			}
+		}
+		_ = json.NewEncoder(w).Encode(cats)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "method not allowed")
+		return
+	}
+	if isGuest {
+		writeError(w, http.StatusForbidden, errCodeForbidden, "guests cannot change lists")
+		return
+	}
+	var req struct {
+		Enabled *bool `json:"enabled"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, errCodeBadRequest, "invalid json")
+		return
+	}
+	if req.Enabled == nil {
+		writeError(w, http.StatusBadRequest, errCodeBadRequest, "missing enabled")
+		return
+	}
+	changed, err := bm.SetCategoryEnabled(userLists, category, *req.Enabled)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			writeError(w, http.StatusNotFound, errCodeNotFound, "category not found")
+			return
+		}
+		slog.Error("API /categories toggle failed", "category", category, "err", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, err.Error())
+		return
+	}
+	for i, fullName := range changed {
+		changed[i] = strings.TrimPrefix(fullName, userMAC+"_")
+	}
+	log.Printf("API /categories/%s enabled=%t changed %d lists for user %s", category, *req.Enabled, len(changed), userMAC)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"category": category, "enabled": *req.Enabled, "changed": changed})
+	if len(changed) > 0 {
+		go notifyRustReload()
+	}
+}
+
+// maxBlockingDisable bounds a timed disable so a typo can't switch filtering off for days
+const maxBlockingDisable = 24 * time.Hour
+
+// handleAnalyticsReset serves POST /analytics/reset, which zeroes the
+// analytics counters without touching the logs. Counters aren't timestamped,
+// so a partial reset (?since=) is refused rather than clearing everything.
+func handleAnalyticsReset(w http.ResponseWriter, r *http.Request, bm *BlocklistManager) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "method not allowed")
+		return
+	}
+	if r.URL.Query().Has("since") {
+		writeError(w, http.StatusBadRequest, errCodeBadRequest, "since is not supported: counters can only be reset in full")
+		return
+	}
+	bm.ResetStats()
+	log.Printf("API /analytics/reset cleared all counters, requested by %s", r.Header.Get("X-User-MAC"))
+	_ = json.NewEncoder(w).Encode(map[string]string{"status": "reset"})
+}
+
+// handleAnalyticsTop serves GET /analytics/top?n=20&kind=blocked|allowed|clients.
+// Like /analytics, admins get network-wide counts unless scope=self.
+func handleAnalyticsTop(w http.ResponseWriter, r *http.Request, bm *BlocklistManager) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "method not allowed")
+		return
+	}
+	q := r.URL.Query()
+	kind := q.Get("kind")
+	if kind == "" {
+		kind = "blocked"
+	}
+	n := 20
+	if v, err := strconv.Atoi(q.Get("n")); err == nil {
+		n = v
+	}
+
+	owner := r.Header.Get("X-User-MAC")
+	if r.Header.Get("X-Is-Admin") == "true" && q.Get("scope") != "self" {
+		owner = ""
+	}
+	top, err := bm.GetTop(owner, kind, n)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errCodeBadRequest, err.Error())
+		return
+	}
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"kind": kind, "top": top})
+}
+
+// listSearchResult is one list's matches for /search
+type listSearchResult struct {
+	List  string   `json:"list"`
+	Count int      `json:"count"` // total matches in the list
+	Items []string `json:"items"` // the offset/limit window of those matches
+}
+
+// handleSearch serves GET /search?q=...&offset=&limit=, reporting which of the
+// requesting user's lists contain entries matching q
+func handleSearch(w http.ResponseWriter, r *http.Request, bm *BlocklistManager, am *AccountManager) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "method not allowed")
+		return
+	}
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	if q == "" {
+		writeError(w, http.StatusBadRequest, errCodeBadRequest, "missing q")
+		return
+	}
+	offset, limit := parsePaging(r.URL.Query(), 100)
+	userMAC := r.Header.Get("X-User-MAC")
+
+	userLists, err := am.GetUserBlocklists(userMAC)
+	if err != nil {
+		slog.Error("failed to get user blocklists", "mac", userMAC, "err", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "failed to load lists")
+		return
+	}
+	sort.Strings(userLists)
+
+	results := []listSearchResult{}
+	total := 0
+	for _, fullName := range userLists {
+		count, items, err := bm.ListDomains(fullName, offset, limit, q)
+		if err != nil || count == 0 {
+			continue
+		}
+		results = append(results, listSearchResult{
+			List:  strings.TrimPrefix(fullName, userMAC+"_"),
+			Count: count,
+			Items: items,
+		})
+		total += count
+	}
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"query":  q,
+		"total":  total,
+		"offset": offset,
+		"limit":  limit,
+		"lists":  results,
+	})
+}
+
+// handleGlobalLists serves /global/lists. Anyone signed in can view global lists;
+// only admins can create, change or delete them.
+//
+//	GET    /global/lists                  names and entry counts
+//	POST   /global/lists/create           {"name", "url" | "items", "category"}
+//	GET    /global/lists/{name}/items     ?offset=&limit=&q=
+//	DELETE /global/lists/{name}/items     {"domain"} or {"domains"}
+//	POST   /global/lists/{name}/append    {"url" | "items"}
+//	DELETE /global/lists/{name}
+func handleGlobalLists(w http.ResponseWriter, r *http.Request, bm *BlocklistManager) {
+	p := strings.Trim(strings.TrimPrefix(r.URL.Path, "/global/lists"), "/")
+	isAdmin := r.Header.Get("X-Is-Admin") == "true"
+	if r.Method != http.MethodGet && !isAdmin {
+		writeError(w, http.StatusForbidden, errCodeForbidden, "admin access required")
+		return
+	}
+
+	if p == "" {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "method not allowed")
+			return
+		}
+		lists := make(map[string]int)
+		bm.mu.RLock()
+		for _, name := range bm.global {
+			lists[name] = len(bm.lists[name])
+		}
+		bm.mu.RUnlock()
+		_ = json.NewEncoder(w).Encode(lists)
+		return
+	}
+
+	if p == "create" {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "method not allowed")
+			return
+		}
+		req, err := decodeListCreate(r)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, errCodeBadRequest, err.Error())
+			return
+		}
+		if req.Name, err = slugifyListName(req.Name); err != nil {
+			writeError(w, http.StatusBadRequest, errCodeBadRequest, err.Error())
+			return
+		}
+		if bm.HasList(req.Name) {
+			writeError(w, http.StatusConflict, errCodeConflict, "list already exists")
+			return
+		}
+		var added int
+		switch {
+		case req.Type == listTypeHosts:
+			added, err = bm.CreateHostsList(r.Context(), req.Name, req.URL, req.Items)
+		case req.URL != "":
+			added, err = bm.AddFileToList(r.Context(), req.Name, req.URL, true)
+		default:
+			added, err = bm.AddItemsToList(req.Name, req.Items, true)
+		}
+		if err == nil {
+			err = bm.MarkGlobal(req.Name, req.Category)
+		}
+		if err != nil {
+			slog.Error("API /global/lists/create failed", "list", req.Name, "err", err)
+			writeError(w, http.StatusInternalServerError, errCodeInternal, err.Error())
+			return
+		}
+		log.Printf("API /global/lists/create wrote %d lines to %s", added, req.Name)
+		fmt.Fprintf(w, "added %d lines to %s\n", added, req.Name)
+		go notifyRustReload()
+		return
+	}
+
+	parts := strings.SplitN(p, "/", 2)
+	name := parts[0]
+	if !validGlobalListName(name) || !bm.IsGlobalList(name) {
+		writeError(w, http.StatusNotFound, errCodeNotFound, "list not found")
+		return
+	}
+	op := ""
+	if len(parts) == 2 {
+		op = parts[1]
+	}
+
+	switch {
+	case op == "items" && r.Method == http.MethodGet:
+		offset, limit := parsePaging(r.URL.Query(), 100)
+		total, items, err := bm.ListDomains(name, offset, limit, r.URL.Query().Get("q"))
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, errCodeInternal, err.Error())
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"total": total, "items": items, "offset": offset, "limit": limit})
+
+	case op == "items" && r.Method == http.MethodDelete:
+		domains, bulk, err := decodeDomains(r)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, errCodeBadRequest, err.Error())
+			return
+		}
+		writeRemoveDomains(w, bm, name, domains, bulk)
+
+	case op == "append" && r.Method == http.MethodPost:
+		fetchURL, items, err := decodeListAppend(r)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, errCodeBadRequest, err.Error())
+			return
+		}
+		var added int
+		if fetchURL != "" {
+			added, err = bm.AddFileToList(r.Context(), name, fetchURL, false)
+		} else {
+			added, err = bm.AddItemsToList(name, items, false)
+		}
+		if err != nil {
+			slog.Error("API /global/lists/append failed", "list", name, "err", err)
+			writeError(w, http.StatusInternalServerError, errCodeInternal, err.Error())
+			return
+		}
+		log.Printf("API /global/lists/%s/append added %d lines", name, added)
+		fmt.Fprintf(w, "added %d lines to %s\n", added, name)
+		go notifyRustReload()
+
+	case op == "" && r.Method == http.MethodDelete:
+		if err := bm.DeleteList(name); err != nil {
+			if errors.Is(err, errOutsideListDir) {
+				slog.Warn("API global delete rejected", "list", name, "err", err)
+				writeError(w, http.StatusBadRequest, errCodeBadRequest, "invalid list name")
+				return
+			}
+			slog.Error("API global delete failed", "list", name, "err", err)
+			writeError(w, http.StatusInternalServerError, errCodeInternal, err.Error())
+			return
+		}
+		_ = bm.LoadAll()
+		log.Printf("API deleted global list %s", name)
+		io.WriteString(w, "deleted\n")
+		go notifyRustReload()
+
+	case op == "" || op == "items" || op == "append":
+		writeError(w, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "method not allowed")
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// handleBlocking serves /blocking/status, /blocking/enable and /blocking/disable
+func handleBlocking(w http.ResponseWriter, r *http.Request) {
+	action := strings.TrimPrefix(r.URL.Path, "/blocking/")
+	switch action {
+	case "status":
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "method not allowed")
+			return
+		}
+
+	case "enable":
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "method not allowed")
+			return
+		}
+		blockingSwitch.Enable()
+
+	case "disable":
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "method not allowed")
+			return
+		}
+		// minutes is optional; omitted or 0 disables until re-enabled
+		var req struct {
+			Minutes float64 `json:"minutes"`
+		}
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				writeError(w, http.StatusBadRequest, errCodeBadRequest, "invalid json")
+				return
+			}
+		}
+		d := time.Duration(req.Minutes * float64(time.Minute))
+		if d < 0 || d > maxBlockingDisable {
+			writeError(w, http.StatusBadRequest, errCodeBadRequest, fmt.Sprintf("minutes must be between 0 and %d", int(maxBlockingDisable.Minutes())))
+			return
+		}
+		blockingSwitch.Disable(d)
+
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(blockingSwitch.Status())
+}
+
+// ownLogsOnly reports whether the caller may only read the log entries of its
+// own devices, and whose those are. As with /analytics, only admins see the
+// whole network's queries.
+func ownLogsOnly(r *http.Request) (string, bool) {
+	if r.Header.Get("X-Is-Admin") == "true" {
+		return "", false
+	}
+	return r.Header.Get("X-User-MAC"), true
+}
+
+// handleLogs handles log operations
+func handleLogs(w http.ResponseWriter, r *http.Request, bm *BlocklistManager, am *AccountManager) {
+	isGuest := r.Header.Get("X-Is-Guest") == "true"
+
+	switch r.Method {
+	case http.MethodGet:
+		q := r.URL.Query()
+		limit := 100
+		if v := q.Get("limit"); v != "" {
+			if n, err := strconv.Atoi(v); err == nil {
+				limit = n
+			}
+		}
+		var logs []QueryEntry
+		if mac, own := ownLogsOnly(r); own {
+			logs = bm.GetLogsFor(mac, limit)
+		} else {
+			logs = bm.GetLogs(limit)
+		}
+		if q.Get("labels") == "true" {
+			if names, err := am.DeviceNames(); err == nil {
+				for i := range logs {
+					logs[i].Label = deviceLabel(names, logs[i].Client)
+				}
+			} else {
+				slog.Error("failed to load device names", "err", err)
+			}
+		}
+		_ = json.NewEncoder(w).Encode(logs)
+		return
+
+	case http.MethodDelete:
+		if isGuest {
+			writeError(w, http.StatusForbidden, errCodeForbidden, "guests cannot delete logs")
+			return
+		}
+		// ?client= removes a single device's history, e.g. for a privacy request
+		if client := r.URL.Query().Get("client"); client != "" {
+			if r.Header.Get("X-Is-Admin") != "true" {
+				writeError(w, http.StatusForbidden, errCodeForbidden, "admin access required")
+				return
+			}
+			purge, err := bm.DeleteClientLogs(client)
+			if errors.Is(err, ErrInvalidClient) {
+				writeError(w, http.StatusBadRequest, errCodeBadRequest, err.Error())
+				return
+			}
+			if err != nil {
+				slog.Error("API /logs: deleting client logs failed", "client", client, "err", err)
+				writeError(w, http.StatusInternalServerError, errCodeInternal, err.Error())
+				return
+			}
+			_ = json.NewEncoder(w).Encode(purge)
+			return
+		}
+		if err := bm.DeleteLogs(); err != nil {
+			writeError(w, http.StatusInternalServerError, errCodeInternal, err.Error())
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{"status": "deleted"})
+		return
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "method not allowed")
+		return
+	}
+}
+
+// handleLogsExport serves GET /logs/export?format=csv|json&since=&source=recent|file.
+// Entries are written as they are read so large exports aren't buffered. since
+// accepts RFC 3339 or unix seconds. Non-admins only get their own devices' entries.
+func handleLogsExport(w http.ResponseWriter, r *http.Request, bm *BlocklistManager) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "method not allowed")
+		return
+	}
+	q := r.URL.Query()
+
+	var since time.Time
+	if v := q.Get("since"); v != "" {
+		if t, err := time.Parse(time.RFC3339, v); err == nil {
+			since = t
+		} else if secs, err := strconv.ParseInt(v, 10, 64); err == nil {
+			since = time.Unix(secs, 0)
+		} else {
+			writeError(w, http.StatusBadRequest, errCodeBadRequest, "invalid since: want RFC 3339 or unix seconds")
+			return
+		}
+	}
+
+	fromFile := false
+	switch q.Get("source") {
+	case "", "recent":
+	case "file":
+		fromFile = true
+	default:
+		writeError(w, http.StatusBadRequest, errCodeBadRequest, "invalid source: want recent or file")
+		return
+	}
+
+	var write func(QueryEntry) error
+	var flush func() error
+	switch q.Get("format") {
+	case "", "csv":
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="piblock-logs.csv"`)
+		cw := csv.NewWriter(w)
+		if err := cw.Write([]string{"time", "client", "domain", "qtype", "blocked", "reason", "mode"}); err != nil {
+			return
+		}
+		write = func(e QueryEntry) error {
+			return cw.Write([]string{e.Time.UTC().Format(time.RFC3339Nano), e.Client, e.Domain, e.Qtype, strconv.FormatBool(e.Blocked), e.Reason, e.Mode})
+		}
+		flush = func() error {
+			cw.Flush()
+			return cw.Error()
+		}
+	case "json":
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Content-Disposition", `attachment; filename="piblock-logs.jsonl"`)
+		enc := json.NewEncoder(w)
+		write = func(e QueryEntry) error { return enc.Encode(e) }
+		flush = func() error { return nil }
+	default:
+		writeError(w, http.StatusBadRequest, errCodeBadRequest, "invalid format: want csv or json")
+		return
+	}
+
+	if mac, own := ownLogsOnly(r); own {
+		all := write
+		write = func(e QueryEntry) error {
+			if mac == "" || clientOwner(e.Client) != mac {
+				return nil
+			}
+			return all(e)
+		}
+	}
+	if err := bm.EachLogEntry(fromFile, since, write); err != nil {
+		slog.Error("API /logs/export failed", "err", err)
+	}
+	if err := flush(); err != nil {
+		slog.Error("API /logs/export flush failed", "err", err)
+	}
+}
+
+// handleDevices serves device labels. Anyone signed in can read them; only
+// admins can change them.
+//
+//	GET    /devices          {"<mac>": "label", ...}
+//	POST   /devices/{mac}    {"label": "Kitchen iPad"}
+//	DELETE /devices/{mac}
+func handleDevices(w http.ResponseWriter, r *http.Request, am *AccountManager) {
+	device := strings.Trim(strings.TrimPrefix(r.URL.Path, "/devices"), "/")
+	if device == "" {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "method not allowed")
+			return
+		}
+		names, err := am.DeviceNames()
+		if err != nil {
+			slog.Error("failed to load device names", "err", err)
+			writeError(w, http.StatusInternalServerError, errCodeInternal, "failed to load device names")
+			return
+		}
+		_ = json.NewEncoder(w).Encode(names)
+		return
+	}
+
+	if r.Header.Get("X-Is-Admin") != "true" {
+		writeError(w, http.StatusForbidden, errCodeForbidden, "admin access required")
+		return
+	}
+	var label string
+	switch r.Method {
+	case http.MethodPost:
+		var req struct {
+			Label string `json:"label"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, errCodeBadRequest, "invalid json")
+			return
+		}
+		label = req.Label
+	case http.MethodDelete:
+	default:
+		writeError(w, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "method not allowed")
+		return
+	}
+	if err := am.SetDeviceName(device, label); err != nil {
+		if errors.Is(err, ErrInvalidDevice) || errors.Is(err, ErrDeviceLabelTooLong) {
+			writeError(w, http.StatusBadRequest, errCodeBadRequest, err.Error())
+			return
+		}
+		slog.Error("failed to set device name", "device", device, "err", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "failed to set device name")
+		return
+	}
+	_ = json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+// handleRecords lists, adds, replaces and deletes local DNS records:
+// GET/POST /records, PUT/DELETE /records/{id}
+func handleRecords(w http.ResponseWriter, r *http.Request, am *AccountManager) {
+	idPart := strings.Trim(strings.TrimPrefix(r.URL.Path, "/records"), "/")
+	if idPart == "" && r.Method == http.MethodGet {
+		records, err := am.LocalRecords()
+		if err != nil {
+			slog.Error("failed to load local records", "err", err)
+			writeError(w, http.StatusInternalServerError, errCodeInternal, "failed to load records")
+			return
+		}
+		_ = json.NewEncoder(w).Encode(records)
+		return
+	}
+
+	if r.Header.Get("X-Is-Admin") != "true" {
+		writeError(w, http.StatusForbidden, errCodeForbidden, "admin access required")
+		return
+	}
+	var id int64
+	if idPart != "" {
+		var err error
+		if id, err = strconv.ParseInt(idPart, 10, 64); err != nil || id <= 0 {
+			writeError(w, http.StatusBadRequest, errCodeBadRequest, "invalid record id")
+			return
+		}
+	}
+
+	var (
+		rec LocalRecord
+		err error
+	)
+	switch {
+	case idPart == "" && r.Method == http.MethodPost, idPart != "" && r.Method == http.MethodPut:
+		if err := json.NewDecoder(r.Body).Decode(&rec); err != nil {
+			writeError(w, http.StatusBadRequest, errCodeBadRequest, "invalid json")
+			return
+		}
+		if idPart == "" {
+			rec, err = am.AddLocalRecord(rec)
+		} else {
+			rec, err = am.UpdateLocalRecord(id, rec)
+		}
+	case idPart != "" && r.Method == http.MethodDelete:
+		err = am.DeleteLocalRecord(id)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "method not allowed")
+		return
+	}
+	switch {
+	case errors.Is(err, ErrInvalidRecord):
+		writeError(w, http.StatusBadRequest, errCodeBadRequest, err.Error())
+		return
+	case errors.Is(err, ErrRecordNotFound):
+		writeError(w, http.StatusNotFound, errCodeNotFound, err.Error())
+		return
+	case errors.Is(err, ErrRecordConflict):
+		writeError(w, http.StatusConflict, errCodeConflict, err.Error())
+		return
+	case err != nil:
+		slog.Error("failed to change local record", "err", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, "failed to change record")
+		return
+	}
+	if r.Method == http.MethodDelete {
+		_ = json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+		return
+	}
+	if r.Method == http.MethodPost {
+		w.WriteHeader(http.StatusCreated)
+	}
+	_ = json.NewEncoder(w).Encode(rec)
+}
+
+// handleSafeSearch gets or sets the requesting user's safe-search preference
+func handleSafeSearch(w http.ResponseWriter, r *http.Request, am *AccountManager) {
+	userMAC := r.Header.Get("X-User-MAC")
+	isGuest := r.Header.Get("X-Is-Guest") == "true"
+
+	switch r.Method {
+	case http.MethodGet:
+		enabled := safeSearchEnabledFor(AppConfig(), userMAC, am)
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"enabled": enabled})
+		return
+
+	case http.MethodPost:
+		if isGuest {
+			writeError(w, http.StatusForbidden, errCodeForbidden, "guests cannot change settings")
+			return
+		}
+		var req struct {
+			Enabled *bool `json:"enabled"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, errCodeBadRequest, "invalid json")
+			return
+		}
+		if req.Enabled == nil {
+			writeError(w, http.StatusBadRequest, errCodeBadRequest, "missing enabled")
+			return
+		}
+		if err := am.SetSafeSearch(userMAC, *req.Enabled); err != nil {
+			writeError(w, http.StatusInternalServerError, errCodeInternal, err.Error())
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"enabled": *req.Enabled})
+		return
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "method not allowed")
+		return
+	}
+}
+
+// handlePrune reports (and with ?apply=true removes) orphaned list files and associations
+func handlePrune(w http.ResponseWriter, r *http.Request, bm *BlocklistManager, am *AccountManager) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "method not allowed")
+		return
+	}
+	apply := r.URL.Query().Get("apply") == "true"
+	report, err := pruneOrphans(bm, am, apply)
+	if err != nil {
+		slog.Error("API /maintenance/prune failed", "err", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, err.Error())
+		return
+	}
+	log.Printf("API /maintenance/prune apply=%t files=%d missing=%d orphaned=%d", apply, len(report.OrphanFiles), len(report.MissingFiles), len(report.OrphanAssociations))
+	_ = json.NewEncoder(w).Encode(report)
+}
+
+// handleBackup serves GET /backup: a gzipped tar of the lists, accounts and config
+func handleBackup(w http.ResponseWriter, r *http.Request, bm *BlocklistManager, am *AccountManager) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "method not allowed")
+		return
+	}
+	if bm.dir == "" {
+		writeError(w, http.StatusConflict, errCodeConflict, ErrListsInMemory.Error())
+		return
+	}
+	name := fmt.Sprintf("piblock-backup-%s.tar.gz", time.Now().UTC().Format("20060102-150405"))
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+	if err := WriteBackup(w, bm, am); err != nil {
+		// headers are already out once streaming starts; the truncated archive won't unpack
+		slog.Error("API /backup failed", "err", err)
+		return
+	}
+	log.Printf("API /backup wrote %s for %s", name, r.Header.Get("X-User-MAC"))
+}
+
+// handleRestore serves POST /restore with a backup archive as the body. It
+// validates the archive and reports what it holds; with ?confirm=true it then
+// replaces every list, account and the config with the archive's.
+func handleRestore(w http.ResponseWriter, r *http.Request, bm *BlocklistManager, am *AccountManager) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "method not allowed")
+		return
+	}
+	apply := r.URL.Query().Get("confirm") == "true"
+	summary, err := RestoreBackup(r.Body, bm, am, runningCommandLine, apply)
+	if err != nil {
+		if errors.Is(err, ErrInvalidBackup) {
+			writeError(w, http.StatusBadRequest, errCodeBadRequest, err.Error())
+			return
+		}
+		if errors.Is(err, ErrListsInMemory) {
+			writeError(w, http.StatusConflict, errCodeConflict, err.Error())
+			return
+		}
+		slog.Error("API /restore failed", "applied", summary.Applied, "err", err)
+		writeError(w, http.StatusInternalServerError, errCodeInternal, err.Error())
+		return
+	}
+	log.Printf("API /restore confirm=%t lists=%d accounts=%d by %s", apply, summary.Lists, summary.Accounts, r.Header.Get("X-User-MAC"))
+	_ = json.NewEncoder(w).Encode(summary)
+	if summary.Applied {
+		go notifyRustReload()
+	}
+}
+
+// queryEvent is a query decision reported by the rust DNS backend
+type queryEvent struct {
+	Domain  string `json:"domain"`
+	Client  string `json:"client"`
+	Qtype   string `json:"qtype"`
+	Blocked bool   `json:"blocked"`
+}
+
+// maxQueryEvents caps how many events a single ingestion request may carry
+const maxQueryEvents = 1000
+
+// handleQueryEvents ingests query decisions from the rust backend so analytics and
+// logs stay accurate regardless of which backend answered
+func handleQueryEvents(w http.ResponseWriter, r *http.Request, bm *BlocklistManager) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "method not allowed")
+		return
+	}
+	token := r.Header.Get("X-Events-Token")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(rustEventsToken)) != 1 {
+		writeError(w, http.StatusUnauthorized, errCodeUnauthorized, "invalid events token")
+		return
+	}
+
+	var events []queryEvent
+	if err := json.NewDecoder(r.Body).Decode(&events); err != nil {
+		writeError(w, http.StatusBadRequest, errCodeBadRequest, "invalid json")
+		return
+	}
+	if len(events) > maxQueryEvents {
+		writeError(w, http.StatusBadRequest, errCodeBadRequest, fmt.Sprintf("too many events (max %d)", maxQueryEvents))
+		return
+	}
+
+	accepted := 0
+	for _, ev := range events {
+		domain := normalizePattern(ev.Domain)
+		if domain == "" {
+			continue
+		}
+		bm.RecordQueryOfType(domain, ev.Client, ev.Qtype, ev.Blocked)
+		accepted++
+	}
+	_ = json.NewEncoder(w).Encode(map[string]int{"accepted": accepted})
+}
+
+// handleHealth reports liveness and which DNS backend is serving queries
+func handleHealth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "method not allowed")
+		return
+	}
+	backend := dnsBackend.Snapshot()
+	status := "ok"
+	if backend.Backend == backendNone {
+		status = "degraded"
+	}
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"status": status, "dns": backend})
+}
+
+// handleValidate fetches a remote blocklist URL and previews its contents. With
+// "list" set, entries already in that list (the caller's own, else a global one)
+// are counted as duplicates. It runs behind authMiddleware; guests can't use
+// it, and the download goes through publicFetchClient, which only connects to
+// public addresses.
+func handleValidate(bm *BlocklistManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "method not allowed")
+			return
+		}
+		if r.Header.Get("X-Is-Guest") == "true" {
+			writeError(w, http.StatusForbidden, errCodeForbidden, "guests cannot validate lists")
+			return
+		}
+		var req struct {
+			URL  string `json:"url"`
+			List string `json:"list"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, errCodeBadRequest, "bad request: "+err.Error())
+			return
+		}
+
+		var existing map[string]struct{}
+		if req.List != "" {
+			target := req.List
+			if own := r.Header.Get("X-User-MAC") + "_" + req.List; bm.HasList(own) {
+				target = own
+			} else if !bm.IsGlobalList(req.List) {
+				writeError(w, http.StatusNotFound, errCodeNotFound, "list not found")
+				return
+			}
+			set, err := bm.ListPatternSet(target)
+			if err != nil {
+				writeError(w, http.StatusNotFound, errCodeNotFound, "list not found")
+				return
+			}
+			existing = set
+		}
+
+		// Validate URL to prevent SSRF
+		parsedURL, err := url.Parse(req.URL)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, errCodeBadRequest, "invalid URL")
+			return
+		}
+
+		// Require explicit scheme
+		if parsedURL.Scheme == "" {
+			writeError(w, http.StatusBadRequest, errCodeBadRequest, "URL must include scheme (http or https)")
+			return
+		}
+
+		// Only allow http and https schemes
+		if parsedURL.Scheme != "http" && parsedURL.Scheme != "https" {
+			writeError(w, http.StatusBadRequest, errCodeBadRequest, "only http and https URLs are allowed")
+			return
+		}
+
+		lines, err := fetchListLines(r.Context(), publicFetchClient, req.URL, nil)
+		if errors.Is(err, ErrPrivateAddress) {
+			writeError(w, http.StatusBadRequest, errCodeBadRequest, ErrPrivateAddress.Error())
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusBadRequest, errCodeBadRequest, "fetch failed: "+err.Error())
+			return
+		}
+		_ = json.NewEncoder(w).Encode(previewList(lines, existing))
+	}
 }
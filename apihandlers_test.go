@@ -0,0 +1,569 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// asUser sets the headers the auth middleware gives a signed-in caller
+func asUser(r *http.Request, mac string, admin, guest bool) *http.Request {
+	r.Header.Set("X-User-MAC", mac)
+	r.Header.Set("X-Is-Admin", map[bool]string{true: "true", false: "false"}[admin])
+	r.Header.Set("X-Is-Guest", map[bool]string{true: "true", false: "false"}[guest])
+	return r
+}
+
+func TestLogsOnlyShowCallersOwnDevices(t *testing.T) {
+	const (
+		macA, ipA = "aa:aa:aa:aa:aa:01", "192.168.50.11"
+		macB, ipB = "bb:bb:bb:bb:bb:02", "192.168.50.22"
+	)
+	ipMACCache.SetIPMAC(ipA, macA)
+	ipMACCache.SetIPMAC(ipB, macB)
+
+	bm := newTestBlocklistManager(t, nil)
+	bm.RecordQueryOfType("a-only.example", ipA+":40000", "A", false)
+	bm.RecordQueryOfType("b-secret.example", ipB+":40001", "A", false)
+	bm.RecordQueryOfType("b-secret2.example", ipB+":40002", "AAAA", false)
+
+	// the persistent log holds the same three queries for source=file
+	bm.logPath = filepath.Join(t.TempDir(), "logs.jsonl")
+	var file strings.Builder
+	for _, e := range bm.GetLogs(0) {
+		data, _ := json.Marshal(e)
+		file.Write(append(data, '\n'))
+	}
+	if err := os.WriteFile(bm.logPath, []byte(file.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		mac        string
+		admin      bool
+		guest      bool
+		wantSee    []string
+		wantHidden []string
+	}{
+		{"user A sees only A", macA, false, false, []string{"a-only.example"}, []string{"b-secret.example", "b-secret2.example"}},
+		{"user B sees only B", macB, false, false, []string{"b-secret.example", "b-secret2.example"}, []string{"a-only.example"}},
+		{"guest sees only its device", macA, false, true, []string{"a-only.example"}, []string{"b-secret.example"}},
+		{"session without a MAC sees nothing", "", false, false, nil, []string{"a-only.example", "b-secret.example"}},
+		{"admin sees everyone", macA, true, false, []string{"a-only.example", "b-secret.example", "b-secret2.example"}, nil},
+	}
+	for _, tt := range tests {
+		for _, target := range []string{"/logs", "/logs/export?format=json", "/logs/export?format=csv&source=file"} {
+			t.Run(tt.name+" "+target, func(t *testing.T) {
+				rec := httptest.NewRecorder()
+				r := asUser(httptest.NewRequest(http.MethodGet, target, nil), tt.mac, tt.admin, tt.guest)
+				if strings.HasPrefix(target, "/logs/export") {
+					handleLogsExport(rec, r, bm)
+				} else {
+					handleLogs(rec, r, bm, nil)
+				}
+				if rec.Code != http.StatusOK {
+					t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+				}
+				body := rec.Body.String()
+				for _, d := range tt.wantSee {
+					if !strings.Contains(body, d) {
+						t.Errorf("missing %s in %s", d, body)
+					}
+				}
+				for _, d := range tt.wantHidden {
+					if strings.Contains(body, d) {
+						t.Errorf("leaked %s in %s", d, body)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestGetLogsForAppliesLimitAfterFiltering(t *testing.T) {
+	ipMACCache.SetIPMAC("192.168.51.11", "aa:aa:aa:aa:aa:11")
+	bm := newTestBlocklistManager(t, nil)
+	bm.RecordQueryOfType("mine1.example", "192.168.51.11:1000", "A", false)
+	bm.RecordQueryOfType("mine2.example", "192.168.51.11:1001", "A", false)
+	for i := 0; i < 5; i++ {
+		bm.RecordQueryOfType("other.example", "192.168.51.99:1000", "A", false)
+	}
+	got := bm.GetLogsFor("aa:aa:aa:aa:aa:11", 1)
+	if len(got) != 1 || got[0].Domain != "mine2.example" {
+		t.Fatalf("GetLogsFor limit 1 = %+v, want the newest own entry", got)
+	}
+}
+
+func TestValidateRefusesPrivateTargets(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("internal.example\n"))
+	}))
+	defer upstream.Close()
+
+	bm := newTestBlocklistManager(t, nil)
+	h := handleValidate(bm)
+	tests := []struct {
+		name   string
+		guest  bool
+		url    string
+		status int
+	}{
+		{"loopback", false, upstream.URL, http.StatusBadRequest},
+		{"localhost name", false, strings.Replace(upstream.URL, "127.0.0.1", "localhost", 1), http.StatusBadRequest},
+		{"guest", true, upstream.URL, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := strings.NewReader(`{"url":"` + tt.url + `"}`)
+			r := asUser(httptest.NewRequest(http.MethodPost, "/validate", body), "aa:aa:aa:aa:aa:01", false, tt.guest)
+			w := httptest.NewRecorder()
+			h(w, r)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if strings.Contains(w.Body.String(), "internal.example") {
+				t.Fatalf("response leaks the internal list: %s", w.Body)
+			}
+		})
+	}
+}
+
+func TestValidateNeedsSession(t *testing.T) {
+	am := newTestAccountManager(t)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/validate", authMiddleware(am, handleValidate(newTestBlocklistManager(t, nil))))
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/validate", strings.NewReader(`{"url":"https://example.com/list.txt"}`)))
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestQueryEventsIngestion(t *testing.T) {
+	tests := []struct {
+		name      string
+		token     string
+		body      string
+		status    int
+		wantNames []string
+	}{
+		{"wrong token", "nope", `[{"domain":"a.example","client":"192.168.1.5:1"}]`, http.StatusUnauthorized, nil},
+		{"no token", "", `[]`, http.StatusUnauthorized, nil},
+		{"bad json", rustEventsToken, `{`, http.StatusBadRequest, nil},
+		{"too many", rustEventsToken, "[" + strings.Repeat(`{"domain":"a.example"},`, maxQueryEvents) + `{"domain":"a.example"}]`, http.StatusBadRequest, nil},
+		{"recorded", rustEventsToken, `[{"domain":"A.Example.","client":"192.168.1.5:1","qtype":"A"},{"domain":"","client":"x"},{"domain":"ads.example","client":"192.168.1.5:1","blocked":true}]`, http.StatusOK, []string{"a.example", "ads.example"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bm := newTestBlocklistManager(t, nil)
+			r := httptest.NewRequest(http.MethodPost, "/events/queries", strings.NewReader(tt.body))
+			if tt.token != "" {
+				r.Header.Set("X-Events-Token", tt.token)
+			}
+			w := httptest.NewRecorder()
+			handleQueryEvents(w, r, bm)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			var names []string
+			for _, e := range bm.GetLogs(10) {
+				names = append(names, e.Domain)
+			}
+			if !slices.Equal(names, tt.wantNames) {
+				t.Errorf("logged %v, want %v", names, tt.wantNames)
+			}
+		})
+	}
+}
+
+func TestListCreateStrict(t *testing.T) {
+	const mac = "aa:bb:cc:00:00:20"
+	bm := newTestBlocklistManager(t, nil)
+	am := newTestAccountManager(t, mac)
+	steps := []struct {
+		name   string
+		body   string
+		status int
+		want   []string // the list's entries afterwards
+	}{
+		{"create", `{"name":"ads","items":["a.example"]}`, http.StatusOK, []string{"a.example"}},
+		{"create again appends", `{"name":"ads","items":["b.example"]}`, http.StatusOK, []string{"a.example", "b.example"}},
+		{"strict create of an existing list", `{"name":"ads","items":["c.example"],"strict":true}`, http.StatusConflict, []string{"a.example", "b.example"}},
+		{"strict create of a new list", `{"name":"other","items":["d.example"],"strict":true}`, http.StatusOK, nil},
+	}
+	for _, st := range steps {
+		r := asUser(httptest.NewRequest(http.MethodPost, "/lists/create", strings.NewReader(st.body)), mac, false, false)
+		w := httptest.NewRecorder()
+		handleListCreate(w, r, bm, am)
+		if w.Code != st.status {
+			t.Fatalf("%s: status = %d, want %d: %s", st.name, w.Code, st.status, w.Body)
+		}
+		if st.want == nil {
+			continue
+		}
+		_, items, err := bm.ListDomains(mac+"_ads", 0, 100, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		// appending merges through a set, so entries come back in no set order
+		slices.Sort(items)
+		if !slices.Equal(items, st.want) {
+			t.Errorf("%s: list holds %v, want %v", st.name, items, st.want)
+		}
+	}
+	lists, err := am.GetUserBlocklists(mac)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(lists)
+	if want := []string{mac + "_ads", mac + "_other"}; !slices.Equal(lists, want) {
+		t.Errorf("associated lists = %v, want %v", lists, want)
+	}
+}
+
+func TestListRename(t *testing.T) {
+	const mac = "aa:bb:cc:00:00:30"
+	tests := []struct {
+		name    string
+		guest   bool
+		target  string
+		body    string
+		status  int
+		renamed bool
+	}{
+		{"renamed", false, "ads", `{"new_name":"Ad Servers"}`, http.StatusOK, true},
+		{"target exists", false, "ads", `{"new_name":"trackers"}`, http.StatusConflict, false},
+		{"missing list", false, "nope", `{"new_name":"fresh"}`, http.StatusNotFound, false},
+		{"unsafe new name", false, "ads", `{"new_name":"../../etc/passwd"}`, http.StatusBadRequest, false},
+		{"empty new name", false, "ads", `{"new_name":"  "}`, http.StatusBadRequest, false},
+		{"guest", true, "ads", `{"new_name":"fresh"}`, http.StatusForbidden, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withConfig(t, func(c *Config) { c.GuestPermissions = []string{guestViewLists} })
+			am := newTestAccountManager(t, mac)
+			bm := newTestBlocklistManager(t, map[string][]string{
+				mac + "_ads":      {"ads.example"},
+				mac + "_trackers": {"t.example"},
+			})
+			for _, l := range []string{mac + "_ads", mac + "_trackers"} {
+				if err := am.AddUserBlocklist(mac, l); err != nil {
+					t.Fatal(err)
+				}
+			}
+			bm.RecordListHit(mac+"_ads", "ads.example")
+			session := am.createSession(mac, tt.guest).ID
+
+			w := callAPI(newTestAPI(bm, am), http.MethodPost, "/lists/"+tt.target+"/rename", session, tt.body)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			oldName, newName := mac+"_ads", mac+"_Ad-Servers"
+			if bm.HasList(oldName) == tt.renamed || bm.HasList(newName) != tt.renamed {
+				t.Errorf("old list kept = %t, new list made = %t; want renamed=%t", bm.HasList(oldName), bm.HasList(newName), tt.renamed)
+			}
+			if !tt.renamed {
+				return
+			}
+			lists, _ := am.GetUserBlocklists(mac)
+			if !slices.Contains(lists, newName) || slices.Contains(lists, oldName) {
+				t.Errorf("associations = %v, want %s in place of %s", lists, newName, oldName)
+			}
+			if stats, err := bm.GetListStats(newName, 1); err != nil || stats.Matches != 1 {
+				t.Errorf("hit counts not carried over: %+v, %v", stats, err)
+			}
+		})
+	}
+}
+
+// countingStore counts the list writes and reloads going through a memStore
+type countingStore struct {
+	*memStore
+	writes, reloads int
+}
+
+func (s *countingStore) Write(name string, lines []string) error {
+	s.writes++
+	return s.memStore.Write(name, lines)
+}
+
+func (s *countingStore) Names() ([]string, error) {
+	s.reloads++
+	return s.memStore.Names()
+}
+
+func TestBulkRemoveDomains(t *testing.T) {
+	const mac = "aa:bb:cc:00:11:08"
+	entries := []string{"a.example", "b.example", "c.example", "d.example"}
+	tests := []struct {
+		name        string
+		guest       bool
+		list        string
+		body        string
+		status      int
+		wantRemoved int
+		wantLeft    []string
+	}{
+		{"several at once", false, "ads", `{"domains":["a.example","C.example","nope.example"]}`, http.StatusOK, 2, []string{"b.example", "d.example"}},
+		{"single form", false, "ads", `{"domain":"b.example"}`, http.StatusOK, 1, []string{"a.example", "c.example", "d.example"}},
+		{"single form, not in list", false, "ads", `{"domain":"nope.example"}`, http.StatusNotFound, 0, entries},
+		{"bulk, none in list", false, "ads", `{"domains":["nope.example"]}`, http.StatusOK, 0, entries},
+		{"empty domains", false, "ads", `{"domains":[]}`, http.StatusBadRequest, 0, entries},
+		{"missing list", false, "nope", `{"domains":["a.example"]}`, http.StatusNotFound, 0, entries},
+		{"guest", true, "ads", `{"domains":["a.example"]}`, http.StatusForbidden, 0, entries},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &countingStore{memStore: newMemStore(map[string][]string{mac + "_ads": entries})}
+			bm := newBlocklistManager(store)
+			if err := bm.LoadAll(); err != nil {
+				t.Fatal(err)
+			}
+			am := newTestAccountManager(t, mac)
+			if err := am.AddUserBlocklist(mac, mac+"_ads"); err != nil {
+				t.Fatal(err)
+			}
+			store.writes, store.reloads = 0, 0
+
+			r := asUser(httptest.NewRequest(http.MethodDelete, "/lists/items/"+tt.list, strings.NewReader(tt.body)), mac, false, tt.guest)
+			w := httptest.NewRecorder()
+			handleListItems(w, r, bm, am)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if tt.status == http.StatusOK {
+				var resp struct {
+					Removed int `json:"removed"`
+				}
+				if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+					t.Fatal(err)
+				}
+				if resp.Removed != tt.wantRemoved {
+					t.Errorf("removed = %d, want %d", resp.Removed, tt.wantRemoved)
+				}
+			}
+			// one rewrite and one reload however many entries go; none if nothing changed
+			wantOps := min(tt.wantRemoved, 1)
+			if store.writes != wantOps || store.reloads != wantOps {
+				t.Errorf("%d writes and %d reloads, want %d of each", store.writes, store.reloads, wantOps)
+			}
+			_, left, err := bm.ListDomains(mac+"_ads", 0, 100, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			slices.Sort(left)
+			if !slices.Equal(left, tt.wantLeft) {
+				t.Errorf("list holds %v, want %v", left, tt.wantLeft)
+			}
+		})
+	}
+}
+
+func TestAddSingleDomain(t *testing.T) {
+	const mac = "aa:bb:cc:00:11:55"
+	entries := []string{"a.example", "b.example"}
+	tests := []struct {
+		name      string
+		guest     bool
+		list      string
+		body      string
+		status    int
+		wantTotal int
+		wantLeft  []string
+	}{
+		{"added", false, "ads", `{"domain":"c.example"}`, http.StatusOK, 3, []string{"a.example", "b.example", "c.example"}},
+		{"normalized", false, "ads", `{"domain":" *.Track.Example. "}`, http.StatusOK, 3, []string{"*.track.example", "a.example", "b.example"}},
+		{"already listed", false, "ads", `{"domain":"A.example"}`, http.StatusConflict, 0, entries},
+		{"invalid", false, "ads", `{"domain":"bad domain.example"}`, http.StatusBadRequest, 0, entries},
+		{"missing domain", false, "ads", `{"domain":"  "}`, http.StatusBadRequest, 0, entries},
+		{"bad json", false, "ads", `{"domain":`, http.StatusBadRequest, 0, entries},
+		{"missing list", false, "nope", `{"domain":"c.example"}`, http.StatusNotFound, 0, entries},
+		{"guest", true, "ads", `{"domain":"c.example"}`, http.StatusForbidden, 0, entries},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bm := newTestBlocklistManager(t, map[string][]string{mac + "_ads": entries})
+			am := newTestAccountManager(t, mac)
+			r := asUser(httptest.NewRequest(http.MethodPost, "/lists/items/"+tt.list, strings.NewReader(tt.body)), mac, false, tt.guest)
+			w := httptest.NewRecorder()
+			handleListItems(w, r, bm, am)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if tt.status == http.StatusOK {
+				var resp struct {
+					Domain string `json:"domain"`
+					Total  int    `json:"total"`
+				}
+				if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+					t.Fatal(err)
+				}
+				if resp.Total != tt.wantTotal || !slices.Contains(tt.wantLeft, resp.Domain) {
+					t.Errorf("response %+v, want total %d and a domain from %v", resp, tt.wantTotal, tt.wantLeft)
+				}
+			}
+			_, left, err := bm.ListDomains(mac+"_ads", 0, 100, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			slices.Sort(left)
+			if !slices.Equal(left, tt.wantLeft) {
+				t.Errorf("list holds %v, want %v", left, tt.wantLeft)
+			}
+		})
+	}
+}
+
+func TestSearchAcrossUserLists(t *testing.T) {
+	const mac, other = "aa:bb:cc:00:11:09", "aa:bb:cc:00:11:10"
+	bm := newTestBlocklistManager(t, map[string][]string{
+		mac + "_ads":     {"ads.doubleclick.example", "banner.example"},
+		mac + "_track":   {"stats.doubleclick.example", "pixel.doubleclick.example"},
+		mac + "_misc":    {"unrelated.example"},
+		other + "_ads":   {"x.doubleclick.example"},
+		"unowned-global": {"y.doubleclick.example"},
+	})
+	am := newTestAccountManager(t, mac, other)
+	for _, l := range []string{"ads", "track", "misc"} {
+		if err := am.AddUserBlocklist(mac, mac+"_"+l); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := am.AddUserBlocklist(other, other+"_ads"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		query     string
+		status    int
+		wantTotal int
+		want      map[string][]string // list to the entries returned
+	}{
+		{"in two lists", "?q=doubleclick", http.StatusOK, 3, map[string][]string{
+			"ads":   {"ads.doubleclick.example"},
+			"track": {"stats.doubleclick.example", "pixel.doubleclick.example"},
+		}},
+		{"paged per list", "?q=doubleclick&limit=1", http.StatusOK, 3, map[string][]string{
+			"ads":   {"ads.doubleclick.example"},
+			"track": {"stats.doubleclick.example"},
+		}},
+		{"second page", "?q=doubleclick&offset=1", http.StatusOK, 3, map[string][]string{
+			"ads":   {},
+			"track": {"pixel.doubleclick.example"},
+		}},
+		{"no match", "?q=nothing-here", http.StatusOK, 0, map[string][]string{}},
+		{"missing q", "?q=+", http.StatusBadRequest, 0, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := asUser(httptest.NewRequest(http.MethodGet, "/search"+tt.query, nil), mac, false, false)
+			w := httptest.NewRecorder()
+			handleSearch(w, r, bm, am)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			var resp struct {
+				Total int                `json:"total"`
+				Lists []listSearchResult `json:"lists"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Total != tt.wantTotal {
+				t.Errorf("total = %d, want %d", resp.Total, tt.wantTotal)
+			}
+			got := make(map[string][]string)
+			for _, l := range resp.Lists {
+				got[l.List] = l.Items
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("lists = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLogsExportIncludesBlockReason(t *testing.T) {
+	bm := newTestBlocklistManager(t, nil)
+	bm.RecordBlockedQuery("ads.example", "192.168.71.24:1000", "A", MatchDetail{List: "ads", Pattern: "*.example"}, "nx")
+	bm.RecordQueryOfType("fine.example", "192.168.71.24:1001", "A", false)
+	tests := []struct {
+		format string
+		want   []string
+	}{
+		{"csv", []string{"time,client,domain,qtype,blocked,reason,mode\n", ",ads.example,A,true,ads: *.example,nx\n", ",fine.example,A,false,,\n"}},
+		{"json", []string{`"reason":"ads: *.example","mode":"nx"`}},
+	}
+	for _, tt := range tests {
+		r := asUser(httptest.NewRequest(http.MethodGet, "/logs/export?format="+tt.format, nil), "aa:00:00:00:11:23", true, false)
+		w := httptest.NewRecorder()
+		handleLogsExport(w, r, bm)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: status %d: %s", tt.format, w.Code, w.Body)
+		}
+		for _, s := range tt.want {
+			if !strings.Contains(w.Body.String(), s) {
+				t.Errorf("%s export lacks %q:\n%s", tt.format, s, w.Body)
+			}
+		}
+	}
+	// allowed queries carry neither field
+	for _, e := range bm.GetLogs(2) {
+		if e.Blocked {
+			continue
+		}
+		b, err := json.Marshal(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(b), `"reason"`) || strings.Contains(string(b), `"mode"`) {
+			t.Errorf("allowed query encoded as %s", b)
+		}
+	}
+}
+
+func TestAnalyticsReset(t *testing.T) {
+	const admin, user = "aa:00:00:00:11:71", "aa:00:00:00:11:72"
+	withConfig(t, func(c *Config) { c.AdminMACs = []string{admin}; c.BcryptCost = 4 })
+	bm := newTestBlocklistManager(t, nil)
+	am := newTestAccountManager(t, admin, user)
+	mux := newTestAPI(bm, am)
+	adminSession := am.createSession(admin, false).ID
+	userSession := am.createSession(user, false).ID
+	bm.RecordQueryOfType("ads.example", "192.168.172.1:1000", "A", true)
+
+	// each step runs against the counts the previous one left
+	tests := []struct {
+		name, method, target, session string
+		status                        int
+		wantQueries                   int
+	}{
+		{"not an admin", http.MethodPost, "/analytics/reset", userSession, http.StatusForbidden, 1},
+		{"GET", http.MethodGet, "/analytics/reset", adminSession, http.StatusMethodNotAllowed, 1},
+		{"partial reset refused", http.MethodPost, "/analytics/reset?since=2026-01-01T00:00:00Z", adminSession, http.StatusBadRequest, 1},
+		{"reset", http.MethodPost, "/analytics/reset", adminSession, http.StatusOK, 0},
+	}
+	for _, tt := range tests {
+		w := callAPI(mux, tt.method, tt.target, tt.session, "")
+		if w.Code != tt.status {
+			t.Errorf("%s: status %d, want %d: %s", tt.name, w.Code, tt.status, w.Body)
+		}
+		if got := bm.GetStats().Queries; got != tt.wantQueries {
+			t.Errorf("%s: %d queries counted, want %d", tt.name, got, tt.wantQueries)
+		}
+	}
+	if n := len(bm.GetLogs(10)); n != 1 {
+		t.Errorf("%d log entries after reset, want the logs kept", n)
+	}
+}
@@ -2,20 +2,35 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"net/http"
 	"log"
+	"log/slog"
+	"strconv"
 )
 
-// StartAuthAPIServer starts API endpoints for account management
-func StartAuthAPIServer(am *AccountManager, addr string) error {
+// StartAPI serves the account, list, analytics and control endpoints on a single
+// mux so the frontend only needs to know about one backend address.
+func StartAPI(bm *BlocklistManager, am *AccountManager, addr string) error {
 	mux := http.NewServeMux()
+	registerAuthRoutes(mux, bm, am)
+	registerAPIRoutes(mux, bm, am)
 
+	if !isLoopbackBind(addr) {
+		slog.Warn("API is listening beyond loopback; sign-in and /health are reachable from the network", "addr", addr)
+	}
+	log.Printf("API server starting on %s", addr)
+	return http.ListenAndServe(addr, logRequests(limitRequestBody(mux)))
+}
+
+// registerAuthRoutes mounts the /auth/* account management endpoints. bm is used
+// to seed default lists for new accounts.
+func registerAuthRoutes(mux *http.ServeMux, bm *BlocklistManager, am *AccountManager) {
 	// Account setup/check endpoint
 	mux.HandleFunc("/auth/check", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
-			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			writeError(w, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "method not allowed")
 			return
 		}
 
@@ -23,19 +38,16 @@ func StartAuthAPIServer(am *AccountManager, addr string) error {
 			MACAddress string `json:"mac_address"`
 		}
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			http.Error(w, "invalid request", http.StatusBadRequest)
+			writeError(w, http.StatusBadRequest, errCodeBadRequest, "invalid request")
 			return
 		}
 
-		if req.MACAddress == "" {
-			// Try to detect MAC from request
-			mac, err := GetClientMAC(r)
-			if err != nil {
-				http.Error(w, "could not determine MAC address", http.StatusBadRequest)
-				return
-			}
-			req.MACAddress = mac
+		mac, err := resolveClientMAC(r, req.MACAddress)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, errCodeBadRequest, err.Error())
+			return
 		}
+		req.MACAddress = mac
 
 		// Cache IP to MAC mapping
 		clientIP := getClientIP(r)
@@ -43,7 +55,7 @@ func StartAuthAPIServer(am *AccountManager, addr string) error {
 
 		exists, err := am.AccountExists(req.MACAddress)
 		if err != nil {
-			http.Error(w, "database error", http.StatusInternalServerError)
+			writeError(w, http.StatusInternalServerError, errCodeInternal, "database error")
 			return
 		}
 
@@ -58,7 +70,7 @@ func StartAuthAPIServer(am *AccountManager, addr string) error {
 	// Create account
 	mux.HandleFunc("/auth/create", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
-			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			writeError(w, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "method not allowed")
 			return
 		}
 
@@ -67,34 +79,38 @@ func StartAuthAPIServer(am *AccountManager, addr string) error {
 			Passcode   string `json:"passcode"`
 		}
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			http.Error(w, "invalid request", http.StatusBadRequest)
+			writeError(w, http.StatusBadRequest, errCodeBadRequest, "invalid request")
 			return
 		}
 
-		if req.MACAddress == "" {
-			mac, err := GetClientMAC(r)
-			if err != nil {
-				http.Error(w, "could not determine MAC address", http.StatusBadRequest)
-				return
-			}
-			req.MACAddress = mac
+		mac, err := resolveClientMAC(r, req.MACAddress)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, errCodeBadRequest, err.Error())
+			return
 		}
+		req.MACAddress = mac
 
 		// Cache IP to MAC mapping
 		clientIP := getClientIP(r)
 		ipMACCache.SetIPMAC(clientIP, req.MACAddress)
 
 		if req.Passcode == "" {
-			http.Error(w, "passcode is required", http.StatusBadRequest)
+			writeError(w, http.StatusBadRequest, errCodeBadRequest, "passcode is required")
 			return
 		}
 
 		if err := am.CreateAccount(req.MACAddress, req.Passcode); err != nil {
-			log.Printf("Failed to create account: %v", err)
-			http.Error(w, fmt.Sprintf("failed to create account: %v", err), http.StatusInternalServerError)
+			if errors.Is(err, ErrWeakPasscode) {
+				writeError(w, http.StatusBadRequest, errCodeBadRequest, err.Error())
+				return
+			}
+			slog.Error("failed to create account", "mac", req.MACAddress, "err", err)
+			writeError(w, http.StatusInternalServerError, errCodeInternal, fmt.Sprintf("failed to create account: %v", err))
 			return
 		}
 
+		seedDefaultLists(bm, am, req.MACAddress)
+
 		// Create session after account creation
 		session := am.createSession(req.MACAddress, false)
 
@@ -109,7 +125,7 @@ func StartAuthAPIServer(am *AccountManager, addr string) error {
 	// Login
 	mux.HandleFunc("/auth/login", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
-			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			writeError(w, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "method not allowed")
 			return
 		}
 
@@ -118,18 +134,16 @@ func StartAuthAPIServer(am *AccountManager, addr string) error {
 			Passcode   string `json:"passcode"`
 		}
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			http.Error(w, "invalid request", http.StatusBadRequest)
+			writeError(w, http.StatusBadRequest, errCodeBadRequest, "invalid request")
 			return
 		}
 
-		if req.MACAddress == "" {
-			mac, err := GetClientMAC(r)
-			if err != nil {
-				http.Error(w, "could not determine MAC address", http.StatusBadRequest)
-				return
-			}
-			req.MACAddress = mac
+		mac, err := resolveClientMAC(r, req.MACAddress)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, errCodeBadRequest, err.Error())
+			return
 		}
+		req.MACAddress = mac
 
 		// Cache IP to MAC mapping
 		clientIP := getClientIP(r)
@@ -137,8 +151,8 @@ func StartAuthAPIServer(am *AccountManager, addr string) error {
 
 		session, err := am.Authenticate(req.MACAddress, req.Passcode)
 		if err != nil {
-			log.Printf("Authentication failed for MAC %s: %v", req.MACAddress, err)
-			http.Error(w, "authentication failed", http.StatusUnauthorized)
+			slog.Warn("authentication failed", "mac", req.MACAddress, "err", err)
+			writeError(w, http.StatusUnauthorized, errCodeUnauthorized, "authentication failed")
 			return
 		}
 
@@ -153,7 +167,12 @@ func StartAuthAPIServer(am *AccountManager, addr string) error {
 	// Guest login
 	mux.HandleFunc("/auth/guest", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
-			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			writeError(w, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "method not allowed")
+			return
+		}
+
+		if !AppConfig().GuestAccessEnabled() {
+			writeError(w, http.StatusForbidden, errCodeForbidden, "guest access is disabled")
 			return
 		}
 
@@ -161,18 +180,16 @@ func StartAuthAPIServer(am *AccountManager, addr string) error {
 			MACAddress string `json:"mac_address"`
 		}
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			http.Error(w, "invalid request", http.StatusBadRequest)
+			writeError(w, http.StatusBadRequest, errCodeBadRequest, "invalid request")
 			return
 		}
 
-		if req.MACAddress == "" {
-			mac, err := GetClientMAC(r)
-			if err != nil {
-				http.Error(w, "could not determine MAC address", http.StatusBadRequest)
-				return
-			}
-			req.MACAddress = mac
+		mac, err := resolveClientMAC(r, req.MACAddress)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, errCodeBadRequest, err.Error())
+			return
 		}
+		req.MACAddress = mac
 
 		// Cache IP to MAC mapping
 		clientIP := getClientIP(r)
@@ -191,7 +208,7 @@ func StartAuthAPIServer(am *AccountManager, addr string) error {
 	// Logout
 	mux.HandleFunc("/auth/logout", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
-			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			writeError(w, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "method not allowed")
 			return
 		}
 
@@ -199,7 +216,7 @@ func StartAuthAPIServer(am *AccountManager, addr string) error {
 			SessionID string `json:"session_id"`
 		}
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			http.Error(w, "invalid request", http.StatusBadRequest)
+			writeError(w, http.StatusBadRequest, errCodeBadRequest, "invalid request")
 			return
 		}
 
@@ -214,7 +231,7 @@ func StartAuthAPIServer(am *AccountManager, addr string) error {
 	// Verify session
 	mux.HandleFunc("/auth/verify", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
-			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			writeError(w, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "method not allowed")
 			return
 		}
 
@@ -222,13 +239,13 @@ func StartAuthAPIServer(am *AccountManager, addr string) error {
 			SessionID string `json:"session_id"`
 		}
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			http.Error(w, "invalid request", http.StatusBadRequest)
+			writeError(w, http.StatusBadRequest, errCodeBadRequest, "invalid request")
 			return
 		}
 
 		session, err := am.GetSession(req.SessionID)
 		if err != nil {
-			http.Error(w, "invalid session", http.StatusUnauthorized)
+			writeError(w, http.StatusUnauthorized, errCodeUnauthorized, "invalid session")
 			return
 		}
 
@@ -237,13 +254,46 @@ func StartAuthAPIServer(am *AccountManager, addr string) error {
 			"valid":       true,
 			"is_guest":    session.IsGuest,
 			"mac_address": session.MACAddress,
+			"is_admin":    am.IsAdmin(session),
+			"expires_at":  session.ExpiresAt,
+		})
+	})
+
+	// Refresh session - extends expiry of a still-valid session
+	mux.HandleFunc("/auth/refresh", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "method not allowed")
+			return
+		}
+
+		var req struct {
+			SessionID string `json:"session_id"`
+			Rotate    bool   `json:"rotate"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, errCodeBadRequest, "invalid request")
+			return
+		}
+
+		session, err := am.RefreshSession(req.SessionID, req.Rotate)
+		if err != nil {
+			writeError(w, http.StatusUnauthorized, errCodeUnauthorized, "invalid session")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":    true,
+			"session_id": session.ID,
+			"is_guest":   session.IsGuest,
+			"expires_at": session.ExpiresAt,
 		})
 	})
 
 	// Change passcode
 	mux.HandleFunc("/auth/change-passcode", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
-			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			writeError(w, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "method not allowed")
 			return
 		}
 
@@ -253,24 +303,24 @@ func StartAuthAPIServer(am *AccountManager, addr string) error {
 			NewPasscode string `json:"new_passcode"`
 		}
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			http.Error(w, "invalid request", http.StatusBadRequest)
+			writeError(w, http.StatusBadRequest, errCodeBadRequest, "invalid request")
 			return
 		}
 
 		session, err := am.GetSession(req.SessionID)
 		if err != nil {
-			http.Error(w, "invalid session", http.StatusUnauthorized)
+			writeError(w, http.StatusUnauthorized, errCodeUnauthorized, "invalid session")
 			return
 		}
 
 		if session.IsGuest {
-			http.Error(w, "guests cannot change passcode", http.StatusForbidden)
+			writeError(w, http.StatusForbidden, errCodeForbidden, "guests cannot change passcode")
 			return
 		}
 
 		if err := am.ChangePasscode(session.MACAddress, req.OldPasscode, req.NewPasscode); err != nil {
-			log.Printf("Failed to change passcode: %v", err)
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			slog.Warn("failed to change passcode", "mac", session.MACAddress, "err", err)
+			writeError(w, http.StatusBadRequest, errCodeBadRequest, err.Error())
 			return
 		}
 
@@ -281,8 +331,74 @@ func StartAuthAPIServer(am *AccountManager, addr string) error {
 		})
 	})
 
-	log.Printf("Auth API server starting on %s", addr)
-	return http.ListenAndServe(addr, mux)
+	// List (GET) or revoke (DELETE ?ref=) the caller's own sessions
+	mux.HandleFunc("/auth/sessions", authMiddleware(am, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Is-Guest") == "true" {
+			writeError(w, http.StatusForbidden, errCodeForbidden, "guests cannot manage sessions")
+			return
+		}
+		mac := r.Header.Get("X-User-MAC")
+
+		switch r.Method {
+		case http.MethodGet:
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"sessions": am.SessionsFor(mac, r.Header.Get("X-Session-ID")),
+			})
+		case http.MethodDelete:
+			ref := r.URL.Query().Get("ref")
+			if ref == "" {
+				writeError(w, http.StatusBadRequest, errCodeBadRequest, "ref is required")
+				return
+			}
+			if err := am.RevokeSession(mac, ref); err != nil {
+				if errors.Is(err, ErrSessionNotFound) {
+					writeError(w, http.StatusNotFound, errCodeNotFound, err.Error())
+					return
+				}
+				writeError(w, http.StatusInternalServerError, errCodeInternal, err.Error())
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"success": true,
+			})
+		default:
+			writeError(w, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "method not allowed")
+		}
+	}))
+
+	// List accounts - admins only
+	mux.HandleFunc("/auth/accounts", adminMiddleware(am, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "method not allowed")
+			return
+		}
+
+		offset := 0
+		limit := 100
+		if v, err := strconv.Atoi(r.URL.Query().Get("offset")); err == nil && v >= 0 {
+			offset = v
+		}
+		if v, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && v > 0 && v <= 1000 {
+			limit = v
+		}
+
+		accounts, total, err := am.ListAccounts(offset, limit)
+		if err != nil {
+			slog.Error("failed to list accounts", "err", err)
+			writeError(w, http.StatusInternalServerError, errCodeInternal, "database error")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"total":    total,
+			"offset":   offset,
+			"limit":    limit,
+			"accounts": accounts,
+		})
+	}))
 }
 
 // authMiddleware checks for valid session and adds user info to request context
@@ -291,123 +407,235 @@ func authMiddleware(am *AccountManager, next http.HandlerFunc) http.HandlerFunc
 		// Get session ID from header
 		sessionID := r.Header.Get("X-Session-ID")
 		if sessionID == "" {
-			http.Error(w, "missing session", http.StatusUnauthorized)
+			writeError(w, http.StatusUnauthorized, errCodeUnauthorized, "missing session")
 			return
 		}
 
 		session, err := am.GetSession(sessionID)
 		if err != nil {
-			http.Error(w, "invalid or expired session", http.StatusUnauthorized)
+			writeError(w, http.StatusUnauthorized, errCodeUnauthorized, "invalid or expired session")
 			return
 		}
 
 		// Add session info to headers for downstream handlers
 		r.Header.Set("X-User-MAC", session.MACAddress)
 		r.Header.Set("X-Is-Guest", fmt.Sprintf("%t", session.IsGuest))
+		r.Header.Set("X-Is-Admin", fmt.Sprintf("%t", am.IsAdmin(session)))
 
 		next(w, r)
 	}
 }
 
-// guestAllowedMiddleware checks session and allows guests for read-only operations
-func guestAllowedMiddleware(am *AccountManager, next http.HandlerFunc) http.HandlerFunc {
+// adminMiddleware checks for a valid session belonging to an administrator
+func adminMiddleware(am *AccountManager, next http.HandlerFunc) http.HandlerFunc {
+	return authMiddleware(am, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Is-Admin") != "true" {
+			writeError(w, http.StatusForbidden, errCodeForbidden, "admin access required")
+			return
+		}
+		next(w, r)
+	})
+}
+
+// guestAllowedMiddleware checks session and allows guests read-only access when
+// AppConfig.GuestPermissions grants perm
+func guestAllowedMiddleware(am *AccountManager, perm string, next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		sessionID := r.Header.Get("X-Session-ID")
 		if sessionID == "" {
-			http.Error(w, "missing session", http.StatusUnauthorized)
+			writeError(w, http.StatusUnauthorized, errCodeUnauthorized, "missing session")
 			return
 		}
 
 		session, err := am.GetSession(sessionID)
 		if err != nil {
-			http.Error(w, "invalid or expired session", http.StatusUnauthorized)
+			writeError(w, http.StatusUnauthorized, errCodeUnauthorized, "invalid or expired session")
+			return
+		}
+
+		if session.IsGuest && !AppConfig().GuestCan(perm) {
+			writeError(w, http.StatusForbidden, errCodeForbidden, "guests can't view this")
 			return
 		}
 
 		// Check if guest is trying to modify (allow GET, HEAD, OPTIONS for guests)
 		if session.IsGuest && r.Method != http.MethodGet && r.Method != http.MethodHead && r.Method != http.MethodOptions {
-			http.Error(w, "guests can only view, not modify", http.StatusForbidden)
+			writeError(w, http.StatusForbidden, errCodeForbidden, "guests can only view, not modify")
 			return
 		}
 
 		r.Header.Set("X-User-MAC", session.MACAddress)
 		r.Header.Set("X-Is-Guest", fmt.Sprintf("%t", session.IsGuest))
+		r.Header.Set("X-Is-Admin", fmt.Sprintf("%t", am.IsAdmin(session)))
 
 		next(w, r)
 	}
 }
 
-// StartInternalAPIServerWithAuth starts the internal API with authentication
-func StartInternalAPIServerWithAuth(bm *BlocklistManager, am *AccountManager) error {
-	addr := "127.0.0.1:8081"
-	mux := http.NewServeMux()
-
+// registerAPIRoutes mounts the list, analytics and control endpoints with the
+// middleware each route needs
+func registerAPIRoutes(mux *http.ServeMux, bm *BlocklistManager, am *AccountManager) {
 	// Wrap handlers with authentication middleware
 	// For lists operations, use guestAllowedMiddleware to allow read-only guest access
 	
 	// Lists endpoints - guests can view
-	mux.HandleFunc("/lists/create", guestAllowedMiddleware(am, func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/lists/create", guestAllowedMiddleware(am, guestViewLists, func(w http.ResponseWriter, r *http.Request) {
 		handleListCreate(w, r, bm, am)
 	}))
 
-	mux.HandleFunc("/lists/items/", guestAllowedMiddleware(am, func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/lists/items/", guestAllowedMiddleware(am, guestViewLists, func(w http.ResponseWriter, r *http.Request) {
 		handleListItems(w, r, bm, am)
 	}))
 
-	mux.HandleFunc("/lists/", guestAllowedMiddleware(am, func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/lists/", guestAllowedMiddleware(am, guestViewLists, func(w http.ResponseWriter, r *http.Request) {
 		handleLists(w, r, bm, am)
 	}))
 
+	// Search across the user's lists - guests can view
+	mux.HandleFunc("/search", guestAllowedMiddleware(am, guestViewLists, func(w http.ResponseWriter, r *http.Request) {
+		handleSearch(w, r, bm, am)
+	}))
+	mux.HandleFunc("/check", guestAllowedMiddleware(am, guestViewLists, func(w http.ResponseWriter, r *http.Request) {
+		handleCheck(w, r, bm, am)
+	}))
+
+	// Global lists - guests can view, admins manage (checked in the handler)
+	mux.HandleFunc("/global/lists", guestAllowedMiddleware(am, guestViewLists, func(w http.ResponseWriter, r *http.Request) {
+		handleGlobalLists(w, r, bm)
+	}))
+	mux.HandleFunc("/global/lists/", guestAllowedMiddleware(am, guestViewLists, func(w http.ResponseWriter, r *http.Request) {
+		handleGlobalLists(w, r, bm)
+	}))
+
+	// Categories - guests can view
+	mux.HandleFunc("/categories", guestAllowedMiddleware(am, guestViewLists, func(w http.ResponseWriter, r *http.Request) {
+		handleCategories(w, r, bm, am)
+	}))
+	mux.HandleFunc("/categories/", guestAllowedMiddleware(am, guestViewLists, func(w http.ResponseWriter, r *http.Request) {
+		handleCategories(w, r, bm, am)
+	}))
+
 	// Analytics - guests can view
-	mux.HandleFunc("/analytics", guestAllowedMiddleware(am, func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/analytics", guestAllowedMiddleware(am, guestViewAnalytics, func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
-			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			writeError(w, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "method not allowed")
 			return
 		}
 		
-		// Filter analytics by user's MAC address if not guest
-		isGuest := r.Header.Get("X-Is-Guest") == "true"
+		// Admins see network-wide stats; everyone else only their own devices
 		userMAC := r.Header.Get("X-User-MAC")
-		
-		s := bm.GetStats()
-		
-		// If not a guest, could filter stats by user - for now return all
-		// In a production system, you'd track per-user analytics
-		if !isGuest && userMAC != "" {
-			// Future: filter by user
+		var stats StatsSnapshot
+		if r.Header.Get("X-Is-Admin") == "true" && r.URL.Query().Get("scope") != "self" {
+			stats = bm.GetStats()
+		} else {
+			stats = bm.GetStatsForUser(userMAC)
+		}
+		if r.URL.Query().Get("labels") == "true" {
+			if names, err := am.DeviceNames(); err == nil {
+				stats.ClientLabels = make(map[string]string)
+				for client := range stats.ClientHits {
+					if label := deviceLabel(names, client); label != "" {
+						stats.ClientLabels[client] = label
+					}
+				}
+			} else {
+				slog.Error("failed to load device names", "err", err)
+			}
 		}
-		
-		_ = json.NewEncoder(w).Encode(s)
+		_ = json.NewEncoder(w).Encode(stats)
+	}))
+	mux.HandleFunc("/analytics/top", guestAllowedMiddleware(am, guestViewAnalytics, func(w http.ResponseWriter, r *http.Request) {
+		handleAnalyticsTop(w, r, bm)
+	}))
+	mux.HandleFunc("/analytics/reset", adminMiddleware(am, func(w http.ResponseWriter, r *http.Request) {
+		handleAnalyticsReset(w, r, bm)
 	}))
 
 	// Logs - guests can view
-	mux.HandleFunc("/logs", guestAllowedMiddleware(am, func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/logs", guestAllowedMiddleware(am, guestViewLogs, func(w http.ResponseWriter, r *http.Request) {
 		handleLogs(w, r, bm, am)
 	}))
+	mux.HandleFunc("/logs/export", guestAllowedMiddleware(am, guestViewLogs, func(w http.ResponseWriter, r *http.Request) {
+		handleLogsExport(w, r, bm)
+	}))
+
+	// Device labels - guests can view, admins edit (checked in the handler)
+	mux.HandleFunc("/devices", guestAllowedMiddleware(am, guestViewDevices, func(w http.ResponseWriter, r *http.Request) {
+		handleDevices(w, r, am)
+	}))
+	mux.HandleFunc("/devices/", guestAllowedMiddleware(am, guestViewDevices, func(w http.ResponseWriter, r *http.Request) {
+		handleDevices(w, r, am)
+	}))
+
+	// Device inventory - admins only
+	mux.HandleFunc("/clients", adminMiddleware(am, func(w http.ResponseWriter, r *http.Request) {
+		handleClients(w, r, bm, am)
+	}))
+
+	// Local DNS records - guests can view, admins edit (checked in the handler)
+	mux.HandleFunc("/records", guestAllowedMiddleware(am, guestViewDevices, func(w http.ResponseWriter, r *http.Request) {
+		handleRecords(w, r, am)
+	}))
+	mux.HandleFunc("/records/", guestAllowedMiddleware(am, guestViewDevices, func(w http.ResponseWriter, r *http.Request) {
+		handleRecords(w, r, am)
+	}))
+
+	// Safe search preference - guests can view
+	mux.HandleFunc("/settings/safesearch", guestAllowedMiddleware(am, guestViewSettings, func(w http.ResponseWriter, r *http.Request) {
+		handleSafeSearch(w, r, am)
+	}))
+
+	// Blocking kill-switch - anyone signed in can see the status, only admins can toggle it
+	mux.HandleFunc("/blocking/status", guestAllowedMiddleware(am, guestViewSettings, handleBlocking))
+	mux.HandleFunc("/blocking/enable", adminMiddleware(am, handleBlocking))
+	mux.HandleFunc("/blocking/disable", adminMiddleware(am, handleBlocking))
 
 	// Reload - authenticated users only
 	mux.HandleFunc("/reload", authMiddleware(am, func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
-			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			writeError(w, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "method not allowed")
 			return
 		}
 		
 		isGuest := r.Header.Get("X-Is-Guest") == "true"
 		if isGuest {
-			http.Error(w, "guests cannot reload", http.StatusForbidden)
+			writeError(w, http.StatusForbidden, errCodeForbidden, "guests cannot reload")
 			return
 		}
 		
-		if err := bm.LoadAll(); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+		report, err := bm.Reload()
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, errCodeInternal, err.Error())
 			return
 		}
-		io.WriteString(w, "reloaded\n")
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(report)
 	}))
 
-	// Validate - no auth required
-	mux.HandleFunc("/validate", handleValidate(bm))
+	// Maintenance - admins only
+	mux.HandleFunc("/maintenance/prune", adminMiddleware(am, func(w http.ResponseWriter, r *http.Request) {
+		handlePrune(w, r, bm, am)
+	}))
+
+	// Matcher and upstream timings - admins only
+	mux.HandleFunc("/metrics", adminMiddleware(am, handleMetrics))
+
+	// Backup and restore - admins only
+	mux.HandleFunc("/backup", adminMiddleware(am, func(w http.ResponseWriter, r *http.Request) {
+		handleBackup(w, r, bm, am)
+	}))
+	mux.HandleFunc("/restore", adminMiddleware(am, func(w http.ResponseWriter, r *http.Request) {
+		handleRestore(w, r, bm, am)
+	}))
+
+	// Query events from the rust backend - authenticated by a per-run token
+	mux.HandleFunc("/events/queries", func(w http.ResponseWriter, r *http.Request) {
+		handleQueryEvents(w, r, bm)
+	})
+
+	// Health - no auth required
+	mux.HandleFunc("/health", handleHealth)
 
-	log.Printf("Internal API server with auth starting on %s", addr)
-	return http.ListenAndServe(addr, mux)
+	// Validate - requires a session; guests are refused in the handler
+	mux.HandleFunc("/validate", authMiddleware(am, handleValidate(bm)))
 }
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// newTestAPI mounts every API route over bm and am
+func newTestAPI(bm *BlocklistManager, am *AccountManager) *http.ServeMux {
+	mux := http.NewServeMux()
+	registerAuthRoutes(mux, bm, am)
+	registerAPIRoutes(mux, bm, am)
+	return mux
+}
+
+// callAPI sends mux a request with body under sessionID (none when empty)
+func callAPI(mux http.Handler, method, target, sessionID, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	if sessionID != "" {
+		r.Header.Set("X-Session-ID", sessionID)
+	}
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, r)
+	return w
+}
+
+func TestListAccountsAdminOnly(t *testing.T) {
+	const admin, user, idle = "aa:00:00:00:00:01", "aa:00:00:00:00:02", "aa:00:00:00:00:03"
+	withConfig(t, func(c *Config) { c.AdminMACs = []string{admin}; c.BcryptCost = 4 })
+	am := newTestAccountManager(t, admin, user, idle)
+	if err := am.AddUserBlocklist(user, user+"_ads"); err != nil {
+		t.Fatal(err)
+	}
+	mux := newTestAPI(newTestBlocklistManager(t, nil), am)
+	adminSession := am.createSession(admin, false).ID
+	userSession := am.createSession(user, false).ID
+	guestSession := am.CreateGuestSession(idle).ID
+
+	tests := []struct {
+		name      string
+		session   string
+		query     string
+		status    int
+		wantMACs  []string
+		wantTotal int
+	}{
+		{"no session", "", "", http.StatusUnauthorized, nil, 0},
+		{"guest", guestSession, "", http.StatusForbidden, nil, 0},
+		{"regular user", userSession, "", http.StatusForbidden, nil, 0},
+		{"admin", adminSession, "", http.StatusOK, []string{admin, user, idle}, 3},
+		{"admin, paged", adminSession, "?offset=1&limit=1", http.StatusOK, []string{user}, 3},
+		{"admin, bad paging falls back", adminSession, "?offset=-1&limit=5000", http.StatusOK, []string{admin, user, idle}, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := callAPI(mux, http.MethodGet, "/auth/accounts"+tt.query, tt.session, "")
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			var resp struct {
+				Total    int              `json:"total"`
+				Accounts []AccountSummary `json:"accounts"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Total != tt.wantTotal {
+				t.Errorf("total = %d, want %d", resp.Total, tt.wantTotal)
+			}
+			var macs []string
+			for _, a := range resp.Accounts {
+				macs = append(macs, a.MACAddress)
+				if want := a.MACAddress != idle; a.ActiveSession != want {
+					t.Errorf("%s active_session = %t, want %t (guest sessions don't count)", a.MACAddress, a.ActiveSession, want)
+				}
+				if want := map[bool]int{true: 1}[a.MACAddress == user]; a.BlocklistCount != want {
+					t.Errorf("%s blocklist_count = %d, want %d", a.MACAddress, a.BlocklistCount, want)
+				}
+			}
+			if !slices.Equal(macs, tt.wantMACs) {
+				t.Errorf("accounts = %v, want %v", macs, tt.wantMACs)
+			}
+		})
+	}
+}
+
+func TestAuthRejectsMalformedMAC(t *testing.T) {
+	withConfig(t, func(c *Config) { c.BcryptCost = 4 })
+	am := newTestAccountManager(t)
+	mux := newTestAPI(newTestBlocklistManager(t, nil), am)
+	for _, target := range []string{"/auth/check", "/auth/create", "/auth/login", "/auth/guest"} {
+		for _, mac := range []string{"aa:bb:cc", "not-a-mac", "aa:bb:cc:dd:ee:ff:00:11", "ip:999.1.1.1"} {
+			body := `{"mac_address":"` + mac + `","passcode":"` + testPasscode + `"}`
+			w := callAPI(mux, http.MethodPost, target, "", body)
+			if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "invalid MAC address") {
+				t.Errorf("POST %s with %q: status %d: %s", target, mac, w.Code, w.Body)
+			}
+		}
+	}
+	// nothing was created along the way
+	if _, total, err := am.ListAccounts(0, 10); err != nil || total != 0 {
+		t.Errorf("%d accounts (%v), want none", total, err)
+	}
+}
+
+func TestSessionsAPI(t *testing.T) {
+	const mac = "aa:bb:cc:00:11:62"
+	withConfig(t, func(c *Config) { c.BcryptCost = 4 })
+	am := newTestAccountManager(t, mac)
+	mux := newTestAPI(newTestBlocklistManager(t, nil), am)
+	current, old := am.createSession(mac, false).ID, am.createSession(mac, false).ID
+	guest := am.CreateGuestSession("aa:bb:cc:00:11:63").ID
+
+	list := func() []SessionInfo {
+		t.Helper()
+		w := callAPI(mux, http.MethodGet, "/auth/sessions", current, "")
+		var resp struct{ Sessions []SessionInfo }
+		if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &resp) != nil {
+			t.Fatalf("GET /auth/sessions: %d %s", w.Code, w.Body)
+		}
+		if strings.Contains(w.Body.String(), current) || strings.Contains(w.Body.String(), old) {
+			t.Errorf("listing reveals a session ID: %s", w.Body)
+		}
+		return resp.Sessions
+	}
+	sessions := list()
+	if len(sessions) != 2 {
+		t.Fatalf("listed %d sessions, want 2", len(sessions))
+	}
+	for _, s := range sessions {
+		if s.Current != (s.Ref == sessionRef(current)) {
+			t.Errorf("session %s marked current %v", s.Ref, s.Current)
+		}
+	}
+
+	tests := []struct {
+		method, query, session string
+		status                 int
+	}{
+		{http.MethodDelete, "", current, http.StatusBadRequest},
+		{http.MethodDelete, "?ref=nope", current, http.StatusNotFound},
+		{http.MethodDelete, "?ref=" + sessionRef(old), guest, http.StatusForbidden},
+		{http.MethodGet, "", guest, http.StatusForbidden},
+		{http.MethodGet, "", "", http.StatusUnauthorized},
+		{http.MethodPost, "", current, http.StatusMethodNotAllowed},
+		{http.MethodDelete, "?ref=" + sessionRef(old), current, http.StatusOK},
+		{http.MethodGet, "", old, http.StatusUnauthorized}, // revoked
+	}
+	for _, tt := range tests {
+		if w := callAPI(mux, tt.method, "/auth/sessions"+tt.query, tt.session, ""); w.Code != tt.status {
+			t.Errorf("%s /auth/sessions%s: status %d, want %d: %s", tt.method, tt.query, w.Code, tt.status, w.Body)
+		}
+	}
+	if sessions := list(); len(sessions) != 1 || !sessions[0].Current {
+		t.Errorf("after revoking: %+v, want only the current session", sessions)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DNS backend names reported by /health
+const (
+	backendNone           = "none"
+	backendRustFFI        = "rust-ffi"
+	backendRustSubprocess = "rust-subprocess"
+	backendGo             = "go"
+)
+
+// DNSBackendStatus tracks which DNS implementation is currently serving queries
+type DNSBackendStatus struct {
+	mu        sync.RWMutex
+	backend   string
+	since     time.Time
+	fallbacks int
+	lastError string
+}
+
+// DNSBackendSnapshot is a point-in-time copy of DNSBackendStatus
+type DNSBackendSnapshot struct {
+	Backend   string    `json:"backend"`
+	Since     time.Time `json:"since"`
+	Fallbacks int       `json:"fallbacks"`
+	LastError string    `json:"last_error,omitempty"`
+}
+
+var dnsBackend = &DNSBackendStatus{backend: backendNone}
+
+// Set records name as the active backend
+func (s *DNSBackendStatus) Set(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.backend = name
+	s.since = time.Now().UTC()
+	slog.Info("DNS backend active", "backend", name)
+}
+
+// RecordFailure records that backend name failed (to start or while running)
+// and that we are falling back to the next one
+func (s *DNSBackendStatus) RecordFailure(name string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fallbacks++
+	s.lastError = name + ": " + err.Error()
+	if s.backend == name {
+		s.backend = backendNone
+	}
+}
+
+// Active returns the name of the active backend
+func (s *DNSBackendStatus) Active() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.backend
+}
+
+// Snapshot returns a copy of the current status
+func (s *DNSBackendStatus) Snapshot() DNSBackendSnapshot {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return DNSBackendSnapshot{Backend: s.backend, Since: s.since, Fallbacks: s.fallbacks, LastError: s.lastError}
+}
+
+// startDNSBackend starts the first DNS backend that works: the Rust runtime via
+// FFI, then a rust subprocess, then the Go DNS server. If the rust subprocess
+// later exits the Go server takes over so there is always a resolver.
+func startDNSBackend(bm *BlocklistManager, am *AccountManager) {
+	// The linked runtime reads its event-reporting settings from our environment
+	for _, kv := range rustEventsEnv() {
+		k, v, _ := strings.Cut(kv, "=")
+		os.Setenv(k, v)
+	}
+
+	// Try to start linked rustdns via cgo FFI
+	if err := StartRustLinked(rustControlAddr, AppConfig().RustDNSBind); err == nil {
+		dnsBackend.Set(backendRustFFI)
+		return
+	} else {
+		dnsBackend.RecordFailure(backendRustFFI, err)
+		slog.Warn("StartRustLinked failed; trying subprocess approach", "err", err)
+	}
+
+	// Try subprocess launch; fall back to Go if it dies later
+	onExit := func(err error) {
+		dnsBackend.RecordFailure(backendRustSubprocess, err)
+		slog.Error("rustdns subprocess exited; falling back to Go DNS server", "err", err)
+		startGoDNS(bm, am)
+	}
+	// mark the subprocess active before launching so an immediate exit can't be
+	// overwritten by a late Set
+	dnsBackend.Set(backendRustSubprocess)
+	if err := startRustDNSIfPresent(onExit); err != nil {
+		dnsBackend.RecordFailure(backendRustSubprocess, err)
+		slog.Warn("rust dns subprocess start failed; falling back to Go DNS server", "err", err)
+		startGoDNS(bm, am)
+	}
+}
+
+// startGoDNS runs the Go DNS server, blocking until it stops
+func startGoDNS(bm *BlocklistManager, am *AccountManager) {
+	dnsBackend.Set(backendGo)
+	if err := StartDNSServer(AppConfig().DNSBind, bm, am); err != nil {
+		log.Fatalf("DNS server error: %v", err)
+	}
+}
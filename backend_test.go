@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestDNSBackendStatus(t *testing.T) {
+	type step struct {
+		set  string // backend made active, or "" to record fail's failure
+		fail string
+	}
+	tests := []struct {
+		name          string
+		steps         []step
+		wantBackend   string
+		wantFallbacks int
+		wantLastError string
+	}{
+		{"nothing started", nil, backendNone, 0, ""},
+		{"FFI works", []step{{set: backendRustFFI}}, backendRustFFI, 0, ""},
+		{"FFI fails, subprocess works", []step{
+			{fail: backendRustFFI}, {set: backendRustSubprocess},
+		}, backendRustSubprocess, 1, backendRustFFI + ": boom"},
+		{"subprocess dies, Go takes over", []step{
+			{fail: backendRustFFI}, {set: backendRustSubprocess}, {fail: backendRustSubprocess}, {set: backendGo},
+		}, backendGo, 2, backendRustSubprocess + ": boom"},
+		{"subprocess dies before Go is up", []step{
+			{set: backendRustSubprocess}, {fail: backendRustSubprocess},
+		}, backendNone, 1, backendRustSubprocess + ": boom"},
+		{"a stale failure leaves the active backend", []step{
+			{set: backendGo}, {fail: backendRustSubprocess},
+		}, backendGo, 1, backendRustSubprocess + ": boom"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &DNSBackendStatus{backend: backendNone}
+			for _, st := range tt.steps {
+				if st.set != "" {
+					s.Set(st.set)
+				} else {
+					s.RecordFailure(st.fail, errors.New("boom"))
+				}
+			}
+			snap := s.Snapshot()
+			if snap.Backend != tt.wantBackend || s.Active() != tt.wantBackend {
+				t.Errorf("backend = %q, want %q", snap.Backend, tt.wantBackend)
+			}
+			if snap.Fallbacks != tt.wantFallbacks {
+				t.Errorf("fallbacks = %d, want %d", snap.Fallbacks, tt.wantFallbacks)
+			}
+			if snap.LastError != tt.wantLastError {
+				t.Errorf("last error = %q, want %q", snap.LastError, tt.wantLastError)
+			}
+			if tt.wantBackend != backendNone && snap.Since.IsZero() {
+				t.Error("since not set for the active backend")
+			}
+		})
+	}
+}
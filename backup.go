@@ -0,0 +1,543 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// A backup is a gzipped tar holding:
+//
+//	manifest.json        backupManifest
+//	config.json          the running Config
+//	accounts.db          a consistent copy of the accounts database
+//	lists/<name>.txt     every list file
+//	lists/<name>.meta.json
+const backupVersion = 1
+
+// maxRestoreBytes caps an uploaded backup archive; maxRestoreExpanded caps what
+// it may decompress to
+const (
+	maxRestoreBytes    = 512 << 20
+	maxRestoreExpanded = 4 * maxRestoreBytes
+)
+
+// backupTables are the accounts tables carried in a backup, parents first
+var backupTables = []string{"accounts", "user_blocklists", "user_settings", "device_names", "local_records"}
+
+// ErrInvalidBackup is wrapped by every error about the contents of an archive
+var ErrInvalidBackup = errors.New("invalid backup")
+
+func invalidBackup(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrInvalidBackup, fmt.Sprintf(format, args...))
+}
+
+// backupManifest describes a backup archive
+type backupManifest struct {
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+	Lists     int       `json:"lists"`
+}
+
+// RestoreSummary is what a restore found in (and, once confirmed, applied from) an archive
+type RestoreSummary struct {
+	CreatedAt time.Time `json:"created_at"`
+	Lists     int       `json:"lists"`
+	Accounts  int       `json:"accounts"`
+	Applied   bool      `json:"applied"`
+	// RestartNeeded names the restored settings that only take effect on a restart
+	RestartNeeded []string `json:"restart_needed,omitempty"`
+}
+
+// isBackupListFile reports whether name is a list or list metadata file that
+// belongs in a backup; temporary files are dot-prefixed and skipped
+func isBackupListFile(name string) bool {
+	if name == "" || strings.HasPrefix(name, ".") || strings.ContainsAny(name, `/\`) {
+		return false
+	}
+	return strings.HasSuffix(name, ".txt") || strings.HasSuffix(name, ".meta.json")
+}
+
+// WriteBackup streams a backup archive of the lists, accounts and config to w
+func WriteBackup(w io.Writer, bm *BlocklistManager, am *AccountManager) error {
+	if bm.dir == "" {
+		return ErrListsInMemory
+	}
+	tmp, err := os.MkdirTemp("", "piblock-backup-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	dbCopy := filepath.Join(tmp, "accounts.db")
+	if err := am.SnapshotDB(dbCopy); err != nil {
+		return err
+	}
+
+	entries, err := os.ReadDir(bm.dir)
+	if err != nil {
+		return err
+	}
+	var listFiles []string
+	for _, e := range entries {
+		if !e.IsDir() && isBackupListFile(e.Name()) {
+			listFiles = append(listFiles, e.Name())
+		}
+	}
+
+	manifest := backupManifest{Version: backupVersion, CreatedAt: time.Now().UTC()}
+	for _, name := range listFiles {
+		if strings.HasSuffix(name, ".txt") {
+			manifest.Lists++
+		}
+	}
+	manifestJSON, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	configJSON, err := json.MarshalIndent(AppConfig(), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	zw := gzip.NewWriter(w)
+	tw := tar.NewWriter(zw)
+	if err := addTarBytes(tw, "manifest.json", manifestJSON); err != nil {
+		return err
+	}
+	if err := addTarBytes(tw, "config.json", configJSON); err != nil {
+		return err
+	}
+	if err := addTarFile(tw, "accounts.db", dbCopy); err != nil {
+		return err
+	}
+	for _, name := range listFiles {
+		err := addTarFile(tw, "lists/"+name, filepath.Join(bm.dir, name))
+		if errors.Is(err, os.ErrNotExist) {
+			// deleted since ReadDir
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+func addTarBytes(tw *tar.Writer, name string, data []byte) error {
+	hdr := &tar.Header{Name: name, Mode: 0o644, Size: int64(len(data)), ModTime: time.Now()}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err := tw.Write(data)
+	return err
+}
+
+func addTarFile(tw *tar.Writer, name, src string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	hdr := &tar.Header{Name: name, Mode: 0o644, Size: info.Size(), ModTime: info.ModTime()}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err = io.CopyN(tw, f, info.Size())
+	return err
+}
+
+// RestoreBackup reads a backup archive and checks it is complete and usable. With
+// apply set it then replaces every list, the accounts and the config (see
+// restoreConfig) and reloads; without, it only reports what the archive holds.
+// cl is the command line the process started with.
+func RestoreBackup(r io.Reader, bm *BlocklistManager, am *AccountManager, cl commandLine, apply bool) (RestoreSummary, error) {
+	if bm.dir == "" {
+		return RestoreSummary{}, ErrListsInMemory
+	}
+	tmp, err := os.MkdirTemp("", "piblock-restore-")
+	if err != nil {
+		return RestoreSummary{}, err
+	}
+	defer os.RemoveAll(tmp)
+
+	manifest, lists, err := extractBackup(r, tmp)
+	if err != nil {
+		return RestoreSummary{}, err
+	}
+	summary := RestoreSummary{CreatedAt: manifest.CreatedAt}
+	for _, name := range lists {
+		if strings.HasSuffix(name, ".txt") {
+			summary.Lists++
+		}
+	}
+
+	cfg, err := readBackupConfig(filepath.Join(tmp, "config.json"))
+	if err != nil {
+		return summary, err
+	}
+	dbCopy := filepath.Join(tmp, "accounts.db")
+	if summary.Accounts, err = am.CheckBackupDB(dbCopy); err != nil {
+		return summary, err
+	}
+	if !apply {
+		return summary, nil
+	}
+
+	if err := restoreListFiles(filepath.Join(tmp, "lists"), bm.dir, lists); err != nil {
+		return summary, fmt.Errorf("restoring lists: %w", err)
+	}
+	if err := am.RestoreDB(dbCopy); err != nil {
+		return summary, fmt.Errorf("restoring accounts: %w", err)
+	}
+	summary.Applied = true
+	if summary.RestartNeeded, err = restoreConfig(cfg, cl); err != nil {
+		return summary, fmt.Errorf("restoring config: %w", err)
+	}
+	if err := bm.LoadAll(); err != nil {
+		return summary, err
+	}
+	return summary, nil
+}
+
+// extractBackup unpacks an archive into dir, rejecting anything outside the
+// expected layout. It returns the manifest and the list file names.
+func extractBackup(r io.Reader, dir string) (backupManifest, []string, error) {
+	var manifest backupManifest
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return manifest, nil, invalidBackup("%v", err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "lists"), 0o755); err != nil {
+		return manifest, nil, err
+	}
+
+	budget := int64(maxRestoreExpanded)
+	seen := make(map[string]bool)
+	var lists []string
+	tr := tar.NewReader(zr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return manifest, nil, invalidBackup("%v", err)
+		}
+		if hdr.Typeflag == tar.TypeDir {
+			continue
+		}
+		name := path.Clean(hdr.Name)
+		if hdr.Typeflag != tar.TypeReg {
+			return manifest, nil, invalidBackup("%s is not a regular file", name)
+		}
+		if seen[name] {
+			return manifest, nil, invalidBackup("%s appears twice", name)
+		}
+		seen[name] = true
+		switch {
+		case name == "manifest.json", name == "config.json", name == "accounts.db":
+		case strings.HasPrefix(name, "lists/") && isBackupListFile(strings.TrimPrefix(name, "lists/")):
+			lists = append(lists, strings.TrimPrefix(name, "lists/"))
+		default:
+			return manifest, nil, invalidBackup("unexpected entry %s", name)
+		}
+		if hdr.Size > budget {
+			return manifest, nil, invalidBackup("contents too large")
+		}
+		budget -= hdr.Size
+		if err := writeExtracted(filepath.Join(dir, filepath.FromSlash(name)), tr, hdr.Size); err != nil {
+			return manifest, nil, err
+		}
+	}
+
+	for _, required := range []string{"manifest.json", "config.json", "accounts.db"} {
+		if !seen[required] {
+			return manifest, nil, invalidBackup("missing %s", required)
+		}
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "manifest.json"))
+	if err != nil {
+		return manifest, nil, err
+	}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return manifest, nil, invalidBackup("manifest: %v", err)
+	}
+	if manifest.Version != backupVersion {
+		return manifest, nil, invalidBackup("unsupported version %d (want %d)", manifest.Version, backupVersion)
+	}
+	return manifest, lists, nil
+}
+
+func writeExtracted(dst string, r io.Reader, size int64) error {
+	f, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.CopyN(f, r, size); err != nil {
+		f.Close()
+		return invalidBackup("%v", err)
+	}
+	return f.Close()
+}
+
+// readBackupConfig reads the archived config as a config file is read and
+// makes sure it passes validation
+func readBackupConfig(p string) (*Config, error) {
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := decodeConfig(data)
+	if err != nil {
+		return nil, invalidBackup("config: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, invalidBackup("config: %v", err)
+	}
+	return cfg, nil
+}
+
+// restoreConfig makes the archived cfg the running config. The running
+// directories are kept, since the restored lists and accounts were written
+// there. With a config file, cfg is written to it and reloaded as on SIGHUP;
+// without one it lasts until the next restart. It returns the settings that
+// need a restart to change.
+func restoreConfig(cfg *Config, cl commandLine) ([]string, error) {
+	running := AppConfig()
+	cfg.BlocklistDir, cfg.DataDir = running.BlocklistDir, running.DataDir
+	if cl.ConfigFile == "" {
+		slog.Warn("restore: no config file to write (start with -config); the restored settings are lost on restart")
+		return applyConfig(cfg)
+	}
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := writeFileAtomic(cl.ConfigFile, append(data, '\n')); err != nil {
+		return nil, err
+	}
+	return reloadConfig(cl)
+}
+
+// writeFileAtomic replaces path with data through a temporary file in the
+// same directory, so a reader never sees it half-written
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// restoreListFiles makes dst hold exactly the list files named in names: each is
+// copied in atomically and any list or metadata file not in the backup removed
+func restoreListFiles(src, dst string, names []string) error {
+	keep := make(map[string]bool, len(names))
+	for _, name := range names {
+		keep[name] = true
+		data, err := os.ReadFile(filepath.Join(src, name))
+		if err != nil {
+			return err
+		}
+		if err := writeFileAtomic(filepath.Join(dst, name), data); err != nil {
+			return err
+		}
+	}
+
+	entries, err := os.ReadDir(dst)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if e.IsDir() || !isBackupListFile(e.Name()) || keep[e.Name()] {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dst, e.Name())); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// SnapshotDB writes a consistent copy of the accounts database to dst
+func (am *AccountManager) SnapshotDB(dst string) error {
+	if _, err := am.exec("VACUUM INTO ?", dst); err != nil {
+		return fmt.Errorf("failed to snapshot accounts database: %w", err)
+	}
+	return nil
+}
+
+// CheckBackupDB verifies the database at p has every backed-up table with the
+// columns this version uses, and returns how many accounts it holds
+func (am *AccountManager) CheckBackupDB(p string) (int, error) {
+	var accounts int
+	err := am.withBackupDB(p, func(ctx context.Context, conn *sql.Conn) error {
+		for _, table := range backupTables {
+			if _, err := backupColumns(ctx, conn, table); err != nil {
+				return err
+			}
+		}
+		return conn.QueryRowContext(ctx, "SELECT COUNT(*) FROM backup.accounts").Scan(&accounts)
+	})
+	return accounts, err
+}
+
+// RestoreDB replaces the contents of every backed-up table with the rows from
+// the database at p, in one transaction
+func (am *AccountManager) RestoreDB(p string) error {
+	return am.withBackupDB(p, func(ctx context.Context, conn *sql.Conn) error {
+		tx, err := conn.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		// children first so deleting never trips a foreign key
+		for i := len(backupTables) - 1; i >= 0; i-- {
+			if _, err := tx.ExecContext(ctx, "DELETE FROM main."+backupTables[i]); err != nil {
+				return err
+			}
+		}
+		for _, table := range backupTables {
+			cols, err := backupColumns(ctx, conn, table)
+			if err != nil {
+				return err
+			}
+			list := strings.Join(cols, ", ")
+			q := fmt.Sprintf("INSERT INTO main.%s (%s) SELECT %s FROM backup.%s", table, list, list, table)
+			if _, err := tx.ExecContext(ctx, q); err != nil {
+				return err
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		if err := am.dropOrphanSessions(); err != nil {
+			return err
+		}
+		return am.refreshLocalRecords()
+	})
+}
+
+// dropOrphanSessions ends the sessions of accounts that no longer exist, as
+// after a restore. Guest sessions belong to no account and are kept.
+func (am *AccountManager) dropOrphanSessions() error {
+	rows, err := am.query("SELECT mac_address FROM accounts")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	accounts := make(map[string]bool)
+	for rows.Next() {
+		var mac string
+		if err := rows.Scan(&mac); err != nil {
+			return err
+		}
+		accounts[mac] = true
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	am.mu.Lock()
+	defer am.mu.Unlock()
+	for id, s := range am.sessions {
+		if !s.IsGuest && !accounts[s.MACAddress] {
+			delete(am.sessions, id)
+			log.Printf("Ended session %s for removed account %s", sessionRef(id), s.MACAddress)
+		}
+	}
+	return nil
+}
+
+// withBackupDB attaches the database at p as "backup" on a dedicated connection
+func (am *AccountManager) withBackupDB(p string, fn func(ctx context.Context, conn *sql.Conn) error) error {
+	ctx := context.Background()
+	conn, err := am.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, "ATTACH DATABASE ? AS backup", p); err != nil {
+		return invalidBackup("database: %v", err)
+	}
+	defer conn.ExecContext(ctx, "DETACH DATABASE backup")
+	return fn(ctx, conn)
+}
+
+// backupColumns returns the live columns of table, failing if the attached
+// backup lacks the table or any of them
+func backupColumns(ctx context.Context, conn *sql.Conn, table string) ([]string, error) {
+	live, err := tableColumns(ctx, conn, "main", table)
+	if err != nil {
+		return nil, err
+	}
+	backed, err := tableColumns(ctx, conn, "backup", table)
+	if err != nil {
+		return nil, err
+	}
+	if len(backed) == 0 {
+		return nil, invalidBackup("database: missing table %s", table)
+	}
+	have := make(map[string]bool, len(backed))
+	for _, c := range backed {
+		have[c] = true
+	}
+	for _, c := range live {
+		if !have[c] {
+			return nil, invalidBackup("database: %s has no column %s", table, c)
+		}
+	}
+	return live, nil
+}
+
+func tableColumns(ctx context.Context, conn *sql.Conn, schema, table string) ([]string, error) {
+	rows, err := conn.QueryContext(ctx, fmt.Sprintf("PRAGMA %s.table_info(%s)", schema, table))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var cols []string
+	for rows.Next() {
+		var (
+			cid, notNull, pk int
+			name, typ        string
+			dflt             sql.NullString
+		)
+		if err := rows.Scan(&cid, &name, &typ, &notNull, &dflt, &pk); err != nil {
+			return nil, err
+		}
+		cols = append(cols, name)
+	}
+	return cols, rows.Err()
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// tarEntry is one file in an archive built by makeArchive
+type tarEntry struct {
+	name string
+	body []byte
+	typ  byte // tar.TypeReg when zero
+}
+
+func makeArchive(t *testing.T, entries []tarEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(zw)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Mode: 0o644, Size: int64(len(e.body)), Typeflag: e.typ}
+		if e.typ == tar.TypeSymlink {
+			hdr.Size, hdr.Linkname = 0, "/etc/passwd"
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(e.body); err != nil && e.typ != tar.TypeSymlink {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// newBackupFixture returns managers over a list directory holding ads.txt and
+// an account for mac whose device is labeled "Kitchen iPad"
+func newBackupFixture(t *testing.T, mac string) (*BlocklistManager, *AccountManager, string) {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "ads.txt"), []byte("ads.example\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	bm, err := NewBlocklistManager(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	am := newTestAccountManager(t, mac)
+	if err := am.SetDeviceName(mac, "Kitchen iPad"); err != nil {
+		t.Fatal(err)
+	}
+	return bm, am, dir
+}
+
+func TestBackupRoundTrip(t *testing.T) {
+	const mac, removed, guest = "aa:bb:cc:00:11:29", "aa:bb:cc:00:11:31", "aa:bb:cc:00:11:32"
+	prevLog := slog.Default()
+	t.Cleanup(func() { slog.SetDefault(prevLog) })
+	withConfig(t, func(c *Config) {
+		c.BlockingMode, c.AdminMACs, c.DNSBind = "nx", []string{mac}, "0.0.0.0:5353"
+		c.BcryptCost = 4
+	})
+	bm, am, dir := newBackupFixture(t, mac)
+	var archive bytes.Buffer
+	if err := WriteBackup(&archive, bm, am); err != nil {
+		t.Fatal(err)
+	}
+
+	// diverge from the backup
+	configFile := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(configFile, []byte(`{"blocking_mode": "null"}`+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	withConfig(t, func(c *Config) { c.BlockingMode, c.BcryptCost = "null", 4 })
+	if err := os.Remove(filepath.Join(dir, "ads.txt")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "extra.txt"), []byte("extra.example\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := am.SetDeviceName(mac, "Hall iPad"); err != nil {
+		t.Fatal(err)
+	}
+	if err := am.CreateAccount(removed, testPasscode); err != nil {
+		t.Fatal(err)
+	}
+	if err := bm.LoadAll(); err != nil {
+		t.Fatal(err)
+	}
+	kept := am.createSession(mac, false).ID
+	ended := am.createSession(removed, false).ID
+	guestSession := am.CreateGuestSession(guest).ID
+
+	tests := []struct {
+		name      string
+		apply     bool
+		wantFiles []string
+		wantLabel string
+		wantMode  string
+		wantAdmin bool
+	}{
+		{"preview changes nothing", false, []string{"extra.txt"}, "Hall iPad", "null", false},
+		{"apply restores the backup", true, []string{"ads.txt"}, "Kitchen iPad", "nx", true},
+	}
+	for _, tt := range tests {
+		summary, err := RestoreBackup(bytes.NewReader(archive.Bytes()), bm, am, commandLine{ConfigFile: configFile}, tt.apply)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if summary.Lists != 1 || summary.Accounts != 1 || summary.Applied != tt.apply || summary.CreatedAt.IsZero() {
+			t.Errorf("%s: summary %+v", tt.name, summary)
+		}
+		var files []string
+		for _, name := range dirNames(t, dir) {
+			if isBackupListFile(name) {
+				files = append(files, name)
+			}
+		}
+		if !slices.Equal(files, tt.wantFiles) {
+			t.Errorf("%s: list files %v, want %v", tt.name, files, tt.wantFiles)
+		}
+		if got := bm.IsBlocked("ads.example"); got != tt.apply {
+			t.Errorf("%s: ads.example blocked = %v, want %v", tt.name, got, tt.apply)
+		}
+		names, err := am.DeviceNames()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if names[mac] != tt.wantLabel {
+			t.Errorf("%s: device labeled %q, want %q", tt.name, names[mac], tt.wantLabel)
+		}
+
+		// the config is written to the config file and reloaded from it
+		cfg := AppConfig()
+		if cfg.BlockingMode != tt.wantMode || cfg.IsAdminMAC(mac) != tt.wantAdmin {
+			t.Errorf("%s: running blocking_mode %q, admin %v; want %q, %v", tt.name, cfg.BlockingMode, cfg.IsAdminMAC(mac), tt.wantMode, tt.wantAdmin)
+		}
+		onDisk, err := LoadConfig(configFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if onDisk.BlockingMode != tt.wantMode {
+			t.Errorf("%s: config file has blocking_mode %q, want %q", tt.name, onDisk.BlockingMode, tt.wantMode)
+		}
+		if !tt.apply {
+			continue
+		}
+		// listeners keep running as they are; the restored dns_bind waits for a restart
+		if cfg.DNSBind != defaultConfig().DNSBind || !slices.Equal(summary.RestartNeeded, []string{"dns_bind"}) {
+			t.Errorf("%s: dns_bind %q, restart needed for %v; want the running one kept and reported", tt.name, cfg.DNSBind, summary.RestartNeeded)
+		}
+		if onDisk.DNSBind != "0.0.0.0:5353" || onDisk.BlocklistDir != cfg.BlocklistDir || onDisk.DataDir != cfg.DataDir {
+			t.Errorf("%s: config file has dns_bind %q and directories %q, %q; want the archived bind and the running directories",
+				tt.name, onDisk.DNSBind, onDisk.BlocklistDir, onDisk.DataDir)
+		}
+	}
+
+	// the removed account's session ended with it
+	for _, s := range []struct {
+		id   string
+		want bool
+	}{{kept, true}, {ended, false}, {guestSession, true}} {
+		if _, err := am.GetSession(s.id); (err == nil) != s.want {
+			t.Errorf("session of %s: %v, want it kept %v", sessionRef(s.id), err, s.want)
+		}
+	}
+}
+
+func TestRestoreRejectsBadArchives(t *testing.T) {
+	const mac = "aa:bb:cc:00:11:30"
+	bm, am, dir := newBackupFixture(t, mac)
+	manifest := []byte(`{"version":1,"created_at":"2026-01-02T03:04:05Z","lists":1}`)
+	config, err := json.Marshal(AppConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	dbPath := filepath.Join(t.TempDir(), "accounts.db")
+	if err := am.SnapshotDB(dbPath); err != nil {
+		t.Fatal(err)
+	}
+	db, err := os.ReadFile(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	strippedPath := filepath.Join(t.TempDir(), "stripped.db")
+	stripped := newTestAccountManager(t)
+	if _, err := stripped.exec("DROP TABLE device_names"); err != nil {
+		t.Fatal(err)
+	}
+	if err := stripped.SnapshotDB(strippedPath); err != nil {
+		t.Fatal(err)
+	}
+	noDevices, err := os.ReadFile(strippedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// with builds a valid archive, replacing the entries named in extra; an
+	// entry with an explicit type is always added
+	with := func(extra ...tarEntry) []byte {
+		entries := []tarEntry{
+			{name: "manifest.json", body: manifest},
+			{name: "config.json", body: config},
+			{name: "accounts.db", body: db},
+			{name: "lists/ads.txt", body: []byte("other.example\n")},
+		}
+		for _, e := range extra {
+			if i := slices.IndexFunc(entries, func(have tarEntry) bool { return have.name == e.name }); i >= 0 && e.typ == 0 {
+				entries[i] = e
+				continue
+			}
+			entries = append(entries, e)
+		}
+		return makeArchive(t, entries)
+	}
+	without := func(name string) []byte {
+		return makeArchive(t, slices.DeleteFunc([]tarEntry{
+			{name: "manifest.json", body: manifest},
+			{name: "config.json", body: config},
+			{name: "accounts.db", body: db},
+		}, func(e tarEntry) bool { return e.name == name }))
+	}
+
+	if _, err := RestoreBackup(bytes.NewReader(with()), bm, am, commandLine{}, false); err != nil {
+		t.Fatalf("the valid archive was rejected: %v", err)
+	}
+	tests := []struct {
+		name    string
+		archive []byte
+	}{
+		{"not gzip", []byte("PK\x03\x04 not a tarball")},
+		{"missing manifest", without("manifest.json")},
+		{"missing config", without("config.json")},
+		{"missing accounts", without("accounts.db")},
+		{"unsupported version", with(tarEntry{name: "manifest.json", body: []byte(`{"version":99}`)})},
+		{"bad manifest", with(tarEntry{name: "manifest.json", body: []byte(`{`)})},
+		{"invalid config", with(tarEntry{name: "config.json", body: []byte(`{"blocking_mode":"sideways"}`)})},
+		{"accounts table missing", with(tarEntry{name: "accounts.db", body: noDevices})},
+		{"accounts not a database", with(tarEntry{name: "accounts.db", body: []byte("not sqlite, just text that is long enough to have a header")})},
+		{"path escape", with(tarEntry{name: "../evil.txt", body: []byte("x")})},
+		{"nested list", with(tarEntry{name: "lists/sub/ads.txt", body: []byte("x")})},
+		{"unexpected file", with(tarEntry{name: "lists/run.sh", body: []byte("x")})},
+		{"symlink", with(tarEntry{name: "lists/link.txt", typ: tar.TypeSymlink})},
+		{"duplicate", with(tarEntry{name: "config.json", body: config, typ: tar.TypeReg})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := RestoreBackup(bytes.NewReader(tt.archive), bm, am, commandLine{}, true)
+			if !errors.Is(err, ErrInvalidBackup) {
+				t.Fatalf("RestoreBackup = %v, want ErrInvalidBackup", err)
+			}
+			// nothing was applied
+			if data, err := os.ReadFile(filepath.Join(dir, "ads.txt")); err != nil || string(data) != "ads.example\n" {
+				t.Errorf("ads.txt now %q (%v)", data, err)
+			}
+			if names, _ := am.DeviceNames(); names[mac] != "Kitchen iPad" {
+				t.Errorf("device names now %v", names)
+			}
+		})
+	}
+}
+
+func TestBackupAPIAdminOnly(t *testing.T) {
+	const admin, user = "aa:00:00:00:11:29", "aa:00:00:00:11:30"
+	withConfig(t, func(c *Config) { c.AdminMACs = []string{admin}; c.BcryptCost = 4 })
+	bm, am, _ := newBackupFixture(t, admin)
+	if err := am.CreateAccount(user, testPasscode); err != nil {
+		t.Fatal(err)
+	}
+	mux := newTestAPI(bm, am)
+	adminSession := am.createSession(admin, false).ID
+	userSession := am.createSession(user, false).ID
+
+	tests := []struct {
+		name, method, target, session string
+		status                        int
+	}{
+		{"user backup", http.MethodGet, "/backup", userSession, http.StatusForbidden},
+		{"user restore", http.MethodPost, "/restore", userSession, http.StatusForbidden},
+		{"backup via POST", http.MethodPost, "/backup", adminSession, http.StatusMethodNotAllowed},
+		{"restore of garbage", http.MethodPost, "/restore", adminSession, http.StatusBadRequest},
+		{"admin backup", http.MethodGet, "/backup", adminSession, http.StatusOK},
+	}
+	for _, tt := range tests {
+		w := callAPI(mux, tt.method, tt.target, tt.session, "not an archive")
+		if w.Code != tt.status {
+			t.Fatalf("%s: status %d, want %d: %s", tt.name, w.Code, tt.status, w.Body)
+		}
+		if tt.status != http.StatusOK {
+			continue
+		}
+		if ct := w.Header().Get("Content-Type"); ct != "application/gzip" {
+			t.Errorf("%s: Content-Type %q", tt.name, ct)
+		}
+		if _, err := RestoreBackup(bytes.NewReader(w.Body.Bytes()), bm, am, commandLine{}, false); err != nil {
+			t.Errorf("%s: downloaded archive doesn't restore: %v", tt.name, err)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net"
+
+	"github.com/miekg/dns"
+)
+
+// Values of AppConfig.BlockedOtherTypes
+const (
+	blockedOtherNoData   = "nodata"
+	blockedOtherNXDomain = "nxdomain"
+)
+
+// blockedAnswer fills msg with the reply to a blocked question and returns the
+// blocking mode actually applied. In redirect and null modes only address
+// queries get a synthesized answer; every other type (HTTPS/SVCB, MX, TXT, ...)
+// gets a proper negative answer so clients don't read the name as existing and
+// go around the block, e.g. by using the ECH keys of an HTTPS record.
+func blockedAnswer(cfg *Config, msg *dns.Msg, q dns.Question, mode, blockPageIP string) string {
+	switch mode {
+	case "redirect":
+		// point browsers at the block page server
+		if q.Qtype == dns.TypeA || q.Qtype == dns.TypeANY {
+			target := blockPageIP
+			if target == "" {
+				target = "127.0.0.1"
+			}
+			msg.Answer = append(msg.Answer, &dns.A{
+				Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+				A:   net.ParseIP(target),
+			})
+			return mode
+		}
+		blockedNegative(cfg, msg, q)
+		return mode
+	case "nx":
+		msg.Rcode = dns.RcodeNameError
+		return mode
+	}
+	// null route (0.0.0.0 / :: unless a sink is configured)
+	switch q.Qtype {
+	case dns.TypeA, dns.TypeANY:
+		msg.Answer = append(msg.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 0},
+			A:   nullSinkIP(cfg.NullSinkIP, net.IPv4zero),
+		})
+	case dns.TypeAAAA:
+		msg.Answer = append(msg.Answer, &dns.AAAA{
+			Hdr:  dns.RR_Header{Name: q.Name, Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: 0},
+			AAAA: nullSinkIP(cfg.NullSinkIPv6, net.IPv6zero),
+		})
+	default:
+		blockedNegative(cfg, msg, q)
+	}
+	return "null"
+}
+
+// nullSinkIP returns the configured sink address, or def when unset or unparseable
+func nullSinkIP(configured string, def net.IP) net.IP {
+	if ip := net.ParseIP(configured); ip != nil {
+		return ip
+	}
+	return def
+}
+
+// blockedNegative answers a blocked question that has no synthesized record:
+// NXDOMAIN when cfg.BlockedOtherTypes says so, else NODATA with an SOA in
+// the authority section so resolvers cache the empty answer (RFC 2308).
+func blockedNegative(cfg *Config, msg *dns.Msg, q dns.Question) {
+	if cfg.BlockedOtherTypes == blockedOtherNXDomain {
+		msg.Rcode = dns.RcodeNameError
+		return
+	}
+	msg.Ns = append(msg.Ns, &dns.SOA{
+		Hdr:     dns.RR_Header{Name: q.Name, Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: 60},
+		Ns:      "piblock.",
+		Mbox:    "hostmaster.piblock.",
+		Serial:  1,
+		Refresh: 3600,
+		Retry:   600,
+		Expire:  86400,
+		Minttl:  60,
+	})
+}
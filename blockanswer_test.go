@@ -0,0 +1,127 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestBlockedAnswer(t *testing.T) {
+	tests := []struct {
+		mode, other string
+		qtype       uint16
+		wantMode    string
+		wantRcode   int
+		wantAnswer  string // value of the single answer; "" for none
+		wantSOA     bool
+	}{
+		{"redirect", "", dns.TypeA, "redirect", dns.RcodeSuccess, "192.168.1.2", false},
+		{"redirect", "", dns.TypeANY, "redirect", dns.RcodeSuccess, "192.168.1.2", false},
+		{"redirect", "", dns.TypeAAAA, "redirect", dns.RcodeSuccess, "", true},
+		{"redirect", "", dns.TypeHTTPS, "redirect", dns.RcodeSuccess, "", true},
+		{"redirect", blockedOtherNXDomain, dns.TypeHTTPS, "redirect", dns.RcodeNameError, "", false},
+		{"null", "", dns.TypeA, "null", dns.RcodeSuccess, "0.0.0.0", false},
+		{"null", "", dns.TypeAAAA, "null", dns.RcodeSuccess, "::", false},
+		{"null", "", dns.TypeSVCB, "null", dns.RcodeSuccess, "", true},
+		{"null", blockedOtherNXDomain, dns.TypeMX, "null", dns.RcodeNameError, "", false},
+		{"", "", dns.TypeTXT, "null", dns.RcodeSuccess, "", true}, // unset mode null-routes
+		{"nx", "", dns.TypeA, "nx", dns.RcodeNameError, "", false},
+		{"nx", blockedOtherNoData, dns.TypeHTTPS, "nx", dns.RcodeNameError, "", false},
+	}
+	for _, tt := range tests {
+		withConfig(t, func(c *Config) { c.BlockedOtherTypes = tt.other })
+		q := dns.Question{Name: "ads.example.", Qtype: tt.qtype, Qclass: dns.ClassINET}
+		msg := new(dns.Msg)
+		msg.SetQuestion(q.Name, q.Qtype)
+		msg = msg.SetReply(msg)
+		name := tt.mode + " " + dns.TypeToString[tt.qtype] + " " + tt.other
+		if got := blockedAnswer(AppConfig(), msg, q, tt.mode, "192.168.1.2"); got != tt.wantMode {
+			t.Errorf("%s: mode %q, want %q", name, got, tt.wantMode)
+		}
+		if msg.Rcode != tt.wantRcode {
+			t.Errorf("%s: rcode %s, want %s", name, dns.RcodeToString[msg.Rcode], dns.RcodeToString[tt.wantRcode])
+		}
+		var got string
+		if len(msg.Answer) == 1 {
+			switch rr := msg.Answer[0].(type) {
+			case *dns.A:
+				got = rr.A.String()
+			case *dns.AAAA:
+				got = rr.AAAA.String()
+			}
+		}
+		if got != tt.wantAnswer || len(msg.Answer) > 1 {
+			t.Errorf("%s: answers %v, want %q", name, msg.Answer, tt.wantAnswer)
+		}
+		hasSOA := len(msg.Ns) == 1 && msg.Ns[0].Header().Rrtype == dns.TypeSOA
+		if hasSOA != tt.wantSOA {
+			t.Errorf("%s: authority %v, want SOA %v", name, msg.Ns, tt.wantSOA)
+		}
+	}
+}
+
+func TestValidateBlockedOtherTypes(t *testing.T) {
+	for value, ok := range map[string]bool{"": true, blockedOtherNoData: true, blockedOtherNXDomain: true, "refused": false, "NODATA": false} {
+		c := defaultConfig()
+		c.BlockedOtherTypes = value
+		if err := c.Validate(); (err == nil) != ok {
+			t.Errorf("Validate with blocked_other_types %q = %v, want ok %v", value, err, ok)
+		}
+	}
+}
+
+func TestNullSinkAddresses(t *testing.T) {
+	tests := []struct {
+		sink4, sink6 string
+		qtype        uint16
+		want         string
+	}{
+		{"", "", dns.TypeA, "0.0.0.0"},
+		{"", "", dns.TypeAAAA, "::"},
+		{"127.0.0.2", "", dns.TypeA, "127.0.0.2"},
+		{"127.0.0.2", "", dns.TypeANY, "127.0.0.2"},
+		{"127.0.0.2", "", dns.TypeAAAA, "::"},
+		{"", "fd00::53", dns.TypeAAAA, "fd00::53"},
+		{"", "fd00::53", dns.TypeA, "0.0.0.0"},
+	}
+	for _, tt := range tests {
+		withConfig(t, func(c *Config) { c.NullSinkIP, c.NullSinkIPv6 = tt.sink4, tt.sink6 })
+		msg := new(dns.Msg)
+		msg.SetQuestion("ads.example.", tt.qtype)
+		msg = msg.SetReply(msg)
+		blockedAnswer(AppConfig(), msg, msg.Question[0], "null", "192.168.1.2")
+		var got string
+		if len(msg.Answer) == 1 {
+			switch rr := msg.Answer[0].(type) {
+			case *dns.A:
+				got = rr.A.String()
+			case *dns.AAAA:
+				got = rr.AAAA.String()
+			}
+		}
+		if got != tt.want {
+			t.Errorf("sinks %q/%q, %s: answers %v, want %s", tt.sink4, tt.sink6, dns.TypeToString[tt.qtype], msg.Answer, tt.want)
+		}
+	}
+}
+
+func TestValidateNullSinks(t *testing.T) {
+	tests := []struct {
+		sink4, sink6 string
+		ok           bool
+	}{
+		{"", "", true},
+		{"10.0.0.53", "fd00::53", true},
+		{"fd00::53", "", false},
+		{"", "10.0.0.53", false},
+		{"", "::ffff:10.0.0.53", false}, // IPv4-mapped
+		{"sink.lan", "", false},
+	}
+	for _, tt := range tests {
+		c := defaultConfig()
+		c.NullSinkIP, c.NullSinkIPv6 = tt.sink4, tt.sink6
+		if err := c.Validate(); (err == nil) != tt.ok {
+			t.Errorf("Validate with sinks %q/%q = %v, want ok %v", tt.sink4, tt.sink6, err, tt.ok)
+		}
+	}
+}
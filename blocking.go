@@ -0,0 +1,120 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// BlockingSwitch is the global kill-switch for filtering. While it is off every
+// query is forwarded; a timed disable turns blocking back on automatically.
+type BlockingSwitch struct {
+	mu       sync.Mutex
+	disabled bool
+	until    time.Time // zero when disabled indefinitely
+	timer    *time.Timer
+	onChange func(enabled bool)
+}
+
+// BlockingStatus is the JSON shape returned by /blocking/status. PausedUntil is
+// when a timed disable ends; Until carries the same value for older clients.
+type BlockingStatus struct {
+	Enabled     bool       `json:"enabled"`
+	Until       *time.Time `json:"until,omitempty"`
+	PausedUntil *time.Time `json:"paused_until,omitempty"`
+}
+
+var blockingSwitch = &BlockingSwitch{}
+
+// Enabled reports whether blocking is currently in effect
+func (s *BlockingSwitch) Enabled() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return !s.disabledLocked()
+}
+
+// disabledLocked reports whether blocking is off; a lapsed timed disable counts as on
+func (s *BlockingSwitch) disabledLocked() bool {
+	return s.disabled && (s.until.IsZero() || time.Now().Before(s.until))
+}
+
+// Disable turns blocking off. With d > 0 it is re-enabled automatically after d.
+func (s *BlockingSwitch) Disable(d time.Duration) {
+	s.mu.Lock()
+	s.disabled = true
+	s.until = time.Time{}
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	if d > 0 {
+		s.until = time.Now().Add(d)
+		s.timer = time.AfterFunc(d, s.expire)
+	}
+	onChange := s.onChange
+	s.mu.Unlock()
+
+	if d > 0 {
+		log.Printf("blocking disabled for %s", d)
+	} else {
+		log.Printf("blocking disabled")
+	}
+	if onChange != nil {
+		onChange(false)
+	}
+}
+
+// Enable turns blocking back on and cancels any pending timer
+func (s *BlockingSwitch) Enable() {
+	s.mu.Lock()
+	wasDisabled := s.disabled
+	s.disabled = false
+	s.until = time.Time{}
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	onChange := s.onChange
+	s.mu.Unlock()
+
+	if wasDisabled {
+		log.Printf("blocking enabled")
+	}
+	if onChange != nil {
+		onChange(true)
+	}
+}
+
+// expire re-enables blocking when a timed disable runs out
+func (s *BlockingSwitch) expire() {
+	s.mu.Lock()
+	if !s.disabled || s.until.IsZero() || time.Now().Before(s.until) {
+		s.mu.Unlock()
+		return
+	}
+	s.mu.Unlock()
+	s.Enable()
+}
+
+// Status returns whether blocking is on and, for a timed disable, when it resumes
+func (s *BlockingSwitch) Status() BlockingStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.disabledLocked() {
+		return BlockingStatus{Enabled: true}
+	}
+	st := BlockingStatus{Enabled: false}
+	if !s.until.IsZero() {
+		until := s.until
+		st.Until = &until
+		st.PausedUntil = &until
+	}
+	return st
+}
+
+// OnChange registers a callback run after every Enable/Disable (e.g. to sync rust)
+func (s *BlockingSwitch) OnChange(fn func(enabled bool)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onChange = fn
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBlockingSwitch(t *testing.T) {
+	tests := []struct {
+		name        string
+		run         func(s *BlockingSwitch)
+		wait        time.Duration
+		wantEnabled bool
+		wantUntil   bool
+	}{
+		{"starts enabled", func(s *BlockingSwitch) {}, 0, true, false},
+		{"disabled indefinitely", func(s *BlockingSwitch) { s.Disable(0) }, 0, false, false},
+		{"timed disable pending", func(s *BlockingSwitch) { s.Disable(time.Hour) }, 0, false, true},
+		{"timed disable lapses", func(s *BlockingSwitch) { s.Disable(20 * time.Millisecond) }, 60 * time.Millisecond, true, false},
+		{"enable cancels the timer", func(s *BlockingSwitch) { s.Disable(time.Hour); s.Enable() }, 0, true, false},
+		{"indefinite replaces timed", func(s *BlockingSwitch) { s.Disable(20 * time.Millisecond); s.Disable(0) }, 60 * time.Millisecond, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &BlockingSwitch{}
+			var (
+				mu      sync.Mutex
+				changes []bool
+			)
+			s.OnChange(func(enabled bool) {
+				mu.Lock()
+				changes = append(changes, enabled)
+				mu.Unlock()
+			})
+			tt.run(s)
+			time.Sleep(tt.wait)
+			mu.Lock()
+			defer mu.Unlock()
+			if got := s.Enabled(); got != tt.wantEnabled {
+				t.Errorf("Enabled() = %v, want %v", got, tt.wantEnabled)
+			}
+			st := s.Status()
+			if st.Enabled != tt.wantEnabled || (st.PausedUntil != nil) != tt.wantUntil {
+				t.Errorf("Status() = %+v, want enabled %v with until %v", st, tt.wantEnabled, tt.wantUntil)
+			}
+			if tt.wantEnabled && len(changes) > 0 && !changes[len(changes)-1] {
+				t.Errorf("last OnChange reported disabled; changes %v", changes)
+			}
+		})
+	}
+}
+
+func TestDisabledBlockingForwardsEverything(t *testing.T) {
+	t.Cleanup(blockingSwitch.Enable)
+	withConfig(t, func(c *Config) { c.UnidentifiedClients = unidentifiedBlock })
+	bm := newTestBlocklistManager(t, map[string][]string{"ads": {"ads.example"}})
+
+	check := func(want bool) {
+		t.Helper()
+		if got := bm.IsBlocked("ads.example"); got != want {
+			t.Errorf("IsBlocked = %v, want %v", got, want)
+		}
+		if _, got := bm.MatchUnidentified(AppConfig(), "ads.example", 0); got != want {
+			t.Errorf("MatchUnidentified = %v, want %v", got, want)
+		}
+	}
+	check(true)
+	blockingSwitch.Disable(30 * time.Millisecond)
+	check(false)
+	time.Sleep(80 * time.Millisecond)
+	check(true)
+}
+
+func TestHandleBlocking(t *testing.T) {
+	t.Cleanup(blockingSwitch.Enable)
+	tests := []struct {
+		method, path, body string
+		wantStatus         int
+		wantEnabled        bool
+		wantPaused         bool // a paused_until about five minutes out
+	}{
+		{http.MethodGet, "/blocking/status", "", http.StatusOK, true, false},
+		{http.MethodPost, "/blocking/disable", `{"minutes": 5}`, http.StatusOK, false, true},
+		{http.MethodGet, "/blocking/status", "", http.StatusOK, false, true},
+		{http.MethodPost, "/blocking/enable", "", http.StatusOK, true, false},
+		{http.MethodPost, "/blocking/disable", "", http.StatusOK, false, false},
+		{http.MethodPost, "/blocking/enable", "", http.StatusOK, true, false},
+		{http.MethodPost, "/blocking/disable", `{"minutes": -1}`, http.StatusBadRequest, true, false},
+		{http.MethodPost, "/blocking/disable", `{"minutes": 100000}`, http.StatusBadRequest, true, false},
+		{http.MethodPost, "/blocking/disable", `{minutes`, http.StatusBadRequest, true, false},
+		{http.MethodGet, "/blocking/disable", "", http.StatusMethodNotAllowed, true, false},
+		{http.MethodPost, "/blocking/status", "", http.StatusMethodNotAllowed, true, false},
+		{http.MethodGet, "/blocking/other", "", http.StatusNotFound, true, false},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		handleBlocking(rec, httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body)))
+		if rec.Code != tt.wantStatus {
+			t.Fatalf("%s %s %s: status %d, want %d", tt.method, tt.path, tt.body, rec.Code, tt.wantStatus)
+		}
+		if got := blockingSwitch.Enabled(); got != tt.wantEnabled {
+			t.Fatalf("%s %s %s: blocking enabled %v, want %v", tt.method, tt.path, tt.body, got, tt.wantEnabled)
+		}
+		if rec.Code == http.StatusOK {
+			var st BlockingStatus
+			if err := json.Unmarshal(rec.Body.Bytes(), &st); err != nil || st.Enabled != tt.wantEnabled {
+				t.Fatalf("%s %s: body %s", tt.method, tt.path, rec.Body)
+			}
+			if (st.PausedUntil != nil) != tt.wantPaused {
+				t.Fatalf("%s %s: body %s, want paused_until %v", tt.method, tt.path, rec.Body, tt.wantPaused)
+			}
+			if tt.wantPaused {
+				if left := time.Until(*st.PausedUntil); left < 4*time.Minute || left > 5*time.Minute {
+					t.Errorf("%s %s: paused for another %v, want about 5m", tt.method, tt.path, left)
+				}
+				if st.Until == nil || !st.Until.Equal(*st.PausedUntil) {
+					t.Errorf("%s %s: until %v differs from paused_until %v", tt.method, tt.path, st.Until, st.PausedUntil)
+				}
+			}
+		}
+	}
+}
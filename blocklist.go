@@ -2,28 +2,47 @@ package main
 
 import (
     "bufio"
+    "context"
     "encoding/json"
     "errors"
+    "fmt"
     "io"
+    "io/fs"
     "net"
-    "net/http"
     "os"
     "path/filepath"
     "regexp"
+    "slices"
+    "sort"
     "strings"
     "sync"
+    "sync/atomic"
     "time"
-    "log"
+    "unicode/utf8"
+    "log/slog"
 )
 
 // BlocklistManager loads and manages blocklist files from a directory.
 // Each file is a plain text file with one pattern per line. Patterns can
 // include '*' wildcards (see docs). Lines starting with '#' and blank lines are ignored.
 type BlocklistManager struct {
-    dir      string
+    dir      string                    // empty when lists are kept in memory only
+    store    listStore                 // where list contents and sidecars are kept
     mu       sync.RWMutex
+    // editMu serializes read-modify-write edits of list contents, held from
+    // reading a list until the edit is reloaded so no edit is lost to another
+    editMu   sync.Mutex
     lists    map[string][]string       // raw patterns per list filename (no ext)
-    compiled []*regexp.Regexp         // combined compiled regexps for fast checks
+    matchers map[string]listMatcher   // built matcher per enabled list
+    order    []string                  // enabled lists in the order Match tries them
+    newMatcher MatcherFactory          // strategy used to build matchers in LoadAll
+    // HTTPClient downloads remote lists; nil uses fetchClient
+    HTTPClient HTTPClient
+    meta     map[string]ListMeta       // sidecar metadata per list (category, enabled)
+    global   []string                  // lists flagged global, consulted for every user
+    hosts    map[string]localRecordSet // records per enabled hosts list
+    lastLoad LoadReport                // outcome of the most recent LoadAll
+    loadedAt atomic.Int64              // unix nanos of the last completed LoadAll
     // analytics
     statsMu       sync.RWMutex
     queries       int
@@ -31,13 +50,39 @@ type BlocklistManager struct {
     domainHits    map[string]int // counts for blocked domains
     allHits       map[string]int // counts for all queried domains
     clientHits    map[string]int // counts per client IP
-    // recent queries (simple append-only ring)
+    clientSeen    map[string]*clientActivity // per client IP: counts and last seen, for /clients
+    listHits      map[string]map[string]int // per list: counts per blocked domain
+    userStats     map[string]*userCounters  // per MAC (or ip: fallback) counters
+    // recent queries (ring buffer; recentStart is the oldest entry once full)
     recentMu      sync.Mutex
     recent        []QueryEntry
+    recentStart   int
     recentCap     int
-    // persistent logs file (JSON lines)
+    // persistent logs file (JSON lines), written by a single background writer
     logPath       string
     logMu         sync.Mutex
+    logCh         chan QueryEntry
+    logFlush      chan chan struct{} // flushLog requests; closed once the queue is written
+    logDropped    atomic.Uint64 // entries dropped because the writer fell behind
+    logDropWarn   *logThrottle
+}
+
+// logQueueSize bounds how many log entries may wait for the background writer.
+const logQueueSize = 4096
+
+// MatchDetail describes which list and pattern caused a domain to be blocked.
+type MatchDetail struct {
+    List    string `json:"list"`
+    Pattern string `json:"pattern"`
+}
+
+// userCounters holds the analytics counters for a single user.
+type userCounters struct {
+    queries        int
+    blockedQueries int
+    domainHits     map[string]int
+    allHits        map[string]int
+    clientHits     map[string]int
 }
 
 // QueryEntry is a single DNS query record stored for recent logs.
@@ -45,7 +90,13 @@ type QueryEntry struct {
     Time    time.Time `json:"time"`
     Domain  string    `json:"domain"`
     Client  string    `json:"client"`
+    Qtype   string    `json:"qtype,omitempty"` // e.g. "A", "AAAA"; empty when unknown
     Blocked bool      `json:"blocked"`
+    // Reason is the list and pattern that blocked the query ("list: pattern") and
+    // Mode the blocking mode applied; both are empty for allowed queries
+    Reason  string    `json:"reason,omitempty"`
+    Mode    string    `json:"mode,omitempty"`
+    Label   string    `json:"label,omitempty"` // device name, filled in by the API on request
 }
 
 // NewBlocklistManager ensures dir exists, loads all lists and compiles patterns.
@@ -56,112 +107,390 @@ func NewBlocklistManager(dir string) (*BlocklistManager, error) {
     if err := os.MkdirAll(dir, 0o755); err != nil {
         return nil, err
     }
-        bm := &BlocklistManager{
-            dir: dir,
-            lists: make(map[string][]string),
-            domainHits: make(map[string]int),
-            clientHits: make(map[string]int),
-            allHits: make(map[string]int),
-            recent: make([]QueryEntry, 0, 500),
-            recentCap: 500,
-        }
+    bm := newBlocklistManager(&dirStore{dir: dir})
+    bm.dir = dir
     if err := bm.LoadAll(); err != nil {
         return nil, err
     }
     // logs file inside the same directory
     bm.logPath = filepath.Join(dir, "logs.jsonl")
+    bm.logCh = make(chan QueryEntry, logQueueSize)
+    bm.logFlush = make(chan chan struct{})
+    go bm.logWriter()
+    go bm.logCompactor(logCompactInterval)
     return bm, nil
 }
 
-// LoadAll reads all .txt files from the directory and compiles patterns.
+// NewMemoryBlocklistManager returns a manager that never touches the disk. It
+// starts with a copy of lists (name to patterns); lists created or edited
+// through it live in memory only, and queries go to the recent logs and
+// analytics but not to a log file. Meant for tests and stateless deployments.
+func NewMemoryBlocklistManager(lists map[string][]string) (*BlocklistManager, error) {
+    return newMemoryBlocklistManager(newMemStore(lists))
+}
+
+func newMemoryBlocklistManager(store *memStore) (*BlocklistManager, error) {
+    bm := newBlocklistManager(store)
+    if err := bm.LoadAll(); err != nil {
+        return nil, err
+    }
+    return bm, nil
+}
+
+// newBlocklistManager returns a manager over store with nothing loaded yet
+func newBlocklistManager(store listStore) *BlocklistManager {
+        return &BlocklistManager{
+            store: store,
+            lists: make(map[string][]string),
+            matchers: make(map[string]listMatcher),
+            newMatcher: newRegexMatcher,
+            meta: make(map[string]ListMeta),
+            hosts: make(map[string]localRecordSet),
+            listHits: make(map[string]map[string]int),
+            userStats: make(map[string]*userCounters),
+            domainHits: make(map[string]int),
+            clientHits: make(map[string]int),
+            clientSeen: make(map[string]*clientActivity),
+            allHits: make(map[string]int),
+            recent: make([]QueryEntry, 0, AppConfig().RecentLogCapacity()),
+            recentCap: AppConfig().RecentLogCapacity(),
+            logDropWarn: &logThrottle{interval: 10 * time.Second},
+        }
+}
+
+// LoadAll reads every list from the store and compiles patterns.
+// If the store can't be listed the previously loaded lists stay in effect and
+// the error is returned. A list file that can't be read keeps its previous
+// patterns; the reload still completes and the read errors are returned joined.
 func (b *BlocklistManager) LoadAll() error {
-    entries, err := os.ReadDir(b.dir)
+    report, err := b.loadAll()
     if err != nil {
         return err
     }
+    var readErrs []error
+    for _, f := range report.Failed {
+        readErrs = append(readErrs, fmt.Errorf("%s: %s", f.List, f.Error))
+    }
+    return errors.Join(readErrs...)
+}
+
+// loadAll does the work of LoadAll and reports what changed. Only a failure to
+// list the store is returned as an error.
+func (b *BlocklistManager) loadAll() (LoadReport, error) {
+    names, err := b.store.Names()
+    if err != nil {
+        slog.Error("LoadAll: blocklist directory unreadable; keeping previous lists", "dir", b.dir, "err", err)
+        return LoadReport{}, err
+    }
+
+    b.mu.RLock()
+    previous := b.lists
+    b.mu.RUnlock()
 
+    var failed []LoadFailure
     lists := make(map[string][]string)
-    for _, e := range entries {
-        if e.IsDir() {
-            continue
+    meta := make(map[string]ListMeta)
+    for _, base := range names {
+        patterns, err := b.store.Read(base)
+        if err != nil {
+            if errors.Is(err, fs.ErrNotExist) {
+                // removed between Names and Read
+                continue
+            }
+            failed = append(failed, LoadFailure{List: base, Error: err.Error()})
+            if prev, ok := previous[base]; ok {
+                slog.Warn("LoadAll: list unreadable; keeping previous patterns", "list", base, "err", err)
+                patterns = prev
+            } else if len(patterns) == 0 {
+                continue
+            }
         }
-        name := e.Name()
-        if !strings.HasSuffix(strings.ToLower(name), ".txt") {
+        lists[base] = patterns
+        meta[base] = b.readListMeta(base)
+    }
+
+    // hosts lists answer their names instead of blocking them
+    hosts := make(map[string]localRecordSet)
+    for name := range lists {
+        if meta[name].Type != listTypeHosts || !meta[name].Enabled {
             continue
         }
-        path := filepath.Join(b.dir, name)
-        f, err := os.Open(path)
+        raw, err := b.store.ReadRaw(name)
         if err != nil {
+            slog.Warn("LoadAll: hosts list unreadable; keeping previous records", "list", name, "err", err)
+            b.mu.RLock()
+            if prev, ok := b.hosts[name]; ok {
+                hosts[name] = prev
+            }
+            b.mu.RUnlock()
             continue
         }
-        patterns, _ := readLines(f)
-        _ = f.Close()
-        base := strings.TrimSuffix(name, filepath.Ext(name))
-        lists[base] = patterns
+        hosts[name] = hostsRecordSet(raw)
     }
 
-    // compile into regexps
-    compiled := make([]*regexp.Regexp, 0)
-    for _, pats := range lists {
+    // build a matcher per list; disabled lists stay loaded but never match.
+    // One config snapshot keeps every list built with the same settings.
+    cfg := AppConfig()
+    matchers := make(map[string]listMatcher, len(lists))
+    order := make([]string, 0, len(lists))
+    var patternErrs []PatternError
+    for name, pats := range lists {
+        if !meta[name].Enabled || meta[name].Type == listTypeHosts {
+            continue
+        }
+        lm, errs, ok := b.buildMatcher(cfg, name, pats, meta[name])
+        patternErrs = append(patternErrs, errs...)
+        if !ok {
+            continue
+        }
+        matchers[name] = lm
+        order = append(order, name)
+    }
+    sort.Strings(order)
+
+    global := make([]string, 0)
+    for name := range lists {
+        if meta[name].Global {
+            global = append(global, name)
+        }
+    }
+    sort.Strings(global)
+
+    report := newLoadReport(previous, lists, failed, patternErrs)
+
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    b.lists = lists
+    b.matchers = matchers
+    b.order = order
+    b.meta = meta
+    b.global = global
+    b.hosts = hosts
+    b.lastLoad = report
+    b.loadedAt.Store(report.LoadedAt.UnixNano())
+    return report, nil
+}
+
+// buildMatcher compiles the patterns of one enabled blocklist. Patterns that
+// don't compile are skipped and returned; ok is false when the matcher as a
+// whole failed to build.
+func (b *BlocklistManager) buildMatcher(cfg *Config, name string, pats []string, meta ListMeta) (lm listMatcher, errs []PatternError, ok bool) {
+    m := b.newMatcher(meta.MatchesSubdomains(cfg))
+    for _, p := range pats {
+        if p = strings.TrimSpace(p); p == "" {
+            continue
+        }
+        if err := m.Add(p); err != nil {
+            slog.Warn("skipping pattern that doesn't compile", "list", name, "pattern", p, "err", err)
+            errs = append(errs, PatternError{List: name, Pattern: p, Error: err.Error()})
+        }
+    }
+    // "*.example.com" also blocks example.com when configured, unless the list has it already
+    wildcardOf := make(map[string]string)
+    if cfg.BlockApexWithWildcard {
         for _, p := range pats {
-            if p = strings.TrimSpace(p); p == "" {
+            apex := wildcardApex(normalizePattern(p))
+            if _, dup := wildcardOf[apex]; apex == "" || dup || slices.Contains(pats, apex) {
                 continue
             }
-            re, err := patternToRegexp(p)
-            if err == nil && re != nil {
-                compiled = append(compiled, re)
+            if err := m.Add(apex); err == nil {
+                wildcardOf[apex] = normalizePattern(p)
             }
         }
     }
+    if err := m.Build(); err != nil {
+        slog.Warn("skipping list whose matcher failed to build", "list", name, "err", err)
+        return listMatcher{}, append(errs, PatternError{List: name, Error: err.Error()}), false
+    }
+    var built Matcher = m
+    if len(wildcardOf) > 0 {
+        built = apexMatcher{Matcher: m, wildcardOf: wildcardOf}
+    }
+    return listMatcher{Matcher: built, qtypes: meta.QueryTypes()}, errs, true
+}
 
-    b.mu.Lock()
-    defer b.mu.Unlock()
-    b.lists = lists
-    b.compiled = compiled
-    return nil
+// LoadedAt returns when the lists were last (re)loaded.
+func (b *BlocklistManager) LoadedAt() time.Time {
+    return time.Unix(0, b.loadedAt.Load())
 }
 
-// IsBlocked returns true if the domain matches any compiled pattern.
-// domain should be a host like "tracker.example.com" (trailing dot is tolerated).
+// readListFile reads the patterns of a single list file.
+func readListFile(path string) ([]string, error) {
+    f, err := os.Open(path)
+    if err != nil {
+        return nil, err
+    }
+    defer f.Close()
+    return readLines(f)
+}
+
+// IsBlocked returns true if the domain matches any enabled list.
+// domain is a host like "tracker.example.com" (trailing dot is tolerated); a
+// URL or host:port is reduced to its host first.
 func (b *BlocklistManager) IsBlocked(domain string) bool {
-    d := strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".")
+    host, err := hostFromInput(domain)
+    if err != nil {
+        return false
+    }
+    _, ok := b.Match(host)
+    return ok
+}
+
+// Match returns the list and pattern of the first enabled list matching domain.
+// Nothing matches while the blocking kill-switch is off.
+func (b *BlocklistManager) Match(domain string) (MatchDetail, bool) {
+    return b.MatchType(domain, 0)
+}
+
+// MatchType is Match for a query of type qtype, skipping lists scoped to other
+// record types. qtype 0 matches regardless of scoping.
+func (b *BlocklistManager) MatchType(domain string, qtype uint16) (MatchDetail, bool) {
+    if !blockingSwitch.Enabled() {
+        return MatchDetail{}, false
+    }
+    d := canonicalDomain(strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), "."))
     b.mu.RLock()
     defer b.mu.RUnlock()
-    for _, re := range b.compiled {
-        if re.MatchString(d) {
-            return true
+    for _, name := range b.order {
+        lm := b.matchers[name]
+        if !lm.appliesTo(qtype) {
+            continue
+        }
+        if p, ok := lm.Match(d); ok {
+            return MatchDetail{List: name, Pattern: p}, true
         }
     }
-    return false
+    return MatchDetail{}, false
+}
+
+// HasList reports whether a list is stored under listName.
+func (b *BlocklistManager) HasList(listName string) bool {
+    return b.store.Exists(listName)
+}
+
+// DeleteList removes a list and its metadata from the store. The caller reloads.
+func (b *BlocklistManager) DeleteList(listName string) error {
+    b.editMu.Lock()
+    defer b.editMu.Unlock()
+    return b.store.Remove(listName)
+}
+
+// ErrListExists is returned when an operation would overwrite an existing list.
+var ErrListExists = errors.New("list already exists")
+
+// RenameList renames a list and its metadata sidecar, then reloads.
+// It fails with ErrListExists if newName is taken and os.ErrNotExist if oldName is missing.
+func (b *BlocklistManager) RenameList(oldName, newName string) error {
+    if oldName == "" || newName == "" {
+        return errors.New("missing list name")
+    }
+    b.editMu.Lock()
+    defer b.editMu.Unlock()
+    if !b.HasList(oldName) {
+        return os.ErrNotExist
+    }
+    if b.HasList(newName) {
+        return ErrListExists
+    }
+    if err := b.store.Rename(oldName, newName); err != nil {
+        return err
+    }
+
+    // carry per-list hit counts over to the new name
+    b.statsMu.Lock()
+    if hits, ok := b.listHits[oldName]; ok {
+        b.listHits[newName] = hits
+        delete(b.listHits, oldName)
+    }
+    b.statsMu.Unlock()
+
+    if err := b.LoadAll(); err != nil {
+        slog.Error("RenameList: reload failed", "err", err)
+    }
+    slog.Info("RenameList: renamed list", "from", oldName, "to", newName)
+    return nil
+}
+
+// MergeLists writes the union of the normalized entries of sources into dest and
+// reloads once. dest may be one of the sources; otherwise it must not exist yet.
+// It returns the number of entries in dest.
+func (b *BlocklistManager) MergeLists(sources []string, dest string) (int, error) {
+    if len(sources) == 0 || dest == "" {
+        return 0, errors.New("missing source or destination list")
+    }
+    b.editMu.Lock()
+    defer b.editMu.Unlock()
+    destIsSource := false
+    for _, src := range sources {
+        if src == dest {
+            destIsSource = true
+        }
+        if !b.HasList(src) {
+            return 0, os.ErrNotExist
+        }
+        if b.isHostsList(src) {
+            return 0, ErrHostsList
+        }
+    }
+    if !destIsSource && b.HasList(dest) {
+        return 0, ErrListExists
+    }
+
+    set := make(map[string]struct{})
+    for _, src := range sources {
+        lines, err := b.store.Read(src)
+        if err != nil {
+            return 0, err
+        }
+        for _, l := range lines {
+            if s := normalizePattern(l); s != "" {
+                set[s] = struct{}{}
+            }
+        }
+    }
+    entries := make([]string, 0, len(set))
+    for k := range set {
+        entries = append(entries, k)
+    }
+    sort.Strings(entries)
+
+    if err := b.store.Write(dest, entries); err != nil {
+        slog.Error("MergeLists: write failed", "list", dest, "err", err)
+        return 0, err
+    }
+    if err := b.LoadAll(); err != nil {
+        slog.Error("MergeLists: reload failed", "err", err)
+    }
+    slog.Info("MergeLists: wrote entries", "sources", sources, "dest", dest, "entries", len(entries))
+    return len(entries), nil
 }
 
 // AddFileToList downloads the URL (raw text) and appends unique entries into the named list.
-// If createIfMissing is true it creates a new list file.
-func (b *BlocklistManager) AddFileToList(listName, url string, createIfMissing bool) (int, error) {
+// If createIfMissing is true it creates a new list file. Cancelling ctx aborts the download.
+func (b *BlocklistManager) AddFileToList(ctx context.Context, listName, url string, createIfMissing bool) (int, error) {
     if listName == "" || url == "" {
         return 0, errors.New("missing list name or url")
     }
+    if b.isHostsList(listName) {
+        lines, err := b.hostsSource(ctx, listName, url, nil)
+        if err != nil {
+            return 0, err
+        }
+        return b.writeHostsLines(listName, lines, false)
+    }
 
-    client := &http.Client{Timeout: 15 * time.Second}
-    resp, err := client.Get(url)
+    newLines, err := fetchListLines(ctx, b.fetcher(), url, b.readListMeta(listName).FetchHeader())
     if err != nil {
-        log.Printf("AddFileToList: failed to GET %s: %v", url, err)
+        slog.Error("AddFileToList: fetch failed", "url", url, "err", err)
         return 0, err
     }
-    defer resp.Body.Close()
-    if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-        return 0, errors.New("failed to fetch file: " + resp.Status)
-    }
-
-    newLines, _ := readLines(resp.Body)
+    b.editMu.Lock()
+    defer b.editMu.Unlock()
     // filter and normalize lines
     set := make(map[string]struct{})
 
-    path := filepath.Join(b.dir, listName+".txt")
     // read existing
-    if f, err := os.Open(path); err == nil {
-        old, _ := readLines(f)
-        _ = f.Close()
+    if old, err := b.store.Read(listName); err == nil {
         for _, l := range old {
             s := normalizePattern(l)
             if s != "" {
@@ -185,78 +514,71 @@ func (b *BlocklistManager) AddFileToList(listName, url string, createIfMissing b
     }
 
     // write back
-    f, err := os.Create(path)
-    if err != nil {
-        log.Printf("AddFileToList: failed to create %s: %v", path, err)
+    if err := b.store.Write(listName, setKeys(set)); err != nil {
+        slog.Error("AddFileToList: write failed", "list", listName, "err", err)
         return 0, err
     }
-    defer f.Close()
-    for k := range set {
-        if _, err := f.WriteString(k + "\n"); err != nil {
-            return added, err
-        }
-    }
 
     // reload lists
     if err := b.LoadAll(); err != nil {
-        log.Printf("AddFileToList: reload failed: %v", err)
+        slog.Error("AddFileToList: reload failed", "err", err)
     }
-    log.Printf("AddFileToList: appended %d entries to %s", added, listName)
+    slog.Info("AddFileToList: appended entries", "list", listName, "added", added)
     return added, nil
 }
 
 // ReplaceListFromURL downloads the file and replaces the named list entirely with the parsed domains.
-func (b *BlocklistManager) ReplaceListFromURL(listName, url string) (int, error) {
+// Cancelling ctx aborts the download.
+func (b *BlocklistManager) ReplaceListFromURL(ctx context.Context, listName, url string) (int, error) {
     if listName == "" || url == "" {
         return 0, errors.New("missing list name or url")
     }
-    client := &http.Client{Timeout: 15 * time.Second}
-    resp, err := client.Get(url)
-    if err != nil {
-        log.Printf("ReplaceListFromURL: failed to GET %s: %v", url, err)
-        return 0, err
-    }
-    defer resp.Body.Close()
-    if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-        return 0, errors.New("failed to fetch file: " + resp.Status)
+    if b.isHostsList(listName) {
+        lines, err := b.hostsSource(ctx, listName, url, nil)
+        if err != nil {
+            return 0, err
+        }
+        return b.writeHostsLines(listName, lines, true)
     }
-
-    newLines, _ := readLines(resp.Body)
-    path := filepath.Join(b.dir, listName+".txt")
-    f, err := os.Create(path)
+    newLines, err := fetchListLines(ctx, b.fetcher(), url, b.readListMeta(listName).FetchHeader())
     if err != nil {
-        log.Printf("ReplaceListFromURL: failed to create %s: %v", path, err)
+        slog.Error("ReplaceListFromURL: fetch failed", "url", url, "err", err)
         return 0, err
     }
-    defer f.Close()
-    written := 0
+    b.editMu.Lock()
+    defer b.editMu.Unlock()
+    entries := make([]string, 0, len(newLines))
     for _, l := range newLines {
         if l == "" { continue }
-        if _, err := f.WriteString(l + "\n"); err != nil {
-            return written, err
-        }
-        written++
+        entries = append(entries, l)
     }
+    if err := b.store.Write(listName, entries); err != nil {
+        slog.Error("ReplaceListFromURL: write failed", "list", listName, "err", err)
+        return 0, err
+    }
+    written := len(entries)
     if err := b.LoadAll(); err != nil {
-        log.Printf("ReplaceListFromURL: reload failed: %v", err)
+        slog.Error("ReplaceListFromURL: reload failed", "err", err)
     }
-    log.Printf("ReplaceListFromURL: wrote %d entries to %s", written, listName)
+    slog.Info("ReplaceListFromURL: wrote entries", "list", listName, "written", written)
     return written, nil
 }
 
-// AddItemsToList appends unique normalized items into the named list file.
+// AddItemsToList appends unique normalized items into the named list.
 // items may contain raw domains; normalization is applied. If createIfMissing is true,
-// the list file is created when missing.
+// the list is created when missing.
 func (b *BlocklistManager) AddItemsToList(listName string, items []string, createIfMissing bool) (int, error) {
     if listName == "" {
         return 0, errors.New("missing list name")
     }
-    path := filepath.Join(b.dir, listName+".txt")
+    if b.isHostsList(listName) {
+        return b.writeHostsLines(listName, hostsItemLines(items), false)
+    }
+    b.editMu.Lock()
+    defer b.editMu.Unlock()
     set := make(map[string]struct{})
     // read existing
-    if f, err := os.Open(path); err == nil {
-        old, _ := readLines(f)
-        _ = f.Close()
+    if old, err := b.store.Read(listName); err == nil {
         for _, l := range old {
             s := normalizePattern(l)
             if s != "" {
@@ -271,29 +593,27 @@ func (b *BlocklistManager) AddItemsToList(listName string, items []string, creat
         // split on commas/spaces/newlines if the single item contains many
         parts := strings.FieldsFunc(it, func(r rune) bool { return r == ',' || r == ' ' || r == '\n' || r == '\r' || r == '\t' })
         for _, p := range parts {
-            s := normalizePattern(p)
-            if s == "" { continue }
-            if _, ok := set[s]; !ok {
-                set[s] = struct{}{}
-                added++
+            pats := []string{normalizePattern(p)}
+            if isABPRule(p) {
+                pats, _ = convertABPRule(p)
+            }
+            for _, s := range pats {
+                if s == "" { continue }
+                if _, ok := set[s]; !ok {
+                    set[s] = struct{}{}
+                    added++
+                }
             }
         }
     }
     // write back
-    f, err := os.Create(path)
-    if err != nil {
+    if err := b.store.Write(listName, setKeys(set)); err != nil {
         return 0, err
     }
-    defer f.Close()
-    for k := range set {
-        if _, err := f.WriteString(k + "\n"); err != nil {
-            return added, err
-        }
-    }
     if err := b.LoadAll(); err != nil {
-        log.Printf("AddItemsToList: reload failed: %v", err)
+        slog.Error("AddItemsToList: reload failed", "err", err)
     }
-    log.Printf("AddItemsToList: appended %d entries to %s", added, listName)
+    slog.Info("AddItemsToList: appended entries", "list", listName, "added", added)
     return added, nil
 }
 
@@ -309,20 +629,33 @@ func (b *BlocklistManager) RecordQuery(domain string, blocked bool) {
     b.statsMu.Unlock()
 
     // append recent log (no client info)
-    b.recentMu.Lock()
-    defer b.recentMu.Unlock()
-    entry := QueryEntry{Time: time.Now().UTC(), Domain: domain, Blocked: blocked}
-    b.recent = append(b.recent, entry)
-    if len(b.recent) > b.recentCap {
-        drop := len(b.recent) - b.recentCap
-        b.recent = b.recent[drop:]
-    }
-    // persist to disk (best-effort)
-    go b.appendLog(entry)
+    b.pushRecent(QueryEntry{Time: time.Now().UTC(), Domain: domain, Blocked: blocked})
 }
 
 // RecordQueryWithClient records a query including the client's address.
 func (b *BlocklistManager) RecordQueryWithClient(domain, client string, blocked bool) {
+    b.RecordQueryOfType(domain, client, "", blocked)
+}
+
+// RecordQueryOfType records a query including the client's address and query type.
+func (b *BlocklistManager) RecordQueryOfType(domain, client, qtype string, blocked bool) {
+    b.recordQuery(QueryEntry{Domain: domain, Client: client, Qtype: qtype, Blocked: blocked})
+}
+
+// RecordBlockedQuery records a blocked query along with the match that blocked
+// it and the blocking mode used to answer.
+func (b *BlocklistManager) RecordBlockedQuery(domain, client, qtype string, detail MatchDetail, mode string) {
+    reason := detail.List
+    if detail.Pattern != "" {
+        reason += ": " + detail.Pattern
+    }
+    b.recordQuery(QueryEntry{Domain: domain, Client: client, Qtype: qtype, Blocked: true, Reason: reason, Mode: mode})
+}
+
+// recordQuery updates the counters for e and appends it to the recent log.
+func (b *BlocklistManager) recordQuery(e QueryEntry) {
+    domain, client, blocked := e.Domain, e.Client, e.Blocked
+    e.Time = time.Now().UTC()
     b.statsMu.Lock()
     b.queries++
     if blocked {
@@ -331,24 +664,111 @@ func (b *BlocklistManager) RecordQueryWithClient(domain, client string, blocked
     }
     b.allHits[domain]++
     if client != "" {
-        b.clientHits[client]++
+        // count per device: client carries the query's source port, which changes every time
+        ip := GetClientIP(client)
+        b.clientHits[ip]++
+        b.noteClient(client, blocked, e.Time)
+        if owner := clientOwner(client); owner != "" {
+            uc, ok := b.userStats[owner]
+            if !ok {
+                uc = &userCounters{domainHits: make(map[string]int), allHits: make(map[string]int), clientHits: make(map[string]int)}
+                b.userStats[owner] = uc
+            }
+            uc.queries++
+            if blocked {
+                uc.blockedQueries++
+                uc.domainHits[domain]++
+            }
+            uc.allHits[domain]++
+            uc.clientHits[ip]++
+        }
     }
     b.statsMu.Unlock()
 
+    b.pushRecent(e)
+}
+
+// pushRecent adds an entry to the recent ring, overwriting the oldest once full,
+// and queues it for the persistent log. The ring is allocated at its full
+// capacity up front and its storage is reused for its lifetime. Both happen under recentMu so the file
+// and the ring see entries in the same order.
+func (b *BlocklistManager) pushRecent(e QueryEntry) {
     b.recentMu.Lock()
     defer b.recentMu.Unlock()
-    entry := QueryEntry{Time: time.Now().UTC(), Domain: domain, Client: client, Blocked: blocked}
-    b.recent = append(b.recent, entry)
-    if len(b.recent) > b.recentCap {
-        drop := len(b.recent) - b.recentCap
-        b.recent = b.recent[drop:]
+    b.enqueueLog(e)
+    if len(b.recent) < b.recentCap {
+        b.recent = append(b.recent, e)
+        return
+    }
+    b.recent[b.recentStart] = e
+    b.recentStart = (b.recentStart + 1) % b.recentCap
+}
+
+// enqueueLog hands an entry to the background writer without blocking. If the
+// writer has fallen behind the entry is dropped and counted.
+func (b *BlocklistManager) enqueueLog(e QueryEntry) {
+    if b.logCh == nil {
+        return
+    }
+    select {
+    case b.logCh <- e:
+    default:
+        dropped := b.logDropped.Add(1)
+        if ok, _ := b.logDropWarn.Allow(); ok {
+            slog.Warn("query log writer falling behind; dropping entries", "dropped_total", dropped)
+        }
+    }
+}
+
+// LogDropped returns how many log entries were dropped because the writer fell behind.
+func (b *BlocklistManager) LogDropped() uint64 {
+    return b.logDropped.Load()
+}
+
+// logWriter drains logCh, writing whatever is queued in one batch per wakeup.
+// A flushLog request is answered once everything queued before it is written.
+func (b *BlocklistManager) logWriter() {
+    batch := make([]QueryEntry, 0, 256)
+    for {
+        select {
+        case e := <-b.logCh:
+            batch = append(batch[:0], e)
+            b.appendLog(b.drainLog(batch))
+        case done := <-b.logFlush:
+            for batch = b.drainLog(batch[:0]); len(batch) > 0; batch = b.drainLog(batch[:0]) {
+                b.appendLog(batch)
+            }
+            close(done)
+        }
     }
-    // persist to disk (best-effort)
-    go b.appendLog(entry)
 }
 
-// appendLog writes a single QueryEntry as a JSON line to the log file. Best-effort: failures are logged but not returned.
-func (b *BlocklistManager) appendLog(e QueryEntry) {
+// drainLog appends the entries waiting in logCh to batch, up to its capacity
+func (b *BlocklistManager) drainLog(batch []QueryEntry) []QueryEntry {
+    for len(batch) < cap(batch) {
+        select {
+        case e := <-b.logCh:
+            batch = append(batch, e)
+        default:
+            return batch
+        }
+    }
+    return batch
+}
+
+// flushLog waits until the entries queued so far are in the log file. It must
+// not be called with logMu held.
+func (b *BlocklistManager) flushLog() {
+    if b.logFlush == nil {
+        return
+    }
+    done := make(chan struct{})
+    b.logFlush <- done
+    <-done
+}
+
+// appendLog writes QueryEntry records as JSON lines to the log file. Best-effort: failures are logged but not returned.
+func (b *BlocklistManager) appendLog(entries []QueryEntry) {
     if b.logPath == "" {
         return
     }
@@ -356,22 +776,27 @@ func (b *BlocklistManager) appendLog(e QueryEntry) {
     defer b.logMu.Unlock()
     f, err := os.OpenFile(b.logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
     if err != nil {
-        log.Printf("appendLog: open failed: %v", err)
+        slog.Error("appendLog: open failed", "err", err)
         return
     }
+    // close before rotating; Windows can't rename an open file
+    defer b.rotateLogIfNeeded()
     defer f.Close()
-    data, err := json.Marshal(e)
-    if err != nil {
-        log.Printf("appendLog: marshal failed: %v", err)
-        return
+    w := bufio.NewWriter(f)
+    for _, e := range entries {
+        data, err := json.Marshal(e)
+        if err != nil {
+            slog.Error("appendLog: marshal failed", "err", err)
+            continue
+        }
+        w.Write(append(data, '\n'))
     }
-    if _, err := f.Write(append(data, '\n')); err != nil {
-        log.Printf("appendLog: write failed: %v", err)
-        return
+    if err := w.Flush(); err != nil {
+        slog.Error("appendLog: write failed", "err", err)
     }
 }
 
-// DeleteLogs truncates the persistent log file and clears in-memory recent logs.
+// DeleteLogs truncates the persistent log file, removes its rotated segments and clears in-memory recent logs.
 func (b *BlocklistManager) DeleteLogs() error {
     b.logMu.Lock()
     defer b.logMu.Unlock()
@@ -384,31 +809,196 @@ func (b *BlocklistManager) DeleteLogs() error {
             }
             if f, err := os.Create(b.logPath); err == nil { _ = f.Close() }
         }
+        if err := b.removeLogSegments(); err != nil {
+            return err
+        }
     }
     b.recentMu.Lock()
-    b.recent = make([]QueryEntry, 0, b.recentCap)
+    clear(b.recent)
+    b.recent = b.recent[:0]
+    b.recentStart = 0
     b.recentMu.Unlock()
     return nil
 }
 
+// compactRecent removes the entries drop matches from the recent ring in place,
+// keeping chronological order and the ring's storage, and returns how many it
+// removed. Caller holds recentMu.
+func (b *BlocklistManager) compactRecent(drop func(QueryEntry) bool) int {
+    // rotate the oldest entry to the front; DeleteFunc then zeroes the freed tail
+    slices.Reverse(b.recent[:b.recentStart])
+    slices.Reverse(b.recent[b.recentStart:])
+    slices.Reverse(b.recent)
+    b.recentStart = 0
+    n := len(b.recent)
+    b.recent = slices.DeleteFunc(b.recent, drop)
+    return n - len(b.recent)
+}
+
 // GetLogs returns up to `limit` most recent QueryEntry records (most recent last).
 func (b *BlocklistManager) GetLogs(limit int) []QueryEntry {
     b.recentMu.Lock()
     defer b.recentMu.Unlock()
-    if limit <= 0 || limit > len(b.recent) {
-        limit = len(b.recent)
+    n := len(b.recent)
+    if limit <= 0 || limit > n {
+        limit = n
     }
     res := make([]QueryEntry, limit)
-    copy(res, b.recent[len(b.recent)-limit:])
+    // the newest entry sits just before recentStart; copy the last `limit` in order
+    for i := 0; i < limit; i++ {
+        res[i] = b.recent[(b.recentStart+n-limit+i)%n]
+    }
     return res
 }
 
+// GetLogsFor is GetLogs limited to the entries from owner's devices, as
+// clientOwner attributes them. An empty owner has no entries.
+func (b *BlocklistManager) GetLogsFor(owner string, limit int) []QueryEntry {
+    if owner == "" {
+        return []QueryEntry{}
+    }
+    all := b.GetLogs(0)
+    own := all[:0]
+    for _, e := range all {
+        if clientOwner(e.Client) == owner {
+            own = append(own, e)
+        }
+    }
+    if limit > 0 && len(own) > limit {
+        own = own[len(own)-limit:]
+    }
+    return own
+}
+
+// RecordListHit attributes a blocked query for domain to the list that matched it.
+func (b *BlocklistManager) RecordListHit(list, domain string) {
+    if list == "" {
+        return
+    }
+    b.statsMu.Lock()
+    defer b.statsMu.Unlock()
+    hits, ok := b.listHits[list]
+    if !ok {
+        hits = make(map[string]int)
+        b.listHits[list] = hits
+    }
+    hits[domain]++
+}
+
+// DomainCount is a domain and how often it was seen.
+type DomainCount struct {
+    Domain string `json:"domain"`
+    Count  int    `json:"count"`
+}
+
+// ListStats describes how much blocking a single list is responsible for.
+type ListStats struct {
+    Entries    int           `json:"entries"`
+    Matches    int           `json:"matches"`
+    TopDomains []DomainCount `json:"top_domains"`
+}
+
+// GetListStats returns entry and match counts for the named list along with its
+// top `top` matched domains.
+func (b *BlocklistManager) GetListStats(listName string, top int) (ListStats, error) {
+    b.mu.RLock()
+    arr, ok := b.lists[listName]
+    b.mu.RUnlock()
+    if !ok {
+        return ListStats{}, os.ErrNotExist
+    }
+    b.statsMu.RLock()
+    hits := b.listHits[listName]
+    total := 0
+    counts := make([]DomainCount, 0, len(hits))
+    for d, n := range hits {
+        total += n
+        counts = append(counts, DomainCount{Domain: d, Count: n})
+    }
+    b.statsMu.RUnlock()
+    return ListStats{Entries: len(arr), Matches: total, TopDomains: topDomainCounts(counts, top)}, nil
+}
+
+// topDomainCounts sorts counts by descending count (ties by domain) and truncates to n.
+func topDomainCounts(counts []DomainCount, n int) []DomainCount {
+    sort.Slice(counts, func(i, j int) bool {
+        if counts[i].Count != counts[j].Count {
+            return counts[i].Count > counts[j].Count
+        }
+        return counts[i].Domain < counts[j].Domain
+    })
+    if n > 0 && len(counts) > n {
+        counts = counts[:n]
+    }
+    return counts
+}
+
+// TopCount is a domain or client and how often it was seen.
+type TopCount struct {
+    Name  string `json:"name"`
+    Count int    `json:"count"`
+}
+
+// maxTopN caps how many entries GetTop returns.
+const maxTopN = 100
+
+// GetTop returns the n most frequent entries of kind "blocked" (domains),
+// "allowed" (domains) or "clients", sorted by descending count with ties by name.
+// owner limits the counts to one user's devices; empty means network-wide.
+func (b *BlocklistManager) GetTop(owner, kind string, n int) ([]TopCount, error) {
+    if n <= 0 || n > maxTopN {
+        n = maxTopN
+    }
+    b.statsMu.RLock()
+    defer b.statsMu.RUnlock()
+    blocked, all, clients := b.domainHits, b.allHits, b.clientHits
+    if owner != "" {
+        uc, ok := b.userStats[owner]
+        if !ok {
+            return []TopCount{}, nil
+        }
+        blocked, all, clients = uc.domainHits, uc.allHits, uc.clientHits
+    }
+
+    counts := make([]TopCount, 0)
+    switch kind {
+    case "blocked":
+        for k, v := range blocked {
+            counts = append(counts, TopCount{Name: k, Count: v})
+        }
+    case "allowed":
+        for k, v := range all {
+            if v -= blocked[k]; v > 0 {
+                counts = append(counts, TopCount{Name: k, Count: v})
+            }
+        }
+    case "clients":
+        for k, v := range clients {
+            counts = append(counts, TopCount{Name: k, Count: v})
+        }
+    default:
+        return nil, fmt.Errorf("unknown kind %q", kind)
+    }
+
+    sort.Slice(counts, func(i, j int) bool {
+        if counts[i].Count != counts[j].Count {
+            return counts[i].Count > counts[j].Count
+        }
+        return counts[i].Name < counts[j].Name
+    })
+    if len(counts) > n {
+        counts = counts[:n]
+    }
+    return counts, nil
+}
+
 // StatsSnapshot holds simple analytics data returned by the API.
 type StatsSnapshot struct {
     Queries       int            `json:"queries"`
     Blocked       int            `json:"blocked"`
     DomainHits    map[string]int `json:"domain_hits"`
     ClientHits    map[string]int `json:"client_hits"`
+    ClientLabels  map[string]string `json:"client_labels,omitempty"` // device names, filled in by the API on request
 }
 
 // GetStats returns a snapshot of analytics.
@@ -427,6 +1017,44 @@ func (b *BlocklistManager) GetStats() StatsSnapshot {
     return StatsSnapshot{Queries: b.queries, Blocked: b.blockedQueries, DomainHits: dh, ClientHits: ch}
 }
 
+// GetStatsForUser returns the analytics for queries made by the user's devices only.
+func (b *BlocklistManager) GetStatsForUser(mac string) StatsSnapshot {
+    b.statsMu.RLock()
+    defer b.statsMu.RUnlock()
+    uc, ok := b.userStats[mac]
+    if !ok {
+        return StatsSnapshot{DomainHits: map[string]int{}, ClientHits: map[string]int{}}
+    }
+    dh := make(map[string]int, len(uc.domainHits))
+    for k, v := range uc.domainHits {
+        dh[k] = v
+    }
+    ch := make(map[string]int, len(uc.clientHits))
+    for k, v := range uc.clientHits {
+        ch[k] = v
+    }
+    return StatsSnapshot{Queries: uc.queries, Blocked: uc.blockedQueries, DomainHits: dh, ClientHits: ch}
+}
+
+// ResetStats zeroes the analytics counters: the network-wide and per-user
+// totals, the per-list match counts and the /clients query counts. Devices
+// keep their last-seen time, and the recent and persistent logs are left for
+// DeleteLogs.
+func (b *BlocklistManager) ResetStats() {
+    b.statsMu.Lock()
+    defer b.statsMu.Unlock()
+    b.queries = 0
+    b.blockedQueries = 0
+    b.domainHits = make(map[string]int)
+    b.allHits = make(map[string]int)
+    b.clientHits = make(map[string]int)
+    b.listHits = make(map[string]map[string]int)
+    b.userStats = make(map[string]*userCounters)
+    for _, a := range b.clientSeen {
+        a.queries, a.blocked = 0, 0
+    }
+}
+
 // ListDomains returns domains from a named list with simple pagination and optional substring search.
 func (b *BlocklistManager) ListDomains(listName string, offset, limit int, q string) (total int, items []string, err error) {
     b.mu.RLock()
@@ -435,7 +1063,7 @@ func (b *BlocklistManager) ListDomains(listName string, offset, limit int, q str
     if !ok {
         return 0, nil, os.ErrNotExist
     }
-    lowerQ := strings.ToLower(strings.TrimSpace(q))
+    lowerQ := canonicalDomain(strings.ToLower(strings.TrimSpace(q)))
     filtered := make([]string, 0, len(arr))
     if lowerQ == "" {
         filtered = append(filtered, arr...)
@@ -460,45 +1088,184 @@ func (b *BlocklistManager) ListDomains(listName string, offset, limit int, q str
 
 // RemoveDomain removes a domain from the named list file and reloads lists.
 func (b *BlocklistManager) RemoveDomain(listName, domain string) (bool, error) {
-    if listName == "" || domain == "" {
+    if domain == "" {
         return false, errors.New("missing parameters")
     }
-    b.mu.Lock()
-    arr, ok := b.lists[listName]
-    if !ok {
-        b.mu.Unlock()
-        return false, os.ErrNotExist
+    n, err := b.RemoveDomains(listName, []string{domain})
+    return n > 0, err
+}
+
+// RemoveDomains removes every given domain from the named list with a single
+// file rewrite and reload. It returns how many entries were actually removed.
+func (b *BlocklistManager) RemoveDomains(listName string, domains []string) (int, error) {
+    if listName == "" || len(domains) == 0 {
+        return 0, errors.New("missing parameters")
+    }
+    if b.isHostsList(listName) {
+        return 0, ErrHostsList
+    }
+    b.editMu.Lock()
+    defer b.editMu.Unlock()
+    drop := make(map[string]struct{}, len(domains))
+    for _, d := range domains {
+        if norm := normalizePattern(d); norm != "" {
+            drop[norm] = struct{}{}
+        }
+    }
+    arr, err := b.readForEdit(listName)
+    if err != nil {
+        return 0, err
     }
-    norm := normalizePattern(domain)
     newArr := make([]string, 0, len(arr))
-    removed := false
+    removed := 0
     for _, d := range arr {
-        if d == norm {
-            removed = true
+        if _, ok := drop[d]; ok {
+            removed++
             continue
         }
         newArr = append(newArr, d)
     }
-    b.mu.Unlock()
-    if !removed {
-        return false, nil
+    if removed == 0 {
+        return 0, nil
+    }
+    if err := b.store.Write(listName, newArr); err != nil {
+        return 0, err
+    }
+    if err := b.LoadAll(); err != nil {
+        return removed, err
     }
-    // write file
-    path := filepath.Join(b.dir, listName+".txt")
-    f, err := os.Create(path)
+    return removed, nil
+}
+
+// Errors returned by EditDomain
+var (
+    ErrEntryNotFound = errors.New("entry not found")
+    ErrEntryExists   = errors.New("entry already in list")
+    ErrInvalidEntry  = errors.New("invalid entry")
+)
+
+// EditDomain replaces one entry of the named list with another in place, so the
+// entry keeps its position in the file. The new entry must be a valid pattern.
+func (b *BlocklistManager) EditDomain(listName, oldEntry, newEntry string) error {
+    if listName == "" {
+        return errors.New("missing parameters")
+    }
+    from := normalizePattern(oldEntry)
+    to := normalizePattern(newEntry)
+    if from == "" {
+        return errors.New("missing old entry")
+    }
+    if err := validatePattern(to); err != nil {
+        return err
+    }
+    if b.isHostsList(listName) {
+        return ErrHostsList
+    }
+    b.editMu.Lock()
+    defer b.editMu.Unlock()
+    arr, err := b.readForEdit(listName)
     if err != nil {
-        return false, err
+        return err
     }
-    defer f.Close()
-    for _, d := range newArr {
-        if _, err := f.WriteString(d + "\n"); err != nil {
-            return false, err
+    idx := -1
+    exists := false
+    for i, d := range arr {
+        if d == from && idx < 0 {
+            idx = i
+        }
+        if d == to {
+            exists = true
         }
     }
-    if err := b.LoadAll(); err != nil {
-        return true, err
+    if idx < 0 {
+        return ErrEntryNotFound
+    }
+    if exists {
+        return ErrEntryExists
+    }
+    arr[idx] = to
+    if err := b.store.Write(listName, arr); err != nil {
+        return err
     }
-    return true, nil
+    return b.LoadAll()
+}
+
+// AddDomain appends a single validated entry to an existing list and returns
+// the list's new size. It fails with ErrEntryExists when the entry is already there.
+func (b *BlocklistManager) AddDomain(listName, entry string) (int, error) {
+    if listName == "" {
+        return 0, errors.New("missing parameters")
+    }
+    d := normalizePattern(entry)
+    if err := validatePattern(d); err != nil {
+        return 0, err
+    }
+    if b.isHostsList(listName) {
+        return 0, ErrHostsList
+    }
+    b.editMu.Lock()
+    defer b.editMu.Unlock()
+    arr, err := b.readForEdit(listName)
+    if err != nil {
+        return 0, err
+    }
+    if slices.Contains(arr, d) {
+        return 0, ErrEntryExists
+    }
+    arr = append(arr, d)
+    if err := b.store.Write(listName, arr); err != nil {
+        return 0, err
+    }
+    return len(arr), b.LoadAll()
+}
+
+// readForEdit reads listName's stored patterns for an edit made under editMu.
+// The store is read rather than the loaded copy, which lags behind until the
+// previous edit's reload. A missing list is os.ErrNotExist.
+func (b *BlocklistManager) readForEdit(listName string) ([]string, error) {
+    arr, err := b.store.Read(listName)
+    if errors.Is(err, fs.ErrNotExist) {
+        return nil, os.ErrNotExist
+    }
+    return arr, err
+}
+
+// setKeys returns the members of set in no particular order
+func setKeys(set map[string]struct{}) []string {
+    keys := make([]string, 0, len(set))
+    for k := range set {
+        keys = append(keys, k)
+    }
+    return keys
+}
+
+// writeListFileAtomic writes entries to a temporary file next to path and
+// renames it into place, so a reader never sees a half-written list
+func writeListFileAtomic(path string, entries []string) error {
+    f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+    if err != nil {
+        return err
+    }
+    tmp := f.Name()
+    w := bufio.NewWriter(f)
+    for _, d := range entries {
+        w.WriteString(d)
+        w.WriteByte('\n')
+    }
+    err = w.Flush()
+    if err == nil {
+        err = f.Sync()
+    }
+    if cerr := f.Close(); err == nil {
+        err = cerr
+    }
+    if err == nil {
+        err = os.Rename(tmp, path)
+    }
+    if err != nil {
+        os.Remove(tmp)
+    }
+    return err
 }
 
 // helper: parseHostsLines reads hosts-formatted content and returns a slice
@@ -508,10 +1275,33 @@ func (b *BlocklistManager) RemoveDomain(listName, domain string) (bool, error) {
 // It strips inline comments ("# ..."), ignores blank lines and comment lines,
 // and filters out IP-only entries and common localhost names.
 func readLines(r io.Reader) ([]string, error) {
+    domains, skipped, err := parseListLines(r)
+    if skipped > 0 {
+        slog.Info("skipped unsupported adblock rules", "count", skipped)
+    }
+    return domains, err
+}
+
+// parseListLines parses hosts-style, plain-domain and AdBlock Plus lines. It also
+// returns how many AdBlock rules were skipped because DNS can't enforce them.
+func parseListLines(r io.Reader) ([]string, int, error) {
     s := bufio.NewScanner(r)
     domains := make([]string, 0)
+    skipped := 0
     for s.Scan() {
-        line := s.Text()
+        line := strings.TrimSpace(s.Text())
+        // AdBlock rules must be handled before '#' comments are stripped ("##" is cosmetic syntax)
+        if isABPRule(line) {
+            pats, ok := convertABPRule(line)
+            if !ok {
+                if line != "" && !strings.HasPrefix(line, "!") && !strings.HasPrefix(line, "[") {
+                    skipped++
+                }
+                continue
+            }
+            domains = append(domains, pats...)
+            continue
+        }
         // strip inline comment
         if idx := strings.Index(line, "#"); idx >= 0 {
             line = line[:idx]
@@ -547,7 +1337,7 @@ func readLines(r io.Reader) ([]string, error) {
             domains = append(domains, n)
         }
     }
-    return domains, s.Err()
+    return domains, skipped, s.Err()
 }
 
 func isIPString(s string) bool {
@@ -571,11 +1361,60 @@ func isLocalHostName(s string) bool {
 func normalizePattern(p string) string {
     p = strings.TrimSpace(p)
     p = strings.TrimSuffix(p, ".")
-    p = strings.ToLower(p)
     if p == "" || strings.HasPrefix(p, "#") {
         return ""
     }
-    return p
+    if !utf8.ValidString(p) {
+        // keep badly encoded entries intact so the load report can point at them
+        return p
+    }
+    return canonicalDomain(strings.ToLower(p))
+}
+
+// DNS limits on a name without its trailing dot (RFC 1035)
+const (
+    maxDomainLength = 253
+    maxLabelLength  = 63
+)
+
+// checkDomainLimits rejects names, or patterns, longer than DNS allows in
+// total or in any one label
+func checkDomainLimits(name string) error {
+    if len(name) > maxDomainLength {
+        return fmt.Errorf("%w %.64q...: longer than %d characters", ErrInvalidEntry, name, maxDomainLength)
+    }
+    for _, l := range strings.Split(name, ".") {
+        if len(l) > maxLabelLength {
+            return fmt.Errorf("%w %q: label longer than %d characters", ErrInvalidEntry, name, maxLabelLength)
+        }
+    }
+    return nil
+}
+
+// validatePattern checks a normalized entry looks like a domain or wildcard
+// pattern: hostname characters and '*' only, no empty labels, within DNS
+// length limits
+func validatePattern(p string) error {
+    if p == "" {
+        return fmt.Errorf("%w: empty", ErrInvalidEntry)
+    }
+    if err := checkDomainLimits(p); err != nil {
+        return err
+    }
+    if isIPString(p) {
+        return fmt.Errorf("%w %q: IP addresses can't be blocked by name", ErrInvalidEntry, p)
+    }
+    for _, c := range p {
+        if !(c >= 'a' && c <= 'z') && !(c >= '0' && c <= '9') && !strings.ContainsRune("-_.*", c) {
+            return fmt.Errorf("%w %q: unexpected character %q", ErrInvalidEntry, p, c)
+        }
+    }
+    for _, l := range strings.Split(p, ".") {
+        if l == "" {
+            return fmt.Errorf("%w %q: empty label", ErrInvalidEntry, p)
+        }
+    }
+    return nil
 }
 
 // patternToRegexp converts a wildcard pattern into a regexp that matches whole domain names.
@@ -583,14 +1422,24 @@ func normalizePattern(p string) string {
 //  - '*' matches any sequence of characters (including dots).
 //  - patterns are matched against the full domain string (no trailing dot).
 //  - example: "*.example.com" -> matches "sub.example.com" but not "example.com".
-func patternToRegexp(p string) (*regexp.Regexp, error) {
+//  - with subdomains set, a plain entry (no '*') also matches its subdomains,
+//    so "example.com" behaves like "example.com" plus "*.example.com".
+func patternToRegexp(p string, subdomains bool) (*regexp.Regexp, error) {
     p = normalizePattern(p)
     if p == "" {
         return nil, nil
     }
+    // nothing longer than DNS allows can match, and compiling it only costs memory
+    if err := checkDomainLimits(p); err != nil {
+        return nil, err
+    }
     // Escape regex meta then replace escaped '*' with '.*'
     esc := regexp.QuoteMeta(p)
     esc = strings.ReplaceAll(esc, "\\*", ".*")
-    full := "^" + esc + "$"
+    prefix := "^"
+    if subdomains && !strings.Contains(p, "*") {
+        prefix = `^(?:.*\.)?`
+    }
+    full := prefix + esc + "$"
     return regexp.Compile(full)
 }
@@ -0,0 +1,617 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestBlocklistManager returns a manager over in-memory lists
+func newTestBlocklistManager(t *testing.T, lists map[string][]string) *BlocklistManager {
+	t.Helper()
+	bm, err := newMemoryBlocklistManager(newMemStore(lists))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bm
+}
+
+func TestTopClientsCountDevicesNotSourcePorts(t *testing.T) {
+	tests := []struct {
+		name    string
+		clients []string
+		want    []TopCount
+	}{
+		{
+			name:    "IPv4 ports collapse",
+			clients: []string{"192.168.1.5:40000", "192.168.1.5:40001", "192.168.1.5:52000", "192.168.1.6:40000"},
+			want:    []TopCount{{Name: "192.168.1.5", Count: 3}, {Name: "192.168.1.6", Count: 1}},
+		},
+		{
+			name:    "IPv6 ports collapse",
+			clients: []string{"[fd00::5]:5353", "[fd00::5]:5354"},
+			want:    []TopCount{{Name: "fd00::5", Count: 2}},
+		},
+		{
+			name:    "bare IP kept",
+			clients: []string{"192.168.1.5", "192.168.1.5:40000"},
+			want:    []TopCount{{Name: "192.168.1.5", Count: 2}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bm := newTestBlocklistManager(t, nil)
+			for _, c := range tt.clients {
+				bm.RecordQueryOfType("example.com", c, "A", false)
+			}
+			got, err := bm.GetTop("", "clients", 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("GetTop clients = %+v, want %+v", got, tt.want)
+			}
+			if stats := bm.GetStats(); len(stats.ClientHits) != len(tt.want) {
+				t.Errorf("GetStats client_hits = %v, want %d devices", stats.ClientHits, len(tt.want))
+			}
+		})
+	}
+}
+
+// slowWriteStore widens the window between an edit reading a list and
+// writing it back, so unserialized edits would overwrite each other
+type slowWriteStore struct {
+	listStore
+}
+
+func (s slowWriteStore) Write(name string, lines []string) error {
+	time.Sleep(2 * time.Millisecond)
+	return s.listStore.Write(name, lines)
+}
+
+func TestConcurrentListEditsKeepEveryUpdate(t *testing.T) {
+	const n = 20
+	tests := []struct {
+		name  string
+		start []string
+		edit  func(bm *BlocklistManager, i int) error
+		want  func(i int) string
+	}{
+		{
+			name: "AddDomain",
+			edit: func(bm *BlocklistManager, i int) error {
+				_, err := bm.AddDomain("kids", fmt.Sprintf("add%d.example", i))
+				return err
+			},
+			want: func(i int) string { return fmt.Sprintf("add%d.example", i) },
+		},
+		{
+			name: "EditDomain",
+			start: func() []string {
+				var s []string
+				for i := 0; i < n; i++ {
+					s = append(s, fmt.Sprintf("old%d.example", i))
+				}
+				return s
+			}(),
+			edit: func(bm *BlocklistManager, i int) error {
+				return bm.EditDomain("kids", fmt.Sprintf("old%d.example", i), fmt.Sprintf("new%d.example", i))
+			},
+			want: func(i int) string { return fmt.Sprintf("new%d.example", i) },
+		},
+		{
+			name: "AddItemsToList",
+			edit: func(bm *BlocklistManager, i int) error {
+				_, err := bm.AddItemsToList("kids", []string{fmt.Sprintf("item%d.example", i)}, false)
+				return err
+			},
+			want: func(i int) string { return fmt.Sprintf("item%d.example", i) },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bm := newTestBlocklistManager(t, map[string][]string{"kids": append([]string{"seed.example"}, tt.start...)})
+			bm.store = slowWriteStore{bm.store}
+			var wg sync.WaitGroup
+			errs := make(chan error, n)
+			for i := 0; i < n; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					errs <- tt.edit(bm, i)
+				}(i)
+			}
+			wg.Wait()
+			close(errs)
+			for err := range errs {
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			set, err := bm.ListPatternSet("kids")
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < n; i++ {
+				if _, ok := set[tt.want(i)]; !ok {
+					t.Errorf("lost update %s", tt.want(i))
+				}
+			}
+			if _, ok := set["seed.example"]; !ok {
+				t.Error("lost the existing entry")
+			}
+		})
+	}
+}
+
+func TestGetListStats(t *testing.T) {
+	bm := newTestBlocklistManager(t, map[string][]string{
+		"ads":   {"ads.example", "tracker.example", "pixel.example"},
+		"quiet": {"never.example"},
+	})
+	for domain, n := range map[string]int{"ads.example": 3, "tracker.example": 5, "pixel.example": 3} {
+		for i := 0; i < n; i++ {
+			bm.RecordListHit("ads", domain)
+		}
+	}
+	bm.RecordListHit("", "ignored.example")
+	tests := []struct {
+		list    string
+		top     int
+		want    ListStats
+		wantErr bool
+	}{
+		{"ads", 2, ListStats{Entries: 3, Matches: 11, TopDomains: []DomainCount{{"tracker.example", 5}, {"ads.example", 3}}}, false},
+		{"ads", 0, ListStats{Entries: 3, Matches: 11, TopDomains: []DomainCount{{"tracker.example", 5}, {"ads.example", 3}, {"pixel.example", 3}}}, false},
+		{"quiet", 10, ListStats{Entries: 1, TopDomains: []DomainCount{}}, false},
+		{"missing", 10, ListStats{}, true},
+	}
+	for _, tt := range tests {
+		got, err := bm.GetListStats(tt.list, tt.top)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("GetListStats(%q) error = %v, want error %t", tt.list, err, tt.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("GetListStats(%q, %d) = %+v, want %+v", tt.list, tt.top, got, tt.want)
+		}
+	}
+}
+
+func TestMergeLists(t *testing.T) {
+	tests := []struct {
+		name    string
+		sources []string
+		dest    string
+		want    []string
+		wantErr error
+	}{
+		{"merged into a new list", []string{"a", "b"}, "ab", []string{"dup.example", "one.example", "two.example"}, nil},
+		{"copied", []string{"a"}, "a-copy", []string{"dup.example", "one.example"}, nil},
+		{"merged into a source", []string{"a", "b"}, "a", []string{"dup.example", "one.example", "two.example"}, nil},
+		{"destination exists", []string{"a"}, "b", nil, ErrListExists},
+		{"missing source", []string{"a", "nope"}, "new", nil, os.ErrNotExist},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bm := newTestBlocklistManager(t, map[string][]string{
+				"a": {"one.example", "DUP.example."},
+				"b": {"dup.example", "two.example", "# comment"},
+			})
+			n, err := bm.MergeLists(tt.sources, tt.dest)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			_, got, err := bm.ListDomains(tt.dest, 0, 100, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != len(tt.want) || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("dest holds %d: %v, want %v", n, got, tt.want)
+			}
+			if _, blocked := bm.Match("two.example"); !blocked {
+				t.Error("merged entries not loaded")
+			}
+		})
+	}
+}
+
+func TestGetStatsForUser(t *testing.T) {
+	const macA, macB = "aa:bb:cc:00:00:40", "aa:bb:cc:00:00:41"
+	ipMACCache.SetIPMAC("192.168.80.1", macA)
+	ipMACCache.SetIPMAC("192.168.80.2", macA)
+	ipMACCache.SetIPMAC("192.168.80.3", macB)
+	bm := newTestBlocklistManager(t, nil)
+	for _, q := range []struct {
+		domain, client string
+		blocked        bool
+	}{
+		{"ads.example", "192.168.80.1:1000", true},
+		{"ads.example", "192.168.80.2:1000", true},
+		{"news.example", "192.168.80.1:1001", false},
+		{"tracker.example", "192.168.80.3:1000", true},
+		{"news.example", "192.168.80.9:1000", false},
+	} {
+		bm.RecordQueryOfType(q.domain, q.client, "A", q.blocked)
+	}
+	tests := []struct {
+		owner string
+		want  StatsSnapshot
+	}{
+		{macA, StatsSnapshot{Queries: 3, Blocked: 2,
+			DomainHits: map[string]int{"ads.example": 2},
+			ClientHits: map[string]int{"192.168.80.1": 2, "192.168.80.2": 1}}},
+		{macB, StatsSnapshot{Queries: 1, Blocked: 1,
+			DomainHits: map[string]int{"tracker.example": 1},
+			ClientHits: map[string]int{"192.168.80.3": 1}}},
+		{"ip:192.168.80.9", StatsSnapshot{Queries: 1,
+			DomainHits: map[string]int{},
+			ClientHits: map[string]int{"192.168.80.9": 1}}},
+		{"aa:bb:cc:00:00:49", StatsSnapshot{DomainHits: map[string]int{}, ClientHits: map[string]int{}}},
+	}
+	for _, tt := range tests {
+		if got := bm.GetStatsForUser(tt.owner); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("GetStatsForUser(%s) = %+v, want %+v", tt.owner, got, tt.want)
+		}
+	}
+	if all := bm.GetStats(); all.Queries != 5 || all.Blocked != 3 {
+		t.Errorf("network-wide stats = %d queries, %d blocked; want 5, 3", all.Queries, all.Blocked)
+	}
+}
+
+func TestRecentLogRing(t *testing.T) {
+	tests := []struct {
+		cap, queries, wantLen int
+	}{
+		{minRecentLogCap, 10, 10},
+		{minRecentLogCap, minRecentLogCap, minRecentLogCap},
+		{minRecentLogCap, 3*minRecentLogCap + 7, minRecentLogCap},
+		{0, defaultRecentLogCap + 1, defaultRecentLogCap},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("cap %d, %d queries", tt.cap, tt.queries), func(t *testing.T) {
+			withConfig(t, func(c *Config) { c.RecentLogCap = tt.cap })
+			bm := newTestBlocklistManager(t, nil)
+			for i := 0; i < tt.queries; i++ {
+				bm.RecordQueryOfType(fmt.Sprintf("d%d.example", i), "192.168.1.5:1", "A", false)
+			}
+			got := bm.GetLogs(maxRecentLogCap)
+			if len(got) != tt.wantLen {
+				t.Fatalf("kept %d entries, want %d", len(got), tt.wantLen)
+			}
+			// the newest entries, oldest first
+			for i, e := range got {
+				if want := fmt.Sprintf("d%d.example", tt.queries-tt.wantLen+i); e.Domain != want {
+					t.Fatalf("entry %d = %s, want %s", i, e.Domain, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRecentLogCapacity(t *testing.T) {
+	tests := []struct{ cap, want int }{
+		{0, defaultRecentLogCap},
+		{-5, defaultRecentLogCap},
+		{1, minRecentLogCap},
+		{2000, 2000},
+		{maxRecentLogCap + 1, maxRecentLogCap},
+	}
+	for _, tt := range tests {
+		if got := (&Config{RecentLogCap: tt.cap}).RecentLogCapacity(); got != tt.want {
+			t.Errorf("RecentLogCapacity(%d) = %d, want %d", tt.cap, got, tt.want)
+		}
+	}
+}
+
+func TestQueryLogWriterKeepsOrder(t *testing.T) {
+	tests := []struct {
+		name      string
+		producers int
+		perProd   int
+	}{
+		{"one producer", 1, 2000},
+		{"many producers", 50, 200},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bm, err := NewBlocklistManager(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			before := runtime.NumGoroutine()
+			var wg sync.WaitGroup
+			for p := 0; p < tt.producers; p++ {
+				wg.Add(1)
+				go func(p int) {
+					defer wg.Done()
+					for i := 0; i < tt.perProd; i++ {
+						bm.RecordQueryOfType(fmt.Sprintf("p%d-%d.example", p, i), "192.168.1.5:1", "A", false)
+					}
+				}(p)
+			}
+			wg.Wait()
+			// recording must not leave goroutines behind per query
+			if n := runtime.NumGoroutine(); n > before+5 {
+				t.Errorf("%d goroutines after flooding, %d before", n, before)
+			}
+			bm.flushLog()
+
+			f, err := os.Open(bm.logPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			next := make([]int, tt.producers)
+			written := 0
+			sc := bufio.NewScanner(f)
+			for sc.Scan() {
+				var e QueryEntry
+				if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+					t.Fatal(err)
+				}
+				var p, i int
+				if _, err := fmt.Sscanf(e.Domain, "p%d-%d.example", &p, &i); err != nil {
+					t.Fatalf("unexpected entry %q", e.Domain)
+				}
+				// entries may be dropped, but each producer's survivors stay in order
+				if i < next[p] {
+					t.Fatalf("producer %d: entry %d written after %d", p, i, next[p]-1)
+				}
+				next[p] = i + 1
+				written++
+			}
+			if total := tt.producers * tt.perProd; written+int(bm.LogDropped()) != total {
+				t.Errorf("written %d + dropped %d, want %d", written, bm.LogDropped(), total)
+			}
+		})
+	}
+}
+
+func TestLoadAllKeepsListsWhenDirUnreadable(t *testing.T) {
+	tests := []struct {
+		name     string
+		breakDir func(dir string) error
+	}{
+		{"directory removed", os.RemoveAll},
+		{"directory replaced by a file", func(dir string) error {
+			if err := os.RemoveAll(dir); err != nil {
+				return err
+			}
+			return os.WriteFile(dir, nil, 0o644)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := filepath.Join(t.TempDir(), "lists")
+			if err := os.MkdirAll(dir, 0o755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(dir, "ads.txt"), []byte("ads.example\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			bm, err := NewBlocklistManager(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := tt.breakDir(dir); err != nil {
+				t.Fatal(err)
+			}
+			if err := bm.LoadAll(); err == nil {
+				t.Fatal("LoadAll over an unreadable directory returned nil")
+			}
+			if !bm.IsBlocked("ads.example") {
+				t.Error("failed reload dropped the previously loaded lists")
+			}
+			if total, _, err := bm.ListDomains("ads", 0, 10, ""); err != nil || total != 1 {
+				t.Errorf("ads list after failed reload: %d domains, %v", total, err)
+			}
+
+			// once the directory is back, reloads pick up its contents again
+			os.RemoveAll(dir)
+			if err := os.MkdirAll(dir, 0o755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(dir, "other.txt"), []byte("other.example\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if err := bm.LoadAll(); err != nil {
+				t.Fatal(err)
+			}
+			if bm.IsBlocked("ads.example") || !bm.IsBlocked("other.example") {
+				t.Error("reload after recovery didn't replace the lists")
+			}
+		})
+	}
+}
+
+func TestMatchSubdomains(t *testing.T) {
+	on, off := true, false
+	tests := []struct {
+		name   string
+		global bool
+		list   *bool // the list's own setting; nil follows the global one
+		want   map[string]bool
+	}{
+		{"strict by default", false, nil,
+			map[string]bool{"example.com": true, "sub.example.com": false, "wild.example": false, "a.wild.example": true}},
+		{"global on", true, nil,
+			map[string]bool{"example.com": true, "sub.example.com": true, "a.b.example.com": true, "notexample.com": false, "wild.example": false, "a.wild.example": true}},
+		{"list turns it on", false, &on,
+			map[string]bool{"example.com": true, "sub.example.com": true, "notexample.com": false}},
+		{"list turns it off", true, &off,
+			map[string]bool{"example.com": true, "sub.example.com": false}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withConfig(t, func(c *Config) { c.MatchSubdomains = tt.global })
+			bm := newTestBlocklistManager(t, map[string][]string{"l": {"example.com", "*.wild.example"}})
+			if err := bm.SetListMeta("l", ListMeta{Enabled: true, MatchSubdomains: tt.list}); err != nil {
+				t.Fatal(err)
+			}
+			for domain, want := range tt.want {
+				if got := bm.IsBlocked(domain); got != want {
+					t.Errorf("IsBlocked(%q) = %v, want %v", domain, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckDomainLimits(t *testing.T) {
+	label63, label64 := strings.Repeat("a", 63), strings.Repeat("a", 64)
+	// four 61-character labels and their dots make 248 characters
+	name248 := strings.Repeat(strings.Repeat("b", 61)+".", 4)
+	tests := []struct {
+		name string
+		ok   bool
+	}{
+		{"example.com", true},
+		{label63 + ".example", true},
+		{label64 + ".example", false},
+		{"*." + label64, false},
+		{name248 + "abcde", true}, // 253
+		{name248 + "abcdef", false},
+	}
+	for _, tt := range tests {
+		err := checkDomainLimits(tt.name)
+		if (err == nil) != tt.ok || (err != nil && !errors.Is(err, ErrInvalidEntry)) {
+			t.Errorf("checkDomainLimits(%d chars) = %v, want ok %v", len(tt.name), err, tt.ok)
+		}
+		// list entries and compiled patterns obey the same limits
+		if err := validatePattern(tt.name); (err == nil) != tt.ok {
+			t.Errorf("validatePattern(%d chars) = %v, want ok %v", len(tt.name), err, tt.ok)
+		}
+		if re, err := patternToRegexp(tt.name, false); (err == nil && re != nil) != tt.ok {
+			t.Errorf("patternToRegexp(%d chars) = %v, %v; want ok %v", len(tt.name), re, err, tt.ok)
+		}
+	}
+}
+
+func TestRecentLogStorageReused(t *testing.T) {
+	const n = 50
+	tests := []struct {
+		name        string
+		queries     int // past n the ring has wrapped
+		dropEvery   int // drop domains whose index is a multiple; 0 clears the log instead
+		wantRemoved int
+	}{
+		{"purge before wrapping", 25, 2, 13}, // d0, d2 ... d24
+		{"purge after wrapping", 75, 3, 16},  // d27, d30 ... d72 of d25-d74
+		{"purge everything", 53, 1, n},
+		{"purge nothing", 53, 1000, 0},
+		{"clear", 53, 0, 0},
+	}
+	for _, tt := range tests {
+		withConfig(t, func(c *Config) { c.RecentLogCap = n })
+		bm := newTestBlocklistManager(t, nil)
+		for i := 0; i < tt.queries; i++ {
+			bm.RecordQueryOfType(fmt.Sprintf("d%d.example", i), "192.168.1.5:1", "A", false)
+		}
+		backing := &bm.recent[:cap(bm.recent)][0]
+		before := bm.GetLogs(maxRecentLogCap)
+
+		var want []QueryEntry
+		if tt.dropEvery == 0 {
+			if err := bm.DeleteLogs(); err != nil {
+				t.Fatal(err)
+			}
+		} else {
+			drop := func(e QueryEntry) bool {
+				var i int
+				fmt.Sscanf(e.Domain, "d%d.example", &i)
+				return i%tt.dropEvery == 0
+			}
+			for _, e := range before {
+				if !drop(e) {
+					want = append(want, e)
+				}
+			}
+			bm.recentMu.Lock()
+			removed := bm.compactRecent(drop)
+			bm.recentMu.Unlock()
+			if removed != tt.wantRemoved {
+				t.Errorf("%s: removed %d, want %d", tt.name, removed, tt.wantRemoved)
+			}
+		}
+		if got := bm.GetLogs(maxRecentLogCap); !reflect.DeepEqual(got, want) && len(got)+len(want) > 0 {
+			t.Errorf("%s: kept %d entries, want %d in order", tt.name, len(got), len(want))
+		}
+		if &bm.recent[:cap(bm.recent)][0] != backing || cap(bm.recent) != n {
+			t.Errorf("%s: ring storage replaced", tt.name)
+		}
+		// freed slots don't pin old entries, and the ring keeps filling in order
+		for _, e := range bm.recent[len(bm.recent):cap(bm.recent)] {
+			if e != (QueryEntry{}) {
+				t.Fatalf("%s: freed slot still holds %+v", tt.name, e)
+			}
+		}
+		bm.RecordQueryOfType("next.example", "192.168.1.5:1", "A", false)
+		if got := bm.GetLogs(1); len(got) != 1 || got[0].Domain != "next.example" {
+			t.Errorf("%s: newest entry after the purge is %+v", tt.name, got)
+		}
+	}
+}
+
+func TestResetStats(t *testing.T) {
+	const mac = "aa:bb:cc:00:11:71"
+	ipMACCache.SetIPMAC("192.168.171.1", mac)
+	bm := newTestBlocklistManager(t, map[string][]string{"ads": {"ads.example"}})
+	record := func() {
+		bm.RecordQueryOfType("ads.example", "192.168.171.1:1000", "A", true)
+		bm.RecordListHit("ads", "ads.example")
+		bm.RecordQueryOfType("news.example", "192.168.171.2:1000", "A", false)
+	}
+	record()
+	bm.ResetStats()
+
+	empty := StatsSnapshot{DomainHits: map[string]int{}, ClientHits: map[string]int{}}
+	if got := bm.GetStats(); got.Queries != 0 || got.Blocked != 0 || len(got.DomainHits) != 0 || len(got.ClientHits) != 0 {
+		t.Errorf("GetStats after reset = %+v, want zeros", got)
+	}
+	if got := bm.GetStatsForUser(mac); !reflect.DeepEqual(got, empty) {
+		t.Errorf("GetStatsForUser after reset = %+v, want %+v", got, empty)
+	}
+	if got, err := bm.GetListStats("ads", 10); err != nil || got.Matches != 0 || len(got.TopDomains) != 0 {
+		t.Errorf("GetListStats after reset = %+v, %v; want no matches", got, err)
+	}
+	// Clients also lists every MAC the shared ipMACCache knows
+	var clients []ClientInfo
+	for _, c := range bm.Clients() {
+		if c.IP == "192.168.171.1" || c.IP == "192.168.171.2" {
+			clients = append(clients, c)
+		}
+	}
+	if len(clients) != 2 {
+		t.Fatalf("%d clients after reset, want both kept", len(clients))
+	}
+	for _, c := range clients {
+		if c.Queries != 0 || c.Blocked != 0 || c.LastSeen == nil {
+			t.Errorf("client after reset = %+v, want zero counts and a last-seen time", c)
+		}
+	}
+	if n := len(bm.GetLogs(10)); n != 2 {
+		t.Errorf("%d log entries after reset, want the 2 recorded", n)
+	}
+
+	// counting starts again from the reset
+	record()
+	if got := bm.GetStats(); got.Queries != 2 || got.Blocked != 1 || got.DomainHits["ads.example"] != 1 {
+		t.Errorf("GetStats after counting again = %+v, want 2 queries, 1 blocked", got)
+	}
+	if got, _ := bm.GetListStats("ads", 10); got.Matches != 1 {
+		t.Errorf("list matches after counting again = %d, want 1", got.Matches)
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
     "log"
+    "log/slog"
     "net/http"
     "strconv"
 )
@@ -9,15 +10,14 @@ import (
 // StartBlockPageServer starts a minimal HTTP server serving a simple blocked page.
 // It reads message content from AppConfig at request time, so toggling the mode
 // affects the page without restarting (port changes require restart).
-func StartBlockPageServer() {
-    port := AppConfig.BlockPagePort
+func StartBlockPageServer(port int) {
     mux := http.NewServeMux()
         mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
                 w.Header().Set("Content-Type", "text/html; charset=utf-8")
                 // Log some request details for diagnostics (don't log sensitive headers)
                 ua := r.Header.Get("User-Agent")
                 remote := r.RemoteAddr
-                log.Printf("block page hit from %s UA=%s", remote, ua)
+                slog.Debug("block page hit", "client", remote, "ua", ua)
 
                 // Serve a minimal, marginless responsive page. Keep it self-contained so it
                 // displays correctly on very small screens.
@@ -56,7 +56,7 @@ func StartBlockPageServer() {
     go func() {
         log.Printf("block page server listening on %s", addr)
         if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-            log.Printf("block page server error: %v", err)
+            slog.Error("block page server error", "err", err)
         }
     }()
 }
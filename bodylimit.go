@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+)
+
+// limitRequestBody caps request bodies at AppConfig.RequestLimit() and only
+// accepts JSON, except for /restore which takes a gzipped backup. Oversized requests get a 413 and other content types a 415.
+// Bodies without a Content-Length are cut off at the limit; the handler's decode
+// then fails and the 400 it writes is turned into a 413.
+func limitRequestBody(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Body == nil || r.Body == http.NoBody || r.ContentLength == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// backup archives are the one non-JSON upload
+		want, limit := "application/json", AppConfig().RequestLimit()
+		if r.URL.Path == "/restore" {
+			want, limit = "application/gzip", maxRestoreBytes
+		}
+		if ct := r.Header.Get("Content-Type"); ct != "" {
+			mt, _, err := mime.ParseMediaType(ct)
+			if err != nil || mt != want {
+				writeError(w, http.StatusUnsupportedMediaType, errCodeUnsupportedMediaType, "unsupported content type: want "+want)
+				return
+			}
+		}
+
+		if r.ContentLength > limit {
+			writeError(w, http.StatusRequestEntityTooLarge, errCodePayloadTooLarge, "request body too large")
+			return
+		}
+
+		body := &limitedBody{ReadCloser: http.MaxBytesReader(w, r.Body, limit)}
+		r.Body = body
+		next.ServeHTTP(&limitedBodyWriter{ResponseWriter: w, body: body}, r)
+	})
+}
+
+// limitedBody records whether the handler ran into the size limit
+type limitedBody struct {
+	io.ReadCloser
+	exceeded bool
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		b.exceeded = true
+	}
+	return n, err
+}
+
+// limitedBodyWriter reports a 413 instead of the handler's 400 when the body was
+// truncated, replacing the handler's error body with its own
+type limitedBodyWriter struct {
+	http.ResponseWriter
+	body     *limitedBody
+	replaced bool
+}
+
+func (w *limitedBodyWriter) WriteHeader(code int) {
+	if code == http.StatusBadRequest && w.body.exceeded {
+		w.replaced = true
+		writeError(w.ResponseWriter, http.StatusRequestEntityTooLarge, errCodePayloadTooLarge, "request body too large")
+		return
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *limitedBodyWriter) Write(p []byte) (int, error) {
+	if w.replaced {
+		return len(p), nil
+	}
+	return w.ResponseWriter.Write(p)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLimitRequestBody(t *testing.T) {
+	const limit = 64
+	withConfig(t, func(c *Config) { c.MaxRequestBytes = limit })
+
+	// decodes like the API handlers do, answering 400 when that fails
+	h := limitRequestBody(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/restore" {
+			io.Copy(io.Discard, r.Body)
+			return
+		}
+		var v map[string]interface{}
+		if r.Body != nil && r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&v); err != nil {
+				writeError(w, http.StatusBadRequest, errCodeBadRequest, "invalid json")
+				return
+			}
+		}
+	}))
+
+	big := `{"items":"` + strings.Repeat("a", 2*limit) + `"}`
+	tests := []struct {
+		name        string
+		path        string
+		contentType string
+		body        string
+		chunked     bool
+		want        int
+	}{
+		{"small", "/lists/create", "application/json", `{"name":"ads"}`, false, http.StatusOK},
+		{"charset parameter", "/lists/create", "application/json; charset=utf-8", `{"name":"ads"}`, false, http.StatusOK},
+		{"no content type", "/lists/create", "", `{"name":"ads"}`, false, http.StatusOK},
+		{"no body", "/lists/create", "text/plain", "", false, http.StatusOK},
+		{"announced too large", "/lists/create", "application/json", big, false, http.StatusRequestEntityTooLarge},
+		{"chunked too large", "/lists/create", "application/json", big, true, http.StatusRequestEntityTooLarge},
+		{"small but invalid", "/lists/create", "application/json", `{"name":`, false, http.StatusBadRequest},
+		{"form post", "/lists/create", "application/x-www-form-urlencoded", "name=ads", false, http.StatusUnsupportedMediaType},
+		{"bad content type", "/lists/create", "text/plain;;", `{}`, false, http.StatusUnsupportedMediaType},
+		{"restore archive", "/restore", "application/gzip", big, false, http.StatusOK},
+		{"restore as json", "/restore", "application/json", `{}`, false, http.StatusUnsupportedMediaType},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				r.Header.Set("Content-Type", tt.contentType)
+			}
+			if tt.chunked {
+				r.ContentLength = -1
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, r)
+			if rec.Code != tt.want {
+				t.Fatalf("status %d, want %d (body %s)", rec.Code, tt.want, rec.Body)
+			}
+			if tt.want == http.StatusRequestEntityTooLarge && !strings.Contains(rec.Body.String(), errCodePayloadTooLarge) {
+				t.Errorf("body %s, want the %s error code", rec.Body, errCodePayloadTooLarge)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// hostFromInput reduces what a user might paste (a bare name, host:port, or a
+// full URL like https://ads.example/banner?id=1) to the normalized host a DNS
+// query would carry. Wildcards and IP addresses are rejected.
+func hostFromInput(s string) (string, error) {
+	host := strings.TrimSpace(s)
+	if strings.Contains(host, "://") {
+		u, err := url.Parse(host)
+		if err != nil {
+			return "", fmt.Errorf("%w %q: not a URL", ErrInvalidEntry, s)
+		}
+		host = u.Hostname()
+	} else {
+		if i := strings.IndexAny(host, "/?#"); i >= 0 {
+			host = host[:i]
+		}
+		if i := strings.LastIndex(host, "@"); i >= 0 {
+			host = host[i+1:]
+		}
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+	}
+	host = normalizePattern(host)
+	if strings.Contains(host, "*") {
+		return "", fmt.Errorf("%w %q: wildcards can't be checked", ErrInvalidEntry, s)
+	}
+	if err := validatePattern(host); err != nil {
+		return "", err
+	}
+	return host, nil
+}
+
+// CheckResult is the answer to GET /check
+type CheckResult struct {
+	Query   string `json:"query"`  // what the caller sent
+	Domain  string `json:"domain"` // the host that was matched
+	Blocked bool   `json:"blocked"`
+	List    string `json:"list,omitempty"`
+	Pattern string `json:"pattern,omitempty"`
+}
+
+// handleCheck serves GET /check?domain=..., reporting whether a query for the
+// domain would be blocked for the caller and by which list. URLs and host:port
+// are accepted and reduced to their host.
+func handleCheck(w http.ResponseWriter, r *http.Request, bm *BlocklistManager, am *AccountManager) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "method not allowed")
+		return
+	}
+	q := r.URL.Query().Get("domain")
+	if strings.TrimSpace(q) == "" {
+		writeError(w, http.StatusBadRequest, errCodeBadRequest, "missing domain")
+		return
+	}
+	host, err := hostFromInput(q)
+	if err != nil {
+		if errors.Is(err, ErrInvalidEntry) {
+			writeError(w, http.StatusBadRequest, errCodeBadRequest, err.Error())
+			return
+		}
+		writeError(w, http.StatusInternalServerError, errCodeInternal, err.Error())
+		return
+	}
+	userMAC := r.Header.Get("X-User-MAC")
+	detail, blocked := bm.MatchForUser(host, 0, userMAC, am)
+	_ = json.NewEncoder(w).Encode(CheckResult{
+		Query:   q,
+		Domain:  host,
+		Blocked: blocked,
+		List:    strings.TrimPrefix(detail.List, userMAC+"_"),
+		Pattern: detail.Pattern,
+	})
+}
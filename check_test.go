@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestHostFromInput(t *testing.T) {
+	tests := []struct {
+		in, want string // want "" for an error
+	}{
+		{"ads.example", "ads.example"},
+		{" Ads.Example. ", "ads.example"},
+		{"ads.example:8443", "ads.example"},
+		{"ads.example/banner?id=1", "ads.example"},
+		{"https://ads.example/banner?id=1", "ads.example"},
+		{"https://user:pw@Ads.Example:8443/x", "ads.example"},
+		{"user@ads.example/x", "ads.example"},
+		{"http://bücher.example/", "xn--bcher-kva.example"},
+		{"*.ads.example", ""},
+		{"192.168.1.1", ""},
+		{"https://192.168.1.1/", ""},
+		{"bad domain.example", ""},
+		{"http://%zz/", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		got, err := hostFromInput(tt.in)
+		if tt.want == "" {
+			if !errors.Is(err, ErrInvalidEntry) {
+				t.Errorf("hostFromInput(%q) = %q, %v; want an invalid entry error", tt.in, got, err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("hostFromInput(%q) = %q, %v; want %q", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestCheckEndpoint(t *testing.T) {
+	const mac, other = "aa:bb:cc:00:11:56", "aa:bb:cc:00:11:57"
+	withConfig(t, func(c *Config) { c.BcryptCost = 4 })
+	bm := newTestBlocklistManager(t, map[string][]string{
+		mac + "_mine":   {"mine.example"},
+		other + "_them": {"theirs.example"},
+	})
+	am := newTestAccountManager(t, mac)
+	if err := am.AddUserBlocklist(mac, mac+"_mine"); err != nil {
+		t.Fatal(err)
+	}
+	mux := newTestAPI(bm, am)
+	session := am.createSession(mac, false).ID
+
+	tests := []struct {
+		method, domain string
+		status         int
+		want           CheckResult
+	}{
+		{http.MethodGet, "https://mine.example/ad.js", http.StatusOK, CheckResult{Domain: "mine.example", Blocked: true, List: "mine", Pattern: "mine.example"}},
+		{http.MethodGet, "MINE.example:443", http.StatusOK, CheckResult{Domain: "mine.example", Blocked: true, List: "mine", Pattern: "mine.example"}},
+		{http.MethodGet, "theirs.example", http.StatusOK, CheckResult{Domain: "theirs.example"}}, // another user's list
+		{http.MethodGet, "*.mine.example", http.StatusBadRequest, CheckResult{}},
+		{http.MethodGet, " ", http.StatusBadRequest, CheckResult{}},
+		{http.MethodPost, "mine.example", http.StatusMethodNotAllowed, CheckResult{}},
+	}
+	for _, tt := range tests {
+		w := callAPI(mux, tt.method, "/check?domain="+url.QueryEscape(tt.domain), session, "")
+		if w.Code != tt.status {
+			t.Errorf("%s %q: status %d, want %d: %s", tt.method, tt.domain, w.Code, tt.status, w.Body)
+			continue
+		}
+		if tt.status != http.StatusOK {
+			continue
+		}
+		var got CheckResult
+		if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+		tt.want.Query = tt.domain
+		if got != tt.want {
+			t.Errorf("check %q = %+v, want %+v", tt.domain, got, tt.want)
+		}
+	}
+	if !bm.IsBlockedForUser("http://mine.example/x", mac, am) || !bm.IsBlocked("mine.example:80") {
+		t.Error("IsBlocked and IsBlockedForUser don't reduce URLs to their host")
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// ErrInvalidClient is returned when a client to purge is neither an IP nor a MAC address
+var ErrInvalidClient = errors.New("client must be an IP or MAC address")
+
+// ClientPurge reports what DeleteClientLogs removed
+type ClientPurge struct {
+	Client  string   `json:"client"` // the MAC, or the IP when no MAC is known
+	IPs     []string `json:"ips"`
+	Entries int      `json:"entries"` // log entries removed from memory and disk
+}
+
+// resolvePurgeClient maps an IP or MAC to the IPs its queries came from and the
+// owner its analytics are counted under. An IP with a known MAC stands for the
+// whole device, so every IP cached for that MAC is included.
+func resolvePurgeClient(client string) (owner string, ips []string, err error) {
+	mac, macErr := parseMACAddress(client)
+	if macErr != nil {
+		ip := net.ParseIP(client)
+		if ip == nil {
+			return "", nil, fmt.Errorf("%w: %q", ErrInvalidClient, client)
+		}
+		ips = append(ips, ip.String())
+		if m, ok := ipMACCache.GetMAC(ip.String()); ok && m != "" && !strings.HasPrefix(m, "ip:") {
+			mac = normalizeMACAddress(m)
+		} else {
+			return "ip:" + ip.String(), ips, nil
+		}
+	}
+	for ip, m := range ipMACCache.Snapshot() {
+		if normalizeMACAddress(m) == mac && !slices.Contains(ips, ip) {
+			ips = append(ips, ip)
+		}
+	}
+	sort.Strings(ips)
+	return mac, ips, nil
+}
+
+// DeleteClientLogs removes one client's queries from the recent logs, the query
+// log and its rotated segments, and takes them out of the analytics: the
+// client's own counters are dropped and the totals reduced by them. Per-list
+// hit counts aren't kept per client and are left alone. client is an IP or MAC.
+func (b *BlocklistManager) DeleteClientLogs(client string) (ClientPurge, error) {
+	owner, ips, err := resolvePurgeClient(client)
+	if err != nil {
+		return ClientPurge{}, err
+	}
+	purge := ClientPurge{Client: strings.TrimPrefix(owner, "ip:"), IPs: ips}
+	ipSet := make(map[string]bool, len(ips))
+	for _, ip := range ips {
+		ipSet[ip] = true
+	}
+	match := func(e QueryEntry) bool { return ipSet[GetClientIP(e.Client)] }
+
+	// entries still waiting for the writer would land after the rewrite
+	b.flushLog()
+	b.logMu.Lock()
+	defer b.logMu.Unlock()
+	if b.logPath != "" {
+		n, err := filterLogFile(b.logPath, false, match)
+		if err != nil {
+			return purge, fmt.Errorf("rewriting query log: %w", err)
+		}
+		purge.Entries += n
+		segs, err := b.logSegments()
+		if err != nil {
+			return purge, err
+		}
+		for _, s := range segs {
+			n, err := filterLogFile(s.path, s.compressed, match)
+			if err != nil {
+				return purge, fmt.Errorf("rewriting %s: %w", s.path, err)
+			}
+			purge.Entries += n
+		}
+	}
+
+	b.recentMu.Lock()
+	if n := b.compactRecent(match); b.logPath == "" {
+		// with a log file the same entries were counted there
+		purge.Entries += n
+	}
+	b.recentMu.Unlock()
+
+	b.statsMu.Lock()
+	if uc, ok := b.userStats[owner]; ok {
+		b.queries -= uc.queries
+		b.blockedQueries -= uc.blockedQueries
+		subtractCounts(b.domainHits, uc.domainHits)
+		subtractCounts(b.allHits, uc.allHits)
+		delete(b.userStats, owner)
+	}
+	for c := range b.clientHits {
+		if ipSet[GetClientIP(c)] {
+			delete(b.clientHits, c)
+		}
+	}
+	for _, ip := range ips {
+		delete(b.clientSeen, ip)
+	}
+	b.statsMu.Unlock()
+
+	log.Printf("Deleted %d log entries for client %s", purge.Entries, purge.Client)
+	return purge, nil
+}
+
+// subtractCounts takes sub's counts off total, dropping keys that reach zero
+func subtractCounts(total, sub map[string]int) {
+	for k, n := range sub {
+		if total[k] -= n; total[k] <= 0 {
+			delete(total, k)
+		}
+	}
+}
+
+// filterLogFile rewrites a JSON-lines query log without the entries drop
+// matches and returns how many were removed. Lines that don't decode are kept.
+// The file is replaced through a temporary copy in the same directory so a
+// failure leaves it intact. Caller holds logMu.
+func filterLogFile(path string, compressed bool, drop func(QueryEntry) bool) (int, error) {
+	in, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+	var r io.Reader = in
+	if compressed {
+		zr, err := gzip.NewReader(in)
+		if err != nil {
+			return 0, err
+		}
+		defer zr.Close()
+		r = zr
+	}
+
+	// dot-prefixed so logSegments never takes it for a rotated segment
+	out, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return 0, err
+	}
+	tmp := out.Name()
+	if fi, err := in.Stat(); err == nil {
+		out.Chmod(fi.Mode().Perm())
+	}
+	var w io.Writer = out
+	var zw *gzip.Writer
+	if compressed {
+		zw = gzip.NewWriter(out)
+		w = zw
+	}
+	bw := bufio.NewWriter(w)
+
+	removed := 0
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 64*1024), 1<<20)
+	for sc.Scan() {
+		line := sc.Bytes()
+		var e QueryEntry
+		if json.Unmarshal(line, &e) == nil && drop(e) {
+			removed++
+			continue
+		}
+		bw.Write(line)
+		bw.WriteByte('\n')
+	}
+	err = sc.Err()
+	if ferr := bw.Flush(); err == nil {
+		err = ferr
+	}
+	if zw != nil {
+		if cerr := zw.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && removed > 0 {
+		in.Close()
+		err = os.Rename(tmp, path)
+	}
+	if err != nil || removed == 0 {
+		os.Remove(tmp)
+	}
+	return removed, err
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDeleteClientLogsCatchesQueuedEntries(t *testing.T) {
+	const (
+		mac, ip   = "cc:cc:cc:cc:cc:03", "192.168.60.33"
+		otherIP   = "192.168.60.44"
+		perClient = 500
+	)
+	ipMACCache.SetIPMAC(ip, mac)
+	tests := []struct {
+		name   string
+		client string
+	}{
+		{"by IP", ip},
+		{"by MAC", mac},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			bm, err := NewBlocklistManager(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// queue without waiting, so the purge starts with entries still in logCh
+			for i := 0; i < perClient; i++ {
+				bm.RecordQueryOfType("purged.example", ip+":5353", "A", false)
+				bm.RecordQueryOfType("kept.example", otherIP+":5353", "A", false)
+			}
+			if _, err := bm.DeleteClientLogs(tt.client); err != nil {
+				t.Fatal(err)
+			}
+			bm.flushLog()
+
+			data, err := os.ReadFile(bm.logPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n := strings.Count(string(data), "purged.example"); n != 0 {
+				t.Errorf("%d purged entries written after DeleteClientLogs returned", n)
+			}
+			if n := strings.Count(string(data), "kept.example"); n != perClient {
+				t.Errorf("kept %d entries from other clients, want %d", n, perClient)
+			}
+			if tmps, _ := filepath.Glob(filepath.Join(dir, ".*.tmp")); len(tmps) > 0 {
+				t.Errorf("temporary files left behind: %v", tmps)
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// clientActivity is what the resolver has seen from one client IP
+type clientActivity struct {
+	queries  int
+	blocked  int
+	lastSeen time.Time
+}
+
+// ClientInfo is one entry of the device inventory served at /clients
+type ClientInfo struct {
+	IP       string     `json:"ip"`
+	MAC      string     `json:"mac,omitempty"`
+	Label    string     `json:"label,omitempty"`
+	Queries  int        `json:"queries"`
+	Blocked  int        `json:"blocked"`
+	LastSeen *time.Time `json:"last_seen,omitempty"` // unset for clients known only from a sign-in
+}
+
+// noteClient updates the activity of the IP behind client. Caller holds statsMu.
+func (b *BlocklistManager) noteClient(client string, blocked bool, at time.Time) {
+	ip := GetClientIP(client)
+	if net.ParseIP(ip) == nil {
+		return
+	}
+	c, ok := b.clientSeen[ip]
+	if !ok {
+		c = &clientActivity{}
+		b.clientSeen[ip] = c
+	}
+	c.queries++
+	if blocked {
+		c.blocked++
+	}
+	c.lastSeen = at
+}
+
+// Clients returns every client IP that has queried the resolver or signed in,
+// most recently seen first, with the MAC it is known by
+func (b *BlocklistManager) Clients() []ClientInfo {
+	byIP := make(map[string]*ClientInfo)
+	b.statsMu.RLock()
+	for ip, c := range b.clientSeen {
+		seen := c.lastSeen
+		byIP[ip] = &ClientInfo{IP: ip, Queries: c.queries, Blocked: c.blocked, LastSeen: &seen}
+	}
+	b.statsMu.RUnlock()
+
+	for ip, mac := range ipMACCache.Snapshot() {
+		if net.ParseIP(ip) == nil {
+			continue
+		}
+		info, ok := byIP[ip]
+		if !ok {
+			info = &ClientInfo{IP: ip}
+			byIP[ip] = info
+		}
+		if !strings.HasPrefix(mac, "ip:") {
+			info.MAC = mac
+		}
+	}
+
+	clients := make([]ClientInfo, 0, len(byIP))
+	for _, info := range byIP {
+		clients = append(clients, *info)
+	}
+	sort.Slice(clients, func(i, j int) bool {
+		a, c := clients[i].LastSeen, clients[j].LastSeen
+		if (a == nil) != (c == nil) {
+			return a != nil
+		}
+		if a != nil && !a.Equal(*c) {
+			return a.After(*c)
+		}
+		return clients[i].IP < clients[j].IP
+	})
+	return clients
+}
+
+// handleClients serves the device inventory, labelled with device names
+func handleClients(w http.ResponseWriter, r *http.Request, bm *BlocklistManager, am *AccountManager) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "method not allowed")
+		return
+	}
+	clients := bm.Clients()
+	if names, err := am.DeviceNames(); err == nil {
+		for i := range clients {
+			clients[i].Label = deviceLabel(names, clients[i].IP)
+		}
+	} else {
+		slog.Error("failed to load device names", "err", err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(clients)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClientsInventory(t *testing.T) {
+	const (
+		phone, laptop, tablet = "192.168.72.1", "192.168.72.2", "192.168.72.3"
+		phoneMAC, tabletMAC   = "aa:bb:cc:00:11:37", "aa:bb:cc:00:11:38"
+	)
+	ipMACCache.SetIPMAC(phone, phoneMAC)
+	ipMACCache.SetIPMAC(tablet, tabletMAC) // signed in, never queried
+	ipMACCache.SetIPMAC(laptop, "ip:"+laptop)
+	am := newTestAccountManager(t)
+	if err := am.SetDeviceName(phoneMAC, "Phone"); err != nil {
+		t.Fatal(err)
+	}
+	if err := am.SetDeviceName("ip:"+laptop, "Laptop"); err != nil {
+		t.Fatal(err)
+	}
+
+	bm := newTestBlocklistManager(t, nil)
+	for _, q := range []struct {
+		client  string
+		blocked bool
+	}{
+		{phone + ":1000", false},
+		{laptop + ":1000", true},
+		{phone + ":1001", true}, // another source port, same device
+		{"not-an-address", false},
+	} {
+		bm.RecordQueryOfType("a.example", q.client, "A", q.blocked)
+		time.Sleep(time.Millisecond)
+	}
+
+	r := asUser(httptest.NewRequest(http.MethodGet, "/clients", nil), "aa:00:00:00:11:37", true, false)
+	w := httptest.NewRecorder()
+	handleClients(w, r, bm, am)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	var all []ClientInfo
+	if err := json.Unmarshal(w.Body.Bytes(), &all); err != nil {
+		t.Fatal(err)
+	}
+	// the IP to MAC cache is shared with other tests
+	type row struct {
+		IP, MAC, Label   string
+		Queries, Blocked int
+		Seen             bool
+	}
+	var got []row
+	for _, c := range all {
+		if strings.HasPrefix(c.IP, "192.168.72.") {
+			got = append(got, row{c.IP, c.MAC, c.Label, c.Queries, c.Blocked, c.LastSeen != nil})
+		}
+	}
+	want := []row{
+		{phone, phoneMAC, "Phone", 2, 1, true}, // most recently seen first
+		{laptop, "", "Laptop", 1, 1, true},
+		{tablet, tabletMAC, "", 0, 0, false},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("clients = %+v, want %+v", got, want)
+	}
+
+	w = httptest.NewRecorder()
+	handleClients(w, httptest.NewRequest(http.MethodPost, "/clients", nil), bm, am)
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST /clients: status %d, want %d", w.Code, http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// queryGroup coalesces identical upstream queries that are in flight at the
+// same time: the first caller sends the query and the others wait for its
+// answer, so twenty clients asking for the same uncached name cost one
+// upstream exchange rather than twenty.
+type queryGroup struct {
+	mu    sync.Mutex
+	calls map[string]*queryCall
+}
+
+// queryCall is one upstream exchange that other callers may be waiting on
+type queryCall struct {
+	done chan struct{}
+	resp *dns.Msg
+	err  error
+}
+
+// upstreamQueries coalesces the Go DNS server's forwarded queries
+var upstreamQueries = &queryGroup{calls: make(map[string]*queryCall)}
+
+// do returns the result of fn, or of the fn already running for key. The
+// exchange runs detached from every caller, under its own timeout, so one
+// client giving up doesn't fail it for the others; each caller stops waiting
+// when its own ctx is done and gets its own copy of the response. shared
+// reports whether the result came from another caller's exchange.
+func (g *queryGroup) do(ctx context.Context, key string, timeout time.Duration, fn func(context.Context) (*dns.Msg, error)) (resp *dns.Msg, shared bool, err error) {
+	g.mu.Lock()
+	c, shared := g.calls[key]
+	if !shared {
+		c = &queryCall{done: make(chan struct{})}
+		g.calls[key] = c
+		xctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), timeout)
+		go func() {
+			defer cancel()
+			c.resp, c.err = fn(xctx)
+			g.mu.Lock()
+			delete(g.calls, key)
+			g.mu.Unlock()
+			close(c.done)
+		}()
+	}
+	g.mu.Unlock()
+
+	select {
+	case <-c.done:
+	case <-ctx.Done():
+		return nil, shared, ctx.Err()
+	}
+	if c.resp != nil {
+		return c.resp.Copy(), shared, c.err
+	}
+	return nil, shared, c.err
+}
+
+// coalesceKey identifies queries that get the same upstream answer: the
+// upstream, each question's name (as sent, since case is echoed back), type
+// and class, and the DO and CD bits, which change what is returned
+func coalesceKey(r *dns.Msg, upstream string) string {
+	var b strings.Builder
+	b.WriteString(upstream)
+	for _, q := range r.Question {
+		b.WriteByte('|')
+		b.WriteString(q.Name)
+		b.WriteByte('/')
+		b.WriteString(strconv.Itoa(int(q.Qtype)))
+		b.WriteByte('/')
+		b.WriteString(strconv.Itoa(int(q.Qclass)))
+	}
+	if dnssecOK(r) {
+		b.WriteString("|do")
+	}
+	if r.CheckingDisabled {
+		b.WriteString("|cd")
+	}
+	return b.String()
+}
+
+// coalescedForward is forwardQuery with identical in-flight queries sharing
+// one upstream exchange, which is given up after timeout
+func coalescedForward(ctx context.Context, cfg *Config, r *dns.Msg, upstream string, timeout time.Duration) (*dns.Msg, error) {
+	resp, shared, err := upstreamQueries.do(ctx, coalesceKey(r, upstream), timeout, func(ctx context.Context) (*dns.Msg, error) {
+		return forwardQuery(ctx, cfg, r, upstream)
+	})
+	if shared {
+		upstreamCoalesced.Add(1)
+	}
+	return resp, err
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestQueryGroupSharesOneExchange(t *testing.T) {
+	g := &queryGroup{calls: make(map[string]*queryCall)}
+	release := make(chan struct{})
+	var calls atomic.Int32
+	fn := func(ctx context.Context) (*dns.Msg, error) {
+		calls.Add(1)
+		<-release
+		m := new(dns.Msg)
+		m.Rcode = dns.RcodeSuccess
+		return m, nil
+	}
+
+	const callers = 20
+	var wg sync.WaitGroup
+	var shared atomic.Int32
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, s, err := g.do(context.Background(), "k", time.Second, fn)
+			if err != nil || resp == nil {
+				t.Errorf("do = %v, %v", resp, err)
+			}
+			if s {
+				shared.Add(1)
+			}
+		}()
+	}
+	// let every caller join before the exchange finishes
+	for deadline := time.Now().Add(time.Second); calls.Load() == 0 && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if n := calls.Load(); n != 1 {
+		t.Errorf("fn ran %d times, want 1", n)
+	}
+	if n := shared.Load(); n != callers-1 {
+		t.Errorf("%d callers shared the result, want %d", n, callers-1)
+	}
+}
+
+func TestQueryGroupCallerContexts(t *testing.T) {
+	tests := []struct {
+		name         string
+		cancelLeader bool // the caller that started the exchange gives up
+		cancelWaiter bool // a caller that joined it gives up
+	}{
+		{"leader gives up, waiter still answered", true, false},
+		{"waiter gives up, leader still answered", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := &queryGroup{calls: make(map[string]*queryCall)}
+			started, release := make(chan struct{}), make(chan struct{})
+			fn := func(ctx context.Context) (*dns.Msg, error) {
+				close(started)
+				select {
+				case <-release:
+					return new(dns.Msg), nil
+				case <-ctx.Done():
+					return nil, ctx.Err()
+				}
+			}
+			leaderCtx, cancelLeader := context.WithCancel(context.Background())
+			defer cancelLeader()
+			waiterCtx, cancelWaiter := context.WithCancel(context.Background())
+			defer cancelWaiter()
+
+			type result struct {
+				resp *dns.Msg
+				err  error
+			}
+			leader, waiter := make(chan result, 1), make(chan result, 1)
+			go func() {
+				resp, _, err := g.do(leaderCtx, "k", time.Second, fn)
+				leader <- result{resp, err}
+			}()
+			<-started
+			go func() {
+				resp, _, err := g.do(waiterCtx, "k", time.Second, fn)
+				waiter <- result{resp, err}
+			}()
+			time.Sleep(20 * time.Millisecond)
+
+			check := func(who string, ch chan result, cancelled bool) {
+				t.Helper()
+				select {
+				case res := <-ch:
+					if cancelled && !errors.Is(res.err, context.Canceled) {
+						t.Errorf("%s: err = %v, want context.Canceled", who, res.err)
+					}
+					if !cancelled && (res.err != nil || res.resp == nil) {
+						t.Errorf("%s: do = %v, %v; want the shared answer", who, res.resp, res.err)
+					}
+				case <-time.After(time.Second):
+					t.Fatalf("%s: still waiting", who)
+				}
+			}
+			if tt.cancelLeader {
+				cancelLeader()
+				check("leader", leader, true)
+			}
+			if tt.cancelWaiter {
+				cancelWaiter()
+				check("waiter", waiter, true)
+			}
+			close(release)
+			if !tt.cancelLeader {
+				check("leader", leader, false)
+			}
+			if !tt.cancelWaiter {
+				check("waiter", waiter, false)
+			}
+		})
+	}
+}
+
+func TestQueryGroupExchangeTimesOut(t *testing.T) {
+	g := &queryGroup{calls: make(map[string]*queryCall)}
+	fn := func(ctx context.Context) (*dns.Msg, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	_, _, err := g.do(context.Background(), "k", 10*time.Millisecond, fn)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v, want context.DeadlineExceeded", err)
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if len(g.calls) != 0 {
+		t.Errorf("finished exchange still registered: %v", g.calls)
+	}
+}
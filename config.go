@@ -1,34 +1,539 @@
 package main
 
 import (
+    "fmt"
     "net"
+    "net/url"
+    "strconv"
     "strings"
-    "log"
+    "log/slog"
+    "sync/atomic"
+    "time"
+
+    "golang.org/x/crypto/bcrypt"
 )
 
 // Config holds runtime settings for PiBlock.
 type Config struct {
     Upstream     string `json:"upstream"`      // upstream DNS (host:port)
+    // UpstreamProtocol is how the default upstream is queried: "udp" (default),
+    // "tcp" or "dot" (DNS-over-TLS, usually port 853).
+    UpstreamProtocol string `json:"upstream_protocol"`
+    // UpstreamTLSServerName is the name checked against a DNS-over-TLS upstream's
+    // certificate. It defaults to the upstream's host.
+    UpstreamTLSServerName string `json:"upstream_tls_server_name"`
+    // FallbackUpstreams (host:port) are tried in order when the default
+    // upstream fails, using the same protocol. An upstream failing repeatedly
+    // is skipped for a cooldown; see upstreamhealth.go.
+    FallbackUpstreams []string `json:"fallback_upstreams"`
     BlockingMode string `json:"blocking_mode"` // redirect | null | nx
     BlockPageIP  string `json:"block_page_ip"` // IP to which blocked domains are redirected
     BlockPagePort int   `json:"block_page_port"` // HTTP port for block page
+    // NullSinkIP and NullSinkIPv6 are the addresses null mode answers A and
+    // AAAA queries with, e.g. a loopback alias or a logging honeypot. They
+    // default to 0.0.0.0 and ::.
+    NullSinkIP   string `json:"null_sink_ip"`
+    NullSinkIPv6 string `json:"null_sink_ipv6"`
+    // SafeSearch is the default for users who haven't set their own preference.
+    SafeSearch   bool   `json:"safe_search"`
+    // SafeSearchTargets maps a search engine host to its enforced safe-search host.
+    SafeSearchTargets map[string]string `json:"safe_search_targets"`
+    // ConditionalForwarders maps a domain suffix (e.g. "home.lan") to the
+    // resolver (host:port) that should answer for it. Longest suffix wins.
+    ConditionalForwarders map[string]string `json:"conditional_forwarders"`
+    // LocalDomain is appended to hostnames synthesized for PTR answers (e.g. "lan").
+    LocalDomain string `json:"local_domain"`
+    // LocalHostNames maps client IPs to hostnames returned for PTR queries.
+    LocalHostNames map[string]string `json:"local_host_names"`
+    // SessionTTL and GuestSessionTTL are Go durations (e.g. "24h", "15m").
+    SessionTTL      string `json:"session_ttl"`
+    GuestSessionTTL string `json:"guest_session_ttl"`
+    // SingleSessionPerMAC ends a device's earlier sessions, guest ones
+    // included, whenever it logs in or creates its account.
+    SingleSessionPerMAC bool `json:"single_session_per_mac"`
+    // PasscodeMinLength and PasscodeMinClasses set passcode strength; classes
+    // are lowercase, uppercase, digits and symbols.
+    PasscodeMinLength  int `json:"passcode_min_length"`
+    PasscodeMinClasses int `json:"passcode_min_classes"`
+    // GuestPermissions is what guest sessions may view: view_lists,
+    // view_analytics, view_logs, view_devices and view_settings. All by default;
+    // an empty list disables guest sign-in. Guests can never modify anything.
+    GuestPermissions []string `json:"guest_permissions"`
+    // BcryptCost is the work factor for hashing passcodes.
+    BcryptCost int `json:"bcrypt_cost"`
+    // AdminMACs lists the account MAC addresses allowed to use admin endpoints.
+    AdminMACs []string `json:"admin_macs"`
+    // LogLevel is debug|info|warn|error and LogFormat is text|json.
+    LogLevel  string `json:"log_level"`
+    LogFormat string `json:"log_format"`
+    // QueryLogRate caps how many blocked queries are logged at INFO per second;
+    // repeats of the same name and client within the second are dropped and
+    // the rest are counted in the next line. Every query is still logged at DEBUG.
+    QueryLogRate int `json:"query_log_rate"`
+    // DNSBind is the address (host:port) the Go DNS server listens on and
+    // RustDNSBind the UDP address used by the rust backend.
+    DNSBind     string `json:"dns_bind"`
+    RustDNSBind string `json:"rust_dns_bind"`
+    // APIBind is where the HTTP API listens. Keep it on loopback unless the web
+    // proxy runs on another host or container (point its GO_API at this address).
+    APIBind string `json:"api_bind"`
+    // AllowedClients lists CIDRs (or single IPs) allowed to query DNS. When
+    // empty only loopback, private and link-local ranges are allowed.
+    AllowedClients []string `json:"allowed_clients"`
+    // TrustedProxies lists CIDRs (or single IPs) of reverse proxies in front
+    // of the API. X-Forwarded-For and X-Real-IP are only believed on requests
+    // from them; when empty only loopback (the bundled web proxy) is trusted.
+    TrustedProxies []string `json:"trusted_proxies"`
+    // RefusedResponse is how queries from clients outside AllowedClients are
+    // answered: "refuse" (default) replies REFUSED, "drop" sends nothing so a
+    // spoofed source can't use us to reflect traffic.
+    RefusedResponse string `json:"refused_response"`
+    // RecentLogCap is how many recent queries are kept in memory for /logs.
+    RecentLogCap int `json:"recent_log_cap"`
+    // WatchBlocklistDir reloads lists automatically when files in the blocklist
+    // directory are edited on disk.
+    WatchBlocklistDir bool `json:"watch_blocklist_dir"`
+    // InMemoryLists reads the blocklist directory once at startup and then keeps
+    // lists, list metadata and the query log in memory only: nothing is written
+    // there and changes made through the API are lost on restart. For stateless
+    // deployments; watch_blocklist_dir and /backup don't apply.
+    InMemoryLists bool `json:"in_memory_lists"`
+    // MaxFetchBytes caps the size of a downloaded blocklist.
+    MaxFetchBytes int64 `json:"max_fetch_bytes"`
+    // LogRotateBytes is the size at which logs.jsonl is rotated to logs.jsonl.1;
+    // rotated segments are gzipped and the oldest deleted once all of them
+    // together exceed LogBudgetBytes.
+    LogRotateBytes int64 `json:"log_rotate_bytes"`
+    LogBudgetBytes int64 `json:"log_budget_bytes"`
+    // MaxRequestBytes caps the size of a request body sent to the API.
+    MaxRequestBytes int64 `json:"max_request_bytes"`
+    // FetchAttempts is how many times a blocklist download is tried when the
+    // mirror returns 5xx, times out or drops the connection.
+    FetchAttempts int `json:"fetch_attempts"`
+    // MatchSubdomains makes plain entries like "example.com" also block
+    // "*.example.com". Lists can override it in their .meta.json.
+    MatchSubdomains bool `json:"match_subdomains"`
+    // BlockApexWithWildcard makes a wildcard entry like "*.example.com" also
+    // block "example.com" itself, which it otherwise leaves alone.
+    BlockApexWithWildcard bool `json:"block_apex_with_wildcard"`
+    // RandomizeQueryCase sends forwarded queries with randomized letter case
+    // (0x20 encoding) and checks the upstream echoes the name back.
+    RandomizeQueryCase bool `json:"randomize_query_case"`
+    // DefaultLists names lists in the blocklist directory that are copied to
+    // every new account, e.g. ["ads", "trackers"] for ads.txt and trackers.txt.
+    DefaultLists []string `json:"default_lists"`
+    // MaxConcurrentQueries caps how many queries the Go DNS server forwards
+    // upstream at once; further queries get SERVFAIL until a slot frees up.
+    // Blocked and locally answered queries don't count.
+    MaxConcurrentQueries int `json:"max_concurrent_queries"`
+    // QueryTimeout is a Go duration bounding how long one DNS query may take,
+    // upstream lookups included. Past it the client gets SERVFAIL and the
+    // upstream call is abandoned; keep it below clients' own retry timeout.
+    QueryTimeout string `json:"query_timeout"`
+    // AnyQueries is how ANY queries are answered: "hinfo" returns the minimal
+    // RFC 8482 HINFO record, "notimp" replies NOTIMP and "forward" sends them
+    // upstream. Unset, it is "hinfo" unless dns_bind is a loopback address.
+    AnyQueries string `json:"any_queries"`
+    // BlockedOtherTypes is how blocked queries for types that get no synthesized
+    // record (HTTPS/SVCB, MX, TXT, ... and AAAA in redirect mode) are answered:
+    // "nodata" (default) or "nxdomain". nx blocking mode always answers NXDOMAIN.
+    BlockedOtherTypes string `json:"blocked_other_types"`
+    // UnidentifiedClients decides how queries from clients with no known MAC are
+    // filtered: "all-lists" (default) matches every loaded list, "block" refuses
+    // every name, "allow" applies only global lists, and "use-default-user-list"
+    // applies the lists named in DefaultLists plus global lists. See
+    // SECURITY_SUMMARY.md for the trade-offs.
+    UnidentifiedClients string `json:"unidentified_clients"`
+    // StatsPushURL is an http(s) endpoint that gets a JSON summary of the
+    // analytics (totals and top domains) every StatsPushInterval, e.g. for a
+    // dashboard aggregating several Pis. Unset, nothing is pushed.
+    StatsPushURL string `json:"stats_push_url"`
+    // StatsPushInterval is a Go duration; it defaults to 5m.
+    StatsPushInterval string `json:"stats_push_interval"`
+    // BlockingEnabled is the kill-switch state at startup; /blocking toggles it at runtime.
+    BlockingEnabled bool `json:"blocking_enabled"`
+    // BlocklistDir holds list files and the query log; DataDir holds the
+    // accounts database. Packaged installs usually point both under /var/lib/piblock.
+    // PIBLOCK_BLOCKLIST_DIR / -blocklist-dir and PIBLOCK_DATA_DIR / -data-dir override them.
+    BlocklistDir string `json:"blocklist_dir"`
+    DataDir      string `json:"data_dir"`
+
+    // trustedProxies is TrustedProxies parsed, filled in by setAppConfig so
+    // requests don't parse it again
+    trustedProxies *clientACL
+}
+
+// defaultMaxFetchBytes is used when MaxFetchBytes is unset.
+const defaultMaxFetchBytes = 100 << 20
+
+// Defaults for LogRotateBytes and LogBudgetBytes; sized for an SD card.
+const (
+    defaultLogRotateBytes = 16 << 20
+    defaultLogBudgetBytes = 128 << 20
+)
+
+// defaultQueryLogRate is used when QueryLogRate is unset.
+const defaultQueryLogRate = 20
+
+// defaultMaxConcurrentQueries is used when MaxConcurrentQueries is unset.
+const defaultMaxConcurrentQueries = 512
+
+// defaultQueryTimeout is used when QueryTimeout is unset. It sits under the 5s
+// most stub resolvers wait before retrying.
+const defaultQueryTimeout = 4 * time.Second
+
+// defaultStatsPushInterval is used when StatsPushInterval is unset.
+const defaultStatsPushInterval = 5 * time.Minute
+
+// defaultMaxRequestBytes is used when MaxRequestBytes is unset. It leaves room
+// for pasting a sizeable list of items into /lists/create.
+const defaultMaxRequestBytes = 4 << 20
+
+// Bounds for RecentLogCap; the ring is allocated up front so the maximum keeps memory bounded.
+const (
+    defaultRecentLogCap = 500
+    minRecentLogCap     = 50
+    maxRecentLogCap     = 100000
+)
+
+// appConfig holds the running config. A reload (SIGHUP) swaps in a new one
+// whole, so read it through AppConfig each time rather than keeping the
+// pointer, and never modify the Config it returns.
+var appConfig atomic.Pointer[Config]
+
+func init() {
+    setAppConfig(defaultConfig())
+}
+
+// AppConfig returns the running config
+func AppConfig() *Config {
+    return appConfig.Load()
+}
+
+// setAppConfig makes c the running config. c must have passed Validate.
+func setAppConfig(c *Config) {
+    c.trustedProxies, _ = newTrustedProxies(c.TrustedProxies)
+    appConfig.Store(c)
+}
+
+// defaultConfig returns the built-in settings, used where the config file
+// doesn't say otherwise
+func defaultConfig() *Config {
+    return &Config{
+        Upstream: "1.1.1.1:53",
+        BlockingMode: "redirect",
+        BlockPageIP: "",
+        // Block page runs on a separate port from the Rust control API to avoid collisions.
+        // Default to 8083 so it doesn't conflict with the control API (9080) or frontend (3000).
+        BlockPagePort: 8083,
+        SafeSearch: false,
+        LocalDomain: "lan",
+        SessionTTL: "24h",
+        GuestSessionTTL: "24h",
+        QueryTimeout: "4s",
+        StatsPushInterval: "5m",
+        PasscodeMinLength: 4,
+        PasscodeMinClasses: 1,
+        GuestPermissions: allGuestPermissions,
+        BcryptCost: bcrypt.DefaultCost,
+        LogLevel: "info",
+        LogFormat: "text",
+        QueryLogRate: defaultQueryLogRate,
+        DNSBind: ":53",
+        RustDNSBind: "0.0.0.0:5353",
+        APIBind: "127.0.0.1:8081",
+        RecentLogCap: defaultRecentLogCap,
+        MaxFetchBytes: defaultMaxFetchBytes,
+        MaxRequestBytes: defaultMaxRequestBytes,
+        LogRotateBytes: defaultLogRotateBytes,
+        LogBudgetBytes: defaultLogBudgetBytes,
+        FetchAttempts: 3,
+        BlockingEnabled: true,
+        BlocklistDir: "./blocklist",
+        DataDir: "./data",
+        SafeSearchTargets: map[string]string{
+            "www.google.com":          "forcesafesearch.google.com",
+            "google.com":              "forcesafesearch.google.com",
+            "www.bing.com":            "strict.bing.com",
+            "bing.com":                "strict.bing.com",
+            "duckduckgo.com":          "safe.duckduckgo.com",
+            "www.duckduckgo.com":      "safe.duckduckgo.com",
+            "www.youtube.com":         "restrict.youtube.com",
+            "m.youtube.com":           "restrict.youtube.com",
+            "youtube.com":             "restrict.youtube.com",
+            "youtubei.googleapis.com": "restrict.youtube.com",
+            "youtube.googleapis.com":  "restrict.youtube.com",
+        },
+    }
+}
+
+// Validate checks that configured values are usable.
+func (c *Config) Validate() error {
+    if err := validateBlockingOverride(c.BlockingMode, c.BlockPageIP); err != nil {
+        return err
+    }
+    for field, v := range map[string]string{"session_ttl": c.SessionTTL, "guest_session_ttl": c.GuestSessionTTL, "query_timeout": c.QueryTimeout, "stats_push_interval": c.StatsPushInterval} {
+        if v == "" {
+            continue
+        }
+        d, err := time.ParseDuration(v)
+        if err != nil {
+            return fmt.Errorf("invalid %s %q: %w", field, v, err)
+        }
+        if d <= 0 {
+            return fmt.Errorf("invalid %s %q: must be positive", field, v)
+        }
+    }
+    if c.BcryptCost != 0 && (c.BcryptCost < bcrypt.MinCost || c.BcryptCost > bcrypt.MaxCost) {
+        return fmt.Errorf("invalid bcrypt_cost %d: must be between %d and %d", c.BcryptCost, bcrypt.MinCost, bcrypt.MaxCost)
+    }
+    if c.PasscodeMinLength < 0 {
+        return fmt.Errorf("invalid passcode_min_length %d: must not be negative", c.PasscodeMinLength)
+    }
+    if c.PasscodeMinClasses < 0 || c.PasscodeMinClasses > 4 {
+        return fmt.Errorf("invalid passcode_min_classes %d: must be between 0 and 4", c.PasscodeMinClasses)
+    }
+    for field, v := range map[string]string{"dns_bind": c.DNSBind, "rust_dns_bind": c.RustDNSBind, "api_bind": c.APIBind} {
+        if err := validateBindAddr(v); err != nil {
+            return fmt.Errorf("invalid %s %q: %w", field, v, err)
+        }
+    }
+    switch c.AnyQueries {
+    case "", anyHINFO, anyNotImp, anyForward:
+    default:
+        return fmt.Errorf("invalid any_queries %q: must be hinfo, notimp or forward", c.AnyQueries)
+    }
+    if err := validateGuestPermissions(c.GuestPermissions); err != nil {
+        return err
+    }
+    if ip := net.ParseIP(c.NullSinkIP); c.NullSinkIP != "" && (ip == nil || ip.To4() == nil) {
+        return fmt.Errorf("invalid null_sink_ip %q: must be an IPv4 address", c.NullSinkIP)
+    }
+    if ip := net.ParseIP(c.NullSinkIPv6); c.NullSinkIPv6 != "" && (ip == nil || ip.To4() != nil) {
+        return fmt.Errorf("invalid null_sink_ipv6 %q: must be an IPv6 address", c.NullSinkIPv6)
+    }
+    switch c.BlockedOtherTypes {
+    case "", blockedOtherNoData, blockedOtherNXDomain:
+    default:
+        return fmt.Errorf("invalid blocked_other_types %q: must be nodata or nxdomain", c.BlockedOtherTypes)
+    }
+    switch c.RefusedResponse {
+    case "", refusedReply, refusedDrop:
+    default:
+        return fmt.Errorf("invalid refused_response %q: must be refuse or drop", c.RefusedResponse)
+    }
+    switch c.UnidentifiedClients {
+    case "", unidentifiedAllLists, unidentifiedBlock, unidentifiedAllow, unidentifiedDefaultLists:
+    default:
+        return fmt.Errorf("invalid unidentified_clients %q: must be all-lists, block, allow or use-default-user-list", c.UnidentifiedClients)
+    }
+    switch c.UpstreamProtocol {
+    case "", "udp", "tcp", "dot":
+    default:
+        return fmt.Errorf("invalid upstream_protocol %q: must be udp, tcp or dot", c.UpstreamProtocol)
+    }
+    if c.StatsPushURL != "" {
+        if u, err := url.Parse(c.StatsPushURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+            return fmt.Errorf("invalid stats_push_url %q: must be an http or https URL", c.StatsPushURL)
+        }
+    }
+    if err := checkUpstreamLoops(c); err != nil {
+        return err
+    }
+    if _, err := newClientACL(c.AllowedClients); err != nil {
+        return fmt.Errorf("invalid allowed_clients: %w", err)
+    }
+    if _, err := newTrustedProxies(c.TrustedProxies); err != nil {
+        return fmt.Errorf("invalid trusted_proxies: %w", err)
+    }
+    if c.RecentLogCap != 0 && (c.RecentLogCap < minRecentLogCap || c.RecentLogCap > maxRecentLogCap) {
+        return fmt.Errorf("invalid recent_log_cap %d: must be between %d and %d", c.RecentLogCap, minRecentLogCap, maxRecentLogCap)
+    }
+    if c.FetchAttempts < 0 || c.FetchAttempts > 10 {
+        return fmt.Errorf("invalid fetch_attempts %d: must be between 0 and 10", c.FetchAttempts)
+    }
+    if c.MaxFetchBytes < 0 {
+        return fmt.Errorf("invalid max_fetch_bytes %d: must not be negative", c.MaxFetchBytes)
+    }
+    if err := validateDefaultLists(c.DefaultLists); err != nil {
+        return err
+    }
+    if c.MaxConcurrentQueries < 0 {
+        return fmt.Errorf("invalid max_concurrent_queries %d: must not be negative", c.MaxConcurrentQueries)
+    }
+    if c.QueryLogRate < 0 {
+        return fmt.Errorf("invalid query_log_rate %d: must not be negative", c.QueryLogRate)
+    }
+    if c.LogRotateBytes < 0 {
+        return fmt.Errorf("invalid log_rotate_bytes %d: must not be negative", c.LogRotateBytes)
+    }
+    if c.LogBudgetBytes < 0 {
+        return fmt.Errorf("invalid log_budget_bytes %d: must not be negative", c.LogBudgetBytes)
+    }
+    if c.MaxRequestBytes < 0 {
+        return fmt.Errorf("invalid max_request_bytes %d: must not be negative", c.MaxRequestBytes)
+    }
+    for field, v := range map[string]string{"blocklist_dir": c.BlocklistDir, "data_dir": c.DataDir} {
+        if strings.TrimSpace(v) == "" {
+            return fmt.Errorf("invalid %s: must not be empty", field)
+        }
+    }
+    if _, err := parseLogLevel(c.LogLevel); err != nil {
+        return err
+    }
+    switch strings.ToLower(c.LogFormat) {
+    case "", "text", "json":
+    default:
+        return fmt.Errorf("invalid log_format %q (want text or json)", c.LogFormat)
+    }
+    return nil
+}
+
+// validateBindAddr checks that addr is a host:port with an optional IP host and a valid port.
+func validateBindAddr(addr string) error {
+    host, port, err := net.SplitHostPort(addr)
+    if err != nil {
+        return err
+    }
+    if host != "" && net.ParseIP(host) == nil {
+        return fmt.Errorf("host must be an IP address")
+    }
+    if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+        return fmt.Errorf("port must be between 1 and 65535")
+    }
+    return nil
+}
+
+// isLoopbackBind reports whether a host:port bind address only listens on loopback.
+func isLoopbackBind(addr string) bool {
+    host, _, err := net.SplitHostPort(addr)
+    if err != nil {
+        return false
+    }
+    ip := net.ParseIP(host)
+    return ip != nil && ip.IsLoopback()
+}
+
+// IsAdminMAC reports whether mac is configured as an administrator.
+func (c *Config) IsAdminMAC(mac string) bool {
+    if mac == "" {
+        return false
+    }
+    mac = normalizeMACAddress(mac)
+    for _, m := range c.AdminMACs {
+        if normalizeMACAddress(m) == mac {
+            return true
+        }
+    }
+    return false
+}
+
+// RecentLogCapacity returns the configured recent-log ring size, falling back to
+// defaultRecentLogCap when unset and clamping to the allowed range.
+func (c *Config) RecentLogCapacity() int {
+    switch {
+    case c.RecentLogCap <= 0:
+        return defaultRecentLogCap
+    case c.RecentLogCap < minRecentLogCap:
+        return minRecentLogCap
+    case c.RecentLogCap > maxRecentLogCap:
+        return maxRecentLogCap
+    }
+    return c.RecentLogCap
+}
+
+// FetchLimit returns the maximum blocklist download size in bytes.
+func (c *Config) FetchLimit() int64 {
+    if c.MaxFetchBytes <= 0 {
+        return defaultMaxFetchBytes
+    }
+    return c.MaxFetchBytes
+}
+
+// RequestLimit returns the maximum API request body size in bytes.
+func (c *Config) RequestLimit() int64 {
+    if c.MaxRequestBytes <= 0 {
+        return defaultMaxRequestBytes
+    }
+    return c.MaxRequestBytes
 }
 
-// AppConfig is the global runtime config (default values set in main).
-var AppConfig = &Config{
-    Upstream: "1.1.1.1:53",
-    BlockingMode: "redirect",
-    BlockPageIP: "",
-    // Block page runs on a separate port from the Rust control API to avoid collisions.
-    // Default to 8083 so it doesn't conflict with the control API (9080) or frontend (3000).
-    BlockPagePort: 8083,
+// QueryLogLimit returns how many blocked queries may be logged at INFO per second.
+func (c *Config) QueryLogLimit() int {
+    if c.QueryLogRate <= 0 {
+        return defaultQueryLogRate
+    }
+    return c.QueryLogRate
+}
+
+// ConcurrentQueryLimit returns how many queries may be forwarded upstream at once.
+func (c *Config) ConcurrentQueryLimit() int {
+    if c.MaxConcurrentQueries <= 0 {
+        return defaultMaxConcurrentQueries
+    }
+    return c.MaxConcurrentQueries
+}
+
+// QueryDeadline returns how long one DNS query may take, falling back to
+// defaultQueryTimeout when unset or invalid.
+func (c *Config) QueryDeadline() time.Duration {
+    if d, err := time.ParseDuration(c.QueryTimeout); err == nil && d > 0 {
+        return d
+    }
+    return defaultQueryTimeout
+}
+
+// StatsPushEvery returns how often analytics are pushed to StatsPushURL,
+// falling back to defaultStatsPushInterval when unset or invalid.
+func (c *Config) StatsPushEvery() time.Duration {
+    if d, err := time.ParseDuration(c.StatsPushInterval); err == nil && d > 0 {
+        return d
+    }
+    return defaultStatsPushInterval
+}
+
+// LogRotateLimit returns the query log size that triggers rotation.
+func (c *Config) LogRotateLimit() int64 {
+    if c.LogRotateBytes <= 0 {
+        return defaultLogRotateBytes
+    }
+    return c.LogRotateBytes
+}
+
+// LogBudget returns the total size allowed for rotated query log segments.
+func (c *Config) LogBudget() int64 {
+    if c.LogBudgetBytes <= 0 {
+        return defaultLogBudgetBytes
+    }
+    return c.LogBudgetBytes
+}
+
+// FetchAttemptCount returns how many times a blocklist download is tried (at least once).
+func (c *Config) FetchAttemptCount() int {
+    if c.FetchAttempts < 1 {
+        return 1
+    }
+    return c.FetchAttempts
+}
+
+// SessionDuration returns the configured session lifetime, falling back to
+// defaultSessionTTL when unset or invalid.
+func (c *Config) SessionDuration(isGuest bool) time.Duration {
+    v := c.SessionTTL
+    if isGuest && c.GuestSessionTTL != "" {
+        v = c.GuestSessionTTL
+    }
+    if d, err := time.ParseDuration(v); err == nil && d > 0 {
+        return d
+    }
+    return defaultSessionTTL
 }
 
 // DetectLocalIP determines a likely local IP address by opening a UDP connection.
 func DetectLocalIP() string {
     conn, err := net.Dial("udp", "1.1.1.1:53")
     if err != nil {
-        log.Printf("DetectLocalIP: failed to dial outbound: %v", err)
+        slog.Warn("DetectLocalIP: failed to dial outbound", "err", err)
         return ""
     }
     defer conn.Close()
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// withConfig runs the rest of the test with the default config changed by
+// edit as AppConfig, and puts the previous config back afterwards
+func withConfig(t *testing.T, edit func(c *Config)) {
+	t.Helper()
+	prev := AppConfig()
+	next := defaultConfig()
+	edit(next)
+	if err := next.Validate(); err != nil {
+		t.Fatalf("test config: %v", err)
+	}
+	setAppConfig(next)
+	t.Cleanup(func() { setAppConfig(prev) })
+}
+
+func TestSessionDuration(t *testing.T) {
+	tests := []struct {
+		ttl, guestTTL string
+		guest         bool
+		want          time.Duration
+	}{
+		{"", "", false, defaultSessionTTL},
+		{"", "", true, defaultSessionTTL},
+		{"2h", "", false, 2 * time.Hour},
+		{"2h", "", true, 2 * time.Hour},
+		{"2h", "15m", true, 15 * time.Minute},
+		{"2h", "15m", false, 2 * time.Hour},
+		{"nonsense", "", false, defaultSessionTTL},
+		{"-1h", "", false, defaultSessionTTL},
+	}
+	for _, tt := range tests {
+		c := &Config{SessionTTL: tt.ttl, GuestSessionTTL: tt.guestTTL}
+		if got := c.SessionDuration(tt.guest); got != tt.want {
+			t.Errorf("SessionDuration(ttl=%q, guest_ttl=%q, guest=%t) = %v, want %v", tt.ttl, tt.guestTTL, tt.guest, got, tt.want)
+		}
+	}
+}
+
+func TestQueryDeadline(t *testing.T) {
+	tests := []struct {
+		timeout string
+		want    time.Duration
+	}{
+		{"", defaultQueryTimeout},
+		{"1500ms", 1500 * time.Millisecond},
+		{"nonsense", defaultQueryTimeout},
+		{"-2s", defaultQueryTimeout},
+	}
+	for _, tt := range tests {
+		c := &Config{QueryTimeout: tt.timeout}
+		if got := c.QueryDeadline(); got != tt.want {
+			t.Errorf("QueryDeadline(%q) = %v, want %v", tt.timeout, got, tt.want)
+		}
+	}
+}
+
+func TestValidateRejectsBadSessionTTLs(t *testing.T) {
+	tests := []struct {
+		name string
+		edit func(c *Config)
+	}{
+		{"session_ttl", func(c *Config) { c.SessionTTL = "a day" }},
+		{"guest_session_ttl", func(c *Config) { c.GuestSessionTTL = "0s" }},
+		{"session_ttl", func(c *Config) { c.SessionTTL = "-1h" }},
+		{"query_timeout", func(c *Config) { c.QueryTimeout = "soon" }},
+		{"query_timeout", func(c *Config) { c.QueryTimeout = "0s" }},
+	}
+	for _, tt := range tests {
+		c := defaultConfig()
+		tt.edit(c)
+		if err := c.Validate(); err == nil || !strings.Contains(err.Error(), tt.name) {
+			t.Errorf("Validate with a bad %s = %v, want an error naming it", tt.name, err)
+		}
+	}
+}
+
+func TestValidateBindAddr(t *testing.T) {
+	tests := []struct {
+		addr string
+		ok   bool
+	}{
+		{":53", true},
+		{"0.0.0.0:53", true},
+		{"192.168.1.2:5353", true},
+		{"[::]:53", true},
+		{"[::1]:8053", true},
+		{"53", false},
+		{"localhost:53", false},
+		{"0.0.0.0:0", false},
+		{"0.0.0.0:65536", false},
+		{"0.0.0.0:dns", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if err := validateBindAddr(tt.addr); (err == nil) != tt.ok {
+			t.Errorf("validateBindAddr(%q) = %v, want ok=%t", tt.addr, err, tt.ok)
+		}
+	}
+	c := defaultConfig()
+	c.DNSBind = "127.0.0.1"
+	if err := c.Validate(); err == nil || !strings.Contains(err.Error(), "dns_bind") {
+		t.Errorf("Validate with a bad dns_bind = %v, want an error naming it", err)
+	}
+}
+
+func TestValidateRefusedResponse(t *testing.T) {
+	for value, ok := range map[string]bool{"": true, refusedReply: true, refusedDrop: true, "ignore": false, "DROP": false} {
+		c := defaultConfig()
+		c.RefusedResponse = value
+		if err := c.Validate(); (err == nil) != ok {
+			t.Errorf("Validate with refused_response %q = %v, want ok %v", value, err, ok)
+		}
+	}
+}
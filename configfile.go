@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// restartOnlyFields are the Config fields read once at startup: listeners,
+// stores and background workers built from them keep their values, so a
+// reload leaves them as they are and says a restart is needed instead.
+// BlockingEnabled is only the start state of the kill-switch, which the API
+// toggles at runtime.
+var restartOnlyFields = []string{
+	"DNSBind", "RustDNSBind", "APIBind", "BlockPagePort", "AllowedClients",
+	"TrustedProxies", "RecentLogCap", "BlocklistDir", "DataDir", "InMemoryLists",
+	"WatchBlocklistDir", "StatsPushURL", "StatsPushInterval", "MaxConcurrentQueries",
+	"BlockingEnabled",
+}
+
+// LoadConfig reads the JSON config file at path over the built-in defaults,
+// so settings it leaves out keep their default. Unknown keys are rejected
+// rather than silently ignored. The result isn't validated.
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c, err := decodeConfig(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return c, nil
+}
+
+// decodeConfig reads JSON config data over the built-in defaults, rejecting
+// unknown keys
+func decodeConfig(data []byte) (*Config, error) {
+	c := defaultConfig()
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// buildConfig returns the validated config cl asks for: the config file, or
+// the defaults without one, with the directory overrides applied
+func buildConfig(cl commandLine) (*Config, error) {
+	c := defaultConfig()
+	if cl.ConfigFile != "" {
+		var err error
+		if c, err = LoadConfig(cl.ConfigFile); err != nil {
+			return nil, err
+		}
+	}
+	applyDirOverrides(c, cl)
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// reloadConfig re-reads the config file and swaps it in as the running
+// config with applyConfig. On any error the running config is left alone.
+func reloadConfig(cl commandLine) ([]string, error) {
+	next, err := buildConfig(cl)
+	if err != nil {
+		return nil, err
+	}
+	return applyConfig(next)
+}
+
+// applyConfig swaps in the validated next as the running config; queries
+// already being answered finish with the old one. Fields in restartOnlyFields
+// keep their running values, and the JSON names of those that differ in next
+// are returned.
+func applyConfig(next *Config) ([]string, error) {
+	cur := AppConfig()
+	nv, cv := reflect.ValueOf(next).Elem(), reflect.ValueOf(cur).Elem()
+	var kept []string
+	for _, name := range restartOnlyFields {
+		f, _ := nv.Type().FieldByName(name)
+		if !reflect.DeepEqual(nv.FieldByName(name).Interface(), cv.FieldByName(name).Interface()) {
+			nv.FieldByName(name).Set(cv.FieldByName(name))
+			kept = append(kept, strings.Split(f.Tag.Get("json"), ",")[0])
+		}
+	}
+	if err := setupLogging(next); err != nil {
+		return nil, err
+	}
+	setAppConfig(withBlockPage(next))
+	return kept, nil
+}
+
+// blockPageOnce starts the block page server at most once
+var blockPageOnce sync.Once
+
+// withBlockPage readies c for redirect mode and returns it: the block page
+// server is started the first time a config redirects to it, and an unset
+// BlockPageIP is filled in, on a copy, with a detected local address
+func withBlockPage(c *Config) *Config {
+	if c.BlockingMode != "redirect" || c.BlockPagePort <= 0 {
+		return c
+	}
+	blockPageOnce.Do(func() { StartBlockPageServer(c.BlockPagePort) })
+	if c.BlockPageIP != "" {
+		return c
+	}
+	next := *c
+	if ip := DetectLocalIP(); ip != "" {
+		next.BlockPageIP = ip
+		log.Printf("detected local IP for block page: %s", ip)
+	} else {
+		slog.Warn("could not detect local IP for block page; defaulting to 127.0.0.1")
+		next.BlockPageIP = "127.0.0.1"
+	}
+	return &next
+}
+
+// runningCommandLine is the command line the process started with, so a
+// restore can write the config file it names and reload from it
+var runningCommandLine commandLine
+
+// reloadOnSIGHUP re-reads the config file each time the process gets SIGHUP
+func reloadOnSIGHUP(cl commandLine) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+	for range ch {
+		if cl.ConfigFile == "" {
+			slog.Warn("SIGHUP ignored: no config file to reload (start with -config)")
+			continue
+		}
+		kept, err := reloadConfig(cl)
+		if err != nil {
+			slog.Error("config reload failed; keeping the running config", "file", cl.ConfigFile, "err", err)
+			continue
+		}
+		for _, name := range kept {
+			slog.Warn("config reload: setting needs a restart to change; keeping the running value", "setting", name)
+		}
+		log.Printf("reloaded config from %s", cl.ConfigFile)
+	}
+}
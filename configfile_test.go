@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// TestReloadWhileAnswering swaps the config back and forth while queries and
+// API requests read it; run with -race to check they only see whole snapshots.
+func TestReloadWhileAnswering(t *testing.T) {
+	prevLog := slog.Default()
+	t.Cleanup(func() { slog.SetDefault(prevLog) })
+	withConfig(t, func(c *Config) { c.BlockingMode = "nx" })
+
+	path := filepath.Join(t.TempDir(), "config.json")
+	configs := []string{
+		`{"blocking_mode": "nx", "refused_response": "refuse", "query_timeout": "2s", "log_level": "error"}`,
+		`{"blocking_mode": "null", "refused_response": "drop", "query_timeout": "3s", "log_level": "error", "randomize_query_case": true}`,
+	}
+	cl := commandLine{ConfigFile: path}
+
+	h := newTestDNSHandler(t, map[string][]string{"ads": {"ads.example"}})
+	var wg, started sync.WaitGroup
+	stop := make(chan struct{})
+	for _, client := range []string{"192.168.1.10", "203.0.113.5"} {
+		wg.Add(1)
+		started.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := 0; ; n++ {
+				if n == 1 {
+					started.Done()
+				}
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				serveQuery(h, client, "ads.example", dns.TypeA)
+				r := httptest.NewRequest("GET", "/", nil)
+				r.RemoteAddr = client + ":5000"
+				r.Header.Set("X-Forwarded-For", "192.168.1.99")
+				getClientIP(r)
+			}
+		}()
+	}
+	// reload only once every reader is going
+	started.Wait()
+	for i := 0; i < 50; i++ {
+		if err := os.WriteFile(path, []byte(configs[i%len(configs)]), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := reloadConfig(cl); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(stop)
+	wg.Wait()
+
+	if got := AppConfig().BlockingMode; got != "null" {
+		t.Errorf("after the last reload BlockingMode = %q, want null", got)
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name string
+		file string // "" for no file
+		ok   bool
+		want func(c *Config)
+	}{
+		{"defaults for what's left out", `{"blocking_mode": "nx", "fallback_upstreams": ["9.9.9.9:53"]}`, true, func(c *Config) {
+			c.BlockingMode, c.FallbackUpstreams = "nx", []string{"9.9.9.9:53"}
+		}},
+		{"empty object", `{}`, true, func(c *Config) {}},
+		{"unknown key", `{"blocking_mod": "nx"}`, false, nil},
+		{"wrong type", `{"block_page_port": "8083"}`, false, nil},
+		{"not JSON", `blocking_mode = nx`, false, nil},
+		{"missing file", "", false, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.name+".json")
+			if tt.file != "" {
+				if err := os.WriteFile(path, []byte(tt.file), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			got, err := LoadConfig(path)
+			if (err == nil) != tt.ok {
+				t.Fatalf("LoadConfig = %v, want ok %v", err, tt.ok)
+			}
+			if tt.file == "" && !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("missing file: %v, want %v", err, fs.ErrNotExist)
+			}
+			if !tt.ok {
+				return
+			}
+			want := defaultConfig()
+			tt.want(want)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("LoadConfig = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestReloadConfig(t *testing.T) {
+	prevLog := slog.Default()
+	t.Cleanup(func() { slog.SetDefault(prevLog) })
+
+	tests := []struct {
+		name     string
+		file     string
+		cl       commandLine
+		wantErr  bool
+		wantKept []string
+		check    func(c *Config) bool
+	}{
+		{"applied", `{"blocking_mode": "null", "query_timeout": "2s"}`, commandLine{}, false, nil,
+			func(c *Config) bool { return c.BlockingMode == "null" && c.QueryTimeout == "2s" }},
+		{"restart-only settings kept", `{"blocking_mode": "null", "dns_bind": "0.0.0.0:5353", "blocklist_dir": "/srv/lists"}`, commandLine{}, false,
+			[]string{"dns_bind", "blocklist_dir"},
+			func(c *Config) bool {
+				return c.BlockingMode == "null" && c.DNSBind == defaultConfig().DNSBind && c.BlocklistDir == defaultConfig().BlocklistDir
+			}},
+		{"directory flag still applies", `{"blocking_mode": "null"}`, commandLine{BlocklistDir: "/srv/flag"}, false,
+			[]string{"blocklist_dir"},
+			func(c *Config) bool { return c.BlocklistDir == defaultConfig().BlocklistDir }},
+		{"log level", `{"blocking_mode": "nx", "log_level": "error"}`, commandLine{}, false, nil,
+			func(c *Config) bool {
+				return c.LogLevel == "error" && !slog.Default().Enabled(context.Background(), slog.LevelWarn)
+			}},
+		{"invalid", `{"blocking_mode": "bogus"}`, commandLine{}, true, nil,
+			func(c *Config) bool { return c.BlockingMode == "nx" }},
+		{"unknown key", `{"blocking_mode": "null", "nope": 1}`, commandLine{}, true, nil,
+			func(c *Config) bool { return c.BlockingMode == "nx" }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withConfig(t, func(c *Config) { c.BlockingMode = "nx" })
+			tt.cl.ConfigFile = filepath.Join(t.TempDir(), "config.json")
+			if err := os.WriteFile(tt.cl.ConfigFile, []byte(tt.file), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			kept, err := reloadConfig(tt.cl)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("reloadConfig = %v, want error %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(kept, tt.wantKept) {
+				t.Errorf("kept %q, want %q", kept, tt.wantKept)
+			}
+			if c := AppConfig(); !tt.check(c) {
+				t.Errorf("running config after reload: %+v", c)
+			}
+		})
+	}
+}
+
+func TestReloadChangesBlockingMode(t *testing.T) {
+	prevLog := slog.Default()
+	t.Cleanup(func() { slog.SetDefault(prevLog) })
+	withConfig(t, func(c *Config) { c.BlockingMode = "nx" })
+	h := newTestDNSHandler(t, map[string][]string{"ads": {"ads.example"}})
+	path := filepath.Join(t.TempDir(), "config.json")
+
+	tests := []struct {
+		mode   string
+		rcode  int
+		answer []string
+	}{
+		{"nx", dns.RcodeNameError, nil},
+		{"null", dns.RcodeSuccess, []string{"0.0.0.0"}},
+		{"nx", dns.RcodeNameError, nil},
+	}
+	for _, tt := range tests {
+		if err := os.WriteFile(path, []byte(`{"blocking_mode": "`+tt.mode+`"}`), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := reloadConfig(commandLine{ConfigFile: path}); err != nil {
+			t.Fatal(err)
+		}
+		w := serveQuery(h, "192.168.1.10", "ads.example", dns.TypeA)
+		var answer []string
+		for _, rr := range w.msg.Answer {
+			answer = append(answer, rr.(*dns.A).A.String())
+		}
+		if w.msg.Rcode != tt.rcode || !reflect.DeepEqual(answer, tt.answer) {
+			t.Errorf("after reloading to %s: rcode %s, answer %q; want %s, %q",
+				tt.mode, dns.RcodeToString[w.msg.Rcode], answer, dns.RcodeToString[tt.rcode], tt.answer)
+		}
+	}
+}
+
+func TestReloadChangesQueryLogRate(t *testing.T) {
+	prevLog := slog.Default()
+	t.Cleanup(func() { slog.SetDefault(prevLog) })
+	withConfig(t, func(c *Config) { c.BlockingMode, c.QueryLogRate = "nx", 1 })
+	h := newTestDNSHandler(t, map[string][]string{"ads": {"*.ads.example"}})
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(`{"blocking_mode": "nx", "query_log_rate": 3}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reloadConfig(commandLine{ConfigFile: path}); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	slog.SetDefault(slog.New(slog.NewTextHandler(&buf, nil)))
+	for _, name := range []string{"a", "b", "c", "d"} {
+		serveQuery(h, "192.168.1.10", name+".ads.example", dns.TypeA)
+	}
+	// the handler was built under the old rate of 1; a window may end mid-loop
+	if n := strings.Count(buf.String(), "msg=blocked"); n < 3 {
+		t.Errorf("%d blocked queries logged, want the reloaded rate of 3:\n%s", n, &buf)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+// Environment variables that override the configured directories and name
+// the config file
+const (
+	envBlocklistDir = "PIBLOCK_BLOCKLIST_DIR"
+	envDataDir      = "PIBLOCK_DATA_DIR"
+	envConfigFile   = "PIBLOCK_CONFIG"
+)
+
+// commandLine holds the command-line flags
+type commandLine struct {
+	// ConfigFile is the JSON config to start from and re-read on SIGHUP; empty
+	// runs on the built-in defaults
+	ConfigFile string
+	// BlocklistDir and DataDir override the configured directories when set
+	BlocklistDir string
+	DataDir      string
+	// SelfTest checks the setup, prints a report and exits instead of serving
+	SelfTest bool
+}
+
+// parseCommandLine parses the command-line flags. ConfigFile defaults to
+// PIBLOCK_CONFIG.
+func parseCommandLine(args []string) (commandLine, error) {
+	cl := commandLine{ConfigFile: os.Getenv(envConfigFile)}
+	fs := flag.NewFlagSet("piblock", flag.ContinueOnError)
+	fs.StringVar(&cl.ConfigFile, "config", cl.ConfigFile, "JSON config file, re-read on SIGHUP (env "+envConfigFile+")")
+	fs.StringVar(&cl.BlocklistDir, "blocklist-dir", "", "directory holding blocklist files (env "+envBlocklistDir+")")
+	fs.StringVar(&cl.DataDir, "data-dir", "", "directory holding the accounts database (env "+envDataDir+")")
+	fs.BoolVar(&cl.SelfTest, "selftest", false, "check the DNS port, upstreams and directories, print a report and exit (non-zero on failure)")
+	return cl, fs.Parse(args)
+}
+
+// applyDirOverrides sets BlocklistDir and DataDir from the environment and then
+// from the command line, so a flag beats the environment which beats the config
+func applyDirOverrides(c *Config, cl commandLine) {
+	if v := os.Getenv(envBlocklistDir); v != "" {
+		c.BlocklistDir = v
+	}
+	if v := os.Getenv(envDataDir); v != "" {
+		c.DataDir = v
+	}
+	if cl.BlocklistDir != "" {
+		c.BlocklistDir = cl.BlocklistDir
+	}
+	if cl.DataDir != "" {
+		c.DataDir = cl.DataDir
+	}
+}
+
+// ensureWritableDir creates dir if needed and checks files can be created in
+// it, so a bad path or ownership fails at startup rather than on the first save
+func ensureWritableDir(field, dir string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("%s %q can't be created: %w", field, dir, err)
+	}
+	f, err := os.CreateTemp(dir, ".piblock-write-check-*")
+	if err != nil {
+		return fmt.Errorf("%s %q is not writable: %w", field, dir, err)
+	}
+	name := f.Name()
+	f.Close()
+	return os.Remove(name)
+}
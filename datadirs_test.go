@@ -0,0 +1,124 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestApplyDirOverrides(t *testing.T) {
+	tests := []struct {
+		name      string
+		env, flag string
+		wantDir   string
+	}{
+		{"config value", "", "", "/srv/config-lists"},
+		{"environment beats config", "/srv/env-lists", "", "/srv/env-lists"},
+		{"flag beats environment", "/srv/env-lists", "/srv/flag-lists", "/srv/flag-lists"},
+		{"flag alone", "", "/srv/flag-lists", "/srv/flag-lists"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(envBlocklistDir, tt.env)
+			t.Setenv(envDataDir, tt.env)
+			c := defaultConfig()
+			c.BlocklistDir, c.DataDir = "/srv/config-lists", "/srv/config-lists"
+			applyDirOverrides(c, commandLine{BlocklistDir: tt.flag, DataDir: tt.flag})
+			if c.BlocklistDir != tt.wantDir || c.DataDir != tt.wantDir {
+				t.Errorf("blocklist_dir %q, data_dir %q; want %q", c.BlocklistDir, c.DataDir, tt.wantDir)
+			}
+		})
+	}
+}
+
+func TestParseCommandLine(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     string
+		args    []string
+		want    commandLine
+		wantErr bool
+	}{
+		{"defaults", "", nil, commandLine{}, false},
+		{"config from environment", "/etc/piblock.json", nil, commandLine{ConfigFile: "/etc/piblock.json"}, false},
+		{"flags", "/etc/piblock.json", []string{"-config", "/tmp/c.json", "-blocklist-dir", "/var/lib/piblock/lists", "-data-dir", "/var/lib/piblock", "-selftest"},
+			commandLine{ConfigFile: "/tmp/c.json", BlocklistDir: "/var/lib/piblock/lists", DataDir: "/var/lib/piblock", SelfTest: true}, false},
+		{"unknown flag", "", []string{"-nope"}, commandLine{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(envConfigFile, tt.env)
+			got, err := parseCommandLine(tt.args)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseCommandLine(%q) error = %v, want error %v", tt.args, err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("parseCommandLine(%q) = %+v, want %+v", tt.args, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEnsureWritableDir(t *testing.T) {
+	base := t.TempDir()
+	file := filepath.Join(base, "a-file")
+	if err := os.WriteFile(file, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		dir  string
+		ok   bool
+	}{
+		{"existing", base, true},
+		{"created", filepath.Join(base, "var", "lib", "piblock"), true},
+		{"path is a file", file, false},
+		{"under a file", filepath.Join(file, "lists"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ensureWritableDir("blocklist_dir", tt.dir)
+			if (err == nil) != tt.ok {
+				t.Fatalf("ensureWritableDir(%q) = %v, want ok %v", tt.dir, err, tt.ok)
+			}
+			if !tt.ok {
+				return
+			}
+			// the write check cleans up after itself
+			if left, _ := filepath.Glob(filepath.Join(tt.dir, ".piblock-write-check-*")); len(left) > 0 {
+				t.Errorf("write check left %v behind", left)
+			}
+		})
+	}
+}
+
+func TestManagersAtCustomPaths(t *testing.T) {
+	base := t.TempDir()
+	listDir := filepath.Join(base, "lists", "nested")
+	dataDir := filepath.Join(base, "state")
+	if err := os.MkdirAll(listDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(listDir, "ads.txt"), []byte("ads.example\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	bm, err := NewBlocklistManager(listDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bm.IsBlocked("ads.example") {
+		t.Error("lists in the custom directory weren't loaded")
+	}
+	if want := filepath.Join(listDir, "logs.jsonl"); bm.logPath != want {
+		t.Errorf("query log at %q, want %q", bm.logPath, want)
+	}
+	am, err := NewAccountManager(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer am.Close()
+	if _, err := os.Stat(filepath.Join(dataDir, "accounts.db")); err != nil {
+		t.Errorf("accounts database not in the data directory: %v", err)
+	}
+}
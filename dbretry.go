@@ -0,0 +1,85 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// busyRetries and busyBackoff bound how long a busy/locked statement is retried
+const (
+	busyRetries = 5
+	busyBackoff = 20 * time.Millisecond
+)
+
+// isBusyError reports whether err is a transient SQLITE_BUSY or SQLITE_LOCKED error
+func isBusyError(err error) bool {
+	var se *sqlite.Error
+	if !errors.As(err, &se) {
+		return false
+	}
+	switch se.Code() & 0xff {
+	case sqlite3.SQLITE_BUSY, sqlite3.SQLITE_LOCKED:
+		return true
+	}
+	return false
+}
+
+// retryBusy runs op, retrying with exponential backoff while it fails with a
+// busy/locked error
+func retryBusy(op func() error) error {
+	delay := busyBackoff
+	var err error
+	for attempt := 0; attempt <= busyRetries; attempt++ {
+		if err = op(); err == nil || !isBusyError(err) {
+			return err
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+	return err
+}
+
+// exec runs db.Exec, retrying transient busy/locked errors
+func (am *AccountManager) exec(query string, args ...interface{}) (sql.Result, error) {
+	var res sql.Result
+	err := retryBusy(func() error {
+		var err error
+		res, err = am.db.Exec(query, args...)
+		return err
+	})
+	return res, err
+}
+
+// query runs db.Query, retrying transient busy/locked errors
+func (am *AccountManager) query(query string, args ...interface{}) (*sql.Rows, error) {
+	var rows *sql.Rows
+	err := retryBusy(func() error {
+		var err error
+		rows, err = am.db.Query(query, args...)
+		return err
+	})
+	return rows, err
+}
+
+// busyRow defers a QueryRow until Scan so busy/locked errors can be retried
+type busyRow struct {
+	am    *AccountManager
+	query string
+	args  []interface{}
+}
+
+// queryRow is like db.QueryRow but retries transient busy/locked errors on Scan
+func (am *AccountManager) queryRow(query string, args ...interface{}) *busyRow {
+	return &busyRow{am: am, query: query, args: args}
+}
+
+// Scan runs the query and scans the first row into dest
+func (r *busyRow) Scan(dest ...interface{}) error {
+	return retryBusy(func() error {
+		return r.am.db.QueryRow(r.query, r.args...).Scan(dest...)
+	})
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+// busyErrorFrom returns the error SQLite gives a second connection that tries
+// to write while the first holds the write lock
+func busyErrorFrom(t *testing.T) error {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "busy.db")
+	holder, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { holder.Close() })
+	if _, err := holder.Exec("CREATE TABLE t (v INTEGER)"); err != nil {
+		t.Fatal(err)
+	}
+	tx, err := holder.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { tx.Rollback() })
+	if _, err := tx.Exec("INSERT INTO t VALUES (1)"); err != nil {
+		t.Fatal(err)
+	}
+
+	other, err := sql.Open("sqlite", path+"?_pragma=busy_timeout(0)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { other.Close() })
+	_, err = other.Exec("INSERT INTO t VALUES (2)")
+	if err == nil {
+		t.Fatal("second writer wasn't blocked")
+	}
+	return err
+}
+
+func TestRetryBusy(t *testing.T) {
+	busy := busyErrorFrom(t)
+	if !isBusyError(busy) {
+		t.Fatalf("isBusyError(%v) = false", busy)
+	}
+	other := errors.New("constraint failed")
+	tests := []struct {
+		name      string
+		failures  []error // returned by the first calls; nil after them
+		wantCalls int
+		wantErr   error
+	}{
+		{"succeeds at once", nil, 1, nil},
+		{"retries while busy", []error{busy, busy}, 3, nil},
+		{"other errors aren't retried", []error{other}, 1, other},
+		{"gives up eventually", []error{busy, busy, busy, busy, busy, busy, busy}, busyRetries + 1, busy},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			err := retryBusy(func() error {
+				calls++
+				if calls <= len(tt.failures) {
+					return tt.failures[calls-1]
+				}
+				return nil
+			})
+			if err != tt.wantErr {
+				t.Errorf("err = %v, want %v", err, tt.wantErr)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("op ran %d times, want %d", calls, tt.wantCalls)
+			}
+		})
+	}
+}
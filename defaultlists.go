@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"log/slog"
+	"strings"
+)
+
+// seedDefaultLists gives a new account its own copy of every list named in
+// AppConfig.DefaultLists so it blocks something from the start. Lists are copied
+// rather than shared so users can edit or delete theirs without affecting anyone
+// else. Missing templates are logged and skipped; the account is still usable.
+func seedDefaultLists(bm *BlocklistManager, am *AccountManager, mac string) {
+	defaults := AppConfig().DefaultLists
+	if bm == nil || len(defaults) == 0 {
+		return
+	}
+
+	seeded := 0
+	for _, tmpl := range defaults {
+		userListName := fmt.Sprintf("%s_%s", mac, tmpl)
+		if !bm.HasList(userListName) {
+			if _, err := bm.MergeLists([]string{tmpl}, userListName); err != nil {
+				slog.Error("failed to seed default list", "list", tmpl, "mac", mac, "err", err)
+				continue
+			}
+		}
+		if err := am.AddUserBlocklist(mac, userListName); err != nil {
+			slog.Error("failed to associate default list with user", "list", userListName, "mac", mac, "err", err)
+			continue
+		}
+		seeded++
+	}
+	if seeded > 0 {
+		log.Printf("seeded %d default lists for %s", seeded, mac)
+		go notifyRustReload()
+	}
+}
+
+// validateDefaultLists checks that default list names are plain list names
+func validateDefaultLists(names []string) error {
+	for _, n := range names {
+		if n == "" || strings.Contains(n, "..") || strings.ContainsAny(n, `/\`) {
+			return fmt.Errorf("invalid default list name %q", n)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"slices"
+	"sort"
+	"testing"
+)
+
+func TestSeedDefaultLists(t *testing.T) {
+	const mac = "aa:bb:cc:00:10:06"
+	tests := []struct {
+		name      string
+		defaults  []string
+		wantLists []string
+		blocked   map[string]bool
+	}{
+		{"none configured", nil, nil,
+			map[string]bool{"ads.example": false, "mal.example": false}},
+		{"one list", []string{"ads"}, []string{mac + "_ads"},
+			map[string]bool{"ads.example": true, "mal.example": false}},
+		{"several lists", []string{"ads", "malware"}, []string{mac + "_ads", mac + "_malware"},
+			map[string]bool{"ads.example": true, "mal.example": true}},
+		{"missing template skipped", []string{"gone", "malware"}, []string{mac + "_malware"},
+			map[string]bool{"ads.example": false, "mal.example": true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withConfig(t, func(c *Config) { c.DefaultLists = tt.defaults })
+			bm := newTestBlocklistManager(t, map[string][]string{
+				"ads":     {"ads.example"},
+				"malware": {"mal.example"},
+			})
+			am := newTestAccountManager(t, mac)
+			seedDefaultLists(bm, am, mac)
+
+			lists, err := am.GetUserBlocklists(mac)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sort.Strings(lists)
+			if !slices.Equal(lists, tt.wantLists) {
+				t.Errorf("user lists = %q, want %q", lists, tt.wantLists)
+			}
+			for domain, want := range tt.blocked {
+				if got := bm.IsBlockedForUser(domain, mac, am); got != want {
+					t.Errorf("IsBlockedForUser(%q) = %v, want %v", domain, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestSeededListsAreCopies(t *testing.T) {
+	const mac = "aa:bb:cc:00:10:07"
+	withConfig(t, func(c *Config) { c.DefaultLists = []string{"ads"} })
+	bm := newTestBlocklistManager(t, map[string][]string{"ads": {"ads.example", "track.example"}})
+	am := newTestAccountManager(t, mac)
+	seedDefaultLists(bm, am, mac)
+
+	if _, err := bm.RemoveDomain(mac+"_ads", "track.example"); err != nil {
+		t.Fatal(err)
+	}
+	if bm.IsBlockedForUser("track.example", mac, am) {
+		t.Error("entry removed from the user's copy still blocks for them")
+	}
+	if total, _, err := bm.ListDomains("ads", 0, 10, ""); err != nil || total != 2 {
+		t.Errorf("template has %d entries (%v) after the user edited their copy, want 2", total, err)
+	}
+}
+
+func TestValidateDefaultLists(t *testing.T) {
+	tests := []struct {
+		names []string
+		ok    bool
+	}{
+		{nil, true},
+		{[]string{"ads", "malware"}, true},
+		{[]string{""}, false},
+		{[]string{"../ads"}, false},
+		{[]string{"a/b"}, false},
+		{[]string{`a\b`}, false},
+	}
+	for _, tt := range tests {
+		if err := validateDefaultLists(tt.names); (err == nil) != tt.ok {
+			t.Errorf("validateDefaultLists(%q) = %v, want ok %v", tt.names, err, tt.ok)
+		}
+	}
+}
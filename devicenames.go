@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+)
+
+// maxDeviceLabelLen bounds a device label
+const maxDeviceLabelLen = 64
+
+// ErrInvalidDevice is returned for a device identity that is neither a MAC nor an ip: fallback
+var ErrInvalidDevice = errors.New("invalid device: want a MAC address or ip:<address>")
+
+// ErrDeviceLabelTooLong is returned for a label over maxDeviceLabelLen
+var ErrDeviceLabelTooLong = errors.New("label too long")
+
+// normalizeDeviceID canonicalizes a device identity the same way accounts are
+// keyed: a lowercase colon-separated MAC, or the ip: fallback GetClientMAC uses
+func normalizeDeviceID(id string) (string, error) {
+	id = strings.TrimSpace(id)
+	if ip, ok := strings.CutPrefix(id, "ip:"); ok {
+		if parsed := net.ParseIP(ip); parsed != nil {
+			return "ip:" + parsed.String(), nil
+		}
+		return "", ErrInvalidDevice
+	}
+	mac, err := parseMACAddress(id)
+	if err != nil {
+		return "", ErrInvalidDevice
+	}
+	return mac, nil
+}
+
+// SetDeviceName labels a device; an empty label removes it
+func (am *AccountManager) SetDeviceName(device, label string) error {
+	device, err := normalizeDeviceID(device)
+	if err != nil {
+		return err
+	}
+	label = strings.TrimSpace(label)
+	if len(label) > maxDeviceLabelLen {
+		return fmt.Errorf("%w (max %d characters)", ErrDeviceLabelTooLong, maxDeviceLabelLen)
+	}
+	if label == "" {
+		_, err = am.exec("DELETE FROM device_names WHERE mac_address = ?", device)
+	} else {
+		_, err = am.exec(
+			`INSERT INTO device_names (mac_address, label, updated_at) VALUES (?, ?, CURRENT_TIMESTAMP)
+			ON CONFLICT(mac_address) DO UPDATE SET label = excluded.label, updated_at = CURRENT_TIMESTAMP`,
+			device, label,
+		)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to set device name: %w", err)
+	}
+	log.Printf("Set device name for %s to %q", device, label)
+	return nil
+}
+
+// DeviceNames returns every stored device label keyed by MAC (or ip: fallback)
+func (am *AccountManager) DeviceNames() (map[string]string, error) {
+	rows, err := am.query("SELECT mac_address, label FROM device_names")
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	defer rows.Close()
+
+	names := make(map[string]string)
+	for rows.Next() {
+		var mac, label string
+		if err := rows.Scan(&mac, &label); err != nil {
+			return nil, err
+		}
+		names[mac] = label
+	}
+	return names, rows.Err()
+}
+
+// deviceLabel returns the friendly name for a DNS client address: the stored
+// label for its device, else a known hostname (configured or from a lease), else "".
+func deviceLabel(names map[string]string, client string) string {
+	ip := GetClientIP(client)
+	if net.ParseIP(ip) == nil {
+		return ""
+	}
+	if owner := clientOwner(client); owner != "" {
+		if label, ok := names[owner]; ok {
+			return label
+		}
+	}
+	if name, ok := clientHostNames.ExplicitHostName(AppConfig(), net.ParseIP(ip)); ok {
+		return name
+	}
+	return ""
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeDeviceID(t *testing.T) {
+	tests := []struct {
+		in, want string
+		err      error
+	}{
+		{"AA:BB:CC:DD:EE:FF", "aa:bb:cc:dd:ee:ff", nil},
+		{"aa-bb-cc-dd-ee-ff", "aa:bb:cc:dd:ee:ff", nil},
+		{"aabbccddeeff", "aa:bb:cc:dd:ee:ff", nil},
+		{" ip:192.168.1.7 ", "ip:192.168.1.7", nil},
+		{"ip:fd00:0::1", "ip:fd00::1", nil},
+		{"ip:not-an-ip", "", ErrInvalidDevice},
+		{"kitchen-ipad", "", ErrInvalidDevice},
+		{"", "", ErrInvalidDevice},
+	}
+	for _, tt := range tests {
+		got, err := normalizeDeviceID(tt.in)
+		if got != tt.want || !errors.Is(err, tt.err) {
+			t.Errorf("normalizeDeviceID(%q) = %q, %v; want %q, %v", tt.in, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestSetDeviceName(t *testing.T) {
+	am := newTestAccountManager(t)
+	// each step runs against the state the previous one left
+	steps := []struct {
+		device, label string
+		err           error
+		want          map[string]string
+	}{
+		{"AA:BB:CC:00:11:14", " Kitchen iPad ", nil, map[string]string{"aa:bb:cc:00:11:14": "Kitchen iPad"}},
+		{"aa:bb:cc:00:11:14", "Hall iPad", nil, map[string]string{"aa:bb:cc:00:11:14": "Hall iPad"}},
+		{"ip:192.168.1.7", "Printer", nil, map[string]string{"aa:bb:cc:00:11:14": "Hall iPad", "ip:192.168.1.7": "Printer"}},
+		{"aa:bb:cc:00:11:14", strings.Repeat("x", maxDeviceLabelLen+1), ErrDeviceLabelTooLong, map[string]string{"aa:bb:cc:00:11:14": "Hall iPad", "ip:192.168.1.7": "Printer"}},
+		{"nonsense", "Label", ErrInvalidDevice, map[string]string{"aa:bb:cc:00:11:14": "Hall iPad", "ip:192.168.1.7": "Printer"}},
+		{"aa:bb:cc:00:11:14", "  ", nil, map[string]string{"ip:192.168.1.7": "Printer"}},
+	}
+	for i, st := range steps {
+		if err := am.SetDeviceName(st.device, st.label); !errors.Is(err, st.err) {
+			t.Fatalf("step %d: SetDeviceName(%q, %q) = %v, want %v", i, st.device, st.label, err, st.err)
+		}
+		names, err := am.DeviceNames()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(names) != len(st.want) {
+			t.Fatalf("step %d: names = %v, want %v", i, names, st.want)
+		}
+		for device, label := range st.want {
+			if names[device] != label {
+				t.Errorf("step %d: names = %v, want %v", i, names, st.want)
+			}
+		}
+	}
+}
+
+func TestLogsIncludeDeviceLabels(t *testing.T) {
+	const (
+		mac, ip   = "aa:bb:cc:00:11:15", "192.168.70.15"
+		fallback  = "192.168.70.16" // no known MAC, so labeled by ip:
+		unlabeled = "192.168.70.17"
+	)
+	ipMACCache.SetIPMAC(ip, mac)
+	am := newTestAccountManager(t)
+	if err := am.SetDeviceName(mac, "Kitchen iPad"); err != nil {
+		t.Fatal(err)
+	}
+	if err := am.SetDeviceName("ip:"+fallback, "Printer"); err != nil {
+		t.Fatal(err)
+	}
+	bm := newTestBlocklistManager(t, nil)
+	bm.RecordQueryOfType("a.example", ip+":40000", "A", false)
+	bm.RecordQueryOfType("b.example", fallback+":40000", "A", false)
+	bm.RecordQueryOfType("c.example", unlabeled+":40000", "A", false)
+
+	tests := []struct {
+		query string
+		want  map[string]string // domain to label
+	}{
+		{"?labels=true", map[string]string{"a.example": "Kitchen iPad", "b.example": "Printer", "c.example": ""}},
+		{"", map[string]string{"a.example": "", "b.example": "", "c.example": ""}},
+	}
+	for _, tt := range tests {
+		r := asUser(httptest.NewRequest(http.MethodGet, "/logs"+tt.query, nil), "aa:00:00:00:11:01", true, false)
+		w := httptest.NewRecorder()
+		handleLogs(w, r, bm, am)
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET /logs%s: status %d: %s", tt.query, w.Code, w.Body)
+		}
+		var logs []QueryEntry
+		if err := json.Unmarshal(w.Body.Bytes(), &logs); err != nil {
+			t.Fatal(err)
+		}
+		if len(logs) != len(tt.want) {
+			t.Fatalf("GET /logs%s: %d entries, want %d", tt.query, len(logs), len(tt.want))
+		}
+		for _, e := range logs {
+			if e.Label != tt.want[e.Domain] {
+				t.Errorf("GET /logs%s: %s labeled %q, want %q", tt.query, e.Domain, e.Label, tt.want[e.Domain])
+			}
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net"
+
+	"github.com/miekg/dns"
+)
+
+// ednsUDPSize is the payload size advertised in the OPT record of our replies
+// (the DNS flag day 2020 recommendation)
+const ednsUDPSize = 1232
+
+// dnssecOK reports whether the client set the EDNS DO bit
+func dnssecOK(r *dns.Msg) bool {
+	opt := r.IsEdns0()
+	return opt != nil && opt.Do()
+}
+
+// applyDNSSECFlags finishes a reply to req for DNSSEC-aware clients. An EDNS
+// request gets an OPT record back with its DO bit echoed. AD is set only when
+// every question was answered by an upstream that validated it, and only for
+// clients that signalled they understand AD by setting DO or AD (RFC 6840 5.7).
+// CD is already copied from the request by SetReply.
+func applyDNSSECFlags(reply, req *dns.Msg, validated bool) {
+	if opt := req.IsEdns0(); opt != nil {
+		reply.SetEdns0(ednsUDPSize, opt.Do())
+	}
+	reply.AuthenticatedData = validated && (req.AuthenticatedData || dnssecOK(req))
+}
+
+// udpReplySize is the largest UDP reply req's sender takes: the buffer size
+// in its OPT record, capped at ours, or 512 bytes without EDNS
+func udpReplySize(req *dns.Msg) int {
+	size := dns.MinMsgSize
+	if opt := req.IsEdns0(); opt != nil && int(opt.UDPSize()) > size {
+		size = min(int(opt.UDPSize()), ednsUDPSize)
+	}
+	return size
+}
+
+// writeReply sends msg to the client. Over UDP it is first cut down to
+// udpReplySize with TC set, so the client retries over TCP rather than
+// losing an oversized datagram.
+func writeReply(w dns.ResponseWriter, req, msg *dns.Msg) error {
+	if _, tcp := w.RemoteAddr().(*net.TCPAddr); !tcp {
+		msg.Truncate(udpReplySize(req))
+	}
+	return w.WriteMsg(msg)
+}
@@ -1,20 +1,74 @@
 package main
 
 import (
-    "fmt"
+    "context"
     "github.com/miekg/dns"
-    "log"
-    "net"
+    "log/slog"
+    "strings"
     "time"
 )
 
 // StartDNSServer launches a UDP DNS server at addr (e.g. ":53") using the provided BlocklistManager.
 func StartDNSServer(addr string, bm *BlocklistManager, am *AccountManager) error {
-    dns.HandleFunc(".", func(w dns.ResponseWriter, r *dns.Msg) {
+    handler, err := newDNSHandler(bm, am)
+    if err != nil {
+        return err
+    }
+    dns.HandleFunc(".", handler)
+
+    server := &dns.Server{Addr: addr, Net: "udp"}
+    return server.ListenAndServe()
+}
+
+// newDNSHandler returns the handler StartDNSServer serves: it enforces the
+// client ACL, then answers, blocks or forwards each question.
+func newDNSHandler(bm *BlocklistManager, am *AccountManager) (dns.HandlerFunc, error) {
+    setup := AppConfig()
+    acl, err := newClientACL(setup.AllowedClients)
+    if err != nil {
+        return nil, err
+    }
+    refusedLog := &logThrottle{interval: 10 * time.Second}
+    queryLimit := setup.ConcurrentQueryLimit()
+    upstreamSlots := newQueryLimiter(queryLimit)
+    saturatedLog := &logThrottle{interval: 10 * time.Second}
+    // read live, so a reloaded query_log_rate applies
+    blockedLog := newQueryLogSampler(func() int { return AppConfig().QueryLogLimit() })
+
+    return func(w dns.ResponseWriter, r *dns.Msg) {
+        // one config for the whole query, even if a reload lands halfway through
+        cfg := AppConfig()
         msg := dns.Msg{}
         msg.SetReply(r)
         msg.Authoritative = true
 
+        // refuse clients outside the allowed ranges so we don't act as an open resolver
+        remote := ""
+        if ra := w.RemoteAddr(); ra != nil {
+            remote = GetClientIP(ra.String())
+        }
+        if !acl.Allows(remote) {
+            drop := cfg.RefusedResponse == refusedDrop
+            if ok, suppressed := refusedLog.Allow(); ok {
+                slog.Warn("refused query from disallowed client", "client", remote, "dropped", drop, "suppressed", suppressed)
+            }
+            if drop {
+                // no reply at all, so a spoofed source gets nothing reflected at it
+                return
+            }
+            msg.Authoritative = false
+            msg.Rcode = dns.RcodeRefused
+            _ = w.WriteMsg(&msg)
+            return
+        }
+
+        // bound the whole query, upstream lookups included, so a slow upstream
+        // can't hold this goroutine past the point the client retries
+        ctx, cancel := context.WithTimeout(context.Background(), cfg.QueryDeadline())
+        defer cancel()
+
+        // questions answered by an upstream that validated them (AD set)
+        validated := 0
         for _, q := range r.Question {
             qname := q.Name
             // log client address and query name
@@ -22,79 +76,192 @@ func StartDNSServer(addr string, bm *BlocklistManager, am *AccountManager) error
             if ra := w.RemoteAddr(); ra != nil {
                 clientAddr = ra.String()
             }
-            fmt.Printf("Received query for %s from %s\n", qname, clientAddr)
-            // normalize
+            slog.Debug("received query", "domain", qname, "client", clientAddr)
+            // normalize; internationalized names are matched in their punycode form
             name := qname
             if len(name) > 0 && name[len(name)-1] == '.' {
                 name = name[:len(name)-1]
             }
+            name = canonicalDomain(strings.ToLower(decodeDNSName(name)))
+            // the wire format bounds names already, but decoding can lengthen
+            // them; keep anything over the DNS limits away from the matcher
+            if err := checkDomainLimits(name); err != nil {
+                slog.Debug("rejecting oversized query name", "client", clientAddr, "err", err)
+                msg.Rcode = dns.RcodeFormatError
+                continue
+            }
+
+            // answer reverse lookups for clients we know locally
+            if ptr, ok := answerPTR(cfg, q); ok {
+                msg.Answer = append(msg.Answer, ptr)
+                continue
+            }
+
+            // answer ANY locally so we can't be used for amplification
+            if answerANY(cfg, q, &msg) {
+                bm.RecordQueryOfType(name, clientAddr, dns.TypeToString[q.Qtype], false)
+                continue
+            }
+
+            // answer names configured as local records without filtering or forwarding
+            if answers, tail, ok := am.answerLocalRecord(q, name); ok {
+                if tail != "" {
+                    resolved, err := resolveLocalCNAMETarget(ctx, cfg, tail, q.Qtype)
+                    if ctx.Err() != nil {
+                        writeDeadlineExceeded(w, &msg, name, clientAddr, cfg.QueryDeadline())
+                        return
+                    }
+                    if err != nil {
+                        slog.Debug("local CNAME target lookup failed", "domain", name, "target", tail, "err", err)
+                    }
+                    answers = append(answers, resolved...)
+                }
+                msg.Answer = append(msg.Answer, answers...)
+                bm.RecordQueryOfType(name, clientAddr, dns.TypeToString[q.Qtype], false)
+                continue
+            }
 
             // Get client IP and try to determine MAC address
             clientIP := GetClientIP(clientAddr)
             macAddress, _ := ipMACCache.GetMAC(clientIP)
 
+            // answer names mapped by the client's hosts lists ahead of filtering
+            if answers, ok := bm.answerHostsRecord(cfg, q, name, macAddress, am); ok {
+                msg.Answer = append(msg.Answer, answers...)
+                bm.RecordQueryOfType(name, clientAddr, dns.TypeToString[q.Qtype], false)
+                continue
+            }
+
             // Check if blocked for this specific user
+            var detail MatchDetail
             blocked := false
+            matchStart := time.Now()
             if macAddress != "" && am != nil {
-                blocked = bm.IsBlockedForUser(name, macAddress, am)
+                detail, blocked = bm.MatchForUser(name, q.Qtype, macAddress, am)
             } else {
-                // If we can't identify the user, use global blocklist check
-                blocked = bm.IsBlocked(name)
+                // If we can't identify the user, apply the configured fallback
+                detail, blocked = bm.MatchUnidentified(cfg, name, q.Qtype)
             }
+            matchTiming.Observe(time.Since(matchStart))
 
             if blocked {
-                // Depending on blocking mode, reply differently
-                switch AppConfig.BlockingMode {
-                case "redirect":
-                    // return A record pointing to the block page IP so browsers hit the block page server
-                    target := AppConfig.BlockPageIP
-                    if target == "" {
-                        target = "127.0.0.1"
-                    }
-                    if q.Qtype == dns.TypeA || q.Qtype == dns.TypeANY {
-                        a := new(dns.A)
-                        a.Hdr = dns.RR_Header{Name: q.Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60}
-                        a.A = net.ParseIP(target)
-                        msg.Answer = append(msg.Answer, a)
-                    }
-                case "nx":
-                    // NXDOMAIN
-                    msg.Rcode = dns.RcodeNameError
-                default:
-                    // null route (0.0.0.0)
-                    if q.Qtype == dns.TypeA || q.Qtype == dns.TypeANY {
-                        a := new(dns.A)
-                        a.Hdr = dns.RR_Header{Name: q.Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 0}
-                        a.A = net.ParseIP("0.0.0.0")
-                        msg.Answer = append(msg.Answer, a)
-                    }
-                }
+                // Depending on blocking mode (the matching list's, else the global one), reply differently
+                mode, blockPageIP := bm.BlockingFor(cfg, detail.List)
+                mode = blockedAnswer(cfg, &msg, q, mode, blockPageIP)
                 // record analytics and write reply and stop processing
-                bm.RecordQueryWithClient(name, clientAddr, true)
-                log.Printf("blocked %s for client %s (MAC: %s, mode=%s)", name, clientAddr, macAddress, AppConfig.BlockingMode)
+                bm.RecordBlockedQuery(name, clientAddr, dns.TypeToString[q.Qtype], detail, mode)
+                bm.RecordListHit(detail.List, name)
+                if ok, suppressed := blockedLog.Allow(name + " " + clientAddr); ok {
+                    slog.Info("blocked", "domain", name, "client", clientAddr, "mac", macAddress, "mode", mode, "list", detail.List, "suppressed", suppressed)
+                } else {
+                    slog.Debug("blocked", "domain", name, "client", clientAddr, "mac", macAddress, "mode", mode, "list", detail.List)
+                }
                 _ = w.WriteMsg(&msg)
                 return
             }
 
-            // forward the query upstream (configured or Cloudflare by default)
-            upstream := AppConfig.Upstream
-            if upstream == "" {
-                upstream = "1.1.1.1:53"
+            // everything below goes upstream; fail fast rather than pile up under a flood
+            if !upstreamSlots.TryAcquire() {
+                if ok, suppressed := saturatedLog.Allow(); ok {
+                    slog.Warn("too many queries in flight; answering SERVFAIL", "limit", queryLimit, "suppressed", suppressed)
+                }
+                upstreamSaturated.Add(1)
+                msg.Rcode = dns.RcodeServerFailure
+                bm.RecordQueryOfType(name, clientAddr, dns.TypeToString[q.Qtype], false)
+                continue
+            }
+
+            // forward the query upstream (conditional forwarder, configured or Cloudflare by default)
+            upstream := upstreamFor(cfg, name)
+
+            // rewrite search engines to their safe-search endpoints when enforced
+            if target, ok := safeSearchTarget(cfg, name); ok && (q.Qtype == dns.TypeA || q.Qtype == dns.TypeAAAA) && safeSearchEnabledFor(cfg, macAddress, am) {
+                answers, err := resolveSafeSearch(ctx, cfg, q, target, upstream)
+                if ctx.Err() != nil {
+                    upstreamSlots.Release()
+                    writeDeadlineExceeded(w, &msg, name, clientAddr, cfg.QueryDeadline())
+                    return
+                }
+                if err != nil {
+                    // fail closed: returning the real answer would bypass enforcement
+                    slog.Error("safe search rewrite failed", "domain", name, "err", err)
+                    msg.Rcode = dns.RcodeServerFailure
+                } else {
+                    msg.Answer = append(msg.Answer, answers...)
+                }
+                upstreamSlots.Release()
+                bm.RecordQueryOfType(name, clientAddr, dns.TypeToString[q.Qtype], false)
+                slog.Debug("safe search", "domain", name, "target", target, "client", clientAddr, "mac", macAddress)
+                continue
+            }
+
+            upstreamStart := time.Now()
+            resp, err := coalescedForward(ctx, cfg, r, upstream, cfg.QueryDeadline())
+            upstreamSlots.Release()
+            upstreamTiming.Observe(time.Since(upstreamStart))
+            if ctx.Err() != nil {
+                upstreamErrors.Add(1)
+                writeDeadlineExceeded(w, &msg, name, clientAddr, cfg.QueryDeadline())
+                return
             }
-            c := new(dns.Client)
-            c.ReadTimeout = 5 * time.Second
-            resp, _, err := c.Exchange(r, upstream)
             if err == nil && resp != nil {
+                if len(r.Question) == 1 {
+                    // keep NXDOMAIN and friends: the proofs below only validate with the upstream's rcode
+                    msg.Rcode = resp.Rcode
+                }
                 msg.Answer = append(msg.Answer, resp.Answer...)
+                if dnssecOK(r) {
+                    // pass denial-of-existence proofs through to validating clients
+                    msg.Ns = append(msg.Ns, resp.Ns...)
+                }
+                if resp.AuthenticatedData {
+                    validated++
+                }
+            } else if err != nil {
+                upstreamErrors.Add(1)
+                slog.Debug("upstream query failed", "domain", name, "upstream", upstream, "err", err)
             }
             // record allowed query
-            bm.RecordQueryWithClient(name, clientAddr, false)
-            log.Printf("allowed %s for client %s (MAC: %s)", name, clientAddr, macAddress)
+            bm.RecordQueryOfType(name, clientAddr, dns.TypeToString[q.Qtype], false)
+            slog.Debug("allowed", "domain", name, "client", clientAddr, "mac", macAddress)
         }
 
-        _ = w.WriteMsg(&msg)
-    })
+        applyDNSSECFlags(&msg, r, len(r.Question) > 0 && validated == len(r.Question))
+        _ = writeReply(w, r, &msg)
+    }, nil
+}
 
-    server := &dns.Server{Addr: addr, Net: "udp"}
-    return server.ListenAndServe()
+// writeDeadlineExceeded answers SERVFAIL, dropping anything gathered for msg so
+// far, once a query has run past its timeout
+func writeDeadlineExceeded(w dns.ResponseWriter, msg *dns.Msg, name, clientAddr string, timeout time.Duration) {
+    slog.Debug("query deadline exceeded; answering SERVFAIL", "domain", name, "client", clientAddr, "timeout", timeout)
+    msg.Answer, msg.Ns = nil, nil
+    msg.Rcode = dns.RcodeServerFailure
+    _ = w.WriteMsg(msg)
+}
+
+// upstreamFor returns the resolver to forward name to. A conditional forwarder
+// whose suffix matches name wins (longest suffix first); otherwise the default
+// upstream is used.
+func upstreamFor(cfg *Config, name string) string {
+    d := strings.TrimSuffix(strings.ToLower(name), ".")
+    best := ""
+    bestLen := -1
+    for suffix, upstream := range cfg.ConditionalForwarders {
+        sfx := strings.Trim(strings.ToLower(suffix), ".")
+        if sfx == "" || upstream == "" {
+            continue
+        }
+        if d != sfx && !strings.HasSuffix(d, "."+sfx) {
+            continue
+        }
+        if len(sfx) > bestLen {
+            best = upstream
+            bestLen = len(sfx)
+        }
+    }
+    if best != "" {
+        return best
+    }
+    return defaultUpstream(cfg)
 }
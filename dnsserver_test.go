@@ -0,0 +1,411 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// fakeResponseWriter records the reply the DNS handler writes for one query
+type fakeResponseWriter struct {
+	remote net.Addr
+	msg    *dns.Msg
+}
+
+func (w *fakeResponseWriter) LocalAddr() net.Addr {
+	return &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 53}
+}
+func (w *fakeResponseWriter) RemoteAddr() net.Addr        { return w.remote }
+func (w *fakeResponseWriter) WriteMsg(m *dns.Msg) error   { w.msg = m; return nil }
+func (w *fakeResponseWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (w *fakeResponseWriter) Close() error                { return nil }
+func (w *fakeResponseWriter) TsigStatus() error           { return nil }
+func (w *fakeResponseWriter) TsigTimersOnly(bool)         {}
+func (w *fakeResponseWriter) Hijack()                     {}
+
+// newTestDNSHandler builds the DNS handler over in-memory lists and a
+// throwaway account database
+func newTestDNSHandler(t *testing.T, lists map[string][]string) dns.HandlerFunc {
+	t.Helper()
+	bm, err := newMemoryBlocklistManager(newMemStore(lists))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := newDNSHandler(bm, newTestAccountManager(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+// serveQuery sends h one qtype query for name from client and returns what it wrote
+func serveQuery(h dns.HandlerFunc, client, name string, qtype uint16) *fakeResponseWriter {
+	r := new(dns.Msg)
+	r.SetQuestion(dns.Fqdn(name), qtype)
+	w := &fakeResponseWriter{remote: &net.UDPAddr{IP: net.ParseIP(client), Port: 40000}}
+	h(w, r)
+	return w
+}
+
+func TestDNSHandlerClientACL(t *testing.T) {
+	tests := []struct {
+		name      string
+		allowed   []string
+		refused   string
+		client    string
+		wantReply bool
+		wantRcode int
+	}{
+		{"private client allowed by default", nil, "", "192.168.1.10", true, dns.RcodeNameError},
+		{"loopback allowed by default", nil, "", "127.0.0.1", true, dns.RcodeNameError},
+		{"public client refused by default", nil, "", "203.0.113.5", true, dns.RcodeRefused},
+		{"public client dropped", nil, refusedDrop, "203.0.113.5", false, 0},
+		{"allowed by configured range", []string{"203.0.113.0/24"}, "", "203.0.113.5", true, dns.RcodeNameError},
+		{"outside configured range", []string{"203.0.113.0/24"}, "", "192.168.1.10", true, dns.RcodeRefused},
+		{"outside configured bare IP", []string{"203.0.113.7"}, refusedDrop, "203.0.113.5", false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withConfig(t, func(c *Config) {
+				c.AllowedClients = tt.allowed
+				c.RefusedResponse = tt.refused
+				c.BlockingMode = "nx"
+			})
+			h := newTestDNSHandler(t, map[string][]string{"ads": {"ads.example"}})
+			w := serveQuery(h, tt.client, "ads.example", dns.TypeA)
+			if !tt.wantReply {
+				if w.msg != nil {
+					t.Fatalf("got a reply (rcode %s), want none", dns.RcodeToString[w.msg.Rcode])
+				}
+				return
+			}
+			if w.msg == nil {
+				t.Fatal("got no reply")
+			}
+			if w.msg.Rcode != tt.wantRcode {
+				t.Errorf("rcode = %s, want %s", dns.RcodeToString[w.msg.Rcode], dns.RcodeToString[tt.wantRcode])
+			}
+			if tt.wantRcode == dns.RcodeRefused && len(w.msg.Answer) != 0 {
+				t.Errorf("refused reply carries %d answers", len(w.msg.Answer))
+			}
+		})
+	}
+}
+
+// startFakeUpstream serves h over TCP on a loopback port and returns its address
+func startFakeUpstream(t testing.TB, h dns.HandlerFunc) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &dns.Server{Listener: l, Handler: h}
+	go srv.ActivateAndServe()
+	t.Cleanup(func() { srv.Shutdown() })
+	return l.Addr().String()
+}
+
+// fakeZone answers like a validating resolver: nx. names don't exist (with
+// an NSEC proof), big. names have 60 addresses and anything else has one
+func fakeZone(w dns.ResponseWriter, r *dns.Msg) {
+	m := new(dns.Msg)
+	m.SetReply(r)
+	q := r.Question[0]
+	switch {
+	case dns.IsSubDomain("nx.example.", q.Name):
+		m.Rcode = dns.RcodeNameError
+		m.Ns = append(m.Ns,
+			&dns.SOA{Hdr: dns.RR_Header{Name: "example.", Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: 60}, Ns: "ns.example.", Mbox: "h.example.", Serial: 1, Minttl: 60},
+			&dns.NSEC{Hdr: dns.RR_Header{Name: "mx.example.", Rrtype: dns.TypeNSEC, Class: dns.ClassINET, Ttl: 60}, NextDomain: "oa.example.", TypeBitMap: []uint16{dns.TypeA}})
+	case dns.IsSubDomain("big.example.", q.Name):
+		for i := 0; i < 60; i++ {
+			m.Answer = append(m.Answer, &dns.A{Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60}, A: net.IPv4(10, 0, byte(i/250), byte(i%250+1))})
+		}
+	default:
+		m.Answer = append(m.Answer, &dns.A{Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60}, A: net.IPv4(10, 0, 0, 1)})
+	}
+	w.WriteMsg(m)
+}
+
+func TestDNSHandlerForwardedReplies(t *testing.T) {
+	tests := []struct {
+		name      string
+		qname     string
+		edns      uint16 // 0 sends no OPT record
+		do        bool
+		wantRcode int
+		wantNs    bool
+		wantTC    bool
+		maxSize   int
+	}{
+		{"NXDOMAIN kept for plain clients", "host.nx.example", 0, false, dns.RcodeNameError, false, false, dns.MinMsgSize},
+		{"NXDOMAIN kept with its proof for DO", "host.nx.example", 1232, true, dns.RcodeNameError, true, false, ednsUDPSize},
+		{"small answer fits", "small.example", 0, false, dns.RcodeSuccess, false, false, dns.MinMsgSize},
+		{"large answer truncated to 512 without EDNS", "big.example", 0, false, dns.RcodeSuccess, false, true, dns.MinMsgSize},
+		{"large answer truncated to the client's buffer", "big.example", 700, false, dns.RcodeSuccess, false, true, 700},
+		{"client buffer capped at ours", "big.example", 4096, false, dns.RcodeSuccess, false, false, ednsUDPSize},
+	}
+	upstream := startFakeUpstream(t, fakeZone)
+	withConfig(t, func(c *Config) {
+		c.Upstream = upstream
+		c.UpstreamProtocol = "tcp"
+	})
+	h := newTestDNSHandler(t, nil)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := new(dns.Msg)
+			r.SetQuestion(dns.Fqdn(tt.qname), dns.TypeA)
+			if tt.edns > 0 {
+				r.SetEdns0(tt.edns, tt.do)
+			}
+			w := &fakeResponseWriter{remote: &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 40000}}
+			h(w, r)
+			if w.msg == nil {
+				t.Fatal("got no reply")
+			}
+			if w.msg.Rcode != tt.wantRcode {
+				t.Errorf("rcode = %s, want %s", dns.RcodeToString[w.msg.Rcode], dns.RcodeToString[tt.wantRcode])
+			}
+			if got := len(w.msg.Ns) > 0; got != tt.wantNs {
+				t.Errorf("authority section present = %t, want %t", got, tt.wantNs)
+			}
+			if w.msg.Truncated != tt.wantTC {
+				t.Errorf("TC = %t, want %t", w.msg.Truncated, tt.wantTC)
+			}
+			if n := w.msg.Len(); n > tt.maxSize {
+				t.Errorf("reply is %d bytes, over %d", n, tt.maxSize)
+			}
+			if tt.qname == "big.example" && tt.edns == 4096 && len(w.msg.Answer) == 0 {
+				t.Error("answers dropped")
+			}
+		})
+	}
+}
+
+func TestUDPReplySize(t *testing.T) {
+	tests := []struct {
+		edns uint16
+		want int
+	}{
+		{0, dns.MinMsgSize},
+		{256, dns.MinMsgSize},
+		{1000, 1000},
+		{ednsUDPSize, ednsUDPSize},
+		{65535, ednsUDPSize},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.edns), func(t *testing.T) {
+			r := new(dns.Msg)
+			r.SetQuestion("example.com.", dns.TypeA)
+			if tt.edns > 0 {
+				r.SetEdns0(tt.edns, false)
+			}
+			if got := udpReplySize(r); got != tt.want {
+				t.Errorf("udpReplySize = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpstreamFor(t *testing.T) {
+	withConfig(t, func(c *Config) {
+		c.Upstream = "9.9.9.9:53"
+		c.ConditionalForwarders = map[string]string{
+			"lan":               "192.168.1.1:53",
+			"corp.example":      "10.0.0.1:53",
+			"vpn.corp.example.": "10.8.0.1:53",
+		}
+	})
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"printer.lan", "192.168.1.1:53"},
+		{"lan", "192.168.1.1:53"},
+		{"PRINTER.LAN.", "192.168.1.1:53"},
+		{"wiki.corp.example", "10.0.0.1:53"},
+		{"host.vpn.corp.example", "10.8.0.1:53"},
+		{"notcorp.example", "9.9.9.9:53"},
+		{"planet", "9.9.9.9:53"},
+		{"example.com", "9.9.9.9:53"},
+	}
+	for _, tt := range tests {
+		if got := upstreamFor(AppConfig(), tt.name); got != tt.want {
+			t.Errorf("upstreamFor(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDNSHandlerUsesOneConfigPerQuery(t *testing.T) {
+	var reload sync.Once
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &dns.Server{
+		Listener: l,
+		Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+			// a reload lands while the first question is upstream
+			reload.Do(func() {
+				next := *AppConfig()
+				next.NullSinkIP = "10.8.8.8"
+				setAppConfig(&next)
+			})
+			fakeZone(w, r)
+		}),
+		// the handler forwards the whole two-question message
+		MsgAcceptFunc: func(dns.Header) dns.MsgAcceptAction { return dns.MsgAccept },
+	}
+	go srv.ActivateAndServe()
+	t.Cleanup(func() { srv.Shutdown() })
+	upstream := l.Addr().String()
+	withConfig(t, func(c *Config) {
+		c.Upstream, c.UpstreamProtocol = upstream, "tcp"
+		c.BlockingMode, c.NullSinkIP = "null", "10.9.9.9"
+	})
+	h := newTestDNSHandler(t, map[string][]string{"ads": {"ads.example"}})
+	r := new(dns.Msg)
+	r.Question = []dns.Question{
+		{Name: "www.example.", Qtype: dns.TypeA, Qclass: dns.ClassINET},
+		{Name: "ads.example.", Qtype: dns.TypeA, Qclass: dns.ClassINET},
+	}
+	w := &fakeResponseWriter{remote: &net.UDPAddr{IP: net.ParseIP("192.168.1.70"), Port: 40000}}
+	h(w, r)
+	if w.msg == nil {
+		t.Fatal("no reply")
+	}
+	var got []string
+	for _, rr := range w.msg.Answer {
+		if a, ok := rr.(*dns.A); ok {
+			got = append(got, a.Hdr.Name+" "+a.A.String())
+		}
+	}
+	// the blocked question is answered with the sink the query started with
+	want := []string{"www.example. 10.0.0.1", "ads.example. 10.9.9.9"}
+	if strings.Join(got, ", ") != strings.Join(want, ", ") {
+		t.Errorf("answers %q, want %q", got, want)
+	}
+	if sink := AppConfig().NullSinkIP; sink != "10.8.8.8" {
+		t.Fatalf("the reload didn't happen: null_sink_ip = %q", sink)
+	}
+}
+
+func TestBlockedQueriesRecordReason(t *testing.T) {
+	upstream := startFakeUpstream(t, fakeZone)
+	bm := newTestBlocklistManager(t, map[string][]string{"ads": {"ads.example"}})
+	h, err := newDNSHandler(bm, newTestAccountManager(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name         string
+		mode         string
+		unidentified string
+		domain       string
+		qtype        uint16
+		wantBlocked  bool
+		wantReason   string
+		wantMode     string
+	}{
+		{"redirect", "redirect", "", "ads.example", dns.TypeA, true, "ads: ads.example", "redirect"},
+		{"nx", "nx", "", "ads.example", dns.TypeA, true, "ads: ads.example", "nx"},
+		{"null", "null", "", "ads.example", dns.TypeAAAA, true, "ads: ads.example", "null"},
+		{"no pattern to report", "nx", unidentifiedBlock, "anything.example", dns.TypeA, true, unidentifiedClientList, "nx"},
+		{"allowed", "nx", "", "fine.example", dns.TypeA, false, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withConfig(t, func(c *Config) {
+				c.Upstream, c.UpstreamProtocol = upstream, "tcp"
+				c.BlockingMode = tt.mode
+				if tt.unidentified != "" {
+					c.UnidentifiedClients = tt.unidentified
+				}
+			})
+			serveQuery(h, "192.168.71.23", tt.domain, tt.qtype)
+			logs := bm.GetLogs(1)
+			if len(logs) != 1 {
+				t.Fatalf("%d log entries, want 1", len(logs))
+			}
+			e := logs[0]
+			if e.Domain != tt.domain || e.Blocked != tt.wantBlocked || e.Reason != tt.wantReason || e.Mode != tt.wantMode {
+				t.Errorf("logged %+v, want blocked %v, reason %q, mode %q", e, tt.wantBlocked, tt.wantReason, tt.wantMode)
+			}
+		})
+	}
+}
+
+func TestDNSHandlerRejectsOversizedNames(t *testing.T) {
+	withConfig(t, func(c *Config) { c.BlockingMode = "nx" })
+	h := newTestDNSHandler(t, map[string][]string{"ads": {"*.example"}})
+	tests := []struct {
+		name  string
+		qname string
+		want  int
+	}{
+		{"longest label", strings.Repeat("a", 63) + ".example", dns.RcodeNameError},
+		{"label too long", strings.Repeat("a", 64) + ".example", dns.RcodeFormatError},
+		// fits the wire format, but each "aé" label becomes "xn--a-9fa"
+		{"too long once canonical", strings.Repeat("aé.", 60) + "example", dns.RcodeFormatError},
+	}
+	for _, tt := range tests {
+		q := new(dns.Msg)
+		q.SetQuestion(dns.Fqdn(tt.qname), dns.TypeA)
+		r := q
+		if wire, err := q.Pack(); err == nil {
+			r = new(dns.Msg)
+			if err := r.Unpack(wire); err != nil {
+				t.Fatal(err)
+			}
+		}
+		w := &fakeResponseWriter{remote: &net.UDPAddr{IP: net.ParseIP("192.168.1.10"), Port: 40000}}
+		h(w, r)
+		if w.msg == nil || w.msg.Rcode != tt.want {
+			t.Errorf("%s: reply %v, want %s", tt.name, w.msg, dns.RcodeToString[tt.want])
+		}
+	}
+}
+
+func TestDNSHandlerQueryDeadline(t *testing.T) {
+	unblock := make(chan struct{})
+	upstream := startFakeUpstream(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		if r.Question[0].Name == "slow.example." {
+			<-unblock
+		}
+		fakeZone(w, r)
+	})
+	t.Cleanup(func() { close(unblock) })
+	withConfig(t, func(c *Config) {
+		c.Upstream, c.UpstreamProtocol = upstream, "tcp"
+		c.QueryTimeout = "100ms"
+	})
+	h := newTestDNSHandler(t, nil)
+
+	tests := []struct {
+		domain    string
+		wantRcode int
+		answers   int
+	}{
+		{"slow.example", dns.RcodeServerFailure, 0},
+		{"fast.example", dns.RcodeSuccess, 1},
+	}
+	for _, tt := range tests {
+		errs := upstreamErrors.Load()
+		start := time.Now()
+		w := serveQuery(h, "127.0.0.1", tt.domain, dns.TypeA)
+		if took := time.Since(start); took > time.Second {
+			t.Errorf("%s: answered after %v, want about the 100ms deadline", tt.domain, took)
+		}
+		if w.msg == nil || w.msg.Rcode != tt.wantRcode || len(w.msg.Answer) != tt.answers {
+			t.Errorf("%s: reply %v, want %s with %d answers", tt.domain, w.msg, dns.RcodeToString[tt.wantRcode], tt.answers)
+		}
+		if timedOut := upstreamErrors.Load() != errs; timedOut != (tt.wantRcode == dns.RcodeServerFailure) {
+			t.Errorf("%s: upstream errors went from %d to %d", tt.domain, errs, upstreamErrors.Load())
+		}
+	}
+}
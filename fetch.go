@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+// fetchTimeout bounds a single remote list fetch attempt, even if ctx has no deadline
+const fetchTimeout = 15 * time.Second
+
+// fetchTotalTimeout bounds a fetch including all retries
+const fetchTotalTimeout = 60 * time.Second
+
+// fetchBackoff is the delay before the first retry; it doubles on each attempt
+var fetchBackoff = 500 * time.Millisecond
+
+// maxFetchRedirects is how many redirects a list fetch follows
+const maxFetchRedirects = 5
+
+var fetchClient = &http.Client{Timeout: fetchTimeout, CheckRedirect: checkFetchRedirect}
+
+// publicFetchClient fetches URLs that API callers only want previewed. Its
+// dialer refuses anything but public addresses, so /validate can't be used to
+// reach the LAN or this host.
+var publicFetchClient = &http.Client{
+	Timeout:       fetchTimeout,
+	CheckRedirect: checkFetchRedirect,
+	Transport: &http.Transport{
+		DialContext:           (&net.Dialer{Timeout: 10 * time.Second, Control: refusePrivateAddress}).DialContext,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: fetchTimeout,
+		MaxIdleConns:          4,
+		IdleConnTimeout:       30 * time.Second,
+	},
+}
+
+// ErrPrivateAddress is returned when publicFetchClient would connect to a
+// loopback, private, link-local or otherwise non-public address
+var ErrPrivateAddress = errors.New("requests to private/localhost addresses are not allowed")
+
+// refusePrivateAddress is a net.Dialer Control hook. It checks the address
+// actually being connected to, after name resolution, so a name that resolves
+// to a public address when checked and a private one when used (DNS
+// rebinding) is still refused, as is a redirect to a private host.
+func refusePrivateAddress(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !isPublicIP(ip) {
+		return fmt.Errorf("%w (%s)", ErrPrivateAddress, host)
+	}
+	return nil
+}
+
+// isPublicIP reports whether ip is a globally routable unicast address
+func isPublicIP(ip net.IP) bool {
+	return ip.IsGlobalUnicast() && !ip.IsPrivate() && !ip.IsLoopback() && !ip.IsLinkLocalUnicast()
+}
+
+// HTTPClient sends the requests that download remote lists. *http.Client
+// satisfies it; tests can substitute a stub returning canned responses.
+type HTTPClient interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// fetcher returns the client list downloads go through: HTTPClient when set,
+// otherwise fetchClient
+func (b *BlocklistManager) fetcher() HTTPClient {
+	if b != nil && b.HTTPClient != nil {
+		return b.HTTPClient
+	}
+	return fetchClient
+}
+
+// checkFetchRedirect caps the redirects a list fetch follows. Once a redirect
+// leaves the original origin (scheme, host and port) every header is dropped,
+// so a list's fetch headers never reach another server. The client only
+// strips a few well-known credential headers by itself.
+func checkFetchRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= maxFetchRedirects {
+		return fmt.Errorf("stopped after %d redirects", maxFetchRedirects)
+	}
+	orig := via[0].URL
+	if req.URL.Scheme != orig.Scheme || req.URL.Host != orig.Host {
+		req.Header = make(http.Header)
+	}
+	return nil
+}
+
+// ErrFetchTooLarge is returned when a remote list exceeds AppConfig.MaxFetchBytes
+var ErrFetchTooLarge = errors.New("remote file exceeds size limit")
+
+// fetchStatusError is a non-2xx response from a list mirror
+type fetchStatusError struct {
+	Status string
+	Code   int
+}
+
+func (e *fetchStatusError) Error() string {
+	return "failed to fetch file: " + e.Status
+}
+
+// isRetryableFetchError reports whether a failed attempt may succeed if repeated:
+// server errors, timeouts and connection failures are; 4xx, oversized files and
+// refused private addresses aren't.
+func isRetryableFetchError(err error) bool {
+	var se *fetchStatusError
+	if errors.As(err, &se) {
+		return se.Code >= 500
+	}
+	if errors.Is(err, ErrFetchTooLarge) || errors.Is(err, ErrPrivateAddress) || errors.Is(err, context.Canceled) {
+		return false
+	}
+	// *url.Error is itself a net.Error, so look at what it wraps (e.g. a bad scheme isn't transient)
+	var ue *url.Error
+	if errors.As(err, &ue) {
+		err = ue.Err
+	}
+	var ne net.Error
+	return errors.As(err, &ne) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// fetchListLines downloads url through client, sending header (which may be
+// nil), and parses it into patterns with readLines. See fetchLines.
+func fetchListLines(ctx context.Context, client HTTPClient, url string, header http.Header) ([]string, error) {
+	return fetchLines(ctx, client, url, header, readLines)
+}
+
+// fetchLines downloads url through client, sending header (which may be
+// nil), and turns the body into lines with parse.
+// Cancelling ctx (e.g. the client aborting the API request) aborts the fetch.
+// Files larger than AppConfig.FetchLimit() fail with ErrFetchTooLarge rather
+// than being silently truncated. Transient failures are retried up to
+// AppConfig.FetchAttemptCount() times with exponential backoff, within fetchTotalTimeout.
+func fetchLines(ctx context.Context, client HTTPClient, url string, header http.Header, parse func(io.Reader) ([]string, error)) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, fetchTotalTimeout)
+	defer cancel()
+
+	attempts := AppConfig().FetchAttemptCount()
+	backoff := fetchBackoff
+	var err error
+	for attempt := 1; ; attempt++ {
+		var lines []string
+		lines, err = fetchLinesOnce(ctx, client, url, header, parse)
+		if err == nil {
+			return lines, nil
+		}
+		if attempt >= attempts || !isRetryableFetchError(err) || ctx.Err() != nil {
+			return nil, err
+		}
+		slog.Warn("list fetch failed; retrying", "url", url, "attempt", attempt, "backoff", backoff, "err", err)
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// fetchLinesOnce performs a single download attempt for fetchLines
+func fetchLinesOnce(ctx context.Context, client HTTPClient, url string, header http.Header, parse func(io.Reader) ([]string, error)) ([]string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, &fetchStatusError{Status: resp.Status, Code: resp.StatusCode}
+	}
+
+	limit := AppConfig().FetchLimit()
+	if resp.ContentLength > limit {
+		return nil, fmt.Errorf("%w (%d bytes, limit %d)", ErrFetchTooLarge, resp.ContentLength, limit)
+	}
+	// read one byte past the limit so an exactly-limit-sized file isn't mistaken for truncation
+	body := &io.LimitedReader{R: resp.Body, N: limit + 1}
+	lines, err := parse(body)
+	if err != nil {
+		return nil, err
+	}
+	if body.N <= 0 {
+		return nil, fmt.Errorf("%w (limit %d bytes)", ErrFetchTooLarge, limit)
+	}
+	return lines, nil
+}
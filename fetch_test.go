@@ -0,0 +1,327 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRefusePrivateAddress(t *testing.T) {
+	tests := []struct {
+		address string
+		refused bool
+	}{
+		{"93.184.216.34:443", false},
+		{"[2606:2800:220:1:248:1893:25c8:1946]:443", false},
+		{"127.0.0.1:80", true},
+		{"[::1]:80", true},
+		{"[::ffff:127.0.0.1]:80", true},
+		{"10.1.2.3:80", true},
+		{"172.16.0.1:80", true},
+		{"192.168.1.1:80", true},
+		{"169.254.169.254:80", true},
+		{"[fe80::1]:80", true},
+		{"[fd00::1]:80", true},
+		{"0.0.0.0:80", true},
+		{"224.0.0.1:80", true},
+	}
+	for _, tt := range tests {
+		err := refusePrivateAddress("tcp", tt.address, nil)
+		if got := errors.Is(err, ErrPrivateAddress); got != tt.refused {
+			t.Errorf("refusePrivateAddress(%q) = %v, want refused=%v", tt.address, err, tt.refused)
+		}
+	}
+}
+
+func TestFetchStopsWithContext(t *testing.T) {
+	// the server never answers; only the caller's context can end the fetch
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name string
+		ctx  func() (context.Context, context.CancelFunc)
+		want error
+	}{
+		{
+			name: "cancelled",
+			ctx: func() (context.Context, context.CancelFunc) {
+				ctx, cancel := context.WithCancel(context.Background())
+				time.AfterFunc(50*time.Millisecond, cancel)
+				return ctx, cancel
+			},
+			want: context.Canceled,
+		},
+		{
+			name: "deadline",
+			ctx: func() (context.Context, context.CancelFunc) {
+				return context.WithTimeout(context.Background(), 50*time.Millisecond)
+			},
+			want: context.DeadlineExceeded,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := tt.ctx()
+			defer cancel()
+			start := time.Now()
+			_, err := fetchListLines(ctx, fetchClient, srv.URL, nil)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("fetchListLines = %v, want %v", err, tt.want)
+			}
+			if d := time.Since(start); d > 2*time.Second {
+				t.Errorf("fetch took %v after the context ended", d)
+			}
+		})
+	}
+}
+
+func TestFetchSizeLimit(t *testing.T) {
+	const limit = 64
+	withConfig(t, func(c *Config) { c.MaxFetchBytes = limit })
+
+	tests := []struct {
+		name    string
+		size    int
+		chunked bool // no Content-Length, so only the read limit can catch it
+		tooBig  bool
+	}{
+		{"small", 10, false, false},
+		{"exactly the limit", limit, false, false},
+		{"exactly the limit, chunked", limit, true, false},
+		{"over, announced", limit + 1, false, true},
+		{"over, chunked", limit + 1, true, true},
+		{"far over, chunked", 100 * limit, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := strings.Repeat("a.example\n", tt.size/10+1)[:tt.size]
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.chunked {
+					w.Write([]byte(body[:1]))
+					w.(http.Flusher).Flush()
+					w.Write([]byte(body[1:]))
+					return
+				}
+				w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+				w.Write([]byte(body))
+			}))
+			defer srv.Close()
+
+			_, err := fetchListLines(context.Background(), fetchClient, srv.URL, nil)
+			if got := errors.Is(err, ErrFetchTooLarge); got != tt.tooBig {
+				t.Errorf("fetch of %d bytes: err = %v, want too large = %v", tt.size, err, tt.tooBig)
+			}
+			if !tt.tooBig && err != nil {
+				t.Errorf("fetch of %d bytes: %v", tt.size, err)
+			}
+		})
+	}
+}
+
+func TestFetchRetries(t *testing.T) {
+	prevBackoff := fetchBackoff
+	fetchBackoff = time.Millisecond
+	t.Cleanup(func() { fetchBackoff = prevBackoff })
+
+	tests := []struct {
+		name         string
+		attempts     int
+		failures     int // requests answered with status before succeeding
+		status       int
+		wantAttempts int32
+		wantErr      bool
+	}{
+		{"fails twice then succeeds", 3, 2, http.StatusServiceUnavailable, 3, false},
+		{"5xx until attempts run out", 3, 5, http.StatusInternalServerError, 3, true},
+		{"4xx isn't retried", 3, 5, http.StatusNotFound, 1, true},
+		{"retries turned off", 0, 1, http.StatusBadGateway, 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withConfig(t, func(c *Config) { c.FetchAttempts = tt.attempts })
+			var requests atomic.Int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if int(requests.Add(1)) <= tt.failures {
+					w.WriteHeader(tt.status)
+					return
+				}
+				w.Write([]byte("ads.example\n"))
+			}))
+			defer srv.Close()
+
+			lines, err := fetchListLines(context.Background(), fetchClient, srv.URL, nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("fetchListLines = %v, %v; want error %v", lines, err, tt.wantErr)
+			}
+			if !tt.wantErr && (len(lines) != 1 || lines[0] != "ads.example") {
+				t.Errorf("lines = %v", lines)
+			}
+			if n := requests.Load(); n != tt.wantAttempts {
+				t.Errorf("made %d attempts, want %d", n, tt.wantAttempts)
+			}
+		})
+	}
+}
+
+func TestIsRetryableFetchError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"503", &fetchStatusError{Status: "503 Service Unavailable", Code: 503}, true},
+		{"404", &fetchStatusError{Status: "404 Not Found", Code: 404}, false},
+		{"too large", fmt.Errorf("%w (limit 1 bytes)", ErrFetchTooLarge), false},
+		{"private address", &url.Error{Op: "Get", URL: "http://10.0.0.1", Err: ErrPrivateAddress}, false},
+		{"cancelled", &url.Error{Op: "Get", URL: "http://x", Err: context.Canceled}, false},
+		{"timeout", &url.Error{Op: "Get", URL: "http://x", Err: &net.OpError{Op: "dial", Err: os.ErrDeadlineExceeded}}, true},
+		{"truncated body", io.ErrUnexpectedEOF, true},
+		{"bad scheme", &url.Error{Op: "Get", URL: "ftp://x", Err: errors.New("unsupported protocol scheme")}, false},
+	}
+	for _, tt := range tests {
+		if got := isRetryableFetchError(tt.err); got != tt.want {
+			t.Errorf("%s: isRetryableFetchError(%v) = %v, want %v", tt.name, tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestFetchHeadersStayWithTheOrigin(t *testing.T) {
+	var mu sync.Mutex
+	seen := map[string]string{} // request path to the Authorization it carried
+	record := func(r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		seen[r.Host+r.URL.Path] = r.Header.Get("Authorization")
+	}
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		record(r)
+		w.Write([]byte("moved.example\n"))
+	}))
+	defer other.Close()
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		record(r)
+		switch r.URL.Path {
+		case "/list":
+			http.Redirect(w, r, "/same", http.StatusFound)
+		case "/same":
+			http.Redirect(w, r, other.URL+"/elsewhere", http.StatusFound)
+		default:
+			http.Redirect(w, r, r.URL.Path, http.StatusFound) // forever
+		}
+	}))
+	defer origin.Close()
+
+	bm := newTestBlocklistManager(t, map[string][]string{"feed": {"old.example"}})
+	if err := bm.SetListMeta("feed", ListMeta{Enabled: true, FetchHeaders: map[string]string{"Authorization": "Bearer s3cret"}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bm.ReplaceListFromURL(context.Background(), "feed", origin.URL+"/list"); err != nil {
+		t.Fatal(err)
+	}
+	originHost, otherHost := strings.TrimPrefix(origin.URL, "http://"), strings.TrimPrefix(other.URL, "http://")
+	want := map[string]string{
+		originHost + "/list":     "Bearer s3cret",
+		originHost + "/same":     "Bearer s3cret",
+		otherHost + "/elsewhere": "",
+	}
+	if !reflect.DeepEqual(seen, want) {
+		t.Errorf("Authorization seen per request: %q, want %q", seen, want)
+	}
+	if !bm.IsBlocked("moved.example") {
+		t.Error("the redirected list wasn't saved")
+	}
+
+	_, err := fetchListLines(context.Background(), fetchClient, origin.URL+"/loop", nil)
+	if err == nil || !strings.Contains(err.Error(), fmt.Sprintf("stopped after %d redirects", maxFetchRedirects)) {
+		t.Errorf("redirect loop: err = %v, want the redirect cap", err)
+	}
+}
+
+// stubClient answers list downloads from canned bodies by URL, without a network
+type stubClient struct {
+	bodies map[string]string
+	status int // for every response; 0 means 200
+	urls   []string
+}
+
+func (c *stubClient) Do(req *http.Request) (*http.Response, error) {
+	c.urls = append(c.urls, req.URL.String())
+	body, ok := c.bodies[req.URL.String()]
+	status := c.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	if !ok {
+		status = http.StatusNotFound
+	}
+	return &http.Response{
+		StatusCode:    status,
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		Body:          io.NopCloser(strings.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+func TestListDownloadsUseHTTPClient(t *testing.T) {
+	const feed = "https://lists.example/feed.txt"
+	withConfig(t, func(c *Config) { c.FetchAttempts = 1 })
+	tests := []struct {
+		name    string
+		status  int
+		url     string
+		replace bool
+		wantErr bool
+		want    []string
+	}{
+		{"append", 0, feed, false, false, []string{"ads.example", "new.example", "old.example"}},
+		{"replace", 0, feed, true, false, []string{"ads.example", "new.example"}},
+		{"missing", 0, "https://lists.example/missing.txt", true, true, []string{"old.example"}},
+		{"server error", http.StatusBadGateway, feed, false, true, []string{"old.example"}},
+	}
+	for _, tt := range tests {
+		bm := newTestBlocklistManager(t, map[string][]string{"ads": {"old.example"}})
+		stub := &stubClient{bodies: map[string]string{feed: "# feed\nads.example\nnew.example\n"}, status: tt.status}
+		bm.HTTPClient = stub
+		var err error
+		if tt.replace {
+			_, err = bm.ReplaceListFromURL(context.Background(), "ads", tt.url)
+		} else {
+			_, err = bm.AddFileToList(context.Background(), "ads", tt.url, false)
+		}
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: err = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+		if len(stub.urls) == 0 || stub.urls[0] != tt.url {
+			t.Errorf("%s: stub saw %q, want a request for %s", tt.name, stub.urls, tt.url)
+		}
+		_, got, _ := bm.ListDomains("ads", 0, 10, "")
+		slices.Sort(got)
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s: list holds %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	// without one the shared client, and its redirect policy, is used
+	var bm *BlocklistManager
+	if bm.fetcher() != HTTPClient(fetchClient) {
+		t.Error("a manager without an HTTPClient doesn't use fetchClient")
+	}
+}
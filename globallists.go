@@ -0,0 +1,48 @@
+package main
+
+import (
+	"os"
+	"strings"
+)
+
+// Global lists are ordinary list files without a MAC prefix whose sidecar has
+// "global": true. MatchForUser consults them for every account, so a household
+// can share one list instead of copying it to each device. They are managed by
+// admins through /global/lists.
+
+// GlobalLists returns the names of the loaded lists flagged global
+func (b *BlocklistManager) GlobalLists() []string {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return append([]string(nil), b.global...)
+}
+
+// IsGlobalList reports whether listName is loaded and flagged global
+func (b *BlocklistManager) IsGlobalList(listName string) bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	_, ok := b.lists[listName]
+	return ok && b.meta[listName].Global
+}
+
+// MarkGlobal flags a loaded list as global, keeping the rest of its metadata
+func (b *BlocklistManager) MarkGlobal(listName, category string) error {
+	if !b.HasList(listName) {
+		return os.ErrNotExist
+	}
+	meta := b.readListMeta(listName)
+	meta.Global = true
+	if category != "" {
+		meta.Category = category
+	}
+	if err := b.writeListMeta(listName, meta); err != nil {
+		return err
+	}
+	return b.LoadAll()
+}
+
+// validGlobalListName rejects names that could escape the blocklist directory or
+// be mistaken for a per-user list (those carry a MAC prefix, which has colons)
+func validGlobalListName(name string) bool {
+	return name != "" && !strings.Contains(name, "..") && !strings.ContainsAny(name, `/\:`)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestGlobalListsBlockForEveryUser(t *testing.T) {
+	const mac = "aa:bb:cc:00:11:07"
+	tests := []struct {
+		name    string
+		global  bool
+		enabled bool
+		blocked bool
+	}{
+		{"global list", true, true, true},
+		{"ordinary list", false, true, false},
+		{"disabled global list", true, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bm := newTestBlocklistManager(t, map[string][]string{"household": {"ads.example"}})
+			am := newTestAccountManager(t, mac)
+			if err := bm.SetListMeta("household", ListMeta{Enabled: tt.enabled, Global: tt.global}); err != nil {
+				t.Fatal(err)
+			}
+			if got := bm.IsGlobalList("household"); got != tt.global {
+				t.Errorf("IsGlobalList = %v, want %v", got, tt.global)
+			}
+			// the user has no lists of their own
+			if got := bm.IsBlockedForUser("ads.example", mac, am); got != tt.blocked {
+				t.Errorf("IsBlockedForUser = %v, want %v", got, tt.blocked)
+			}
+		})
+	}
+}
+
+func TestGlobalListsAPI(t *testing.T) {
+	const admin, user = "aa:00:00:00:11:01", "aa:00:00:00:11:02"
+	withConfig(t, func(c *Config) { c.AdminMACs = []string{admin}; c.BcryptCost = 4 })
+	am := newTestAccountManager(t, admin, user)
+	bm := newTestBlocklistManager(t, nil)
+	mux := newTestAPI(bm, am)
+	adminSession := am.createSession(admin, false).ID
+	userSession := am.createSession(user, false).ID
+
+	// each step runs against the state the previous one left
+	tests := []struct {
+		name, method, target, session, body string
+		status                              int
+	}{
+		{"user can't create", http.MethodPost, "/global/lists/create", userSession, `{"name":"household","items":["ads.example"]}`, http.StatusForbidden},
+		{"admin creates", http.MethodPost, "/global/lists/create", adminSession, `{"name":"household","items":["ads.example"]}`, http.StatusOK},
+		{"name taken", http.MethodPost, "/global/lists/create", adminSession, `{"name":"household","items":["x.example"]}`, http.StatusConflict},
+		{"bad body", http.MethodPost, "/global/lists/create", adminSession, `{"name":"household"}`, http.StatusBadRequest},
+		{"user can read", http.MethodGet, "/global/lists", userSession, "", http.StatusOK},
+		{"no session", http.MethodGet, "/global/lists", "", "", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		w := callAPI(mux, tt.method, tt.target, tt.session, tt.body)
+		if w.Code != tt.status {
+			t.Fatalf("%s: status = %d, want %d: %s", tt.name, w.Code, tt.status, w.Body)
+		}
+	}
+
+	w := callAPI(mux, http.MethodGet, "/global/lists", userSession, "")
+	var lists map[string]int
+	if err := json.Unmarshal(w.Body.Bytes(), &lists); err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]int{"household": 1}; !reflect.DeepEqual(lists, want) {
+		t.Errorf("GET /global/lists = %v, want %v", lists, want)
+	}
+	if !bm.IsBlockedForUser("ads.example", user, am) {
+		t.Error("global list created through the API doesn't block for a user without lists")
+	}
+}
+
+func TestValidGlobalListName(t *testing.T) {
+	tests := []struct {
+		name string
+		ok   bool
+	}{
+		{"household", true},
+		{"kids-tv", true},
+		{"", false},
+		{"..", false},
+		{"a/b", false},
+		{`a\b`, false},
+		{"aa:bb:cc:dd:ee:ff_ads", false},
+	}
+	for _, tt := range tests {
+		if got := validGlobalListName(tt.name); got != tt.ok {
+			t.Errorf("validGlobalListName(%q) = %v, want %v", tt.name, got, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import "fmt"
+
+// Guest permissions listed in AppConfig.GuestPermissions. Guests are always
+// read-only; these decide what they may read.
+const (
+	guestViewLists     = "view_lists"     // lists, items, search, global lists, categories
+	guestViewAnalytics = "view_analytics" // /analytics and /analytics/top
+	guestViewLogs      = "view_logs"      // /logs and /logs/export
+	guestViewDevices   = "view_devices"   // device names and local records
+	guestViewSettings  = "view_settings"  // safe search preference and blocking status
+)
+
+// allGuestPermissions is the default: guests may view everything
+var allGuestPermissions = []string{guestViewLists, guestViewAnalytics, guestViewLogs, guestViewDevices, guestViewSettings}
+
+// validateGuestPermissions rejects unknown permission names
+func validateGuestPermissions(perms []string) error {
+	for _, p := range perms {
+		known := false
+		for _, k := range allGuestPermissions {
+			if p == k {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return fmt.Errorf("invalid guest_permissions entry %q: must be one of %v", p, allGuestPermissions)
+		}
+	}
+	return nil
+}
+
+// GuestCan reports whether guests have perm
+func (c *Config) GuestCan(perm string) bool {
+	for _, p := range c.GuestPermissions {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// GuestAccessEnabled reports whether guests may view anything at all; with no
+// permissions guest sign-in is refused
+func (c *Config) GuestAccessEnabled() bool {
+	return len(c.GuestPermissions) > 0
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestGuestPermissionsPerArea(t *testing.T) {
+	const user, guest = "aa:bb:cc:00:11:41", "aa:bb:cc:00:11:42"
+	withConfig(t, func(c *Config) { c.GuestPermissions = []string{guestViewLists, guestViewLogs} })
+	am := newTestAccountManager(t, user)
+	mux := newTestAPI(newTestBlocklistManager(t, nil), am)
+	userSession := am.createSession(user, false).ID
+	guestSession := am.CreateGuestSession(guest).ID
+
+	tests := []struct {
+		method, target string
+		guestAllowed   bool
+	}{
+		{http.MethodGet, "/lists/", true},
+		{http.MethodGet, "/categories", true},
+		{http.MethodGet, "/logs", true},
+		{http.MethodGet, "/analytics", false},
+		{http.MethodGet, "/devices", false},
+		{http.MethodGet, "/records", false},
+		{http.MethodGet, "/settings/safesearch", false},
+		{http.MethodGet, "/blocking/status", false},
+		// viewing never extends to changing
+		{http.MethodPost, "/lists/create", false},
+	}
+	for _, tt := range tests {
+		for _, session := range []string{userSession, guestSession} {
+			w := callAPI(mux, tt.method, tt.target, session, `{"name":"ads","items":"a.example"}`)
+			forbidden := w.Code == http.StatusForbidden
+			if want := session == guestSession && !tt.guestAllowed; forbidden != want {
+				t.Errorf("%s %s as guest=%v: status %d, want forbidden %v: %s", tt.method, tt.target, session == guestSession, w.Code, want, w.Body)
+			}
+		}
+	}
+}
+
+func TestGuestSignInNeedsAPermission(t *testing.T) {
+	tests := []struct {
+		perms  []string
+		status int
+	}{
+		{nil, http.StatusForbidden},
+		{[]string{guestViewSettings}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		withConfig(t, func(c *Config) { c.GuestPermissions = tt.perms })
+		mux := newTestAPI(newTestBlocklistManager(t, nil), newTestAccountManager(t))
+		if w := callAPI(mux, http.MethodPost, "/auth/guest", "", `{"mac_address":"aa:bb:cc:00:11:43"}`); w.Code != tt.status {
+			t.Errorf("guest_permissions %v: status %d, want %d: %s", tt.perms, w.Code, tt.status, w.Body)
+		}
+	}
+}
+
+func TestValidateGuestPermissions(t *testing.T) {
+	tests := []struct {
+		perms []string
+		ok    bool
+	}{
+		{nil, true},
+		{allGuestPermissions, true},
+		{[]string{guestViewLogs}, true},
+		{[]string{"view_everything"}, false},
+		{[]string{guestViewLists, "VIEW_LOGS"}, false},
+	}
+	for _, tt := range tests {
+		if err := validateGuestPermissions(tt.perms); (err == nil) != tt.ok {
+			t.Errorf("validateGuestPermissions(%q) = %v, want ok %v", tt.perms, err, tt.ok)
+		}
+	}
+}
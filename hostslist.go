@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// listTypeHosts marks a list whose "IP name" lines are answered as local
+// records instead of being blocked
+const listTypeHosts = "hosts"
+
+// ErrHostsList is returned by the entry-level edits, which work on patterns
+// and would drop the addresses a hosts list maps its names to
+var ErrHostsList = errors.New("not supported on a hosts list; replace it from a URL or items instead")
+
+// parseHostsMapping turns one hosts-file line ("192.168.1.5 printer.lan nas")
+// into a record per name. Comments, blank lines, lines without a leading IP
+// and names that aren't valid are skipped.
+func parseHostsMapping(line string) []LocalRecord {
+	if idx := strings.Index(line, "#"); idx >= 0 {
+		line = line[:idx]
+	}
+	fields := strings.Fields(line)
+	if len(fields) < 2 {
+		return nil
+	}
+	ip := net.ParseIP(fields[0])
+	if ip == nil {
+		return nil
+	}
+	typ := "AAAA"
+	if ip.To4() != nil {
+		typ = "A"
+	}
+	recs := make([]LocalRecord, 0, len(fields)-1)
+	for _, name := range fields[1:] {
+		if isLocalHostName(strings.ToLower(name)) {
+			continue
+		}
+		rec, err := normalizeLocalRecord(LocalRecord{Name: name, Type: typ, Value: fields[0]})
+		if err != nil {
+			continue
+		}
+		recs = append(recs, rec)
+	}
+	return recs
+}
+
+// canonicalHostsLines rewrites hosts-file lines as one "IP name" line per
+// mapping, dropping duplicates and anything parseHostsMapping skips
+func canonicalHostsLines(lines []string) []string {
+	seen := make(map[string]struct{})
+	out := make([]string, 0, len(lines))
+	for _, l := range lines {
+		for _, rec := range parseHostsMapping(l) {
+			s := rec.Value + " " + rec.Name
+			if _, ok := seen[s]; ok {
+				continue
+			}
+			seen[s] = struct{}{}
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// readHostsLines reads a downloaded hosts file as canonical mapping lines
+func readHostsLines(r io.Reader) ([]string, error) {
+	var lines []string
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		lines = append(lines, s.Text())
+	}
+	return canonicalHostsLines(lines), s.Err()
+}
+
+// hostsItemLines returns the canonical mapping lines in items, each of which
+// may hold several lines
+func hostsItemLines(items []string) []string {
+	var lines []string
+	for _, it := range items {
+		lines = append(lines, strings.FieldsFunc(it, func(r rune) bool { return r == '\n' || r == '\r' })...)
+	}
+	return canonicalHostsLines(lines)
+}
+
+// hostsRecordSet builds the records of a hosts list from its stored lines
+func hostsRecordSet(lines []string) localRecordSet {
+	set := make(localRecordSet)
+	for _, l := range lines {
+		for _, rec := range parseHostsMapping(l) {
+			set[rec.Name] = append(set[rec.Name], rec)
+		}
+	}
+	return set
+}
+
+// isHostsList reports whether a loaded list is a hosts list
+func (b *BlocklistManager) isHostsList(listName string) bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.meta[listName].Type == listTypeHosts
+}
+
+// CreateHostsList creates listName as a hosts list from the file at url or,
+// when url is empty, from items (one mapping per line). It fails with
+// ErrListExists when the list is already there.
+func (b *BlocklistManager) CreateHostsList(ctx context.Context, listName, url string, items []string) (int, error) {
+	if listName == "" {
+		return 0, errors.New("missing list name")
+	}
+	if b.HasList(listName) {
+		return 0, ErrListExists
+	}
+	lines, err := b.hostsSource(ctx, listName, url, items)
+	if err != nil {
+		return 0, err
+	}
+	b.editMu.Lock()
+	defer b.editMu.Unlock()
+	// checked again: the list may have been created during the download
+	if b.HasList(listName) {
+		return 0, ErrListExists
+	}
+	if err := b.store.Write(listName, lines); err != nil {
+		return 0, err
+	}
+	if err := b.writeListMeta(listName, ListMeta{Enabled: true, Type: listTypeHosts}); err != nil {
+		return 0, err
+	}
+	if err := b.LoadAll(); err != nil {
+		slog.Error("CreateHostsList: reload failed", "err", err)
+	}
+	slog.Info("CreateHostsList: wrote mappings", "list", listName, "mappings", len(lines))
+	return len(lines), nil
+}
+
+// hostsSource returns canonical mapping lines downloaded from url, or taken
+// from items when url is empty
+func (b *BlocklistManager) hostsSource(ctx context.Context, listName, url string, items []string) ([]string, error) {
+	if url == "" {
+		return hostsItemLines(items), nil
+	}
+	lines, err := fetchLines(ctx, b.fetcher(), url, b.readListMeta(listName).FetchHeader(), readHostsLines)
+	if err != nil {
+		slog.Error("hosts list fetch failed", "list", listName, "url", url, "err", err)
+		return nil, err
+	}
+	return lines, nil
+}
+
+// writeHostsLines adds canonical mapping lines to the hosts list listName, or
+// replaces its contents when replace is set, and reloads. It returns how many
+// mappings were added (or written, when replacing).
+func (b *BlocklistManager) writeHostsLines(listName string, lines []string, replace bool) (int, error) {
+	b.editMu.Lock()
+	defer b.editMu.Unlock()
+	n := len(lines)
+	if !replace {
+		old, err := b.store.ReadRaw(listName)
+		if err != nil {
+			return 0, err
+		}
+		// stored lines are canonical, so a plain comparison finds the new ones
+		seen := make(map[string]struct{}, len(old))
+		for _, l := range old {
+			seen[l] = struct{}{}
+		}
+		n = 0
+		for _, l := range lines {
+			if _, ok := seen[l]; !ok {
+				old = append(old, l)
+				n++
+			}
+		}
+		lines = old
+	}
+	if err := b.store.Write(listName, lines); err != nil {
+		slog.Error("hosts list: write failed", "list", listName, "err", err)
+		return 0, err
+	}
+	if err := b.LoadAll(); err != nil {
+		slog.Error("hosts list: reload failed", "err", err)
+	}
+	slog.Info("hosts list: wrote mappings", "list", listName, "new", n, "replace", replace)
+	return n, nil
+}
+
+// hostsRecordLists returns the lists whose mappings apply to a client: the
+// account's own lists when its MAC is known, otherwise the ones
+// AppConfig.UnidentifiedClients filters it with. Global lists apply to everyone
+// and are added by hostsRecordsFor.
+func (b *BlocklistManager) hostsRecordLists(cfg *Config, macAddress string, am *AccountManager) []string {
+	if macAddress != "" && am != nil {
+		lists, err := am.GetUserBlocklists(macAddress)
+		if err != nil {
+			slog.Error("failed to get user blocklists", "mac", macAddress, "err", err)
+			return nil
+		}
+		return lists
+	}
+	switch cfg.UnidentifiedClients {
+	case unidentifiedBlock, unidentifiedAllow:
+		return nil
+	case unidentifiedDefaultLists:
+		return cfg.DefaultLists
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	lists := make([]string, 0, len(b.hosts))
+	for name := range b.hosts {
+		lists = append(lists, name)
+	}
+	sort.Strings(lists)
+	return lists
+}
+
+// hostsRecordsFor returns the records the first of lists (then the global
+// lists) to map name has for it
+func (b *BlocklistManager) hostsRecordsFor(name string, lists []string) []LocalRecord {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, names := range [][]string{lists, b.global} {
+		for _, listName := range names {
+			if recs := b.hosts[listName][name]; len(recs) > 0 {
+				return recs
+			}
+		}
+	}
+	return nil
+}
+
+// answerHostsRecord answers q from the hosts lists that apply to the client.
+// A mapped name gets its addresses of the asked type, or an empty (NODATA)
+// answer for other types, rather than being filtered or forwarded. It reports
+// false when no such list maps name.
+func (b *BlocklistManager) answerHostsRecord(cfg *Config, q dns.Question, name, macAddress string, am *AccountManager) ([]dns.RR, bool) {
+	recs := b.hostsRecordsFor(name, b.hostsRecordLists(cfg, macAddress, am))
+	if len(recs) == 0 {
+		return nil, false
+	}
+	return addressAnswers(q, q.Name, recs), true
+}
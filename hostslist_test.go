@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestParseHostsMapping(t *testing.T) {
+	tests := []struct {
+		line string
+		want []LocalRecord
+	}{
+		{"192.168.1.5 printer.lan", []LocalRecord{{Name: "printer.lan", Type: "A", Value: "192.168.1.5"}}},
+		{"192.168.1.5\tPrinter.LAN. nas.lan # shared", []LocalRecord{
+			{Name: "printer.lan", Type: "A", Value: "192.168.1.5"},
+			{Name: "nas.lan", Type: "A", Value: "192.168.1.5"},
+		}},
+		{"fd00::5 nas.lan", []LocalRecord{{Name: "nas.lan", Type: "AAAA", Value: "fd00::5"}}},
+		{"127.0.0.1 localhost nas.lan", []LocalRecord{{Name: "nas.lan", Type: "A", Value: "127.0.0.1"}}},
+		{"::1 localhost ip6-localhost ip6-loopback", []LocalRecord{}},
+		{"192.168.1.5 a..lan nas.lan", []LocalRecord{{Name: "nas.lan", Type: "A", Value: "192.168.1.5"}}},
+		{"# 192.168.1.5 printer.lan", nil},
+		{"printer.lan 192.168.1.5", nil},
+		{"192.168.1.5", nil},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		for i := range tt.want {
+			tt.want[i].TTL = defaultLocalRecordTTL
+		}
+		if got := parseHostsMapping(tt.line); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseHostsMapping(%q) = %+v, want %+v", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestHostsItemLines(t *testing.T) {
+	tests := []struct {
+		name  string
+		items []string
+		want  []string
+	}{
+		{"one mapping per line", []string{"192.168.1.5 printer.lan nas.lan"}, []string{"192.168.1.5 printer.lan", "192.168.1.5 nas.lan"}},
+		{"several lines per item", []string{"192.168.1.5 printer.lan\r\nfd00::5 nas.lan\n"}, []string{"192.168.1.5 printer.lan", "fd00::5 nas.lan"}},
+		{"duplicates dropped", []string{"192.168.1.5 printer.lan", "192.168.1.5 PRINTER.lan"}, []string{"192.168.1.5 printer.lan"}},
+		{"same name, another address", []string{"192.168.1.5 nas.lan", "192.168.1.6 nas.lan"}, []string{"192.168.1.5 nas.lan", "192.168.1.6 nas.lan"}},
+		{"nothing usable", []string{"# comment", "127.0.0.1 localhost"}, []string{}},
+	}
+	for _, tt := range tests {
+		if got := hostsItemLines(tt.items); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: hostsItemLines = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestHostsLists(t *testing.T) {
+	const owner, other = "aa:bb:cc:00:11:63", "aa:bb:cc:00:11:64"
+	const ownerIP, otherIP = "192.168.63.1", "192.168.63.2"
+	upstream := startFakeUpstream(t, fakeZone)
+	withConfig(t, func(c *Config) {
+		c.Upstream, c.UpstreamProtocol = upstream, "tcp"
+		c.BlockingMode = "nx"
+	})
+	ipMACCache.SetIPMAC(ownerIP, owner)
+	ipMACCache.SetIPMAC(otherIP, other)
+	bm := newTestBlocklistManager(t, map[string][]string{"lan": {"*.lan"}})
+	am := newTestAccountManager(t, owner, other)
+	hosts := owner + "_hosts"
+	n, err := bm.CreateHostsList(context.Background(), hosts, "", []string{"192.168.1.5 printer.lan nas.lan\nfd00::5 nas.lan"})
+	if err != nil || n != 3 {
+		t.Fatalf("CreateHostsList = %d, %v; want 3 mappings", n, err)
+	}
+	if _, err := bm.CreateHostsList(context.Background(), hosts, "", []string{"192.168.1.6 tv.lan"}); !errors.Is(err, ErrListExists) {
+		t.Errorf("second create: %v, want %v", err, ErrListExists)
+	}
+	if !bm.isHostsList(hosts) || bm.isHostsList("lan") {
+		t.Error("isHostsList doesn't tell the lists apart")
+	}
+	if err := am.AddUserBlocklist(owner, hosts); err != nil {
+		t.Fatal(err)
+	}
+	h, err := newDNSHandler(bm, am)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// mapped names are answered ahead of filtering; the rest is filtered or
+	// forwarded as usual
+	tests := []struct {
+		name, client, qname string
+		qtype               uint16
+		rcode               int
+		answer              []string
+	}{
+		{"A", ownerIP, "nas.lan", dns.TypeA, dns.RcodeSuccess, []string{"192.168.1.5"}},
+		{"AAAA", ownerIP, "nas.lan", dns.TypeAAAA, dns.RcodeSuccess, []string{"fd00::5"}},
+		{"no AAAA mapping", ownerIP, "printer.lan", dns.TypeAAAA, dns.RcodeSuccess, nil},
+		{"other types get NODATA", ownerIP, "nas.lan", dns.TypeMX, dns.RcodeSuccess, nil},
+		{"unmapped name forwarded", ownerIP, "www.example", dns.TypeA, dns.RcodeSuccess, []string{"10.0.0.1"}},
+		{"another account's list", otherIP, "nas.lan", dns.TypeA, dns.RcodeSuccess, []string{"10.0.0.1"}},
+		{"unidentified client", "192.168.63.3", "printer.lan", dns.TypeA, dns.RcodeSuccess, []string{"192.168.1.5"}},
+		{"unidentified client, unmapped", "192.168.63.3", "tv.lan", dns.TypeA, dns.RcodeNameError, nil},
+	}
+	for _, tt := range tests {
+		w := serveQuery(h, tt.client, tt.qname, tt.qtype)
+		if w.msg == nil || w.msg.Rcode != tt.rcode {
+			t.Errorf("%s: reply %v, want rcode %s", tt.name, w.msg, dns.RcodeToString[tt.rcode])
+			continue
+		}
+		var answer []string
+		for _, rr := range w.msg.Answer {
+			switch rr := rr.(type) {
+			case *dns.A:
+				answer = append(answer, rr.A.String())
+			case *dns.AAAA:
+				answer = append(answer, rr.AAAA.String())
+			}
+		}
+		if !reflect.DeepEqual(answer, tt.answer) {
+			t.Errorf("%s: answer %q, want %q", tt.name, answer, tt.answer)
+		}
+	}
+
+	// appending keeps the existing mappings and skips repeats
+	if n, err := bm.AddItemsToList(hosts, []string{"192.168.1.5 nas.lan tv.lan"}, false); err != nil || n != 1 {
+		t.Errorf("AddItemsToList = %d, %v; want 1 new mapping", n, err)
+	}
+	if w := serveQuery(h, ownerIP, "tv.lan", dns.TypeA); w.msg == nil || len(w.msg.Answer) != 1 {
+		t.Errorf("appended mapping not answered: %v", w.msg)
+	}
+
+	// entry-level edits would lose the addresses
+	edits := map[string]error{
+		"AddDomain":    errOf(bm.AddDomain(hosts, "x.lan")),
+		"RemoveDomain": errOf(bm.RemoveDomain(hosts, "nas.lan")),
+		"EditDomain":   bm.EditDomain(hosts, "nas.lan", "x.lan"),
+		"MergeLists":   errOf(bm.MergeLists([]string{hosts, "lan"}, "merged")),
+	}
+	for name, err := range edits {
+		if !errors.Is(err, ErrHostsList) {
+			t.Errorf("%s on a hosts list: %v, want %v", name, err, ErrHostsList)
+		}
+	}
+}
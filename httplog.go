@@ -0,0 +1,73 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// logRequests logs every API request with its status and duration: at DEBUG
+// normally and at INFO for 4xx/5xx so failing frontend calls show up at the
+// default level.
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		// only the auth middleware may set these; drop client-supplied copies so
+		// the logged identity is the session's
+		for _, h := range []string{"X-User-MAC", "X-Is-Guest", "X-Is-Admin"} {
+			r.Header.Del(h)
+		}
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		level := slog.LevelDebug
+		if rec.Status() >= http.StatusBadRequest {
+			level = slog.LevelInfo
+		}
+		attrs := []any{
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", rec.Status(),
+			"duration", time.Since(start),
+			"client", getClientIP(r),
+		}
+		// set by the auth middleware once the session is checked
+		if mac := r.Header.Get("X-User-MAC"); mac != "" {
+			attrs = append(attrs, "mac", mac)
+		}
+		slog.Log(r.Context(), level, "http request", attrs...)
+	})
+}
+
+// statusRecorder remembers the status code a handler wrote
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusRecorder) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusRecorder) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *statusRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Status returns the written status, or 200 if the handler wrote nothing
+func (w *statusRecorder) Status() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
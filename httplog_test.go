@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLogRequests(t *testing.T) {
+	prev := slog.Default()
+	t.Cleanup(func() { slog.SetDefault(prev) })
+	var buf bytes.Buffer
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+
+	tests := []struct {
+		name      string
+		handler   http.HandlerFunc
+		spoofMAC  string
+		wantLevel string
+		wantCode  int
+		wantMAC   string
+	}{
+		{"writes nothing", func(w http.ResponseWriter, r *http.Request) {}, "", "DEBUG", http.StatusOK, ""},
+		{"body only", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("ok")) }, "", "DEBUG", http.StatusOK, ""},
+		{"not found", http.NotFound, "", "INFO", http.StatusNotFound, ""},
+		{"first status wins", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.WriteHeader(http.StatusOK)
+		}, "", "INFO", http.StatusInternalServerError, ""},
+		{"client-supplied identity dropped", func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("X-User-MAC") != "" {
+				w.WriteHeader(http.StatusTeapot)
+			}
+		}, "aa:bb:cc:dd:ee:ff", "DEBUG", http.StatusOK, ""},
+		{"session identity logged", func(w http.ResponseWriter, r *http.Request) {
+			r.Header.Set("X-User-MAC", "aa:bb:cc:00:11:40")
+		}, "", "DEBUG", http.StatusOK, "aa:bb:cc:00:11:40"},
+	}
+	for _, tt := range tests {
+		buf.Reset()
+		r := httptest.NewRequest(http.MethodGet, "/lists?x=1", nil)
+		if tt.spoofMAC != "" {
+			r.Header.Set("X-User-MAC", tt.spoofMAC)
+			r.Header.Set("X-Is-Admin", "true")
+		}
+		w := httptest.NewRecorder()
+		logRequests(tt.handler).ServeHTTP(w, r)
+		if w.Code != tt.wantCode {
+			t.Errorf("%s: response status %d, want %d", tt.name, w.Code, tt.wantCode)
+		}
+		var line struct {
+			Level, Msg, Method, Path, Client, MAC string
+			Status                                int
+		}
+		if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+			t.Fatalf("%s: log %q: %v", tt.name, buf.String(), err)
+		}
+		if line.Level != tt.wantLevel || line.Msg != "http request" || line.Method != http.MethodGet || line.Path != "/lists" ||
+			line.Status != tt.wantCode || line.MAC != tt.wantMAC || line.Client != "192.0.2.1" {
+			t.Errorf("%s: logged %s", tt.name, buf.String())
+		}
+	}
+}
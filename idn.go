@@ -0,0 +1,77 @@
+package main
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/idna"
+)
+
+// canonicalDomain converts internationalized labels to their ASCII (punycode)
+// form so "bücher.example" and "xn--bcher-kva.example" compare equal. It works
+// label by label so wildcard labels like "*" pass through untouched. A label
+// that can't be converted is kept as-is rather than rejected.
+func canonicalDomain(s string) string {
+	if isASCII(s) {
+		return s
+	}
+	labels := strings.Split(s, ".")
+	for i, l := range labels {
+		if isASCII(l) || !utf8.ValidString(l) {
+			continue
+		}
+		if a, err := idna.Lookup.ToASCII(l); err == nil {
+			labels[i] = a
+		} else if a, err := idna.Punycode.ToASCII(strings.ToLower(l)); err == nil {
+			labels[i] = a
+		}
+	}
+	return strings.Join(labels, ".")
+}
+
+// decodeDNSName undoes the \DDD escapes the dns package uses for non-printable
+// bytes in a question name, so a client sending raw UTF-8 labels can still be
+// canonicalized. Names that don't decode to valid UTF-8 are returned unchanged.
+func decodeDNSName(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c != '\\' || i+1 >= len(s) {
+			b.WriteByte(c)
+			continue
+		}
+		if i+3 < len(s) && isDigit(s[i+1]) && isDigit(s[i+2]) && isDigit(s[i+3]) {
+			v := int(s[i+1]-'0')*100 + int(s[i+2]-'0')*10 + int(s[i+3]-'0')
+			if v > 255 {
+				return s
+			}
+			b.WriteByte(byte(v))
+			i += 3
+			continue
+		}
+		// escaped literal such as \. inside a label; keep it escaped
+		b.WriteByte(c)
+		b.WriteByte(s[i+1])
+		i++
+	}
+	out := b.String()
+	if !utf8.ValidString(out) {
+		return s
+	}
+	return out
+}
+
+func isDigit(c byte) bool { return c >= '0' && c <= '9' }
+
+// isASCII reports whether s contains only ASCII characters
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestCanonicalDomain(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"example.com", "example.com"},
+		{"bücher.example", "xn--bcher-kva.example"},
+		{"xn--bcher-kva.example", "xn--bcher-kva.example"},
+		{"*.bücher.example", "*.xn--bcher-kva.example"},
+		{"www.例え.jp", "www.xn--r8jz45g.jp"},
+		{"bad\xffname.example", "bad\xffname.example"},
+	}
+	for _, tt := range tests {
+		if got := canonicalDomain(tt.in); got != tt.want {
+			t.Errorf("canonicalDomain(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestDecodeDNSName(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"example.com.", "example.com."},
+		{`b\195\188cher.example.`, "bücher.example."},
+		{`a\.b.example.`, `a\.b.example.`},
+		{`bad\255.example.`, `bad\255.example.`},
+		{`big\999.example.`, `big\999.example.`},
+	}
+	for _, tt := range tests {
+		if got := decodeDNSName(tt.in); got != tt.want {
+			t.Errorf("decodeDNSName(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestIDNMatching(t *testing.T) {
+	tests := []struct {
+		name  string
+		entry string
+		query string
+	}{
+		{"unicode query, punycode entry", "xn--bcher-kva.example", "bücher.example"},
+		{"punycode query, unicode entry", "bücher.example", "xn--bcher-kva.example"},
+		{"unicode both", "bücher.example", "BÜCHER.example"},
+		{"wildcard", "*.bücher.example", "shop.xn--bcher-kva.example"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bm := newTestBlocklistManager(t, map[string][]string{"idn": {tt.entry}})
+			if !bm.IsBlocked(tt.query) {
+				t.Errorf("IsBlocked(%q) with entry %q = false", tt.query, tt.entry)
+			}
+		})
+	}
+}
+
+func TestDNSHandlerMatchesRawUnicodeQuery(t *testing.T) {
+	withConfig(t, func(c *Config) { c.BlockingMode = "nx" })
+	h := newTestDNSHandler(t, map[string][]string{"idn": {"xn--bcher-kva.example"}})
+	tests := []struct {
+		name  string
+		qname string
+	}{
+		{"punycode", "xn--bcher-kva.example"},
+		{"raw UTF-8 labels", "bücher.example"},
+	}
+	for _, tt := range tests {
+		// round-trip through the wire format, which escapes non-ASCII bytes as \DDD
+		q := new(dns.Msg)
+		q.SetQuestion(dns.Fqdn(tt.qname), dns.TypeA)
+		wire, err := q.Pack()
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := new(dns.Msg)
+		if err := r.Unpack(wire); err != nil {
+			t.Fatal(err)
+		}
+		w := &fakeResponseWriter{remote: &net.UDPAddr{IP: net.ParseIP("192.168.1.10"), Port: 40000}}
+		h(w, r)
+		if w.msg == nil || w.msg.Rcode != dns.RcodeNameError {
+			t.Errorf("%s: reply %v, want NXDOMAIN", tt.name, w.msg)
+		}
+	}
+}
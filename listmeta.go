@@ -0,0 +1,300 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/textproto"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// ListMeta is per-list metadata stored next to the list as <name>.meta.json.
+// Lists without a sidecar are enabled and uncategorized.
+type ListMeta struct {
+	Category string `json:"category,omitempty"`
+	Enabled  bool   `json:"enabled"`
+	// Global lists apply to every user, not just the account that owns them
+	Global bool `json:"global,omitempty"`
+	// MatchSubdomains overrides AppConfig.MatchSubdomains for this list when set
+	MatchSubdomains *bool `json:"match_subdomains,omitempty"`
+	// BlockingMode and BlockPageIP override AppConfig for queries this list
+	// blocks, e.g. NXDOMAIN for malware while ads go to the block page
+	BlockingMode string `json:"blocking_mode,omitempty"`
+	BlockPageIP  string `json:"block_page_ip,omitempty"`
+	// QTypes limits the list to queries of these record types (e.g. TXT,
+	// HTTPS), leaving other types of the same names alone; empty blocks all types
+	QTypes []string `json:"qtypes,omitempty"`
+	// FetchHeaders are sent when the list is downloaded or refreshed from a
+	// URL, e.g. Authorization for a private feed. The API only ever shows
+	// their names, and they aren't sent on to a redirect's other origin.
+	FetchHeaders map[string]string `json:"fetch_headers,omitempty"`
+	// Type is empty for a blocklist, or "hosts" for a list of "IP name"
+	// mappings answered as local records. It is set when the list is created.
+	Type string `json:"type,omitempty"`
+}
+
+// redactedHeaderValue replaces fetch header values in API responses
+const redactedHeaderValue = "********"
+
+// Redacted returns m with fetch header values masked, for serving over the API
+func (m ListMeta) Redacted() ListMeta {
+	if len(m.FetchHeaders) == 0 {
+		return m
+	}
+	masked := make(map[string]string, len(m.FetchHeaders))
+	for k := range m.FetchHeaders {
+		masked[k] = redactedHeaderValue
+	}
+	m.FetchHeaders = masked
+	return m
+}
+
+// FetchHeader returns FetchHeaders as request headers, or nil when there are none
+func (m ListMeta) FetchHeader() http.Header {
+	if len(m.FetchHeaders) == 0 {
+		return nil
+	}
+	h := make(http.Header, len(m.FetchHeaders))
+	for k, v := range m.FetchHeaders {
+		h.Set(k, v)
+	}
+	return h
+}
+
+// reservedFetchHeaders are managed by the HTTP client and can't be overridden
+var reservedFetchHeaders = []string{"Host", "Content-Length", "Transfer-Encoding", "Connection", "Te", "Upgrade", "Trailer"}
+
+// normalizeFetchHeaders canonicalizes header names for ListMeta.FetchHeaders
+// and rejects names and values that can't go in a request. An empty map
+// clears the headers.
+func normalizeFetchHeaders(headers map[string]string) (map[string]string, error) {
+	if len(headers) == 0 {
+		return nil, nil
+	}
+	out := make(map[string]string, len(headers))
+	for k, v := range headers {
+		name := strings.TrimSpace(k)
+		if name == "" || strings.IndexFunc(name, func(r rune) bool {
+			return r <= ' ' || r >= 0x7f || strings.ContainsRune(`()<>@,;:\"/[]?={}`, r)
+		}) >= 0 {
+			return nil, fmt.Errorf("invalid fetch header name %q", k)
+		}
+		name = textproto.CanonicalMIMEHeaderKey(name)
+		if slices.Contains(reservedFetchHeaders, name) {
+			return nil, fmt.Errorf("fetch header %s can't be set", name)
+		}
+		if strings.ContainsAny(v, "\r\n\x00") {
+			return nil, fmt.Errorf("invalid value for fetch header %s", name)
+		}
+		out[name] = strings.TrimSpace(v)
+	}
+	return out, nil
+}
+
+// MatchesSubdomains reports whether plain entries in the list also block their subdomains
+func (m ListMeta) MatchesSubdomains(cfg *Config) bool {
+	if m.MatchSubdomains != nil {
+		return *m.MatchSubdomains
+	}
+	return cfg.MatchSubdomains
+}
+
+// QueryTypes returns the record types the list is limited to, or nil when it
+// blocks every type. Unknown type names are skipped.
+func (m ListMeta) QueryTypes() []uint16 {
+	if len(m.QTypes) == 0 {
+		return nil
+	}
+	types := make([]uint16, 0, len(m.QTypes))
+	for _, name := range m.QTypes {
+		if t, ok := dns.StringToType[strings.ToUpper(name)]; ok {
+			types = append(types, t)
+		}
+	}
+	return types
+}
+
+// normalizeQTypes uppercases and checks record type names for ListMeta.QTypes
+func normalizeQTypes(names []string) ([]string, error) {
+	out := make([]string, 0, len(names))
+	for _, name := range names {
+		name = strings.ToUpper(strings.TrimSpace(name))
+		if _, ok := dns.StringToType[name]; !ok || name == "ANY" {
+			return nil, fmt.Errorf("invalid qtype %q", name)
+		}
+		if !slices.Contains(out, name) {
+			out = append(out, name)
+		}
+	}
+	return out, nil
+}
+
+// validateBlockingOverride checks a list's blocking mode and block page IP overrides
+func validateBlockingOverride(mode, ip string) error {
+	switch mode {
+	case "", "redirect", "null", "nx":
+	default:
+		return fmt.Errorf("invalid blocking_mode %q: must be redirect, null or nx", mode)
+	}
+	// redirect answers are A records, so the block page needs an IPv4 address
+	if ip != "" && net.ParseIP(ip).To4() == nil {
+		return fmt.Errorf("invalid block_page_ip %q: must be an IPv4 address", ip)
+	}
+	return nil
+}
+
+// BlockingFor returns the blocking mode and block page IP to answer with when
+// listName blocked a query: the list's overrides, else cfg
+func (b *BlocklistManager) BlockingFor(cfg *Config, listName string) (mode, blockPageIP string) {
+	mode, blockPageIP = cfg.BlockingMode, cfg.BlockPageIP
+	b.mu.RLock()
+	m := b.meta[listName]
+	b.mu.RUnlock()
+	if m.BlockingMode != "" {
+		mode = m.BlockingMode
+	}
+	if m.BlockPageIP != "" {
+		blockPageIP = m.BlockPageIP
+	}
+	return mode, blockPageIP
+}
+
+// CategorySummary describes a category and the lists tagged with it
+type CategorySummary struct {
+	Name    string   `json:"name"`
+	Lists   []string `json:"lists"`
+	Enabled int      `json:"enabled"` // number of member lists currently enabled
+	Entries int      `json:"entries"` // total patterns across member lists
+}
+
+// readListMeta loads the sidecar for a list, defaulting when it is missing or unreadable
+func (b *BlocklistManager) readListMeta(listName string) ListMeta {
+	meta := ListMeta{Enabled: true}
+	data, err := b.store.ReadMeta(listName)
+	if err != nil {
+		return meta
+	}
+	_ = json.Unmarshal(data, &meta)
+	meta.Category = normalizeCategory(meta.Category)
+	return meta
+}
+
+// writeListMeta stores the sidecar for a list. Sidecars holding fetch headers
+// (often credentials) are only readable by the owner.
+func (b *BlocklistManager) writeListMeta(listName string, meta ListMeta) error {
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	return b.store.WriteMeta(listName, append(data, '\n'), len(meta.FetchHeaders) > 0)
+}
+
+// normalizeCategory lowercases and trims a category name
+func normalizeCategory(c string) string {
+	return strings.ToLower(strings.TrimSpace(c))
+}
+
+// GetListMeta returns the metadata for a loaded list
+func (b *BlocklistManager) GetListMeta(listName string) (ListMeta, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if _, ok := b.lists[listName]; !ok {
+		return ListMeta{}, os.ErrNotExist
+	}
+	return b.meta[listName], nil
+}
+
+// SetListMeta stores metadata for a loaded list and reloads so the enabled flag takes effect
+func (b *BlocklistManager) SetListMeta(listName string, meta ListMeta) error {
+	b.mu.RLock()
+	_, ok := b.lists[listName]
+	b.mu.RUnlock()
+	if !ok {
+		return os.ErrNotExist
+	}
+	meta.Category = normalizeCategory(meta.Category)
+	if err := b.writeListMeta(listName, meta); err != nil {
+		return err
+	}
+	return b.LoadAll()
+}
+
+// SetCategoryEnabled flips the enabled flag on every list in lists tagged with
+// category, then reloads once. It returns the lists that were changed.
+func (b *BlocklistManager) SetCategoryEnabled(lists []string, category string, enabled bool) ([]string, error) {
+	category = normalizeCategory(category)
+	if category == "" {
+		return nil, errors.New("missing category")
+	}
+
+	b.mu.RLock()
+	var members []string
+	for _, name := range lists {
+		if m, ok := b.meta[name]; ok && m.Category == category {
+			members = append(members, name)
+		}
+	}
+	b.mu.RUnlock()
+	if len(members) == 0 {
+		return nil, os.ErrNotExist
+	}
+
+	changed := []string{}
+	for _, name := range members {
+		meta := b.readListMeta(name)
+		if meta.Enabled == enabled {
+			continue
+		}
+		meta.Enabled = enabled
+		if err := b.writeListMeta(name, meta); err != nil {
+			return changed, err
+		}
+		changed = append(changed, name)
+	}
+	if len(changed) == 0 {
+		return changed, nil
+	}
+	return changed, b.LoadAll()
+}
+
+// Categories summarizes the categories used by the given lists. Uncategorized lists are omitted.
+func (b *BlocklistManager) Categories(lists []string) []CategorySummary {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	byName := make(map[string]*CategorySummary)
+	for _, name := range lists {
+		patterns, ok := b.lists[name]
+		if !ok {
+			continue
+		}
+		m := b.meta[name]
+		if m.Category == "" {
+			continue
+		}
+		cs, ok := byName[m.Category]
+		if !ok {
+			cs = &CategorySummary{Name: m.Category, Lists: []string{}}
+			byName[m.Category] = cs
+		}
+		cs.Lists = append(cs.Lists, name)
+		cs.Entries += len(patterns)
+		if m.Enabled {
+			cs.Enabled++
+		}
+	}
+
+	out := make([]CategorySummary, 0, len(byName))
+	for _, cs := range byName {
+		sort.Strings(cs.Lists)
+		out = append(out, *cs)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
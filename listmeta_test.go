@@ -0,0 +1,423 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestCategoryToggles(t *testing.T) {
+	newManager := func(t *testing.T) *BlocklistManager {
+		bm := newTestBlocklistManager(t, map[string][]string{
+			"ads1":  {"ads1.example"},
+			"ads2":  {"ads2.example", "more-ads.example"},
+			"mal":   {"mal.example"},
+			"plain": {"plain.example"},
+		})
+		for name, category := range map[string]string{"ads1": " Ads ", "ads2": "ADS", "mal": "malware"} {
+			if err := bm.SetListMeta(name, ListMeta{Category: category, Enabled: true}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return bm
+	}
+	all := []string{"ads1", "ads2", "mal", "plain"}
+	tests := []struct {
+		name        string
+		lists       []string // the caller's lists
+		category    string
+		enabled     bool
+		wantChanged []string
+		wantErr     error
+		wantBlocked map[string]bool
+	}{
+		{"disable a category", all, "ads", false, []string{"ads1", "ads2"}, nil,
+			map[string]bool{"ads1.example": false, "more-ads.example": false, "mal.example": true, "plain.example": true}},
+		{"only the caller's lists", []string{"ads1", "mal"}, "ADS", false, []string{"ads1"}, nil,
+			map[string]bool{"ads1.example": false, "ads2.example": true}},
+		{"already enabled", all, "malware", true, []string{}, nil,
+			map[string]bool{"mal.example": true}},
+		{"unknown category", all, "social", false, nil, os.ErrNotExist, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bm := newManager(t)
+			changed, err := bm.SetCategoryEnabled(tt.lists, tt.category, tt.enabled)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(changed, tt.wantChanged) {
+				t.Errorf("changed = %v, want %v", changed, tt.wantChanged)
+			}
+			for domain, want := range tt.wantBlocked {
+				if _, got := bm.Match(domain); got != want {
+					t.Errorf("%s blocked = %t, want %t", domain, got, want)
+				}
+			}
+		})
+	}
+
+	bm := newManager(t)
+	if _, err := bm.SetCategoryEnabled(all, "ads", false); err != nil {
+		t.Fatal(err)
+	}
+	want := []CategorySummary{
+		{Name: "ads", Lists: []string{"ads1", "ads2"}, Enabled: 0, Entries: 3},
+		{Name: "malware", Lists: []string{"mal"}, Enabled: 1, Entries: 1},
+	}
+	if got := bm.Categories(all); !reflect.DeepEqual(got, want) {
+		t.Errorf("Categories = %+v, want %+v", got, want)
+	}
+}
+
+func TestValidateBlockingOverride(t *testing.T) {
+	tests := []struct {
+		mode, ip string
+		ok       bool
+	}{
+		{"", "", true},
+		{"nx", "", true},
+		{"null", "", true},
+		{"redirect", "192.168.1.2", true},
+		{"", "10.0.0.1", true},
+		{"NX", "", false},
+		{"refuse", "", false},
+		{"redirect", "fd00::1", false},
+		{"redirect", "blockpage.lan", false},
+	}
+	for _, tt := range tests {
+		if err := validateBlockingOverride(tt.mode, tt.ip); (err == nil) != tt.ok {
+			t.Errorf("validateBlockingOverride(%q, %q) = %v, want ok %v", tt.mode, tt.ip, err, tt.ok)
+		}
+	}
+}
+
+func TestBlockingOverridePerList(t *testing.T) {
+	withConfig(t, func(c *Config) { c.BlockingMode, c.BlockPageIP = "redirect", "192.168.1.2" })
+	bm := newTestBlocklistManager(t, map[string][]string{
+		"ads":      {"ads.example"},
+		"malware":  {"mal.example"},
+		"sinkhole": {"sink.example"},
+	})
+	for name, meta := range map[string]ListMeta{
+		"malware":  {Enabled: true, BlockingMode: "nx"},
+		"sinkhole": {Enabled: true, BlockPageIP: "10.9.9.9"},
+	} {
+		if err := bm.SetListMeta(name, meta); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h, err := newDNSHandler(bm, newTestAccountManager(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		list, domain     string
+		wantMode, wantIP string
+		wantRcode        int
+		wantAnswer       string // A record; "" for none
+	}{
+		{"ads", "ads.example", "redirect", "192.168.1.2", dns.RcodeSuccess, "192.168.1.2"},
+		{"malware", "mal.example", "nx", "192.168.1.2", dns.RcodeNameError, ""},
+		{"sinkhole", "sink.example", "redirect", "10.9.9.9", dns.RcodeSuccess, "10.9.9.9"},
+		{"no-such-list", "", "redirect", "192.168.1.2", 0, ""},
+	}
+	for _, tt := range tests {
+		if mode, ip := bm.BlockingFor(AppConfig(), tt.list); mode != tt.wantMode || ip != tt.wantIP {
+			t.Errorf("BlockingFor(%q) = %q, %q; want %q, %q", tt.list, mode, ip, tt.wantMode, tt.wantIP)
+		}
+		if tt.domain == "" {
+			continue
+		}
+		w := serveQuery(h, "127.0.0.1", tt.domain, dns.TypeA)
+		if w.msg == nil || w.msg.Rcode != tt.wantRcode {
+			t.Fatalf("%s: reply %v, want %s", tt.domain, w.msg, dns.RcodeToString[tt.wantRcode])
+		}
+		var got string
+		if len(w.msg.Answer) == 1 {
+			if a, ok := w.msg.Answer[0].(*dns.A); ok {
+				got = a.A.String()
+			}
+		}
+		if got != tt.wantAnswer || len(w.msg.Answer) > 1 {
+			t.Errorf("%s: answers %v, want %q", tt.domain, w.msg.Answer, tt.wantAnswer)
+		}
+	}
+}
+
+func TestListMetaBlockingOverrideAPI(t *testing.T) {
+	const mac = "aa:bb:cc:00:11:31"
+	bm := newTestBlocklistManager(t, map[string][]string{mac + "_ads": {"ads.example"}})
+	am := newTestAccountManager(t, mac)
+	// each step runs against the metadata the previous one left
+	steps := []struct {
+		body             string
+		status           int
+		wantMode, wantIP string
+	}{
+		{`{"blocking_mode":" nx "}`, http.StatusOK, "nx", ""},
+		{`{"block_page_ip":"10.1.2.3"}`, http.StatusOK, "nx", "10.1.2.3"},
+		{`{"blocking_mode":"sideways"}`, http.StatusBadRequest, "nx", "10.1.2.3"},
+		{`{"block_page_ip":"fd00::1"}`, http.StatusBadRequest, "nx", "10.1.2.3"},
+		{`{"enabled":true}`, http.StatusOK, "nx", "10.1.2.3"},
+		{`{"blocking_mode":"","block_page_ip":""}`, http.StatusOK, "", ""},
+	}
+	for _, st := range steps {
+		r := asUser(httptest.NewRequest(http.MethodPost, "/lists/ads/meta", strings.NewReader(st.body)), mac, false, false)
+		w := httptest.NewRecorder()
+		handleLists(w, r, bm, am)
+		if w.Code != st.status {
+			t.Fatalf("%s: status %d, want %d: %s", st.body, w.Code, st.status, w.Body)
+		}
+		meta, err := bm.GetListMeta(mac + "_ads")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if meta.BlockingMode != st.wantMode || meta.BlockPageIP != st.wantIP {
+			t.Errorf("%s: meta has mode %q, ip %q; want %q, %q", st.body, meta.BlockingMode, meta.BlockPageIP, st.wantMode, st.wantIP)
+		}
+	}
+}
+
+func TestNormalizeQTypes(t *testing.T) {
+	tests := []struct {
+		in   []string
+		want []string // nil for an error
+	}{
+		{[]string{}, []string{}},
+		{[]string{" txt", "HTTPS"}, []string{"TXT", "HTTPS"}},
+		{[]string{"txt", "TXT"}, []string{"TXT"}},
+		{[]string{"A", "bogus"}, nil},
+		{[]string{"ANY"}, nil},
+	}
+	for _, tt := range tests {
+		got, err := normalizeQTypes(tt.in)
+		if (err == nil) != (tt.want != nil) || (err == nil && !reflect.DeepEqual(got, tt.want)) {
+			t.Errorf("normalizeQTypes(%q) = %q, %v; want %q", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestListMetaQueryTypes(t *testing.T) {
+	tests := []struct {
+		qtypes []string
+		want   []uint16
+	}{
+		{nil, nil},
+		{[]string{"TXT"}, []uint16{dns.TypeTXT}},
+		{[]string{"https", "SVCB"}, []uint16{dns.TypeHTTPS, dns.TypeSVCB}},
+		{[]string{"bogus", "MX"}, []uint16{dns.TypeMX}},
+	}
+	for _, tt := range tests {
+		if got := (ListMeta{QTypes: tt.qtypes}).QueryTypes(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("QueryTypes for %q = %v, want %v", tt.qtypes, got, tt.want)
+		}
+	}
+}
+
+func TestQTypeScopedLists(t *testing.T) {
+	const mac = "aa:bb:cc:00:11:44"
+	upstream := startFakeUpstream(t, fakeZone)
+	withConfig(t, func(c *Config) { c.Upstream, c.UpstreamProtocol = upstream, "tcp" })
+	bm := newTestBlocklistManager(t, map[string][]string{
+		"ads":         {"ads.example"},
+		"txt":         {"track.example"},
+		mac + "_mine": {"mine.example"},
+	})
+	for name, meta := range map[string]ListMeta{
+		"txt":         {Enabled: true, QTypes: []string{"TXT", "HTTPS"}, BlockingMode: "nx"},
+		mac + "_mine": {Enabled: true, QTypes: []string{"TXT"}},
+	} {
+		if err := bm.SetListMeta(name, meta); err != nil {
+			t.Fatal(err)
+		}
+	}
+	am := newTestAccountManager(t, mac)
+	if err := am.AddUserBlocklist(mac, mac+"_mine"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		domain  string
+		qtype   uint16
+		blocked bool
+	}{
+		{"ads.example", dns.TypeA, true}, // unscoped lists block every type
+		{"ads.example", dns.TypeTXT, true},
+		{"track.example", dns.TypeTXT, true},
+		{"track.example", dns.TypeHTTPS, true},
+		{"track.example", dns.TypeA, false},
+		{"track.example", 0, true},          // 0 ignores scoping
+		{"mine.example", dns.TypeTXT, true}, // the user's own list
+		{"mine.example", dns.TypeAAAA, false},
+	}
+	for _, tt := range tests {
+		name := tt.domain + " " + dns.TypeToString[tt.qtype]
+		if tt.domain == "mine.example" {
+			if _, got := bm.MatchForUser(tt.domain, tt.qtype, mac, am); got != tt.blocked {
+				t.Errorf("MatchForUser(%s) = %v, want %v", name, got, tt.blocked)
+			}
+		} else if _, got := bm.MatchType(tt.domain, tt.qtype); got != tt.blocked {
+			t.Errorf("MatchType(%s) = %v, want %v", name, got, tt.blocked)
+		}
+	}
+
+	// over DNS the scoped list answers NXDOMAIN; other types resolve upstream
+	h, err := newDNSHandler(bm, am)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for qtype, want := range map[uint16]int{dns.TypeTXT: dns.RcodeNameError, dns.TypeA: dns.RcodeSuccess} {
+		w := serveQuery(h, "127.0.0.1", "track.example", qtype)
+		if w.msg == nil || w.msg.Rcode != want || (want == dns.RcodeSuccess && len(w.msg.Answer) != 1) {
+			t.Errorf("track.example %s: reply %v, want %s", dns.TypeToString[qtype], w.msg, dns.RcodeToString[want])
+		}
+	}
+}
+
+func TestListMetaQTypesAPI(t *testing.T) {
+	const mac = "aa:bb:cc:00:11:45"
+	bm := newTestBlocklistManager(t, map[string][]string{mac + "_ads": {"ads.example"}})
+	am := newTestAccountManager(t, mac)
+	// each step runs against the metadata the previous one left
+	steps := []struct {
+		body   string
+		status int
+		want   []string
+	}{
+		{`{"qtypes":["txt"," https ","TXT"]}`, http.StatusOK, []string{"TXT", "HTTPS"}},
+		{`{"enabled":true}`, http.StatusOK, []string{"TXT", "HTTPS"}},
+		{`{"qtypes":["NOPE"]}`, http.StatusBadRequest, []string{"TXT", "HTTPS"}},
+		{`{"qtypes":["ANY"]}`, http.StatusBadRequest, []string{"TXT", "HTTPS"}},
+		{`{"qtypes":[]}`, http.StatusOK, nil},
+	}
+	for _, st := range steps {
+		r := asUser(httptest.NewRequest(http.MethodPost, "/lists/ads/meta", strings.NewReader(st.body)), mac, false, false)
+		w := httptest.NewRecorder()
+		handleLists(w, r, bm, am)
+		if w.Code != st.status {
+			t.Fatalf("%s: status %d, want %d: %s", st.body, w.Code, st.status, w.Body)
+		}
+		meta, err := bm.GetListMeta(mac + "_ads")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(meta.QTypes) != len(st.want) || (len(st.want) > 0 && !reflect.DeepEqual(meta.QTypes, st.want)) {
+			t.Errorf("%s: qtypes %q, want %q", st.body, meta.QTypes, st.want)
+		}
+	}
+}
+
+func TestNormalizeFetchHeaders(t *testing.T) {
+	tests := []struct {
+		in      map[string]string
+		want    map[string]string
+		wantErr string
+	}{
+		{nil, nil, ""},
+		{map[string]string{}, nil, ""}, // clears
+		{map[string]string{" authorization ": " Bearer t0ken ", "x-api-key": "k"}, map[string]string{"Authorization": "Bearer t0ken", "X-Api-Key": "k"}, ""},
+		{map[string]string{"": "v"}, nil, "invalid fetch header name"},
+		{map[string]string{"X Key": "v"}, nil, "invalid fetch header name"},
+		{map[string]string{"X-Key:": "v"}, nil, "invalid fetch header name"},
+		{map[string]string{"host": "evil.example"}, nil, "can't be set"},
+		{map[string]string{"Transfer-Encoding": "chunked"}, nil, "can't be set"},
+		{map[string]string{"X-Key": "a\r\nHost: evil.example"}, nil, "invalid value"},
+	}
+	for _, tt := range tests {
+		got, err := normalizeFetchHeaders(tt.in)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("normalizeFetchHeaders(%q) = %v, want an error containing %q", tt.in, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("normalizeFetchHeaders(%q) = %q, %v; want %q", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestListMetaFetchHeaders(t *testing.T) {
+	meta := ListMeta{Enabled: true, FetchHeaders: map[string]string{"Authorization": "Bearer s3cret"}}
+	if got := meta.FetchHeader().Get("Authorization"); got != "Bearer s3cret" {
+		t.Errorf("FetchHeader Authorization = %q", got)
+	}
+	if red := meta.Redacted(); red.FetchHeaders["Authorization"] != redactedHeaderValue || meta.FetchHeaders["Authorization"] != "Bearer s3cret" {
+		t.Errorf("Redacted = %v, original now %v", red.FetchHeaders, meta.FetchHeaders)
+	}
+	if (ListMeta{}).FetchHeader() != nil || (ListMeta{}).Redacted().FetchHeaders != nil {
+		t.Error("a list without fetch headers gained some")
+	}
+
+	// sidecars holding headers are private to the owner
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "feed.txt"), []byte("a.example\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	bm, err := NewBlocklistManager(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, st := range []struct {
+		meta ListMeta
+		perm os.FileMode
+	}{
+		{meta, 0o600},
+		{ListMeta{Enabled: true}, 0o644},
+	} {
+		if err := bm.SetListMeta("feed", st.meta); err != nil {
+			t.Fatal(err)
+		}
+		fi, err := os.Stat(filepath.Join(dir, "feed.meta.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Mode().Perm() != st.perm {
+			t.Errorf("sidecar with %d headers has mode %v, want %v", len(st.meta.FetchHeaders), fi.Mode().Perm(), st.perm)
+		}
+	}
+}
+
+func TestListMetaFetchHeadersAPI(t *testing.T) {
+	const mac = "aa:bb:cc:00:11:50"
+	bm := newTestBlocklistManager(t, map[string][]string{mac + "_feed": {"a.example"}})
+	am := newTestAccountManager(t, mac)
+	// each step runs against the metadata the previous one left
+	steps := []struct {
+		method, body string
+		status       int
+		want         map[string]string // stored headers
+	}{
+		{http.MethodPost, `{"fetch_headers":{"authorization":"Bearer s3cret"}}`, http.StatusOK, map[string]string{"Authorization": "Bearer s3cret"}},
+		{http.MethodGet, "", http.StatusOK, map[string]string{"Authorization": "Bearer s3cret"}},
+		{http.MethodPost, `{"enabled":true}`, http.StatusOK, map[string]string{"Authorization": "Bearer s3cret"}},
+		{http.MethodPost, `{"fetch_headers":{"Host":"evil.example"}}`, http.StatusBadRequest, map[string]string{"Authorization": "Bearer s3cret"}},
+		{http.MethodPost, `{"fetch_headers":{}}`, http.StatusOK, nil},
+	}
+	for _, st := range steps {
+		r := asUser(httptest.NewRequest(st.method, "/lists/feed/meta", strings.NewReader(st.body)), mac, false, false)
+		w := httptest.NewRecorder()
+		handleLists(w, r, bm, am)
+		if w.Code != st.status {
+			t.Fatalf("%s %s: status %d, want %d: %s", st.method, st.body, w.Code, st.status, w.Body)
+		}
+		if strings.Contains(w.Body.String(), "s3cret") {
+			t.Errorf("%s %s: response leaks the header value: %s", st.method, st.body, w.Body)
+		}
+		meta, err := bm.GetListMeta(mac + "_feed")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(meta.FetchHeaders, st.want) {
+			t.Errorf("%s %s: stored headers %q, want %q", st.method, st.body, meta.FetchHeaders, st.want)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrInvalidListName is returned for list names that can't be used as a file name
+var ErrInvalidListName = errors.New("invalid list name")
+
+// maxListNameLength caps a list name as the user sees it; the MAC prefix of
+// per-user lists and the .txt extension come on top
+const maxListNameLength = 64
+
+// slugifyListName turns a user-supplied list name into the one its file is
+// stored under: surrounding space is trimmed and inner runs of whitespace become
+// '-'. What remains must be ASCII letters, digits, '.', '_' and '-', not start
+// with '.' and fit maxListNameLength, so a name can't leave the blocklist
+// directory, hide as a dotfile or pose as another user's list (those carry a
+// MAC prefix, which has colons). Anything else is rejected rather than
+// rewritten, so two different names never end up as the same file.
+func slugifyListName(name string) (string, error) {
+	slug := strings.Join(strings.Fields(name), "-")
+	switch {
+	case slug == "":
+		return "", fmt.Errorf("%w: empty", ErrInvalidListName)
+	case len(slug) > maxListNameLength:
+		return "", fmt.Errorf("%w %q: longer than %d characters", ErrInvalidListName, name, maxListNameLength)
+	case slug[0] == '.':
+		return "", fmt.Errorf("%w %q: must not start with '.'", ErrInvalidListName, name)
+	}
+	for _, c := range slug {
+		if !isListNameChar(c) {
+			return "", fmt.Errorf("%w %q: only letters, digits, '.', '_' and '-' are allowed", ErrInvalidListName, name)
+		}
+	}
+	return slug, nil
+}
+
+func isListNameChar(c rune) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '.' || c == '_' || c == '-'
+}
+
+// slugFromInferred makes a name derived from a URL usable as a list name by
+// replacing the characters slugifyListName rejects with '-'. Unlike a name the
+// user typed there is nothing to ask about, so it is rewritten instead.
+func slugFromInferred(s string) string {
+	s = strings.Map(func(c rune) rune {
+		if isListNameChar(c) {
+			return c
+		}
+		return '-'
+	}, s)
+	s = strings.Trim(s, ".-")
+	if len(s) > maxListNameLength {
+		s = strings.TrimRight(s[:maxListNameLength], ".-")
+	}
+	return s
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestSlugifyListName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string // "" means rejected
+	}{
+		{"ads", "ads"},
+		{"  My Ad   List ", "My-Ad-List"},
+		{"hosts.v2_final-1", "hosts.v2_final-1"},
+		{strings.Repeat("a", maxListNameLength), strings.Repeat("a", maxListNameLength)},
+		{strings.Repeat("a", maxListNameLength+1), ""},
+		{"", ""},
+		{"   ", ""},
+		{"..", ""},
+		{".hidden", ""},
+		{"../../etc/passwd", ""},
+		{"..\\..\\windows", ""},
+		{"sub/list", ""},
+		{"/etc/passwd", ""},
+		{"aa:bb:cc:dd:ee:ff_ads", ""},
+		{"list\x00.txt", ""},
+		{"werbung-ü", ""},
+	}
+	for _, tt := range tests {
+		got, err := slugifyListName(tt.name)
+		if tt.want == "" {
+			if !errors.Is(err, ErrInvalidListName) {
+				t.Errorf("slugifyListName(%q) = %q, %v; want ErrInvalidListName", tt.name, got, err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("slugifyListName(%q) = %q, %v; want %q", tt.name, got, err, tt.want)
+		}
+	}
+}
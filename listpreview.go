@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"strings"
+)
+
+// listPreviewSampleSize is how many entries each sample in a ListPreview holds
+const listPreviewSampleSize = 10
+
+// ListPreview breaks down a fetched list for /validate so it can be vetted
+// before importing
+type ListPreview struct {
+	Count                int            `json:"count"`
+	Sample               []string       `json:"sample"`
+	Wildcards            int            `json:"wildcards"`
+	WildcardSample       []string       `json:"wildcard_sample"`
+	DuplicatesOfExisting int            `json:"duplicates_of_existing"` // only set when a target list is given
+	DuplicateSample      []string       `json:"duplicate_sample"`
+	Invalid              int            `json:"invalid"`
+	InvalidSample        []InvalidEntry `json:"invalid_sample"`
+}
+
+// InvalidEntry is a fetched entry that would be rejected, with the reason
+type InvalidEntry struct {
+	Entry string `json:"entry"`
+	Error string `json:"error"`
+}
+
+// previewList classifies entries as they would be saved. existing holds the
+// target list's patterns, or is nil when there's no target.
+func previewList(entries []string, existing map[string]struct{}) ListPreview {
+	p := ListPreview{
+		Count:           len(entries),
+		Sample:          []string{},
+		WildcardSample:  []string{},
+		DuplicateSample: []string{},
+		InvalidSample:   []InvalidEntry{},
+	}
+	for i, e := range entries {
+		if i < listPreviewSampleSize {
+			p.Sample = append(p.Sample, e)
+		}
+		n := normalizePattern(e)
+		if err := validatePattern(n); err != nil {
+			p.Invalid++
+			if len(p.InvalidSample) < listPreviewSampleSize {
+				p.InvalidSample = append(p.InvalidSample, InvalidEntry{Entry: e, Error: err.Error()})
+			}
+			continue
+		}
+		if strings.Contains(n, "*") {
+			p.Wildcards++
+			if len(p.WildcardSample) < listPreviewSampleSize {
+				p.WildcardSample = append(p.WildcardSample, n)
+			}
+		}
+		if _, dup := existing[n]; dup {
+			p.DuplicatesOfExisting++
+			if len(p.DuplicateSample) < listPreviewSampleSize {
+				p.DuplicateSample = append(p.DuplicateSample, n)
+			}
+		}
+	}
+	return p
+}
+
+// ErrListNotFound is returned for a list that isn't loaded
+var ErrListNotFound = errors.New("list not found")
+
+// ListPatternSet returns the loaded patterns of listName as a set
+func (b *BlocklistManager) ListPatternSet(listName string) (map[string]struct{}, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	arr, ok := b.lists[listName]
+	if !ok {
+		return nil, ErrListNotFound
+	}
+	set := make(map[string]struct{}, len(arr))
+	for _, p := range arr {
+		set[p] = struct{}{}
+	}
+	return set, nil
+}
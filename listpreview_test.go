@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPreviewList(t *testing.T) {
+	existing := map[string]struct{}{"ads.example": {}, "*.track.example": {}}
+	tests := []struct {
+		name          string
+		entries       []string
+		existing      map[string]struct{}
+		wildcards     []string
+		duplicates    []string
+		invalid       []string // entries, in order
+		invalidReason string   // substring of the first reason
+	}{
+		{"plain", []string{"a.example", "b.example"}, nil, nil, nil, nil, ""},
+		{"wildcards normalised", []string{"*.Ads.Example.", "a.example"}, nil, []string{"*.ads.example"}, nil, nil, ""},
+		{"duplicates only with a target", []string{"ADS.example", "*.track.example", "new.example"}, existing,
+			[]string{"*.track.example"}, []string{"ads.example", "*.track.example"}, nil, ""},
+		{"no target no duplicates", []string{"ads.example"}, nil, nil, nil, nil, ""},
+		{"invalid", []string{"bad domain.example", "192.168.1.1", "ok.example", "a..example"}, existing,
+			nil, nil, []string{"bad domain.example", "192.168.1.1", "a..example"}, "unexpected character"},
+		{"invalid never counted twice", []string{"*.bad!.example"}, map[string]struct{}{"*.bad!.example": {}},
+			nil, nil, []string{"*.bad!.example"}, "unexpected character"},
+	}
+	for _, tt := range tests {
+		p := previewList(tt.entries, tt.existing)
+		if p.Count != len(tt.entries) || !reflect.DeepEqual(p.Sample, tt.entries) {
+			t.Errorf("%s: count %d sample %q, want all %d entries", tt.name, p.Count, p.Sample, len(tt.entries))
+		}
+		if p.Wildcards != len(tt.wildcards) || !reflect.DeepEqual(p.WildcardSample, append([]string{}, tt.wildcards...)) {
+			t.Errorf("%s: wildcards %d %q, want %q", tt.name, p.Wildcards, p.WildcardSample, tt.wildcards)
+		}
+		if p.DuplicatesOfExisting != len(tt.duplicates) || !reflect.DeepEqual(p.DuplicateSample, append([]string{}, tt.duplicates...)) {
+			t.Errorf("%s: duplicates %d %q, want %q", tt.name, p.DuplicatesOfExisting, p.DuplicateSample, tt.duplicates)
+		}
+		var invalid []string
+		for _, e := range p.InvalidSample {
+			invalid = append(invalid, e.Entry)
+		}
+		if p.Invalid != len(tt.invalid) || !reflect.DeepEqual(invalid, tt.invalid) {
+			t.Errorf("%s: invalid %d %q, want %q", tt.name, p.Invalid, invalid, tt.invalid)
+		}
+		if tt.invalidReason != "" && !strings.Contains(p.InvalidSample[0].Error, tt.invalidReason) {
+			t.Errorf("%s: reason %q, want it to mention %q", tt.name, p.InvalidSample[0].Error, tt.invalidReason)
+		}
+	}
+}
+
+func TestPreviewListCapsSamples(t *testing.T) {
+	var entries []string
+	for i := 0; i < 3*listPreviewSampleSize; i++ {
+		entries = append(entries, fmt.Sprintf("*.w%d.example", i), fmt.Sprintf("bad %d", i))
+	}
+	p := previewList(entries, nil)
+	if p.Count != len(entries) || p.Wildcards != 3*listPreviewSampleSize || p.Invalid != 3*listPreviewSampleSize {
+		t.Errorf("counts %d/%d/%d, want every entry counted", p.Count, p.Wildcards, p.Invalid)
+	}
+	if len(p.Sample) != listPreviewSampleSize || len(p.WildcardSample) != listPreviewSampleSize || len(p.InvalidSample) != listPreviewSampleSize {
+		t.Errorf("samples %d/%d/%d, want %d each", len(p.Sample), len(p.WildcardSample), len(p.InvalidSample), listPreviewSampleSize)
+	}
+}
+
+func TestListPatternSet(t *testing.T) {
+	bm := newTestBlocklistManager(t, map[string][]string{"ads": {"ads.example", "*.track.example"}})
+	set, err := bm.ListPatternSet("ads")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]struct{}{"ads.example": {}, "*.track.example": {}}; !reflect.DeepEqual(set, want) {
+		t.Errorf("ListPatternSet = %v, want %v", set, want)
+	}
+	if _, err := bm.ListPatternSet("missing"); !errors.Is(err, ErrListNotFound) {
+		t.Errorf("missing list: %v, want %v", err, ErrListNotFound)
+	}
+}
+
+func TestValidateRejectsUnknownTarget(t *testing.T) {
+	const user, other = "aa:00:00:00:11:43", "aa:00:00:00:11:44"
+	withConfig(t, func(c *Config) { c.BcryptCost = 4 })
+	am := newTestAccountManager(t, user)
+	bm := newTestBlocklistManager(t, map[string][]string{other + "_mine": {"a.example"}})
+	mux := newTestAPI(bm, am)
+	session := am.createSession(user, false).ID
+
+	// another user's list is as unknown as a missing one; the URL is never fetched
+	for _, list := range []string{"missing", "mine"} {
+		w := callAPI(mux, http.MethodPost, "/validate", session, `{"url":"http://203.0.113.1/list.txt","list":"`+list+`"}`)
+		if w.Code != http.StatusNotFound {
+			t.Errorf("list %q: status %d, want %d: %s", list, w.Code, http.StatusNotFound, w.Body)
+		}
+	}
+	guest := am.CreateGuestSession("aa:00:00:00:11:45").ID
+	if w := callAPI(mux, http.MethodPost, "/validate", guest, `{"url":"http://203.0.113.1/list.txt"}`); w.Code != http.StatusForbidden {
+		t.Errorf("guest: status %d, want %d", w.Code, http.StatusForbidden)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"maps"
+	"os"
+	"slices"
+	"sort"
+)
+
+// ListReload is the outcome of reloading a single list
+type ListReload struct {
+	List            string         `json:"list"`
+	Patterns        int            `json:"patterns"`
+	AddedPatterns   int            `json:"added_patterns"`
+	RemovedPatterns int            `json:"removed_patterns"`
+	PatternErrors   []PatternError `json:"pattern_errors"`
+}
+
+// ReloadList re-reads one list and its metadata from the store and rebuilds
+// only that list's matcher, leaving every other list as loaded. It returns an
+// os.ErrNotExist error when the list isn't stored (a removed file still needs
+// LoadAll), and on a read error keeps the loaded copy. The list's entries in
+// the last load report are replaced; LoadedAt is left alone, since other
+// lists may have changed on disk since.
+func (b *BlocklistManager) ReloadList(listName string) (ListReload, error) {
+	pats, err := b.store.Read(listName)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return ListReload{}, os.ErrNotExist
+		}
+		return ListReload{}, err
+	}
+	meta := b.readListMeta(listName)
+
+	var hosts localRecordSet
+	var lm listMatcher
+	var patternErrs []PatternError
+	matched := false
+	if meta.Enabled {
+		if meta.Type == listTypeHosts {
+			raw, err := b.store.ReadRaw(listName)
+			if err != nil {
+				return ListReload{}, err
+			}
+			hosts = hostsRecordSet(raw)
+		} else {
+			lm, patternErrs, matched = b.buildMatcher(AppConfig(), listName, pats, meta)
+		}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	before := make(map[string]struct{}, len(b.lists[listName]))
+	for _, p := range b.lists[listName] {
+		before[p] = struct{}{}
+	}
+
+	// the maps are swapped, not edited, as LoadAll does: callers may still hold the old ones
+	lists := maps.Clone(b.lists)
+	lists[listName] = pats
+	metas := maps.Clone(b.meta)
+	metas[listName] = meta
+	matchers := maps.Clone(b.matchers)
+	delete(matchers, listName)
+	order := slices.DeleteFunc(slices.Clone(b.order), func(n string) bool { return n == listName })
+	if matched {
+		matchers[listName] = lm
+		order = append(order, listName)
+		sort.Strings(order)
+	}
+	hostSets := maps.Clone(b.hosts)
+	delete(hostSets, listName)
+	if hosts != nil {
+		hostSets[listName] = hosts
+	}
+	global := slices.DeleteFunc(slices.Clone(b.global), func(n string) bool { return n == listName })
+	if meta.Global {
+		global = append(global, listName)
+		sort.Strings(global)
+	}
+	b.lists, b.meta, b.matchers, b.order, b.hosts, b.global = lists, metas, matchers, order, hostSets, global
+
+	report := b.lastLoad
+	report.Failed = slices.DeleteFunc(slices.Clone(report.Failed), func(f LoadFailure) bool { return f.List == listName })
+	report.PatternErrors = slices.DeleteFunc(slices.Clone(report.PatternErrors), func(e PatternError) bool { return e.List == listName })
+	report.PatternErrors = append(report.PatternErrors, patternErrs...)
+	sort.Slice(report.PatternErrors, func(i, j int) bool {
+		if report.PatternErrors[i].List != report.PatternErrors[j].List {
+			return report.PatternErrors[i].List < report.PatternErrors[j].List
+		}
+		return report.PatternErrors[i].Pattern < report.PatternErrors[j].Pattern
+	})
+	b.lastLoad = report
+
+	after := make(map[string]struct{}, len(pats))
+	for _, p := range pats {
+		after[p] = struct{}{}
+	}
+	res := ListReload{List: listName, Patterns: len(after), PatternErrors: patternErrs}
+	if res.PatternErrors == nil {
+		res.PatternErrors = []PatternError{}
+	}
+	for p := range after {
+		if _, ok := before[p]; !ok {
+			res.AddedPatterns++
+		}
+	}
+	for p := range before {
+		if _, ok := after[p]; !ok {
+			res.RemovedPatterns++
+		}
+	}
+	return res, nil
+}
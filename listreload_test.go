@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestReloadList(t *testing.T) {
+	store := newMemStore(map[string][]string{
+		"a": {"a1.example", "*.old.example"},
+		"b": {"b1.example", "*.b.example"},
+	})
+	bm := newBlocklistManager(store)
+	// exactMatcher refuses wildcards, which gives the reload pattern errors to report
+	bm.newMatcher = func(bool) Matcher { return &exactMatcher{patterns: make(map[string]bool)} }
+	if err := bm.LoadAll(); err != nil {
+		t.Fatal(err)
+	}
+	loadedAt := bm.LoadedAt()
+	if err := store.Write("a", []string{"a1.example", "a2.example", "*.new.example"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Write("b", []string{"b2.example"}); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := bm.ReloadList("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	newErr := PatternError{List: "a", Pattern: "*.new.example", Error: "wildcards not supported"}
+	want := ListReload{List: "a", Patterns: 3, AddedPatterns: 2, RemovedPatterns: 1, PatternErrors: []PatternError{newErr}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ReloadList = %+v, want %+v", got, want)
+	}
+	// only the reloaded list changed
+	tests := []struct {
+		domain, wantList string // "" for no match
+	}{
+		{"a1.example", "a"},
+		{"a2.example", "a"},
+		{"b1.example", "b"},
+		{"b2.example", ""},
+	}
+	for _, tt := range tests {
+		if d, ok := bm.Match(tt.domain); ok != (tt.wantList != "") || d.List != tt.wantList {
+			t.Errorf("after reload, Match(%q) = %+v, %v; want list %q", tt.domain, d, ok, tt.wantList)
+		}
+	}
+	wantErrs := []PatternError{newErr, {List: "b", Pattern: "*.b.example", Error: "wildcards not supported"}}
+	if errs := bm.LastLoad().PatternErrors; !reflect.DeepEqual(errs, wantErrs) {
+		t.Errorf("load report pattern errors %+v, want %+v", errs, wantErrs)
+	}
+	if !bm.LoadedAt().Equal(loadedAt) {
+		t.Error("ReloadList moved LoadedAt")
+	}
+
+	// the sidecar is re-read too
+	if err := bm.writeListMeta("a", ListMeta{Enabled: false}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bm.ReloadList("a"); err != nil {
+		t.Fatal(err)
+	}
+	if d, ok := bm.Match("a1.example"); ok {
+		t.Errorf("disabled list still matches: %+v", d)
+	}
+	if _, err := bm.ReloadList("missing"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("missing list: %v, want %v", err, os.ErrNotExist)
+	}
+}
+
+func TestListReloadAPI(t *testing.T) {
+	const user, other, guest = "aa:00:00:00:11:67", "aa:00:00:00:11:68", "aa:00:00:00:11:69"
+	withConfig(t, func(c *Config) { c.BcryptCost = 4 })
+	store := newMemStore(map[string][]string{
+		user + "_mine":  {"a.example"},
+		other + "_them": {"t.example"},
+	})
+	bm := newBlocklistManager(store)
+	if err := bm.LoadAll(); err != nil {
+		t.Fatal(err)
+	}
+	am := newTestAccountManager(t, user, other, guest)
+	mux := newTestAPI(bm, am)
+	userSession := am.createSession(user, false).ID
+	guestSession := am.CreateGuestSession(guest).ID
+	if err := store.Write(user+"_mine", []string{"b.example"}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name, method, list, session string
+		status                      int
+	}{
+		{"guest", http.MethodPost, "mine", guestSession, http.StatusForbidden},
+		{"GET", http.MethodGet, "mine", userSession, http.StatusMethodNotAllowed},
+		{"missing", http.MethodPost, "nope", userSession, http.StatusNotFound},
+		{"another user's list", http.MethodPost, "them", userSession, http.StatusNotFound},
+		{"own list", http.MethodPost, "mine", userSession, http.StatusOK},
+	}
+	for _, tt := range tests {
+		w := callAPI(mux, tt.method, "/lists/"+tt.list+"/reload", tt.session, "")
+		if w.Code != tt.status {
+			t.Errorf("%s: status %d, want %d: %s", tt.name, w.Code, tt.status, w.Body)
+			continue
+		}
+		if tt.status != http.StatusOK {
+			continue
+		}
+		var got ListReload
+		if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+		want := ListReload{List: "mine", Patterns: 1, AddedPatterns: 1, RemovedPatterns: 1, PatternErrors: []PatternError{}}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: %+v, want %+v", tt.name, got, want)
+		}
+	}
+	if _, ok := bm.Match("b.example"); !ok {
+		t.Error("the reloaded entry doesn't match")
+	}
+	if _, ok := bm.Match("a.example"); ok {
+		t.Error("the removed entry still matches")
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Request parsing for the handlers in apihandlers.go, kept in one place so the
+// user and global routes cannot drift on what they accept.
+// Every error returned here is meant to be sent back as a 400.
+
+// listCreateRequest is the body accepted by /lists/create
+type listCreateRequest struct {
+	Name     string    `json:"name"`
+	URL      string    `json:"url"`
+	Category string    `json:"category"`
+	Items    listItems `json:"items"`
+	// Strict refuses to append to an existing list
+	Strict bool `json:"strict"`
+	// Type "hosts" creates a list of "IP name" mappings answered as local
+	// records; such a list must not exist yet
+	Type string `json:"type"`
+}
+
+// listItems is the "items" field: a single string (split later by
+// AddItemsToList) or an array of strings
+type listItems []string
+
+func (li *listItems) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		*li = nil
+		return nil
+	}
+	var one string
+	if err := json.Unmarshal(data, &one); err == nil {
+		*li = listItems{one}
+		return nil
+	}
+	var many []json.RawMessage
+	if err := json.Unmarshal(data, &many); err != nil {
+		return &fieldError{field: "items", want: "a string or an array of strings", got: jsonKind(data)}
+	}
+	items := make(listItems, 0, len(many))
+	for i, raw := range many {
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return &fieldError{field: fmt.Sprintf("items[%d]", i), want: "string", got: jsonKind(raw)}
+		}
+		items = append(items, s)
+	}
+	*li = items
+	return nil
+}
+
+// decodeListCreate parses a /lists/create body, inferring the list name from the URL when missing
+func decodeListCreate(r *http.Request) (listCreateRequest, error) {
+	var req listCreateRequest
+	if err := decodeJSONBody(r, &req); err != nil {
+		return req, err
+	}
+
+	if req.Name == "" && req.URL != "" {
+		req.Name = listNameFromURL(req.URL)
+	}
+	if req.URL == "" && len(req.Items) == 0 {
+		return req, errors.New(`missing "url" or "items"`)
+	}
+	if req.Name == "" {
+		return req, errors.New(`missing "name"`)
+	}
+	if req.Type != "" && req.Type != listTypeHosts {
+		return req, fmt.Errorf(`invalid "type" %q: expected "hosts" or nothing`, req.Type)
+	}
+	return req, nil
+}
+
+// decodeListAppend parses a /lists/{name}/append body: {"url":"..."} or {"items":...}
+func decodeListAppend(r *http.Request) (fetchURL string, items []string, err error) {
+	var req struct {
+		URL   string    `json:"url"`
+		Items listItems `json:"items"`
+	}
+	if err := decodeJSONBody(r, &req); err != nil {
+		return "", nil, err
+	}
+	if req.URL != "" {
+		return req.URL, nil, nil
+	}
+	if len(req.Items) == 0 {
+		return "", nil, errors.New(`missing "url" or "items"`)
+	}
+	return "", req.Items, nil
+}
+
+// decodeJSONBody decodes a JSON object into v, turning type mismatches into
+// errors that name the offending field
+func decodeJSONBody(r *http.Request, v interface{}) error {
+	err := json.NewDecoder(r.Body).Decode(v)
+	var typeErr *json.UnmarshalTypeError
+	var fieldErr *fieldError
+	switch {
+	case err == nil:
+		return nil
+	case errors.As(err, &fieldErr):
+		return fieldErr
+	case errors.As(err, &typeErr) && typeErr.Field == "":
+		return fmt.Errorf("invalid body: expected a JSON object, got %s", typeErr.Value)
+	case errors.As(err, &typeErr):
+		return &fieldError{field: typeErr.Field, want: typeErr.Type.String(), got: typeErr.Value}
+	}
+	return fmt.Errorf("bad request: %w", err)
+}
+
+// fieldError reports a body field of the wrong JSON type
+type fieldError struct {
+	field, want, got string
+}
+
+func (e *fieldError) Error() string {
+	return fmt.Sprintf("invalid %q: expected %s, got %s", e.field, e.want, e.got)
+}
+
+// jsonKind names the JSON type of a raw value for error messages
+func jsonKind(raw []byte) string {
+	s := strings.TrimSpace(string(raw))
+	if s == "" {
+		return "nothing"
+	}
+	switch s[0] {
+	case '"':
+		return "string"
+	case '[':
+		return "array"
+	case '{':
+		return "object"
+	case 't', 'f':
+		return "bool"
+	case 'n':
+		return "null"
+	}
+	return "number"
+}
+
+// decodeDomains parses a body naming entries to remove: {"domain":"..."} for a
+// single entry or {"domains":[...]} for several. bulk reports which form was used.
+func decodeDomains(r *http.Request) (domains []string, bulk bool, err error) {
+	var req struct {
+		Domain  string   `json:"domain"`
+		Domains []string `json:"domains"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, false, errors.New("invalid json")
+	}
+	if req.Domains != nil {
+		for _, d := range req.Domains {
+			if d = strings.TrimSpace(d); d != "" {
+				domains = append(domains, d)
+			}
+		}
+		if len(domains) == 0 {
+			return nil, true, errors.New("missing domains")
+		}
+		return domains, true, nil
+	}
+	if req.Domain == "" {
+		return nil, false, errors.New("missing domain")
+	}
+	return []string{req.Domain}, false, nil
+}
+
+// listNameFromURL derives a list name from the last path segment of a URL,
+// dropping the extension and falling back to the host. Characters a list name
+// can't hold become '-'.
+func listNameFromURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return ""
+	}
+	base := path.Base(u.Path)
+	if ext := path.Ext(base); ext != "" {
+		base = strings.TrimSuffix(base, ext)
+	}
+	if base == "" || base == "." || base == "/" {
+		base = u.Hostname()
+	}
+	return slugFromInferred(base)
+}
+
+// parsePaging reads offset and limit query parameters, ignoring values that don't parse
+func parsePaging(q url.Values, defaultLimit int) (offset, limit int) {
+	limit = defaultLimit
+	if v, err := strconv.Atoi(q.Get("offset")); err == nil {
+		offset = v
+	}
+	if v, err := strconv.Atoi(q.Get("limit")); err == nil {
+		limit = v
+	}
+	return offset, limit
+}
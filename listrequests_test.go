@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func jsonRequest(body string) *http.Request {
+	return httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+}
+
+func TestDecodeListCreate(t *testing.T) {
+	tests := []struct {
+		body    string
+		want    listCreateRequest
+		wantErr string // "" means accepted
+	}{
+		{`{"name":"ads","items":"a.example"}`, listCreateRequest{Name: "ads", Items: listItems{"a.example"}}, ""},
+		{`{"name":"ads","items":["a.example","b.example"],"strict":true}`,
+			listCreateRequest{Name: "ads", Items: listItems{"a.example", "b.example"}, Strict: true}, ""},
+		{`{"url":"https://lists.example/path/ads.txt"}`, listCreateRequest{Name: "ads", URL: "https://lists.example/path/ads.txt"}, ""},
+		{`{"url":"https://lists.example/"}`, listCreateRequest{Name: "lists.example", URL: "https://lists.example/"}, ""},
+		{`{"name":"lan","items":"192.168.1.2 nas","type":"hosts"}`,
+			listCreateRequest{Name: "lan", Items: listItems{"192.168.1.2 nas"}, Type: listTypeHosts}, ""},
+		{`{"name":"ads"}`, listCreateRequest{}, `missing "url" or "items"`},
+		{`{"items":"a.example"}`, listCreateRequest{}, `missing "name"`},
+		{`{"name":"ads","items":"a.example","type":"regex"}`, listCreateRequest{}, `invalid "type"`},
+		{`{"name":"ads","items":[1]}`, listCreateRequest{}, `invalid "items[0]": expected string, got number`},
+		{`{"name":"ads","items":{}}`, listCreateRequest{}, `invalid "items": expected a string or an array of strings, got object`},
+		{`{"name":1,"items":"a.example"}`, listCreateRequest{}, `invalid "name": expected string, got number`},
+		{`["ads"]`, listCreateRequest{}, "expected a JSON object, got array"},
+		{`{"name":`, listCreateRequest{}, "bad request"},
+	}
+	for _, tt := range tests {
+		got, err := decodeListCreate(jsonRequest(tt.body))
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("decodeListCreate(%s) error = %v, want %q", tt.body, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("decodeListCreate(%s): %v", tt.body, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("decodeListCreate(%s) = %+v, want %+v", tt.body, got, tt.want)
+		}
+	}
+}
+
+func TestJSONKind(t *testing.T) {
+	tests := map[string]string{
+		`"x"`:    "string",
+		` [1]`:   "array",
+		`{}`:     "object",
+		`true`:   "bool",
+		`false`:  "bool",
+		`null`:   "null",
+		`-1.5e3`: "number",
+		"  \n\t": "nothing",
+	}
+	for raw, want := range tests {
+		if got := jsonKind([]byte(raw)); got != want {
+			t.Errorf("jsonKind(%q) = %q, want %q", raw, got, want)
+		}
+	}
+}
+
+func TestListCreateRejectsMalformedFields(t *testing.T) {
+	const mac = "aa:bb:cc:00:11:22"
+	bm := newTestBlocklistManager(t, nil)
+	am := newTestAccountManager(t, mac)
+	tests := []struct {
+		body, want string
+	}{
+		{`{"name":"ads","items":123}`, `invalid "items": expected a string or an array of strings, got number`},
+		{`{"name":"ads","items":["a.example",{"d":"b.example"}]}`, `invalid "items[1]": expected string, got object`},
+		{`{"url":5}`, `invalid "url": expected string, got number`},
+		{`{"name":"ads","items":"a.example","strict":"yes"}`, `invalid "strict": expected bool, got string`},
+		{`{"name":"ads","category":[],"items":"a.example"}`, `invalid "category": expected string, got array`},
+		{`"ads"`, "invalid body: expected a JSON object, got string"},
+	}
+	for _, tt := range tests {
+		r := asUser(httptest.NewRequest(http.MethodPost, "/lists/create", strings.NewReader(tt.body)), mac, false, false)
+		w := httptest.NewRecorder()
+		handleListCreate(w, r, bm, am)
+		var resp apiError
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("%s: %v: %s", tt.body, err, w.Body)
+		}
+		if w.Code != http.StatusBadRequest || resp.Error.Code != errCodeBadRequest || resp.Error.Message != tt.want {
+			t.Errorf("%s: %d %+v, want 400 %q", tt.body, w.Code, resp.Error, tt.want)
+		}
+	}
+	if lists, _ := am.GetUserBlocklists(mac); len(lists) != 0 {
+		t.Errorf("malformed requests created %v", lists)
+	}
+}
+
+func TestDecodeListAppend(t *testing.T) {
+	tests := []struct {
+		body      string
+		wantURL   string
+		wantItems []string
+		wantErr   bool
+	}{
+		{`{"url":"https://lists.example/ads.txt"}`, "https://lists.example/ads.txt", nil, false},
+		{`{"items":"a.example"}`, "", []string{"a.example"}, false},
+		{`{"items":["a.example","b.example"]}`, "", []string{"a.example", "b.example"}, false},
+		{`{}`, "", nil, true},
+		{`{"items":[]}`, "", nil, true},
+		{`{"items":[true]}`, "", nil, true},
+	}
+	for _, tt := range tests {
+		u, items, err := decodeListAppend(jsonRequest(tt.body))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("decodeListAppend(%s) error = %v, want error %v", tt.body, err, tt.wantErr)
+			continue
+		}
+		if u != tt.wantURL || !reflect.DeepEqual(items, tt.wantItems) {
+			t.Errorf("decodeListAppend(%s) = %q, %q; want %q, %q", tt.body, u, items, tt.wantURL, tt.wantItems)
+		}
+	}
+}
+
+func TestDecodeDomains(t *testing.T) {
+	tests := []struct {
+		body     string
+		want     []string
+		wantBulk bool
+		wantErr  bool
+	}{
+		{`{"domain":"a.example"}`, []string{"a.example"}, false, false},
+		{`{"domains":["a.example"," b.example ",""]}`, []string{"a.example", "b.example"}, true, false},
+		{`{"domains":[""]}`, nil, true, true},
+		{`{"domains":[]}`, nil, true, true},
+		{`{}`, nil, false, true},
+		{`not json`, nil, false, true},
+	}
+	for _, tt := range tests {
+		got, bulk, err := decodeDomains(jsonRequest(tt.body))
+		if (err != nil) != tt.wantErr || bulk != tt.wantBulk || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("decodeDomains(%s) = %q, %v, %v; want %q, %v, error %v", tt.body, got, bulk, err, tt.want, tt.wantBulk, tt.wantErr)
+		}
+	}
+}
+
+func TestListNameFromURL(t *testing.T) {
+	tests := []struct{ url, want string }{
+		{"https://lists.example/ads.txt", "ads"},
+		{"https://lists.example/a/b/hosts", "hosts"},
+		{"https://lists.example/", "lists.example"},
+		{"https://lists.example", "lists.example"},
+		{"https://lists.example/my%20list.txt", "my-list"},
+		{"https://lists.example/.hidden", "lists.example"},
+		{"://bad", ""},
+	}
+	for _, tt := range tests {
+		if got := listNameFromURL(tt.url); got != tt.want {
+			t.Errorf("listNameFromURL(%q) = %q, want %q", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestParsePaging(t *testing.T) {
+	tests := []struct {
+		query  string
+		offset int
+		limit  int
+	}{
+		{"", 0, 100},
+		{"offset=20&limit=10", 20, 10},
+		{"offset=x&limit=y", 0, 100},
+		{"limit=5", 0, 5},
+	}
+	for _, tt := range tests {
+		q, _ := url.ParseQuery(tt.query)
+		if offset, limit := parsePaging(q, 100); offset != tt.offset || limit != tt.limit {
+			t.Errorf("parsePaging(%q) = %d, %d; want %d, %d", tt.query, offset, limit, tt.offset, tt.limit)
+		}
+	}
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// listStore holds list contents and their metadata sidecars. BlocklistManager
+// reads and writes lists only through it: dirStore keeps them as files in the
+// blocklist directory, memStore in memory for tests and stateless deployments.
+type listStore interface {
+	// Names returns every stored list
+	Names() ([]string, error)
+	// Read returns a list's patterns, parsed from its lines, or an
+	// fs.ErrNotExist error
+	Read(name string) ([]string, error)
+	// ReadRaw returns a list's lines as stored, or an fs.ErrNotExist error
+	ReadRaw(name string) ([]string, error)
+	// Write creates or replaces a list
+	Write(name string, lines []string) error
+	// Exists reports whether a list is stored
+	Exists(name string) bool
+	// Rename moves a list and its metadata
+	Rename(oldName, newName string) error
+	// Remove deletes a list and its metadata
+	Remove(name string) error
+	// ReadMeta returns a list's raw sidecar, or an fs.ErrNotExist error
+	ReadMeta(name string) ([]byte, error)
+	// WriteMeta stores a list's sidecar; private ones hold credentials
+	WriteMeta(name string, data []byte, private bool) error
+}
+
+// ErrListsInMemory is returned by operations that need the list files on disk
+// when the lists are kept in memory only
+var ErrListsInMemory = errors.New("lists are kept in memory only")
+
+// errOutsideListDir is returned when a list file would resolve outside the blocklist directory
+var errOutsideListDir = errors.New("list file outside the blocklist directory")
+
+// dirStore keeps each list as <name>.txt with a <name>.meta.json sidecar
+type dirStore struct {
+	dir string
+}
+
+// path returns the absolute path of name's file with the given extension,
+// refusing any name with a path separator or that resolves to somewhere other
+// than directly inside the directory. Names are already slugs by the time they
+// get here; this is the last check before a file is written or removed.
+func (s *dirStore) path(name, ext string) (string, error) {
+	if strings.ContainsAny(name, `/\`) {
+		return "", fmt.Errorf("%w: %q", errOutsideListDir, name)
+	}
+	dir, err := filepath.Abs(s.dir)
+	if err != nil {
+		return "", err
+	}
+	fp, err := filepath.Abs(filepath.Join(dir, name+ext))
+	if err != nil {
+		return "", err
+	}
+	if filepath.Dir(fp) != dir {
+		return "", fmt.Errorf("%w: %q", errOutsideListDir, name)
+	}
+	return fp, nil
+}
+
+func (s *dirStore) Names() ([]string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(strings.ToLower(name), ".txt") {
+			continue
+		}
+		names = append(names, strings.TrimSuffix(name, filepath.Ext(name)))
+	}
+	return names, nil
+}
+
+func (s *dirStore) Read(name string) ([]string, error) {
+	fp, err := s.path(name, ".txt")
+	if err != nil {
+		return nil, err
+	}
+	return readListFile(fp)
+}
+
+func (s *dirStore) ReadRaw(name string) ([]string, error) {
+	fp, err := s.path(name, ".txt")
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(fp)
+	if err != nil {
+		return nil, err
+	}
+	return strings.FieldsFunc(string(data), func(r rune) bool { return r == '\n' || r == '\r' }), nil
+}
+
+func (s *dirStore) Write(name string, lines []string) error {
+	fp, err := s.path(name, ".txt")
+	if err != nil {
+		return err
+	}
+	return writeListFileAtomic(fp, lines)
+}
+
+func (s *dirStore) Exists(name string) bool {
+	fp, err := s.path(name, ".txt")
+	if err != nil {
+		return false
+	}
+	info, err := os.Stat(fp)
+	return err == nil && !info.IsDir()
+}
+
+func (s *dirStore) Rename(oldName, newName string) error {
+	from, err := s.path(oldName, ".txt")
+	if err != nil {
+		return err
+	}
+	to, err := s.path(newName, ".txt")
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(from, to); err != nil {
+		return err
+	}
+	from, _ = s.path(oldName, ".meta.json")
+	to, _ = s.path(newName, ".meta.json")
+	if err := os.Rename(from, to); err != nil && !os.IsNotExist(err) {
+		slog.Warn("RenameList: metadata rename failed", "list", oldName, "err", err)
+	}
+	return nil
+}
+
+func (s *dirStore) Remove(name string) error {
+	fp, err := s.path(name, ".txt")
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(fp); err != nil {
+		return err
+	}
+	mp, _ := s.path(name, ".meta.json")
+	if err := os.Remove(mp); err != nil && !os.IsNotExist(err) {
+		slog.Warn("failed to remove list metadata", "list", name, "err", err)
+	}
+	return nil
+}
+
+func (s *dirStore) ReadMeta(name string) ([]byte, error) {
+	fp, err := s.path(name, ".meta.json")
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(fp)
+}
+
+func (s *dirStore) WriteMeta(name string, data []byte, private bool) error {
+	fp, err := s.path(name, ".meta.json")
+	if err != nil {
+		return err
+	}
+	perm := os.FileMode(0o644)
+	if private {
+		perm = 0o600
+	}
+	if err := os.WriteFile(fp, data, perm); err != nil {
+		return err
+	}
+	// WriteFile keeps the mode of an existing file
+	return os.Chmod(fp, perm)
+}
+
+// memStore keeps lists and sidecars in maps; nothing touches the disk. Lines
+// are kept as written and parsed on Read, as a list file would be. It is safe
+// for concurrent use.
+type memStore struct {
+	mu    sync.RWMutex
+	lists map[string][]string
+	meta  map[string][]byte
+}
+
+// newMemStore returns a memStore holding a copy of lists
+func newMemStore(lists map[string][]string) *memStore {
+	s := &memStore{lists: make(map[string][]string, len(lists)), meta: make(map[string][]byte)}
+	for name, lines := range lists {
+		s.lists[name] = slices.Clone(lines)
+	}
+	return s
+}
+
+func (s *memStore) Names() ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	names := make([]string, 0, len(s.lists))
+	for name := range s.lists {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (s *memStore) Read(name string) ([]string, error) {
+	lines, err := s.ReadRaw(name)
+	if err != nil {
+		return nil, err
+	}
+	return readLines(strings.NewReader(strings.Join(lines, "\n")))
+}
+
+func (s *memStore) ReadRaw(name string) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	lines, ok := s.lists[name]
+	if !ok {
+		return nil, fs.ErrNotExist
+	}
+	return slices.Clone(lines), nil
+}
+
+func (s *memStore) Write(name string, lines []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lists[name] = slices.Clone(lines)
+	return nil
+}
+
+func (s *memStore) Exists(name string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.lists[name]
+	return ok
+}
+
+func (s *memStore) Rename(oldName, newName string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	lines, ok := s.lists[oldName]
+	if !ok {
+		return fs.ErrNotExist
+	}
+	s.lists[newName] = lines
+	delete(s.lists, oldName)
+	if m, ok := s.meta[oldName]; ok {
+		s.meta[newName] = m
+		delete(s.meta, oldName)
+	}
+	return nil
+}
+
+func (s *memStore) Remove(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.lists[name]; !ok {
+		return fs.ErrNotExist
+	}
+	delete(s.lists, name)
+	delete(s.meta, name)
+	return nil
+}
+
+func (s *memStore) ReadMeta(name string) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	data, ok := s.meta[name]
+	if !ok {
+		return nil, fs.ErrNotExist
+	}
+	return slices.Clone(data), nil
+}
+
+func (s *memStore) WriteMeta(name string, data []byte, private bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.meta[name] = slices.Clone(data)
+	return nil
+}
+
+// memStoreFromDir returns a memStore holding a copy of every list and
+// sidecar in dir, read once
+func memStoreFromDir(dir string) (*memStore, error) {
+	src := &dirStore{dir: dir}
+	names, err := src.Names()
+	if err != nil {
+		return nil, err
+	}
+	s := newMemStore(nil)
+	for _, name := range names {
+		lines, err := src.ReadRaw(name)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		s.lists[name] = lines
+		if data, err := src.ReadMeta(name); err == nil {
+			s.meta[name] = data
+		}
+	}
+	return s, nil
+}
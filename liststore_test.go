@@ -0,0 +1,160 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestDirStorePathStaysInDir(t *testing.T) {
+	dir := t.TempDir()
+	s := &dirStore{dir: dir}
+	tests := []struct {
+		name string
+		want string // "" means refused
+	}{
+		{"ads", "ads.txt"},
+		{"aa:bb:cc:dd:ee:ff_ads", "aa:bb:cc:dd:ee:ff_ads.txt"},
+		{"..", "...txt"},
+		{"../ads", ""},
+		{"../../etc/passwd", ""},
+		{"aa:bb:cc:dd:ee:ff_../../etc/passwd", ""},
+		{`..\ads`, ""},
+		{"/etc/passwd", ""},
+		{"sub/ads", ""},
+	}
+	for _, tt := range tests {
+		got, err := s.path(tt.name, ".txt")
+		if tt.want == "" {
+			if !errors.Is(err, errOutsideListDir) {
+				t.Errorf("path(%q) = %q, %v; want errOutsideListDir", tt.name, got, err)
+			}
+			continue
+		}
+		if want := filepath.Join(dir, tt.want); err != nil || got != want {
+			t.Errorf("path(%q) = %q, %v; want %q", tt.name, got, err, want)
+		}
+	}
+}
+
+func TestListStores(t *testing.T) {
+	stores := map[string]func(t *testing.T) listStore{
+		"dir": func(t *testing.T) listStore { return &dirStore{dir: t.TempDir()} },
+		"mem": func(t *testing.T) listStore { return newMemStore(nil) },
+	}
+	for kind, newStore := range stores {
+		t.Run(kind, func(t *testing.T) {
+			s := newStore(t)
+			for name, lines := range map[string][]string{
+				"b-ads":  {"# comment", "ads.example", "0.0.0.0 hosts.example"},
+				"a-more": {"more.example"},
+			} {
+				if err := s.Write(name, lines); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := s.WriteMeta("b-ads", []byte(`{"enabled":true}`), true); err != nil {
+				t.Fatal(err)
+			}
+			if names, err := s.Names(); err != nil || !reflect.DeepEqual(names, []string{"a-more", "b-ads"}) {
+				t.Errorf("Names = %q, %v", names, err)
+			}
+			if got, err := s.Read("b-ads"); err != nil || !reflect.DeepEqual(got, []string{"ads.example", "hosts.example"}) {
+				t.Errorf("Read = %q, %v; want parsed patterns", got, err)
+			}
+			if got, err := s.ReadRaw("b-ads"); err != nil || len(got) != 3 || got[0] != "# comment" {
+				t.Errorf("ReadRaw = %q, %v; want the lines as written", got, err)
+			}
+
+			// the sidecar follows its list
+			if err := s.Rename("b-ads", "c-ads"); err != nil {
+				t.Fatal(err)
+			}
+			if s.Exists("b-ads") || !s.Exists("c-ads") {
+				t.Error("Rename left the list under its old name")
+			}
+			if data, err := s.ReadMeta("c-ads"); err != nil || string(data) != `{"enabled":true}` {
+				t.Errorf("ReadMeta after rename = %q, %v", data, err)
+			}
+			if err := s.Remove("c-ads"); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.Rename("c-ads", "d-ads"); !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("Rename of a removed list = %v, want fs.ErrNotExist", err)
+			}
+
+			for what, err := range map[string]error{
+				"Read":     errOf(s.Read("c-ads")),
+				"ReadRaw":  errOf(s.ReadRaw("c-ads")),
+				"ReadMeta": errOf(s.ReadMeta("c-ads")),
+				"Remove":   s.Remove("c-ads"),
+			} {
+				if !errors.Is(err, fs.ErrNotExist) {
+					t.Errorf("%s of a removed list = %v, want fs.ErrNotExist", what, err)
+				}
+			}
+		})
+	}
+}
+
+// errOf returns the error of a two-value call
+func errOf[T any](_ T, err error) error { return err }
+
+func TestMemStoreFromDir(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"ads.txt":       "ads.example\n",
+		"ads.meta.json": `{"enabled":false}`,
+		"notes.md":      "not a list",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s, err := memStoreFromDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names, _ := s.Names(); !reflect.DeepEqual(names, []string{"ads"}) {
+		t.Errorf("Names = %q, want only the list", names)
+	}
+	if data, err := s.ReadMeta("ads"); err != nil || string(data) != `{"enabled":false}` {
+		t.Errorf("ReadMeta = %q, %v", data, err)
+	}
+
+	// edits stay in memory
+	if err := s.Write("ads", []string{"changed.example"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Write("new", []string{"new.example"}); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "ads.txt")); string(data) != "ads.example\n" {
+		t.Errorf("ads.txt now holds %q", data)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "new.txt")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("new.txt was written: %v", err)
+	}
+
+	if _, err := memStoreFromDir(filepath.Join(dir, "missing")); err == nil {
+		t.Error("memStoreFromDir of a missing directory succeeded")
+	}
+}
+
+func TestInMemoryListsRefuseBackups(t *testing.T) {
+	bm := newTestBlocklistManager(t, map[string][]string{"ads": {"ads.example"}})
+	if err := WriteBackup(io.Discard, bm, newTestAccountManager(t)); !errors.Is(err, ErrListsInMemory) {
+		t.Errorf("WriteBackup = %v, want ErrListsInMemory", err)
+	}
+	w := httptest.NewRecorder()
+	handleBackup(w, httptest.NewRequest(http.MethodGet, "/backup", nil), bm, nil)
+	if w.Code != http.StatusConflict {
+		t.Errorf("GET /backup: status %d, want %d", w.Code, http.StatusConflict)
+	}
+}
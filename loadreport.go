@@ -0,0 +1,139 @@
+package main
+
+import (
+	"sort"
+	"time"
+)
+
+// LoadReport describes the outcome of a LoadAll compared with the load before it
+type LoadReport struct {
+	LoadedAt        time.Time      `json:"loaded_at"`
+	Lists           int            `json:"lists"`
+	Patterns        int            `json:"patterns"` // unique patterns across all lists
+	AddedLists      []string       `json:"added_lists"`
+	RemovedLists    []string       `json:"removed_lists"`
+	AddedPatterns   int            `json:"added_patterns"`
+	RemovedPatterns int            `json:"removed_patterns"`
+	Failed          []LoadFailure  `json:"failed"`
+	PatternErrors   []PatternError `json:"pattern_errors"`
+}
+
+// LoadFailure is a list file that couldn't be read during a load
+type LoadFailure struct {
+	List  string `json:"list"`
+	Error string `json:"error"`
+}
+
+// PatternError is a list entry that couldn't be compiled and so never matches
+type PatternError struct {
+	List    string `json:"list"`
+	Pattern string `json:"pattern"`
+	Error   string `json:"error"`
+}
+
+// newLoadReport diffs the lists of a load against the previous one
+func newLoadReport(previous, current map[string][]string, failed []LoadFailure, patternErrs []PatternError) LoadReport {
+	r := LoadReport{
+		LoadedAt:      time.Now(),
+		Lists:         len(current),
+		AddedLists:    []string{},
+		RemovedLists:  []string{},
+		Failed:        failed,
+		PatternErrors: patternErrs,
+	}
+	if r.Failed == nil {
+		r.Failed = []LoadFailure{}
+	}
+	if r.PatternErrors == nil {
+		r.PatternErrors = []PatternError{}
+	}
+	sort.Slice(r.PatternErrors, func(i, j int) bool {
+		if r.PatternErrors[i].List != r.PatternErrors[j].List {
+			return r.PatternErrors[i].List < r.PatternErrors[j].List
+		}
+		return r.PatternErrors[i].Pattern < r.PatternErrors[j].Pattern
+	})
+
+	for name := range current {
+		if _, ok := previous[name]; !ok {
+			r.AddedLists = append(r.AddedLists, name)
+		}
+	}
+	for name := range previous {
+		if _, ok := current[name]; !ok {
+			r.RemovedLists = append(r.RemovedLists, name)
+		}
+	}
+	sort.Strings(r.AddedLists)
+	sort.Strings(r.RemovedLists)
+
+	before := uniquePatterns(previous)
+	after := uniquePatterns(current)
+	r.Patterns = len(after)
+	for p := range after {
+		if _, ok := before[p]; !ok {
+			r.AddedPatterns++
+		}
+	}
+	for p := range before {
+		if _, ok := after[p]; !ok {
+			r.RemovedPatterns++
+		}
+	}
+	return r
+}
+
+// uniquePatterns collects the distinct patterns across lists
+func uniquePatterns(lists map[string][]string) map[string]struct{} {
+	set := make(map[string]struct{})
+	for _, pats := range lists {
+		for _, p := range pats {
+			set[p] = struct{}{}
+		}
+	}
+	return set
+}
+
+// LastLoad returns the report of the most recent LoadAll
+func (b *BlocklistManager) LastLoad() LoadReport {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.lastLoad
+}
+
+// Reload reloads all lists and returns what changed. The error is only set when
+// the directory itself couldn't be read; unreadable files are listed in the report.
+func (b *BlocklistManager) Reload() (LoadReport, error) {
+	return b.loadAll()
+}
+
+// LoadErrors is the failed files and bad patterns from the most recent load
+type LoadErrors struct {
+	LoadedAt      time.Time      `json:"loaded_at"`
+	Failed        []LoadFailure  `json:"failed"`
+	PatternErrors []PatternError `json:"pattern_errors"`
+}
+
+// LoadErrorsFor returns the errors of the most recent load limited to the lists
+// keep accepts, with names passed through display
+func (b *BlocklistManager) LoadErrorsFor(keep func(list string) bool, display func(list string) string) LoadErrors {
+	report := b.LastLoad()
+	out := LoadErrors{
+		LoadedAt:      report.LoadedAt,
+		Failed:        []LoadFailure{},
+		PatternErrors: []PatternError{},
+	}
+	for _, f := range report.Failed {
+		if keep(f.List) {
+			f.List = display(f.List)
+			out.Failed = append(out.Failed, f)
+		}
+	}
+	for _, e := range report.PatternErrors {
+		if keep(e.List) {
+			e.List = display(e.List)
+			out.PatternErrors = append(out.PatternErrors, e)
+		}
+	}
+	return out
+}
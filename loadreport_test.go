@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNewLoadReport(t *testing.T) {
+	tests := []struct {
+		name              string
+		previous, current map[string][]string
+		want              LoadReport
+	}{
+		{
+			name:     "first load",
+			previous: nil,
+			current:  map[string][]string{"ads": {"a.example", "b.example"}, "mal": {"b.example"}},
+			want:     LoadReport{Lists: 2, Patterns: 2, AddedLists: []string{"ads", "mal"}, RemovedLists: []string{}, AddedPatterns: 2},
+		},
+		{
+			name:     "list added",
+			previous: map[string][]string{"ads": {"a.example"}},
+			current:  map[string][]string{"ads": {"a.example"}, "new": {"n.example", "a.example"}},
+			want:     LoadReport{Lists: 2, Patterns: 2, AddedLists: []string{"new"}, RemovedLists: []string{}, AddedPatterns: 1},
+		},
+		{
+			name:     "list removed, patterns shared",
+			previous: map[string][]string{"ads": {"a.example"}, "old": {"a.example", "o.example"}},
+			current:  map[string][]string{"ads": {"a.example"}},
+			want:     LoadReport{Lists: 1, Patterns: 1, AddedLists: []string{}, RemovedLists: []string{"old"}, RemovedPatterns: 1},
+		},
+		{
+			name:     "entries edited",
+			previous: map[string][]string{"ads": {"a.example", "b.example"}},
+			current:  map[string][]string{"ads": {"a.example", "c.example", "d.example"}},
+			want:     LoadReport{Lists: 1, Patterns: 3, AddedLists: []string{}, RemovedLists: []string{}, AddedPatterns: 2, RemovedPatterns: 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := newLoadReport(tt.previous, tt.current, nil, nil)
+			got.LoadedAt = tt.want.LoadedAt
+			tt.want.Failed, tt.want.PatternErrors = []LoadFailure{}, []PatternError{}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("newLoadReport = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReloadReportsNewFile(t *testing.T) {
+	const admin, user, guest = "aa:00:00:00:11:17", "aa:00:00:00:11:18", "aa:00:00:00:11:19"
+	withConfig(t, func(c *Config) { c.AdminMACs = []string{admin}; c.BcryptCost = 4 })
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "ads.txt"), []byte("a.example\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	bm, err := NewBlocklistManager(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	am := newTestAccountManager(t, admin, user, guest)
+	mux := newTestAPI(bm, am)
+	userSession := am.createSession(user, false).ID
+	guestSession := am.CreateGuestSession(guest).ID
+
+	if err := os.WriteFile(filepath.Join(dir, "new.txt"), []byte("a.example\nn1.example\nn2.example\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name, method, session string
+		status                int
+		wantAdded             []string
+	}{
+		{"guest", http.MethodPost, guestSession, http.StatusForbidden, nil},
+		{"GET", http.MethodGet, userSession, http.StatusMethodNotAllowed, nil},
+		{"reports the new file", http.MethodPost, userSession, http.StatusOK, []string{"new"}},
+		{"nothing changed since", http.MethodPost, userSession, http.StatusOK, []string{}},
+	}
+	for _, tt := range tests {
+		w := callAPI(mux, tt.method, "/reload", tt.session, "")
+		if w.Code != tt.status {
+			t.Fatalf("%s: status = %d, want %d: %s", tt.name, w.Code, tt.status, w.Body)
+		}
+		if tt.status != http.StatusOK {
+			continue
+		}
+		var report LoadReport
+		if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+			t.Fatal(err)
+		}
+		if report.Lists != 2 || report.Patterns != 3 || !reflect.DeepEqual(report.AddedLists, tt.wantAdded) {
+			t.Errorf("%s: report %+v, want 2 lists, 3 patterns, added %v", tt.name, report, tt.wantAdded)
+		}
+		if wantNew := 2 * len(tt.wantAdded); report.AddedPatterns != wantNew {
+			t.Errorf("%s: %d added patterns, want %d", tt.name, report.AddedPatterns, wantNew)
+		}
+	}
+}
+
+func TestListErrorsReportsBadFilesAndPatterns(t *testing.T) {
+	const mac, other = "aa:bb:cc:00:11:18", "aa:bb:cc:00:11:19"
+	// a label longer than DNS allows can't be compiled
+	bad, worse := strings.Repeat("a", 64)+".example", strings.Repeat("b", 64)+".example"
+	dir := t.TempDir()
+	files := map[string]string{
+		mac + "_mine.txt":     "good.example\n" + bad + "\n",
+		other + "_theirs.txt": "fine.example\n" + worse + "\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a list file that can be listed but not read
+	if err := os.Symlink(t.TempDir(), filepath.Join(dir, mac+"_broken.txt")); err != nil {
+		t.Fatal(err)
+	}
+	bm := newBlocklistManager(&dirStore{dir: dir})
+	bm.dir = dir
+	if err := bm.LoadAll(); err == nil {
+		t.Error("LoadAll didn't return the read error")
+	}
+	if !bm.IsBlocked("good.example") {
+		t.Error("bad entries kept the rest of the list from loading")
+	}
+	am := newTestAccountManager(t, mac, other)
+
+	tests := []struct {
+		name         string
+		admin        bool
+		wantFailed   []string
+		wantPatterns []string // list/pattern
+	}{
+		{"own lists only", false, []string{"broken"}, []string{"mine/" + bad}},
+		{"admin sees all", true, []string{mac + "_broken"}, []string{mac + "_mine/" + bad, other + "_theirs/" + worse}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := asUser(httptest.NewRequest(http.MethodGet, "/lists/errors", nil), mac, tt.admin, false)
+			w := httptest.NewRecorder()
+			handleLists(w, r, bm, am)
+			if w.Code != http.StatusOK {
+				t.Fatalf("status %d: %s", w.Code, w.Body)
+			}
+			var got LoadErrors
+			if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+			var failed, patterns []string
+			for _, f := range got.Failed {
+				failed = append(failed, f.List)
+				if f.Error == "" {
+					t.Errorf("failure for %s has no reason", f.List)
+				}
+			}
+			for _, e := range got.PatternErrors {
+				patterns = append(patterns, e.List+"/"+e.Pattern)
+			}
+			if !reflect.DeepEqual(failed, tt.wantFailed) || !reflect.DeepEqual(patterns, tt.wantPatterns) {
+				t.Errorf("failed %q, pattern errors %q; want %q, %q", failed, patterns, tt.wantFailed, tt.wantPatterns)
+			}
+		})
+	}
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// Bounds for a local record's TTL
+const (
+	defaultLocalRecordTTL = 300
+	maxLocalRecordTTL     = 86400
+)
+
+// maxCNAMEChain bounds how many local CNAMEs are followed for one question
+const maxCNAMEChain = 8
+
+var (
+	// ErrInvalidRecord is wrapped by every validation error for a local record
+	ErrInvalidRecord = errors.New("invalid record")
+	// ErrRecordNotFound is returned when no local record has the given id
+	ErrRecordNotFound = errors.New("record not found")
+	// ErrRecordConflict is returned for a duplicate record, or a CNAME mixed
+	// with other records for the same name
+	ErrRecordConflict = errors.New("record conflicts with an existing record")
+)
+
+// LocalRecord is a DNS answer served locally instead of forwarding, e.g.
+// nas.home A 192.168.1.10. A name may have several A/AAAA records or one CNAME.
+type LocalRecord struct {
+	ID    int64  `json:"id"`
+	Name  string `json:"name"`
+	Type  string `json:"type"` // A | AAAA | CNAME
+	Value string `json:"value"`
+	TTL   uint32 `json:"ttl"`
+}
+
+// localRecordSet is an immutable snapshot of the local records keyed by name,
+// swapped whole on every change so the DNS handler never takes a lock
+type localRecordSet map[string][]LocalRecord
+
+// normalizeLocalRecord validates rec and canonicalizes its fields
+func normalizeLocalRecord(rec LocalRecord) (LocalRecord, error) {
+	rec.Name = canonicalDomain(strings.ToLower(strings.TrimSuffix(strings.TrimSpace(rec.Name), ".")))
+	if rec.Name == "" || !isDomainName(rec.Name) {
+		return rec, fmt.Errorf("%w: bad name %q", ErrInvalidRecord, rec.Name)
+	}
+	rec.Type = strings.ToUpper(strings.TrimSpace(rec.Type))
+	rec.Value = strings.TrimSpace(rec.Value)
+	switch rec.Type {
+	case "A":
+		ip := net.ParseIP(rec.Value)
+		if ip == nil || ip.To4() == nil {
+			return rec, fmt.Errorf("%w: A value %q is not an IPv4 address", ErrInvalidRecord, rec.Value)
+		}
+		rec.Value = ip.To4().String()
+	case "AAAA":
+		ip := net.ParseIP(rec.Value)
+		if ip == nil || ip.To4() != nil {
+			return rec, fmt.Errorf("%w: AAAA value %q is not an IPv6 address", ErrInvalidRecord, rec.Value)
+		}
+		rec.Value = ip.String()
+	case "CNAME":
+		rec.Value = canonicalDomain(strings.ToLower(strings.TrimSuffix(rec.Value, ".")))
+		if rec.Value == "" || !isDomainName(rec.Value) {
+			return rec, fmt.Errorf("%w: CNAME target %q is not a domain name", ErrInvalidRecord, rec.Value)
+		}
+		if rec.Value == rec.Name {
+			return rec, fmt.Errorf("%w: CNAME points at itself", ErrInvalidRecord)
+		}
+	default:
+		return rec, fmt.Errorf("%w: unsupported type %q (want A, AAAA or CNAME)", ErrInvalidRecord, rec.Type)
+	}
+	if rec.TTL == 0 {
+		rec.TTL = defaultLocalRecordTTL
+	}
+	if rec.TTL > maxLocalRecordTTL {
+		return rec, fmt.Errorf("%w: ttl %d over %d", ErrInvalidRecord, rec.TTL, maxLocalRecordTTL)
+	}
+	return rec, nil
+}
+
+func isDomainName(s string) bool {
+	_, ok := dns.IsDomainName(s)
+	return ok
+}
+
+// LocalRecords returns every local record ordered by name, type and id
+func (am *AccountManager) LocalRecords() ([]LocalRecord, error) {
+	rows, err := am.query("SELECT id, name, type, value, ttl FROM local_records ORDER BY name, type, id")
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	defer rows.Close()
+
+	records := make([]LocalRecord, 0)
+	for rows.Next() {
+		var rec LocalRecord
+		if err := rows.Scan(&rec.ID, &rec.Name, &rec.Type, &rec.Value, &rec.TTL); err != nil {
+			return nil, err
+		}
+		records = append(records, rec)
+	}
+	return records, rows.Err()
+}
+
+// AddLocalRecord stores a new local record and returns it with its id
+func (am *AccountManager) AddLocalRecord(rec LocalRecord) (LocalRecord, error) {
+	rec, err := normalizeLocalRecord(rec)
+	if err != nil {
+		return rec, err
+	}
+	if err := am.checkLocalRecordConflict(rec); err != nil {
+		return rec, err
+	}
+	res, err := am.exec("INSERT INTO local_records (name, type, value, ttl) VALUES (?, ?, ?, ?)",
+		rec.Name, rec.Type, rec.Value, rec.TTL)
+	if err != nil {
+		return rec, fmt.Errorf("failed to add record: %w", err)
+	}
+	if rec.ID, err = res.LastInsertId(); err != nil {
+		return rec, err
+	}
+	log.Printf("Added local record %s %s %s", rec.Name, rec.Type, rec.Value)
+	return rec, am.refreshLocalRecords()
+}
+
+// UpdateLocalRecord replaces the record with the given id
+func (am *AccountManager) UpdateLocalRecord(id int64, rec LocalRecord) (LocalRecord, error) {
+	rec, err := normalizeLocalRecord(rec)
+	if err != nil {
+		return rec, err
+	}
+	rec.ID = id
+	if err := am.checkLocalRecordConflict(rec); err != nil {
+		return rec, err
+	}
+	res, err := am.exec("UPDATE local_records SET name = ?, type = ?, value = ?, ttl = ? WHERE id = ?",
+		rec.Name, rec.Type, rec.Value, rec.TTL, id)
+	if err != nil {
+		return rec, fmt.Errorf("failed to update record: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return rec, ErrRecordNotFound
+	}
+	log.Printf("Updated local record %d to %s %s %s", id, rec.Name, rec.Type, rec.Value)
+	return rec, am.refreshLocalRecords()
+}
+
+// DeleteLocalRecord removes the record with the given id
+func (am *AccountManager) DeleteLocalRecord(id int64) error {
+	res, err := am.exec("DELETE FROM local_records WHERE id = ?", id)
+	if err != nil {
+		return fmt.Errorf("failed to delete record: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrRecordNotFound
+	}
+	log.Printf("Deleted local record %d", id)
+	return am.refreshLocalRecords()
+}
+
+// checkLocalRecordConflict rejects duplicates and CNAMEs sharing a name with
+// other records; rec.ID is ignored so an update doesn't conflict with itself
+func (am *AccountManager) checkLocalRecordConflict(rec LocalRecord) error {
+	rows, err := am.query("SELECT id, type, value FROM local_records WHERE name = ? AND id != ?", rec.Name, rec.ID)
+	if err != nil {
+		return fmt.Errorf("database error: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			id         int64
+			typ, value string
+		)
+		if err := rows.Scan(&id, &typ, &value); err != nil {
+			return err
+		}
+		if typ == rec.Type && value == rec.Value {
+			return fmt.Errorf("%w: %s %s %s exists (id %d)", ErrRecordConflict, rec.Name, typ, value, id)
+		}
+		if typ == "CNAME" || rec.Type == "CNAME" {
+			return fmt.Errorf("%w: %s can't have a CNAME and other records", ErrRecordConflict, rec.Name)
+		}
+	}
+	return rows.Err()
+}
+
+// refreshLocalRecords reloads the snapshot the DNS handler answers from
+func (am *AccountManager) refreshLocalRecords() error {
+	records, err := am.LocalRecords()
+	if err != nil {
+		return err
+	}
+	set := make(localRecordSet)
+	for _, rec := range records {
+		set[rec.Name] = append(set[rec.Name], rec)
+	}
+	am.localRecords.Store(&set)
+	return nil
+}
+
+// localRecordsFor returns the local records for a canonical name
+func (am *AccountManager) localRecordsFor(name string) []LocalRecord {
+	if am == nil {
+		return nil
+	}
+	set := am.localRecords.Load()
+	if set == nil {
+		return nil
+	}
+	return (*set)[name]
+}
+
+// answerLocalRecord answers q from the local records when name has any,
+// following local CNAMEs. A name with records but none of the asked type gets
+// an empty (NODATA) answer rather than being forwarded. When the chain ends at
+// a CNAME target that isn't local, tail names it so the caller can resolve it
+// upstream. It reports false when name has no local records.
+func (am *AccountManager) answerLocalRecord(q dns.Question, name string) (answers []dns.RR, tail string, ok bool) {
+	owner, cur := q.Name, name
+	for i := 0; i < maxCNAMEChain; i++ {
+		recs := am.localRecordsFor(cur)
+		if len(recs) == 0 {
+			if i == 0 {
+				return nil, "", false
+			}
+			return answers, cur, true
+		}
+		if recs[0].Type == "CNAME" {
+			target := dns.Fqdn(recs[0].Value)
+			answers = append(answers, &dns.CNAME{
+				Hdr:    dns.RR_Header{Name: owner, Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: recs[0].TTL},
+				Target: target,
+			})
+			if q.Qtype == dns.TypeCNAME {
+				return answers, "", true
+			}
+			owner, cur = target, recs[0].Value
+			continue
+		}
+		return append(answers, addressAnswers(q, owner, recs)...), "", true
+	}
+	// a CNAME loop; answer with what was collected rather than spin
+	return answers, "", true
+}
+
+// addressAnswers returns the A or AAAA records among recs that match q's type,
+// owned by owner
+func addressAnswers(q dns.Question, owner string, recs []LocalRecord) []dns.RR {
+	var answers []dns.RR
+	for _, rec := range recs {
+		hdr := dns.RR_Header{Name: owner, Class: dns.ClassINET, Ttl: rec.TTL}
+		switch {
+		case rec.Type == "A" && q.Qtype == dns.TypeA:
+			hdr.Rrtype = dns.TypeA
+			answers = append(answers, &dns.A{Hdr: hdr, A: net.ParseIP(rec.Value)})
+		case rec.Type == "AAAA" && q.Qtype == dns.TypeAAAA:
+			hdr.Rrtype = dns.TypeAAAA
+			answers = append(answers, &dns.AAAA{Hdr: hdr, AAAA: net.ParseIP(rec.Value)})
+		}
+	}
+	return answers
+}
+
+// resolveLocalCNAMETarget looks up the non-local end of a local CNAME chain upstream
+func resolveLocalCNAMETarget(ctx context.Context, cfg *Config, target string, qtype uint16) ([]dns.RR, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(target), qtype)
+	m.RecursionDesired = true
+	resp, err := exchangeUpstream(ctx, cfg, m, upstreamFor(cfg, target))
+	if err != nil {
+		return nil, err
+	}
+	if resp == nil || resp.Rcode != dns.RcodeSuccess {
+		return nil, fmt.Errorf("upstream failed to resolve %s", target)
+	}
+	return resp.Answer, nil
+}
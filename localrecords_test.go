@@ -0,0 +1,199 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestNormalizeLocalRecord(t *testing.T) {
+	tests := []struct {
+		in   LocalRecord
+		want LocalRecord
+		err  error
+	}{
+		{LocalRecord{Name: " NAS.Home. ", Type: "a", Value: " 192.168.1.10 "}, LocalRecord{Name: "nas.home", Type: "A", Value: "192.168.1.10", TTL: defaultLocalRecordTTL}, nil},
+		{LocalRecord{Name: "nas.home", Type: "A", Value: "::ffff:192.168.1.10", TTL: 60}, LocalRecord{Name: "nas.home", Type: "A", Value: "192.168.1.10", TTL: 60}, nil},
+		{LocalRecord{Name: "nas.home", Type: "AAAA", Value: "FD00:0::10"}, LocalRecord{Name: "nas.home", Type: "AAAA", Value: "fd00::10", TTL: defaultLocalRecordTTL}, nil},
+		{LocalRecord{Name: "www.home", Type: "cname", Value: "NAS.home."}, LocalRecord{Name: "www.home", Type: "CNAME", Value: "nas.home", TTL: defaultLocalRecordTTL}, nil},
+		{LocalRecord{Name: "bücher.home", Type: "A", Value: "10.0.0.1"}, LocalRecord{Name: "xn--bcher-kva.home", Type: "A", Value: "10.0.0.1", TTL: defaultLocalRecordTTL}, nil},
+		{LocalRecord{Name: "", Type: "A", Value: "10.0.0.1"}, LocalRecord{}, ErrInvalidRecord},
+		{LocalRecord{Name: "nas.home", Type: "A", Value: "fd00::10"}, LocalRecord{}, ErrInvalidRecord},
+		{LocalRecord{Name: "nas.home", Type: "AAAA", Value: "192.168.1.10"}, LocalRecord{}, ErrInvalidRecord},
+		{LocalRecord{Name: "nas.home", Type: "CNAME", Value: "nas.home."}, LocalRecord{}, ErrInvalidRecord},
+		{LocalRecord{Name: "nas.home", Type: "CNAME", Value: ""}, LocalRecord{}, ErrInvalidRecord},
+		{LocalRecord{Name: "nas.home", Type: "MX", Value: "mail.home"}, LocalRecord{}, ErrInvalidRecord},
+		{LocalRecord{Name: "nas.home", Type: "A", Value: "10.0.0.1", TTL: maxLocalRecordTTL + 1}, LocalRecord{}, ErrInvalidRecord},
+	}
+	for _, tt := range tests {
+		got, err := normalizeLocalRecord(tt.in)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("normalizeLocalRecord(%+v) error = %v, want %v", tt.in, err, tt.err)
+			continue
+		}
+		if tt.err == nil && got != tt.want {
+			t.Errorf("normalizeLocalRecord(%+v) = %+v, want %+v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestLocalRecordConflicts(t *testing.T) {
+	am := newTestAccountManager(t)
+	// each step runs against the records the previous ones left; an id
+	// updates (or, with no record, deletes) that record instead of adding
+	steps := []struct {
+		name string
+		id   int64
+		rec  LocalRecord
+		err  error
+	}{
+		{"first A", 0, LocalRecord{Name: "nas.home", Type: "A", Value: "192.168.1.10"}, nil},
+		{"second A", 0, LocalRecord{Name: "nas.home", Type: "A", Value: "192.168.1.11"}, nil},
+		{"duplicate", 0, LocalRecord{Name: "NAS.home", Type: "a", Value: "192.168.1.10"}, ErrRecordConflict},
+		{"CNAME beside A", 0, LocalRecord{Name: "nas.home", Type: "CNAME", Value: "other.home"}, ErrRecordConflict},
+		{"CNAME", 0, LocalRecord{Name: "www.home", Type: "CNAME", Value: "nas.home"}, nil},
+		{"A beside CNAME", 0, LocalRecord{Name: "www.home", Type: "A", Value: "10.0.0.1"}, ErrRecordConflict},
+		{"update keeps its own name", 1, LocalRecord{Name: "nas.home", Type: "A", Value: "192.168.1.10", TTL: 60}, nil},
+		{"update onto a duplicate", 2, LocalRecord{Name: "nas.home", Type: "A", Value: "192.168.1.10"}, ErrRecordConflict},
+		{"update missing", 99, LocalRecord{Name: "x.home", Type: "A", Value: "10.0.0.1"}, ErrRecordNotFound},
+		{"delete", 2, LocalRecord{}, nil},
+		{"delete again", 2, LocalRecord{}, ErrRecordNotFound},
+	}
+	for _, st := range steps {
+		var err error
+		switch {
+		case st.id == 0:
+			_, err = am.AddLocalRecord(st.rec)
+		case st.rec == LocalRecord{}:
+			err = am.DeleteLocalRecord(st.id)
+		default:
+			_, err = am.UpdateLocalRecord(st.id, st.rec)
+		}
+		if !errors.Is(err, st.err) {
+			t.Fatalf("%s: %v, want %v", st.name, err, st.err)
+		}
+	}
+	records, err := am.LocalRecords()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []LocalRecord{
+		{ID: 1, Name: "nas.home", Type: "A", Value: "192.168.1.10", TTL: 60},
+		{ID: 3, Name: "www.home", Type: "CNAME", Value: "nas.home", TTL: defaultLocalRecordTTL},
+	}
+	if !slices.Equal(records, want) {
+		t.Errorf("records = %+v, want %+v", records, want)
+	}
+}
+
+func TestDNSHandlerAnswersLocalRecords(t *testing.T) {
+	upstream := startFakeUpstream(t, fakeZone)
+	withConfig(t, func(c *Config) { c.Upstream, c.UpstreamProtocol = upstream, "tcp" })
+	am := newTestAccountManager(t)
+	for _, rec := range []LocalRecord{
+		{Name: "nas.home", Type: "A", Value: "192.168.1.10"},
+		{Name: "nas.home", Type: "A", Value: "192.168.1.11"},
+		{Name: "nas.home", Type: "AAAA", Value: "fd00::10"},
+		{Name: "www.home", Type: "CNAME", Value: "nas.home"},
+		{Name: "ext.home", Type: "CNAME", Value: "www.example"},
+		{Name: "loop1.home", Type: "CNAME", Value: "loop2.home"},
+		{Name: "loop2.home", Type: "CNAME", Value: "loop1.home"},
+		{Name: "ads.example", Type: "A", Value: "192.168.1.20"},
+	} {
+		if _, err := am.AddLocalRecord(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	bm := newTestBlocklistManager(t, map[string][]string{"ads": {"ads.example"}})
+	h, err := newDNSHandler(bm, am)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a CNAME loop is followed maxCNAMEChain times, then answered as is
+	var loop []string
+	for i := 0; i < maxCNAMEChain/2; i++ {
+		loop = append(loop, "loop1.home. CNAME loop2.home.", "loop2.home. CNAME loop1.home.")
+	}
+	tests := []struct {
+		name  string
+		qtype uint16
+		want  []string // answers as "owner type value"
+	}{
+		{"nas.home", dns.TypeA, []string{"nas.home. A 192.168.1.10", "nas.home. A 192.168.1.11"}},
+		{"NAS.home", dns.TypeAAAA, []string{"NAS.home. AAAA fd00::10"}},
+		{"nas.home", dns.TypeTXT, nil}, // NODATA rather than forwarded
+		{"www.home", dns.TypeA, []string{"www.home. CNAME nas.home.", "nas.home. A 192.168.1.10", "nas.home. A 192.168.1.11"}},
+		{"www.home", dns.TypeCNAME, []string{"www.home. CNAME nas.home."}},
+		{"ext.home", dns.TypeA, []string{"ext.home. CNAME www.example.", "www.example. A 10.0.0.1"}},
+		{"loop1.home", dns.TypeA, loop},
+		{"ads.example", dns.TypeA, []string{"ads.example. A 192.168.1.20"}}, // local records beat blocklists
+		{"other.home", dns.TypeA, []string{"other.home. A 10.0.0.1"}},       // forwarded
+	}
+	for _, tt := range tests {
+		w := serveQuery(h, "127.0.0.1", tt.name, tt.qtype)
+		if w.msg == nil || w.msg.Rcode != dns.RcodeSuccess {
+			t.Fatalf("%s %s: reply %v", tt.name, dns.TypeToString[tt.qtype], w.msg)
+		}
+		var got []string
+		for _, rr := range w.msg.Answer {
+			var value string
+			switch rr := rr.(type) {
+			case *dns.A:
+				value = rr.A.String()
+			case *dns.AAAA:
+				value = rr.AAAA.String()
+			case *dns.CNAME:
+				value = rr.Target
+			}
+			got = append(got, rr.Header().Name+" "+dns.TypeToString[rr.Header().Rrtype]+" "+value)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s %s: answers %q, want %q", tt.name, dns.TypeToString[tt.qtype], got, tt.want)
+		}
+	}
+}
+
+func TestHandleRecords(t *testing.T) {
+	const admin, user = "aa:00:00:00:11:33", "aa:00:00:00:11:34"
+	am := newTestAccountManager(t)
+	// each step runs against the records the previous ones left
+	steps := []struct {
+		method, path, body string
+		admin              bool
+		status             int
+	}{
+		{http.MethodPost, "/records", `{"name":"nas.home","type":"A","value":"192.168.1.10"}`, false, http.StatusForbidden},
+		{http.MethodPost, "/records", `{"name":"nas.home","type":"A","value":"192.168.1.10"}`, true, http.StatusCreated},
+		{http.MethodPost, "/records", `{"name":"nas.home","type":"A","value":"192.168.1.10"}`, true, http.StatusConflict},
+		{http.MethodPost, "/records", `{"name":"nas.home","type":"A","value":"nas"}`, true, http.StatusBadRequest},
+		{http.MethodPost, "/records", `{"name":`, true, http.StatusBadRequest},
+		{http.MethodPut, "/records/1", `{"name":"nas.home","type":"A","value":"192.168.1.12"}`, true, http.StatusOK},
+		{http.MethodPut, "/records/7", `{"name":"nas.home","type":"A","value":"192.168.1.13"}`, true, http.StatusNotFound},
+		{http.MethodPut, "/records/x", `{}`, true, http.StatusBadRequest},
+		{http.MethodGet, "/records", "", false, http.StatusOK},
+		{http.MethodGet, "/records/1", "", true, http.StatusMethodNotAllowed},
+		{http.MethodDelete, "/records/1", "", false, http.StatusForbidden},
+		{http.MethodDelete, "/records/1", "", true, http.StatusOK},
+		{http.MethodDelete, "/records/1", "", true, http.StatusNotFound},
+	}
+	for _, st := range steps {
+		mac := user
+		if st.admin {
+			mac = admin
+		}
+		r := asUser(httptest.NewRequest(st.method, st.path, strings.NewReader(st.body)), mac, st.admin, false)
+		w := httptest.NewRecorder()
+		handleRecords(w, r, am)
+		if w.Code != st.status {
+			t.Fatalf("%s %s %s: status %d, want %d: %s", st.method, st.path, st.body, w.Code, st.status, w.Body)
+		}
+		if st.method == http.MethodGet && st.status == http.StatusOK && !strings.Contains(w.Body.String(), `"value":"192.168.1.12"`) {
+			t.Errorf("GET /records = %s, want the updated record", w.Body)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"time"
+)
+
+// EachLogEntry calls fn for every logged query at or after since (zero means
+// all), oldest first. With fromFile it reads the persistent logs.jsonl instead of
+// the in-memory recent ring; lines that don't decode (e.g. one still being
+// written) are skipped. The file is read without holding the log lock so a slow
+// consumer doesn't stall the writer.
+func (b *BlocklistManager) EachLogEntry(fromFile bool, since time.Time, fn func(QueryEntry) error) error {
+	if !fromFile {
+		for _, e := range b.GetLogs(0) {
+			if e.Time.Before(since) {
+				continue
+			}
+			if err := fn(e); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if b.logPath == "" {
+		return nil
+	}
+	f, err := os.Open(b.logPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	s.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for s.Scan() {
+		var e QueryEntry
+		if err := json.Unmarshal(s.Bytes(), &e); err != nil {
+			continue
+		}
+		if e.Time.Before(since) {
+			continue
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	return s.Err()
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// logLevel is shared by the installed handler so the level can change at runtime
+var logLevel = new(slog.LevelVar)
+
+// parseLogLevel converts a config level name (debug|info|warn|error) to a slog.Level
+func parseLogLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if s == "" {
+		return slog.LevelInfo, nil
+	}
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return level, fmt.Errorf("invalid log_level %q", s)
+	}
+	return level, nil
+}
+
+// setupLogging installs the default slog logger according to cfg. Calls to the
+// standard log package are routed through the same handler at INFO level.
+func setupLogging(cfg *Config) error {
+	level, err := parseLogLevel(cfg.LogLevel)
+	if err != nil {
+		return err
+	}
+	logLevel.Set(level)
+
+	opts := &slog.HandlerOptions{Level: logLevel}
+	var h slog.Handler
+	switch strings.ToLower(cfg.LogFormat) {
+	case "", "text":
+		h = slog.NewTextHandler(os.Stdout, opts)
+	case "json":
+		h = slog.NewJSONHandler(os.Stdout, opts)
+	default:
+		return fmt.Errorf("invalid log_format %q (want text or json)", cfg.LogFormat)
+	}
+	slog.SetDefault(slog.New(h))
+	return nil
+}
+
+// logThrottle limits how often a noisy message is logged. Allow returns true at
+// most once per interval, along with how many calls were suppressed since.
+type logThrottle struct {
+	mu         sync.Mutex
+	interval   time.Duration
+	last       time.Time
+	suppressed int
+}
+
+// Allow reports whether the caller may log now
+func (t *logThrottle) Allow() (bool, int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	if now.Sub(t.last) < t.interval {
+		t.suppressed++
+		return false, 0
+	}
+	n := t.suppressed
+	t.last = now
+	t.suppressed = 0
+	return true, n
+}
+
+// queryLogSampler keeps per-query log lines from flooding stdout under load.
+// Each second it allows up to limit() lines with distinct keys; repeats of a key
+// and anything over the limit are counted and reported by the next allowed line.
+type queryLogSampler struct {
+	limit func() int
+
+	mu         sync.Mutex
+	window     time.Time
+	seen       map[string]struct{}
+	suppressed int
+}
+
+func newQueryLogSampler(limit func() int) *queryLogSampler {
+	return &queryLogSampler{limit: limit, seen: make(map[string]struct{})}
+}
+
+// Allow reports whether a line for key may be logged now, along with how many
+// lines were suppressed since the last allowed one
+func (s *queryLogSampler) Allow(key string) (bool, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if now.Sub(s.window) >= time.Second {
+		s.window = now
+		clear(s.seen)
+	}
+	if _, dup := s.seen[key]; dup || len(s.seen) >= s.limit() {
+		s.suppressed++
+		return false, 0
+	}
+	s.seen[key] = struct{}{}
+	n := s.suppressed
+	s.suppressed = 0
+	return true, n
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"log/slog"
+	"testing"
+	"time"
+)
+
+func TestSetupLogging(t *testing.T) {
+	prev, prevLevel := slog.Default(), logLevel.Level()
+	t.Cleanup(func() {
+		slog.SetDefault(prev)
+		logLevel.Set(prevLevel)
+	})
+	tests := []struct {
+		level, format string
+		want          slog.Level
+		wantErr       bool
+	}{
+		{"", "", slog.LevelInfo, false},
+		{"debug", "text", slog.LevelDebug, false},
+		{"WARN", "json", slog.LevelWarn, false},
+		{"error", "JSON", slog.LevelError, false},
+		{"loud", "", 0, true},
+		{"info", "xml", 0, true},
+	}
+	for _, tt := range tests {
+		err := setupLogging(&Config{LogLevel: tt.level, LogFormat: tt.format})
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("setupLogging(%q, %q) accepted a bad setting", tt.level, tt.format)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("setupLogging(%q, %q) = %v", tt.level, tt.format, err)
+			continue
+		}
+		if got := logLevel.Level(); got != tt.want {
+			t.Errorf("setupLogging(%q, %q) set level %v, want %v", tt.level, tt.format, got, tt.want)
+		}
+	}
+}
+
+func TestLogThrottle(t *testing.T) {
+	th := &logThrottle{interval: time.Hour}
+	// each step runs against the throttle the previous one left; elapse moves
+	// its last allowed line back in time
+	steps := []struct {
+		elapse         time.Duration
+		wantOK         bool
+		wantSuppressed int
+	}{
+		{0, true, 0},
+		{0, false, 0},
+		{time.Minute, false, 0},
+		{time.Hour, true, 2},
+		{0, false, 0},
+		{2 * time.Hour, true, 1},
+	}
+	for i, st := range steps {
+		th.last = th.last.Add(-st.elapse)
+		ok, suppressed := th.Allow()
+		if ok != st.wantOK || suppressed != st.wantSuppressed {
+			t.Errorf("step %d: Allow = %v, %d; want %v, %d", i, ok, suppressed, st.wantOK, st.wantSuppressed)
+		}
+	}
+}
+
+func TestQueryLogSampler(t *testing.T) {
+	limit := 2
+	s := newQueryLogSampler(func() int { return limit })
+	// each step runs against the sampler the previous one left; a new window
+	// starts the next second
+	steps := []struct {
+		key            string
+		newWindow      bool
+		limit          int
+		wantOK         bool
+		wantSuppressed int
+	}{
+		{"a.example client1", false, 2, true, 0},
+		{"a.example client1", false, 2, false, 0}, // repeat
+		{"a.example client2", false, 2, true, 1},
+		{"b.example client1", false, 2, false, 0}, // over the limit
+		{"a.example client1", true, 2, true, 1},
+		{"b.example client1", false, 2, true, 0},
+		{"c.example client1", false, 3, true, 0}, // the limit is read live
+		{"d.example client1", false, 3, false, 0},
+		{"d.example client1", true, 3, true, 1},
+	}
+	for i, st := range steps {
+		if st.newWindow {
+			s.window = s.window.Add(-time.Second)
+		}
+		limit = st.limit
+		ok, suppressed := s.Allow(st.key)
+		if ok != st.wantOK || suppressed != st.wantSuppressed {
+			t.Errorf("step %d: Allow(%q) = %v, %d; want %v, %d", i, st.key, ok, suppressed, st.wantOK, st.wantSuppressed)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// logCompactInterval is how often rotated log segments are compressed and the
+// size budget enforced
+const logCompactInterval = 10 * time.Minute
+
+// logSegment is a rotated copy of the query log: logs.jsonl.N or logs.jsonl.N.gz,
+// where 1 is the newest
+type logSegment struct {
+	path       string
+	n          int
+	compressed bool
+	size       int64
+}
+
+// rotateLogIfNeeded renames the query log to logs.jsonl.1 once it grows past
+// AppConfig.LogRotateLimit(), shifting older segments up by one. Caller holds logMu.
+func (b *BlocklistManager) rotateLogIfNeeded() {
+	info, err := os.Stat(b.logPath)
+	if err != nil || info.Size() < AppConfig().LogRotateLimit() {
+		return
+	}
+	segs, err := b.logSegments()
+	if err != nil {
+		slog.Error("rotate log: listing segments failed", "err", err)
+		return
+	}
+	// shift oldest first so nothing is overwritten
+	for i := len(segs) - 1; i >= 0; i-- {
+		s := segs[i]
+		next := b.segmentPath(s.n+1, s.compressed)
+		if err := os.Rename(s.path, next); err != nil {
+			slog.Error("rotate log: shifting segment failed", "segment", s.path, "err", err)
+			return
+		}
+	}
+	if err := os.Rename(b.logPath, b.segmentPath(1, false)); err != nil {
+		slog.Error("rotate log failed", "err", err)
+	}
+}
+
+// compactLogs gzips uncompressed rotated segments and then deletes the oldest
+// segments until their total size fits AppConfig.LogBudget(). The live log is
+// never touched.
+func (b *BlocklistManager) compactLogs() {
+	if b.logPath == "" {
+		return
+	}
+	b.logMu.Lock()
+	defer b.logMu.Unlock()
+
+	segs, err := b.logSegments()
+	if err != nil {
+		slog.Error("compact logs: listing segments failed", "err", err)
+		return
+	}
+	for i, s := range segs {
+		if s.compressed {
+			continue
+		}
+		gz := b.segmentPath(s.n, true)
+		size, err := gzipFile(s.path, gz)
+		if err != nil {
+			slog.Error("compact logs: compress failed", "segment", s.path, "err", err)
+			continue
+		}
+		os.Remove(s.path)
+		segs[i] = logSegment{path: gz, n: s.n, compressed: true, size: size}
+	}
+
+	budget := AppConfig().LogBudget()
+	var total int64
+	for _, s := range segs {
+		total += s.size
+	}
+	for i := len(segs) - 1; i >= 0 && total > budget; i-- {
+		if err := os.Remove(segs[i].path); err != nil {
+			slog.Error("compact logs: delete failed", "segment", segs[i].path, "err", err)
+			continue
+		}
+		total -= segs[i].size
+		slog.Info("deleted old query log segment to stay within budget", "segment", filepath.Base(segs[i].path))
+	}
+}
+
+// logCompactor runs compactLogs every interval
+func (b *BlocklistManager) logCompactor(interval time.Duration) {
+	b.compactLogs()
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for range t.C {
+		b.compactLogs()
+	}
+}
+
+// logSegments returns the rotated segments of the query log, newest first
+func (b *BlocklistManager) logSegments() ([]logSegment, error) {
+	entries, err := os.ReadDir(filepath.Dir(b.logPath))
+	if err != nil {
+		return nil, err
+	}
+	prefix := filepath.Base(b.logPath) + "."
+	segs := make([]logSegment, 0)
+	for _, e := range entries {
+		name, ok := strings.CutPrefix(e.Name(), prefix)
+		if !ok || e.IsDir() {
+			continue
+		}
+		num, compressed := strings.CutSuffix(name, ".gz")
+		n, err := strconv.Atoi(num)
+		if err != nil || n < 1 {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		segs = append(segs, logSegment{
+			path:       filepath.Join(filepath.Dir(b.logPath), e.Name()),
+			n:          n,
+			compressed: compressed,
+			size:       info.Size(),
+		})
+	}
+	sort.Slice(segs, func(i, j int) bool { return segs[i].n < segs[j].n })
+	return segs, nil
+}
+
+// segmentPath names rotated segment n
+func (b *BlocklistManager) segmentPath(n int, compressed bool) string {
+	p := b.logPath + "." + strconv.Itoa(n)
+	if compressed {
+		p += ".gz"
+	}
+	return p
+}
+
+// removeLogSegments deletes every rotated segment. Caller holds logMu.
+func (b *BlocklistManager) removeLogSegments() error {
+	segs, err := b.logSegments()
+	if err != nil {
+		return err
+	}
+	for _, s := range segs {
+		if err := os.Remove(s.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// gzipFile compresses src into dst and returns the compressed size. dst is
+// written under a temporary name first so a crash never leaves a truncated segment.
+func gzipFile(src, dst string) (int64, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+
+	tmp := dst + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return 0, err
+	}
+	zw := gzip.NewWriter(out)
+	_, err = io.Copy(zw, in)
+	if cerr := zw.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, dst)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return 0, err
+	}
+	info, err := os.Stat(dst)
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
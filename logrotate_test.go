@@ -0,0 +1,125 @@
+package main
+
+import (
+	"compress/gzip"
+	"crypto/rand"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// writeSegments creates the named files in dir, each holding size random
+// bytes so gzip can't shrink them, and returns what was written.
+func writeSegments(t *testing.T, dir string, names []string, size int) map[string][]byte {
+	t.Helper()
+	contents := make(map[string][]byte)
+	for _, name := range names {
+		data := make([]byte, size)
+		rand.Read(data)
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		contents[name] = data
+	}
+	return contents
+}
+
+// dirNames lists the files in dir, sorted
+func dirNames(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	slices.Sort(names)
+	return names
+}
+
+func TestCompactLogs(t *testing.T) {
+	const size = 1000
+	tests := []struct {
+		name   string
+		budget int64
+		files  []string
+		want   []string
+	}{
+		{"compresses rotated segments", 100 * size, []string{"logs.jsonl", "logs.jsonl.1", "logs.jsonl.2.gz", "other.txt"},
+			[]string{"logs.jsonl", "logs.jsonl.1.gz", "logs.jsonl.2.gz", "other.txt"}},
+		{"over budget drops the oldest", 2*size + size/2, []string{"logs.jsonl", "logs.jsonl.1", "logs.jsonl.2", "logs.jsonl.3.gz", "logs.jsonl.4.gz"},
+			[]string{"logs.jsonl", "logs.jsonl.1.gz", "logs.jsonl.2.gz"}},
+		{"live log never counts", size / 2, []string{"logs.jsonl", "logs.jsonl.1"},
+			[]string{"logs.jsonl"}},
+		{"ignores look-alikes", size, []string{"logs.jsonl", "logs.jsonl.bak", "logs.jsonl.0", "logs.jsonl.x.gz"},
+			[]string{"logs.jsonl", "logs.jsonl.0", "logs.jsonl.bak", "logs.jsonl.x.gz"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withConfig(t, func(c *Config) { c.LogBudgetBytes = tt.budget })
+			dir := t.TempDir()
+			contents := writeSegments(t, dir, tt.files, size)
+			bm := &BlocklistManager{logPath: filepath.Join(dir, "logs.jsonl")}
+			bm.compactLogs()
+
+			got := dirNames(t, dir)
+			if !slices.Equal(got, tt.want) {
+				t.Fatalf("files = %v, want %v", got, tt.want)
+			}
+			// a segment compressed here still holds what was rotated
+			if want, ok := contents["logs.jsonl.1"]; ok && slices.Contains(got, "logs.jsonl.1.gz") {
+				f, err := os.Open(filepath.Join(dir, "logs.jsonl.1.gz"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer f.Close()
+				zr, err := gzip.NewReader(f)
+				if err != nil {
+					t.Fatal(err)
+				}
+				data, err := io.ReadAll(zr)
+				if err != nil || !slices.Equal(data, want) {
+					t.Errorf("logs.jsonl.1.gz doesn't hold the rotated segment (%v)", err)
+				}
+			}
+		})
+	}
+}
+
+func TestRotateLog(t *testing.T) {
+	const limit = 100
+	tests := []struct {
+		name  string
+		files []string
+		size  int
+		want  []string
+	}{
+		{"under the limit", []string{"logs.jsonl"}, limit - 1, []string{"logs.jsonl"}},
+		{"first rotation", []string{"logs.jsonl"}, limit, []string{"logs.jsonl.1"}},
+		{"older segments shift up", []string{"logs.jsonl", "logs.jsonl.1", "logs.jsonl.2.gz"}, limit,
+			[]string{"logs.jsonl.1", "logs.jsonl.2", "logs.jsonl.3.gz"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withConfig(t, func(c *Config) { c.LogRotateBytes = limit })
+			dir := t.TempDir()
+			contents := writeSegments(t, dir, tt.files, tt.size)
+			bm := &BlocklistManager{logPath: filepath.Join(dir, "logs.jsonl")}
+			bm.rotateLogIfNeeded()
+
+			if got := dirNames(t, dir); !slices.Equal(got, tt.want) {
+				t.Fatalf("files = %v, want %v", got, tt.want)
+			}
+			if tt.size < limit {
+				return
+			}
+			if data, _ := os.ReadFile(filepath.Join(dir, "logs.jsonl.1")); !slices.Equal(data, contents["logs.jsonl"]) {
+				t.Error("logs.jsonl.1 doesn't hold the live log that was rotated")
+			}
+		})
+	}
+}
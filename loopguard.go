@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+)
+
+// upstreamLoops reports whether forwarding to upstream (host:port) would reach one
+// of our own DNS listeners in binds, which would make every query recurse into
+// this server. Hostnames that can't be resolved are assumed not to loop.
+func upstreamLoops(upstream string, binds []string) (bool, error) {
+	host, port, err := net.SplitHostPort(upstream)
+	if err != nil {
+		return false, err
+	}
+	ips, err := resolveHostIPs(host)
+	if err != nil || len(ips) == 0 {
+		return false, nil
+	}
+
+	var local []net.IP
+	for _, bind := range binds {
+		bhost, bport, err := net.SplitHostPort(bind)
+		if err != nil || bport != port {
+			continue
+		}
+		bip := net.ParseIP(bhost)
+		for _, ip := range ips {
+			if bip != nil && !bip.IsUnspecified() {
+				if bip.Equal(ip) {
+					return true, nil
+				}
+				continue
+			}
+			// wildcard bind: anything addressed to this machine reaches us
+			if ip.IsLoopback() || ip.IsUnspecified() {
+				return true, nil
+			}
+			if local == nil {
+				local = localInterfaceIPs()
+			}
+			for _, l := range local {
+				if l.Equal(ip) {
+					return true, nil
+				}
+			}
+		}
+	}
+	return false, nil
+}
+
+// resolveHostIPs returns the IPs for host, resolving names with a short timeout
+func resolveHostIPs(host string) ([]net.IP, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	ips := make([]net.IP, 0, len(addrs))
+	for _, a := range addrs {
+		ips = append(ips, a.IP)
+	}
+	return ips, nil
+}
+
+// localInterfaceIPs lists the addresses assigned to this machine's interfaces
+func localInterfaceIPs() []net.IP {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return []net.IP{}
+	}
+	ips := make([]net.IP, 0, len(addrs))
+	for _, a := range addrs {
+		if ipn, ok := a.(*net.IPNet); ok {
+			ips = append(ips, ipn.IP)
+		}
+	}
+	return ips
+}
+
+// checkUpstreamLoops rejects an upstream or conditional forwarder that points back at us
+func checkUpstreamLoops(c *Config) error {
+	binds := []string{c.DNSBind, c.RustDNSBind}
+	upstreams := map[string]string{"upstream": c.Upstream}
+	for i, fb := range c.FallbackUpstreams {
+		upstreams[fmt.Sprintf("fallback_upstreams[%d]", i)] = fb
+	}
+	for suffix, fwd := range c.ConditionalForwarders {
+		upstreams[fmt.Sprintf("conditional_forwarders[%s]", suffix)] = fwd
+	}
+	for field, u := range upstreams {
+		if u == "" {
+			continue
+		}
+		loops, err := upstreamLoops(u, binds)
+		if err != nil {
+			return fmt.Errorf("invalid %s %q: %w", field, u, err)
+		}
+		if loops {
+			return fmt.Errorf("invalid %s %q: points at PiBlock's own DNS listener, queries would loop", field, u)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestUpstreamLoops(t *testing.T) {
+	tests := []struct {
+		upstream string
+		binds    []string
+		loops    bool
+	}{
+		{"127.0.0.1:53", []string{":53"}, true},
+		{"127.0.0.1:53", []string{"0.0.0.0:53"}, true},
+		{"[::1]:53", []string{"[::]:53"}, true},
+		{"localhost:53", []string{":53"}, true},
+		{"192.168.1.2:53", []string{"192.168.1.2:53"}, true},
+		{"127.0.0.1:5353", []string{":53", "0.0.0.0:5353"}, true},
+		{"127.0.0.1:53", []string{":5353"}, false},
+		{"192.168.1.3:53", []string{"192.168.1.2:53"}, false},
+		{"127.0.0.1:53", []string{"192.168.1.2:53"}, false},
+		{"1.1.1.1:53", []string{":53"}, false},
+	}
+	for _, tt := range tests {
+		loops, err := upstreamLoops(tt.upstream, tt.binds)
+		if err != nil {
+			t.Fatalf("upstreamLoops(%q, %q): %v", tt.upstream, tt.binds, err)
+		}
+		if loops != tt.loops {
+			t.Errorf("upstreamLoops(%q, %q) = %v, want %v", tt.upstream, tt.binds, loops, tt.loops)
+		}
+	}
+}
+
+func TestValidateRejectsLoopingUpstream(t *testing.T) {
+	tests := []struct {
+		name    string
+		edit    func(c *Config)
+		wantErr string // "" means valid
+	}{
+		{"default", func(c *Config) {}, ""},
+		{"upstream is our listener", func(c *Config) { c.Upstream = "127.0.0.1:53" }, "invalid upstream"},
+		{"upstream is the rust listener", func(c *Config) { c.Upstream = "127.0.0.1:5353" }, "invalid upstream"},
+		{"fallback is our listener", func(c *Config) { c.FallbackUpstreams = []string{"9.9.9.9:53", "127.0.0.1:53"} }, "fallback_upstreams[1]"},
+		{"forwarder is our listener", func(c *Config) { c.ConditionalForwarders = map[string]string{"lan": "127.0.0.1:53"} }, "conditional_forwarders[lan]"},
+		{"our listener on another port", func(c *Config) { c.DNSBind = "127.0.0.1:5300"; c.Upstream = "127.0.0.1:53" }, ""},
+		{"missing port", func(c *Config) { c.Upstream = "127.0.0.1" }, "invalid upstream"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := defaultConfig()
+			tt.edit(c)
+			err := c.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Validate() = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Validate() = %v, want an error mentioning %q", err, tt.wantErr)
+			}
+		})
+	}
+}
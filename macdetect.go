@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
@@ -8,14 +9,17 @@ import (
 )
 
 // GetClientMAC attempts to determine the client's MAC address from the request
-// First tries X-Client-MAC header (set by client), then tries ARP lookup for local IPs
+// First tries X-Client-MAC header (only from a trusted proxy, as with
+// getClientIP), then tries ARP lookup for local IPs
 // NOTE: For production, this should be enhanced with proper network-level MAC detection
 // or integration with DHCP server. The IP fallback is a temporary measure and should
 // be noted as a security limitation - devices behind NAT will share the same identifier.
 func GetClientMAC(r *http.Request) (string, error) {
 	// Check if client sent their MAC in a header
-	if mac := r.Header.Get("X-Client-MAC"); mac != "" {
-		return normalizeMACAddress(mac), nil
+	if _, _, trusted := requestPeer(r); trusted {
+		if mac := r.Header.Get("X-Client-MAC"); mac != "" {
+			return parseMACAddress(mac)
+		}
 	}
 
 	// Get client IP
@@ -26,7 +30,9 @@ func GetClientMAC(r *http.Request) (string, error) {
 
 	// Try ARP lookup for local network clients
 	if mac, err := getMACFromARP(clientIP); err == nil && mac != "" {
-		return normalizeMACAddress(mac), nil
+		if parsed, err := parseMACAddress(mac); err == nil {
+			return parsed, nil
+		}
 	}
 
 	// SECURITY NOTE: For non-local clients or when ARP fails, we use IP as identifier.
@@ -36,27 +42,70 @@ func GetClientMAC(r *http.Request) (string, error) {
 	return fmt.Sprintf("ip:%s", clientIP), nil
 }
 
-// getClientIP extracts the client IP from the request
+// resolveClientMAC returns the account identity for an auth request: the
+// mac_address from the body when set (a MAC or the ip: fallback handed out
+// earlier), else whatever GetClientMAC detects. Errors are meant for the client.
+func resolveClientMAC(r *http.Request, supplied string) (string, error) {
+	if supplied != "" {
+		id, err := normalizeDeviceID(supplied)
+		if err != nil {
+			return "", fmt.Errorf("%w %q", ErrInvalidMAC, supplied)
+		}
+		return id, nil
+	}
+	mac, err := GetClientMAC(r)
+	if errors.Is(err, ErrInvalidMAC) {
+		return "", err
+	}
+	if err != nil {
+		return "", fmt.Errorf("could not determine MAC address")
+	}
+	return mac, nil
+}
+
+// getClientIP returns the address of the client behind r. X-Forwarded-For and
+// X-Real-IP are only believed when the request comes from a trusted proxy
+// (AppConfig.TrustedProxies); otherwise anyone could pick their own address,
+// and with it the device an ip: identity stands for. X-Forwarded-For is read
+// from the right, skipping trusted proxies, because a client can put anything
+// at its left end before the proxy appends the real address.
 func getClientIP(r *http.Request) string {
-	// Check X-Forwarded-For header
-	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
-		parts := strings.Split(xff, ",")
-		if len(parts) > 0 {
-			return strings.TrimSpace(parts[0])
+	remote, proxies, trusted := requestPeer(r)
+	if !trusted {
+		return remote
+	}
+
+	if xff := r.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+		hops := strings.Split(strings.Join(xff, ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			if net.ParseIP(hop) == nil {
+				// the chain is garbled past here; don't guess
+				return remote
+			}
+			if !proxies.Allows(hop) {
+				return hop
+			}
 		}
+		// every hop is a trusted proxy, so the first one is the client
+		return strings.TrimSpace(hops[0])
 	}
 
-	// Check X-Real-IP header
-	if xri := r.Header.Get("X-Real-IP"); xri != "" {
+	if xri := strings.TrimSpace(r.Header.Get("X-Real-IP")); net.ParseIP(xri) != nil {
 		return xri
 	}
+	return remote
+}
 
-	// Use RemoteAddr
-	host, _, err := net.SplitHostPort(r.RemoteAddr)
+// requestPeer returns the address r came from directly, the running trusted
+// proxies, and whether that address is one of them
+func requestPeer(r *http.Request) (remote string, proxies *clientACL, trusted bool) {
+	remote, _, err := net.SplitHostPort(r.RemoteAddr)
 	if err != nil {
-		return r.RemoteAddr
+		remote = r.RemoteAddr
 	}
-	return host
+	proxies = AppConfig().trustedProxies
+	return remote, proxies, proxies != nil && proxies.Allows(remote)
 }
 
 // getMACFromARP attempts to get MAC address from system ARP cache
@@ -99,6 +148,23 @@ func getMACFromARP(ip string) (string, error) {
 	return "", fmt.Errorf("IP not in local network")
 }
 
+// ErrInvalidMAC is returned for a MAC address that doesn't parse as six octets
+var ErrInvalidMAC = errors.New("invalid MAC address")
+
+// parseMACAddress validates a MAC address written with colons, dashes, Cisco
+// dots (0000.5e00.5301) or no separators, returning it lowercase with colons
+func parseMACAddress(mac string) (string, error) {
+	s := strings.TrimSpace(mac)
+	if len(s) == 12 && !strings.ContainsAny(s, ":-.") {
+		s = normalizeMACAddress(s)
+	}
+	hw, err := net.ParseMAC(s)
+	if err != nil || len(hw) != 6 {
+		return "", fmt.Errorf("%w %q", ErrInvalidMAC, mac)
+	}
+	return hw.String(), nil
+}
+
 // normalizeMACAddress normalizes a MAC address to lowercase with colons
 func normalizeMACAddress(mac string) string {
 	// Remove common separators
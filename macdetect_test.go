@@ -0,0 +1,123 @@
+package main
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestForwardedHeadersOnlyFromTrustedProxies(t *testing.T) {
+	withConfig(t, func(c *Config) { c.TrustedProxies = []string{"10.0.0.5", "10.1.0.0/16"} })
+	tests := []struct {
+		name    string
+		remote  string
+		xff     string
+		xri     string
+		mac     string
+		wantIP  string
+		wantMAC string
+	}{
+		{"untrusted peer, no headers", "192.168.1.20:5000", "", "", "", "192.168.1.20", "ip:192.168.1.20"},
+		{"untrusted peer spoofs XFF", "192.168.1.20:5000", "192.168.1.99", "", "", "192.168.1.20", "ip:192.168.1.20"},
+		{"untrusted peer spoofs X-Real-IP", "192.168.1.20:5000", "", "192.168.1.99", "", "192.168.1.20", "ip:192.168.1.20"},
+		{"untrusted peer spoofs X-Client-MAC", "192.168.1.20:5000", "", "", "aa:bb:cc:dd:ee:ff", "192.168.1.20", "ip:192.168.1.20"},
+		{"loopback not trusted once proxies are set", "127.0.0.1:5000", "192.168.1.99", "", "aa:bb:cc:dd:ee:ff", "127.0.0.1", "ip:127.0.0.1"},
+		{"trusted proxy, XFF", "10.0.0.5:5000", "192.168.1.30", "", "", "192.168.1.30", "ip:192.168.1.30"},
+		{"trusted proxy, X-Real-IP", "10.0.0.5:5000", "", "192.168.1.31", "", "192.168.1.31", "ip:192.168.1.31"},
+		{"trusted proxy, X-Client-MAC", "10.0.0.5:5000", "192.168.1.30", "", "AA-BB-CC-DD-EE-FF", "192.168.1.30", "aa:bb:cc:dd:ee:ff"},
+		{"XFF read from the right past trusted hops", "10.1.2.3:5000", "1.2.3.4, 192.168.1.32, 10.1.9.9", "", "", "192.168.1.32", "ip:192.168.1.32"},
+		{"all hops trusted", "10.0.0.5:5000", "10.1.0.1, 10.1.0.2", "", "", "10.1.0.1", "ip:10.1.0.1"},
+		{"garbled XFF", "10.0.0.5:5000", "192.168.1.33, not-an-ip", "", "", "10.0.0.5", "ip:10.0.0.5"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remote
+			if tt.xff != "" {
+				r.Header.Set("X-Forwarded-For", tt.xff)
+			}
+			if tt.xri != "" {
+				r.Header.Set("X-Real-IP", tt.xri)
+			}
+			if tt.mac != "" {
+				r.Header.Set("X-Client-MAC", tt.mac)
+			}
+			if got := getClientIP(r); got != tt.wantIP {
+				t.Errorf("getClientIP = %q, want %q", got, tt.wantIP)
+			}
+			if got, err := GetClientMAC(r); err != nil || got != tt.wantMAC {
+				t.Errorf("GetClientMAC = %q, %v; want %q", got, err, tt.wantMAC)
+			}
+		})
+	}
+}
+
+func TestTrustedProxiesFollowConfigSnapshot(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "127.0.0.1:5000"
+	r.Header.Set("X-Forwarded-For", "192.168.1.40")
+	if got := getClientIP(r); got != "192.168.1.40" {
+		t.Fatalf("default config: getClientIP = %q, want the loopback proxy believed", got)
+	}
+	withConfig(t, func(c *Config) { c.TrustedProxies = []string{"10.0.0.5"} })
+	if got := getClientIP(r); got != "127.0.0.1" {
+		t.Fatalf("after swapping config: getClientIP = %q, want 127.0.0.1", got)
+	}
+}
+
+func TestParseMACAddress(t *testing.T) {
+	tests := []struct {
+		in, want string
+		ok       bool
+	}{
+		{"aa:bb:cc:dd:ee:ff", "aa:bb:cc:dd:ee:ff", true},
+		{" AA-BB-CC-DD-EE-FF ", "aa:bb:cc:dd:ee:ff", true},
+		{"aabb.ccdd.eeff", "aa:bb:cc:dd:ee:ff", true},
+		{"AABBCCDDEEFF", "aa:bb:cc:dd:ee:ff", true},
+		{"aa:bb:cc:dd:ee", "", false},
+		{"aa:bb:cc:dd:ee:ff:00:11", "", false}, // EUI-64
+		{"aa:bb:cc:dd:ee:gg", "", false},
+		{"aabbccddeef", "", false},
+		{"ip:192.168.1.7", "", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		got, err := parseMACAddress(tt.in)
+		if got != tt.want || (err == nil) != tt.ok {
+			t.Errorf("parseMACAddress(%q) = %q, %v; want %q, ok %v", tt.in, got, err, tt.want, tt.ok)
+		}
+		if err != nil && !errors.Is(err, ErrInvalidMAC) {
+			t.Errorf("parseMACAddress(%q) error %v doesn't wrap ErrInvalidMAC", tt.in, err)
+		}
+	}
+}
+
+func TestResolveClientMAC(t *testing.T) {
+	tests := []struct {
+		name      string
+		supplied  string
+		header    string // X-Client-MAC from the (trusted, loopback) proxy
+		want      string
+		wantError error
+	}{
+		{"supplied MAC", "AA-BB-CC-DD-EE-FF", "", "aa:bb:cc:dd:ee:ff", nil},
+		{"supplied ip fallback", "ip:192.168.1.7", "", "ip:192.168.1.7", nil},
+		{"supplied garbage", "kitchen-ipad", "", "", ErrInvalidMAC},
+		{"supplied beats header", "aa:bb:cc:dd:ee:ff", "11:22:33:44:55:66", "aa:bb:cc:dd:ee:ff", nil},
+		{"detected from header", "", "11-22-33-44-55-66", "11:22:33:44:55:66", nil},
+		{"malformed header", "", "11:22:33", "", ErrInvalidMAC},
+		{"nothing to go on", "", "", "ip:192.168.1.40", nil},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("POST", "/auth/check", nil)
+		r.RemoteAddr = "127.0.0.1:5000"
+		r.Header.Set("X-Forwarded-For", "192.168.1.40")
+		if tt.header != "" {
+			r.Header.Set("X-Client-MAC", tt.header)
+		}
+		got, err := resolveClientMAC(r, tt.supplied)
+		if got != tt.want || !errors.Is(err, tt.wantError) || (err != nil) != (tt.wantError != nil) {
+			t.Errorf("%s: resolveClientMAC = %q, %v; want %q, %v", tt.name, got, err, tt.want, tt.wantError)
+		}
+	}
+}
@@ -1,8 +1,12 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
+	"log/slog"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -12,68 +16,101 @@ import (
 )
 
 func main() {
-	// Initialize blocklist manager (loads ./blocklist/*.txt)
-	bm, err := NewBlocklistManager("./blocklist")
+	cl, err := parseCommandLine(os.Args[1:])
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(0)
+		}
+		os.Exit(2)
+	}
+	cfg, err := buildConfig(cl)
+	if err != nil {
+		log.Fatalf("invalid configuration: %v", err)
+	}
+	setAppConfig(cfg)
+	runningCommandLine = cl
+	if cl.SelfTest {
+		if !runSelfTest(os.Stdout, cfg, configuredExchange) {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	if err := setupLogging(cfg); err != nil {
+		log.Fatalf("failed to set up logging: %v", err)
+	}
+
+	// Kill-switch: keep the rust backend in sync and honour the configured start state
+	blockingSwitch.OnChange(func(enabled bool) { go notifyRustBlocking(enabled) })
+	if !cfg.BlockingEnabled {
+		blockingSwitch.Disable(0)
+	}
+
+	// Both directories must be writable: lists, metadata and logs are saved to
+	// the blocklist directory (unless in_memory_lists only reads it) and the
+	// accounts database lives in the data directory
+	if !cfg.InMemoryLists {
+		if err := ensureWritableDir("blocklist_dir", cfg.BlocklistDir); err != nil {
+			log.Fatalf("blocklist directory unusable: %v", err)
+		}
+	}
+	if err := ensureWritableDir("data_dir", cfg.DataDir); err != nil {
+		log.Fatalf("data directory unusable: %v", err)
+	}
+
+	// Initialize blocklist manager (loads <blocklist_dir>/*.txt)
+	var bm *BlocklistManager
+	if cfg.InMemoryLists {
+		var store *memStore
+		if store, err = memStoreFromDir(cfg.BlocklistDir); err == nil {
+			bm, err = newMemoryBlocklistManager(store)
+		}
+		log.Printf("keeping lists in memory only; changes are lost on restart")
+	} else {
+		bm, err = NewBlocklistManager(cfg.BlocklistDir)
+	}
 	if err != nil {
 		log.Fatalf("failed to initialize blocklist manager: %v", err)
 	}
 
+	// Optionally push analytics summaries to an external dashboard
+	if cfg.StatsPushURL != "" {
+		go bm.statsPusher(context.Background(), cfg.StatsPushURL, cfg.StatsPushEvery())
+	}
+
+	// Optionally pick up list files edited directly on disk
+	if cfg.WatchBlocklistDir && !cfg.InMemoryLists {
+		if _, err := startBlocklistWatcher(bm, blocklistWatchDebounce); err != nil {
+			slog.Warn("failed to watch blocklist directory; use /reload after editing lists", "err", err)
+		} else {
+			log.Printf("watching %s for changes", cfg.BlocklistDir)
+		}
+	}
+
 	// Initialize account manager
-	am, err := NewAccountManager("./data")
+	am, err := NewAccountManager(cfg.DataDir)
 	if err != nil {
 		log.Fatalf("failed to initialize account manager: %v", err)
 	}
 	defer am.Close()
 
-	// Start auth API server (binds to 127.0.0.1:8082)
+	// Start the API server (auth, lists, analytics; binds to api_bind, 127.0.0.1:8081 by default)
 	go func() {
-		if err := StartAuthAPIServer(am, "127.0.0.1:8082"); err != nil {
-			log.Fatalf("auth API server error: %v", err)
+		if err := StartAPI(bm, am, cfg.APIBind); err != nil {
+			log.Fatalf("API server error: %v", err)
 		}
 	}()
 
-	// Start internal API server with authentication (binds to 127.0.0.1:8081)
-	go func() {
-		if err := StartInternalAPIServerWithAuth(bm, am); err != nil {
-			log.Fatalf("internal API server error: %v", err)
-		}
-	}()
+	// Ensure block page server is running if redirect mode is enabled. If no
+	// explicit BlockPageIP is configured, a local IP reachable by clients is detected.
+	setAppConfig(withBlockPage(cfg))
 
-	// Ensure block page server is running if redirect mode is enabled.
-	if AppConfig.BlockingMode == "redirect" && AppConfig.BlockPagePort > 0 {
-		// If no explicit BlockPageIP configured, attempt to detect a local IP reachable by clients
-		if AppConfig.BlockPageIP == "" {
-			if ip := DetectLocalIP(); ip != "" {
-				AppConfig.BlockPageIP = ip
-				log.Printf("detected local IP for block page: %s", ip)
-			} else {
-				log.Printf("could not detect local IP for block page; defaulting to 127.0.0.1")
-				AppConfig.BlockPageIP = "127.0.0.1"
-			}
-		}
-		StartBlockPageServer()
-	}
+	// Re-read the config file on SIGHUP
+	go reloadOnSIGHUP(cl)
 
 	// Start DNS server: prefer calling into the Rust runtime via FFI (externs). If
-	// that fails, fall back to launching a rust subprocess; if that also fails fall
-	// back to the Go DNS server implementation.
-	go func() {
-		// Try to start linked rustdns via cgo FFI
-		if err := StartRustLinked("127.0.0.1:9080", "0.0.0.0:5353"); err == nil {
-			log.Printf("started rustdns via FFI")
-			return
-		} else {
-			log.Printf("StartRustLinked failed: %v; trying subprocess approach", err)
-		}
-
-		// Try subprocess launch
-		if err := startRustDNSIfPresent(); err != nil {
-			log.Printf("rust dns subprocess start failed: %v; falling back to Go DNS server", err)
-			if err2 := StartDNSServer(":53", bm, am); err2 != nil {
-				log.Fatalf("DNS server error: %v", err2)
-			}
-		}
-	}()
+	// that fails, fall back to launching a rust subprocess; if that also fails (or
+	// the subprocess dies later) fall back to the Go DNS server implementation.
+	go startDNSBackend(bm, am)
 
 	// Try to auto-launch the Node frontend server (web/server.js).
 	// Prefer a bundled Node runtime under ./node if present (so users don't need a global Node install).
@@ -113,14 +150,14 @@ func main() {
 			// install deps
 			if err := runNpm("ci"); err != nil {
 				// fallback to npm install
-				log.Printf("npm ci failed: %v; trying npm install", err)
+				slog.Warn("npm ci failed; trying npm install", "err", err)
 				if err2 := runNpm("install"); err2 != nil {
-					log.Printf("npm install also failed: %v", err2)
+					slog.Error("npm install also failed", "err", err2)
 				}
 			}
 			// build
 			if err := runNpm("run", "build"); err != nil {
-				log.Printf("frontend build failed (ensure Node is available or bundled in ./node): %v", err)
+				slog.Error("frontend build failed (ensure Node is available or bundled in ./node)", "err", err)
 			} else {
 				log.Printf("frontend build completed")
 			}
@@ -140,7 +177,7 @@ func main() {
 		startCmd.Stdout = os.Stdout
 		startCmd.Stderr = os.Stderr
 		if err := startCmd.Start(); err != nil {
-			log.Printf("failed to start frontend server: %v", err)
+			slog.Error("failed to start frontend server", "err", err)
 			return
 		}
 		log.Printf("started frontend process (pid=%d)", startCmd.Process.Pid)
@@ -150,7 +187,7 @@ func main() {
 	}()
 
 	fmt.Println("Frontend (Node) auto-launch attempted; public UI should be available if Node started")
-	fmt.Println("DNS server started on :53 (udp)")
+	fmt.Printf("DNS server started on %s (udp)\n", cfg.DNSBind)
 
 	// Block forever
 	select {}
@@ -159,7 +196,8 @@ func main() {
 // startRustDNSIfPresent attempts to find a prebuilt Rust DNS binary and launch it as a
 // subprocess. It sets sensible environment variables for the control API and UDP bind.
 // If no binary is found it returns an error so the caller may fall back to Go DNS.
-func startRustDNSIfPresent() error {
+// onExit is called if the subprocess exits after a successful start.
+func startRustDNSIfPresent(onExit func(error)) error {
 	// Search possible locations for a rustdns binary (developer builds and packaged paths)
 	candidates := []string{
 		"./rustdns/target/release/rustdns",
@@ -196,9 +234,11 @@ func startRustDNSIfPresent() error {
 	// configure rustdns control API and UDP bind via env
 	env := os.Environ()
 	// control API binds to localhost:9080 by default; make explicit
-	env = append(env, "RUSTDNS_HTTP_ADDR=127.0.0.1:9080")
-	// use non-privileged UDP port by default; system integrators can set RUSTDNS_UDP_BIND to :53
-	env = append(env, "RUSTDNS_UDP_BIND=0.0.0.0:5353")
+	env = append(env, "RUSTDNS_HTTP_ADDR="+rustControlAddr)
+	// use non-privileged UDP port by default; system integrators can set rust_dns_bind to :53
+	env = append(env, "RUSTDNS_UDP_BIND="+AppConfig().RustDNSBind)
+	// report query decisions back so analytics and logs cover rust-served queries
+	env = append(env, rustEventsEnv()...)
 	cmd.Env = env
 	// redirect stdout/stderr to our process logs
 	stdout, _ := cmd.StdoutPipe()
@@ -215,13 +255,15 @@ func startRustDNSIfPresent() error {
 		io.Copy(os.Stderr, stderr)
 	}()
 
-	// monitor process and return success (don't block) — if it exits soon, return error
+	// monitor process and return success (don't block); any exit is unexpected
+	// since we never stop the subprocess ourselves
 	go func() {
 		err := cmd.Wait()
-		if err != nil {
-			log.Printf("rustdns exited: %v", err)
-		} else {
-			log.Printf("rustdns exited")
+		if err == nil {
+			err = fmt.Errorf("rustdns exited")
+		}
+		if onExit != nil {
+			onExit(err)
 		}
 	}()
 
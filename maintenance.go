@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"log"
+	"regexp"
+)
+
+// perUserListName matches list names carrying a MAC (or ip: fallback) owner prefix
+var perUserListName = regexp.MustCompile(`^([0-9a-f]{2}(:[0-9a-f]{2}){5}|ip:[^_]+)_`)
+
+// PruneReport lists orphans found (and removed, unless DryRun) by pruneOrphans
+type PruneReport struct {
+	DryRun bool `json:"dry_run"`
+	// OrphanFiles are per-user list files that no association points at
+	OrphanFiles []string `json:"orphan_files"`
+	// MissingFiles are associations whose list file no longer exists
+	MissingFiles []UserBlocklist `json:"missing_files"`
+	// OrphanAssociations are associations whose account no longer exists
+	OrphanAssociations []UserBlocklist `json:"orphan_associations"`
+	Errors             []string        `json:"errors,omitempty"`
+}
+
+// pruneOrphans cross-references accounts, user_blocklists and the list files on
+// disk. When apply is false nothing is changed and the report describes what
+// would be removed.
+func pruneOrphans(bm *BlocklistManager, am *AccountManager, apply bool) (PruneReport, error) {
+	report := PruneReport{
+		DryRun:             !apply,
+		OrphanFiles:        []string{},
+		MissingFiles:       []UserBlocklist{},
+		OrphanAssociations: []UserBlocklist{},
+	}
+
+	assocs, err := am.AllUserBlocklists()
+	if err != nil {
+		return report, err
+	}
+
+	bm.mu.RLock()
+	onDisk := make(map[string]bool, len(bm.lists))
+	for name := range bm.lists {
+		onDisk[name] = true
+	}
+	bm.mu.RUnlock()
+
+	associated := make(map[string]bool, len(assocs))
+	for _, ub := range assocs {
+		switch {
+		case !ub.HasAccount:
+			report.OrphanAssociations = append(report.OrphanAssociations, ub)
+		case !onDisk[ub.ListName]:
+			report.MissingFiles = append(report.MissingFiles, ub)
+		default:
+			associated[ub.ListName] = true
+		}
+	}
+
+	for name := range onDisk {
+		if perUserListName.MatchString(name) && !associated[name] {
+			report.OrphanFiles = append(report.OrphanFiles, name)
+		}
+	}
+
+	if !apply {
+		return report, nil
+	}
+
+	for _, ub := range append(report.OrphanAssociations, report.MissingFiles...) {
+		if err := am.RemoveUserBlocklist(ub.MACAddress, ub.ListName); err != nil {
+			report.Errors = append(report.Errors, err.Error())
+		}
+	}
+	for _, name := range report.OrphanFiles {
+		if err := bm.DeleteList(name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			report.Errors = append(report.Errors, err.Error())
+			continue
+		}
+		log.Printf("Pruned orphaned list %s", name)
+	}
+	if len(report.OrphanFiles) > 0 {
+		if err := bm.LoadAll(); err != nil {
+			return report, err
+		}
+		go notifyRustReload()
+	}
+	return report, nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+func TestPruneOrphans(t *testing.T) {
+	const owner, gone = "aa:bb:cc:00:00:10", "aa:bb:cc:00:00:11"
+	tests := []struct {
+		name  string
+		apply bool
+	}{
+		{"dry run", false},
+		{"apply", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			am := newTestAccountManager(t, owner)
+			bm := newTestBlocklistManager(t, map[string][]string{
+				owner + "_kept":   {"kept.example"},
+				owner + "_stray":  {"stray.example"},
+				"ip:10.0.0.9_old": {"old.example"},
+				"shared":          {"shared.example"},
+			})
+			for _, l := range []string{owner + "_kept", owner + "_missing"} {
+				if err := am.AddUserBlocklist(owner, l); err != nil {
+					t.Fatal(err)
+				}
+			}
+			// an association left behind by a database from before foreign keys were enforced
+			ctx := context.Background()
+			conn, err := am.db.Conn(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, q := range []string{"PRAGMA foreign_keys = OFF", "INSERT INTO user_blocklists (mac_address, list_name) VALUES ('" + gone + "', '" + gone + "_ads')", "PRAGMA foreign_keys = ON"} {
+				if _, err := conn.ExecContext(ctx, q); err != nil {
+					t.Fatal(err)
+				}
+			}
+			conn.Close()
+
+			report, err := pruneOrphans(bm, am, tt.apply)
+			if err != nil {
+				t.Fatal(err)
+			}
+			names := func(ubs []UserBlocklist) []string {
+				var out []string
+				for _, ub := range ubs {
+					out = append(out, ub.ListName)
+				}
+				return out
+			}
+			slices.Sort(report.OrphanFiles)
+			if want := []string{owner + "_stray", "ip:10.0.0.9_old"}; !reflect.DeepEqual(report.OrphanFiles, want) {
+				t.Errorf("orphan files = %v, want %v", report.OrphanFiles, want)
+			}
+			if got, want := names(report.MissingFiles), []string{owner + "_missing"}; !reflect.DeepEqual(got, want) {
+				t.Errorf("missing files = %v, want %v", got, want)
+			}
+			if got, want := names(report.OrphanAssociations), []string{gone + "_ads"}; !reflect.DeepEqual(got, want) {
+				t.Errorf("orphan associations = %v, want %v", got, want)
+			}
+			if report.DryRun == tt.apply || len(report.Errors) > 0 {
+				t.Errorf("dry_run = %t, errors = %v", report.DryRun, report.Errors)
+			}
+
+			lists, err := am.GetUserBlocklists(owner)
+			if err != nil {
+				t.Fatal(err)
+			}
+			wantLists := []string{owner + "_kept", owner + "_missing"}
+			if tt.apply {
+				wantLists = []string{owner + "_kept"}
+			}
+			slices.Sort(lists)
+			if !reflect.DeepEqual(lists, wantLists) {
+				t.Errorf("associations after prune = %v, want %v", lists, wantLists)
+			}
+			for name, wantKept := range map[string]bool{owner + "_kept": true, "shared": true, owner + "_stray": !tt.apply, "ip:10.0.0.9_old": !tt.apply} {
+				if got := bm.HasList(name); got != wantKept {
+					t.Errorf("list %s kept = %t, want %t", name, got, wantKept)
+				}
+			}
+		})
+	}
+}
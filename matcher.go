@@ -0,0 +1,102 @@
+package main
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Matcher decides which of a list's patterns a domain matches. Patterns are
+// added, then Build prepares them; after Build, Match is safe for concurrent
+// use and Add must not be called again. BlocklistManager builds one Matcher per
+// enabled list in LoadAll, so a different strategy (a suffix trie,
+// Aho-Corasick) can replace the regexp one without touching its callers.
+type Matcher interface {
+	// Add queues pattern, a list entry with optional '*' wildcards. It returns
+	// an error for patterns the matcher can't represent.
+	Add(pattern string) error
+	// Build prepares the added patterns for matching
+	Build() error
+	// Match returns the first added pattern matching domain, which is
+	// lowercase, canonical and has no trailing dot
+	Match(domain string) (pattern string, ok bool)
+}
+
+// MatcherFactory returns an empty Matcher. subdomains is the list's
+// match_subdomains setting: plain entries also match their subdomains.
+type MatcherFactory func(subdomains bool) Matcher
+
+// regexMatcher compiles every pattern to an anchored regexp and tries them in
+// order
+type regexMatcher struct {
+	subdomains bool
+	patterns   []string
+	res        []*regexp.Regexp
+}
+
+// newRegexMatcher is the default MatcherFactory
+func newRegexMatcher(subdomains bool) Matcher {
+	return &regexMatcher{subdomains: subdomains}
+}
+
+func (m *regexMatcher) Add(pattern string) error {
+	re, err := patternToRegexp(pattern, m.subdomains)
+	if err != nil || re == nil {
+		// blank lines and comments compile to nothing
+		return err
+	}
+	m.patterns = append(m.patterns, pattern)
+	m.res = append(m.res, re)
+	return nil
+}
+
+func (m *regexMatcher) Build() error {
+	return nil
+}
+
+func (m *regexMatcher) Match(domain string) (string, bool) {
+	for i, re := range m.res {
+		if re.MatchString(domain) {
+			return m.patterns[i], true
+		}
+	}
+	return "", false
+}
+
+// listMatcher is a built Matcher for one list with the list's query-type scope
+type listMatcher struct {
+	Matcher
+	qtypes []uint16 // record types the list blocks; nil blocks every type
+}
+
+// appliesTo reports whether the list blocks queries of type qtype; qtype 0
+// stands for any type.
+func (lm listMatcher) appliesTo(qtype uint16) bool {
+	return qtype == 0 || lm.qtypes == nil || slices.Contains(lm.qtypes, qtype)
+}
+
+// apexMatcher reports the wildcard entry when a domain matched the apex form
+// LoadAll added for it under AppConfig.BlockApexWithWildcard, so the match
+// points at a line that is actually in the list
+type apexMatcher struct {
+	Matcher
+	wildcardOf map[string]string // added apex form to its wildcard entry
+}
+
+func (m apexMatcher) Match(domain string) (string, bool) {
+	p, ok := m.Matcher.Match(domain)
+	if w, added := m.wildcardOf[p]; ok && added {
+		return w, true
+	}
+	return p, ok
+}
+
+// wildcardApex returns the apex a "*.example.com" entry leaves out, or "" for
+// entries of any other shape
+func wildcardApex(pattern string) string {
+	apex, ok := strings.CutPrefix(pattern, "*.")
+	if !ok || apex == "" || strings.Contains(apex, "*") {
+		return ""
+	}
+	return apex
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestRegexMatcher(t *testing.T) {
+	tests := []struct {
+		name       string
+		patterns   []string
+		subdomains bool
+		domain     string
+		want       string // matching pattern; "" for no match
+	}{
+		{"exact", []string{"ads.example"}, false, "ads.example", "ads.example"},
+		{"no subdomains by default", []string{"ads.example"}, false, "x.ads.example", ""},
+		{"subdomains", []string{"ads.example"}, true, "x.ads.example", "ads.example"},
+		{"wildcard skips apex", []string{"*.ads.example"}, false, "ads.example", ""},
+		{"wildcard spans labels", []string{"*.ads.example"}, false, "a.b.ads.example", "*.ads.example"},
+		{"anchored", []string{"ads.example"}, true, "bads.example", ""},
+		{"first added wins", []string{"*.example", "ads.example"}, false, "ads.example", "*.example"},
+		{"blank lines skipped", []string{"", "ads.example"}, false, "ads.example", "ads.example"},
+	}
+	for _, tt := range tests {
+		m := newRegexMatcher(tt.subdomains)
+		for _, p := range tt.patterns {
+			if err := m.Add(p); err != nil {
+				t.Fatalf("%s: Add(%q): %v", tt.name, p, err)
+			}
+		}
+		if err := m.Build(); err != nil {
+			t.Fatal(err)
+		}
+		if got, ok := m.Match(tt.domain); got != tt.want || ok != (tt.want != "") {
+			t.Errorf("%s: Match(%q) = %q, %v; want %q", tt.name, tt.domain, got, ok, tt.want)
+		}
+	}
+}
+
+// exactMatcher matches whole names only and refuses wildcards; Build fails
+// when a "fail.build" entry was added
+type exactMatcher struct {
+	patterns map[string]bool
+}
+
+func (m *exactMatcher) Add(p string) error {
+	if strings.Contains(p, "*") {
+		return errors.New("wildcards not supported")
+	}
+	m.patterns[p] = true
+	return nil
+}
+
+func (m *exactMatcher) Build() error {
+	if m.patterns["fail.build"] {
+		return errors.New("build failed")
+	}
+	return nil
+}
+
+func (m *exactMatcher) Match(domain string) (string, bool) {
+	return domain, m.patterns[domain]
+}
+
+func TestMatcherFactoryIsSwappable(t *testing.T) {
+	const mac = "aa:bb:cc:00:11:46"
+	store := newMemStore(map[string][]string{
+		"b-ads":       {"ads.example", "*.wild.example"},
+		"a-more":      {"ads.example"},
+		"broken":      {"fail.build", "broken.example"},
+		mac + "_mine": {"mine.example"},
+	})
+	bm := newBlocklistManager(store)
+	bm.newMatcher = func(bool) Matcher { return &exactMatcher{patterns: make(map[string]bool)} }
+	if err := bm.LoadAll(); err != nil {
+		t.Fatal(err)
+	}
+	am := newTestAccountManager(t, mac)
+	if err := am.AddUserBlocklist(mac, mac+"_mine"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		domain   string
+		wantList string // "" for no match
+	}{
+		{"ads.example", "a-more"}, // lists are tried in name order
+		{"x.wild.example", ""},    // refused by Add
+		{"broken.example", ""},    // its list failed to build
+		{"other.example", ""},
+	}
+	for _, tt := range tests {
+		d, ok := bm.Match(tt.domain)
+		if ok != (tt.wantList != "") || d.List != tt.wantList || (ok && d.Pattern != tt.domain) {
+			t.Errorf("Match(%q) = %+v, %v; want list %q", tt.domain, d, ok, tt.wantList)
+		}
+	}
+	if d, ok := bm.MatchForUser("mine.example", 0, mac, am); !ok || d.List != mac+"_mine" {
+		t.Errorf("MatchForUser = %+v, %v; want the user's list", d, ok)
+	}
+
+	_, errs, ok := bm.buildMatcher(AppConfig(), "b-ads", []string{"ads.example", "*.wild.example"}, ListMeta{Enabled: true})
+	if !ok || len(errs) != 1 || errs[0].Pattern != "*.wild.example" {
+		t.Errorf("buildMatcher = %+v, %v; want the wildcard reported", errs, ok)
+	}
+	if _, errs, ok := bm.buildMatcher(AppConfig(), "broken", []string{"fail.build"}, ListMeta{Enabled: true}); ok || len(errs) != 1 || errs[0].Pattern != "" {
+		t.Errorf("buildMatcher of a failing list = %+v, %v; want one list-wide error", errs, ok)
+	}
+}
+
+func TestWildcardApex(t *testing.T) {
+	tests := []struct {
+		pattern, want string
+	}{
+		{"*.ads.example", "ads.example"},
+		{"*.example", "example"},
+		{"ads.example", ""},
+		{"*.", ""},
+		{"*.*.ads.example", ""},
+		{"x.*.ads.example", ""},
+	}
+	for _, tt := range tests {
+		if got := wildcardApex(tt.pattern); got != tt.want {
+			t.Errorf("wildcardApex(%q) = %q, want %q", tt.pattern, got, tt.want)
+		}
+	}
+}
+
+func TestBlockApexWithWildcard(t *testing.T) {
+	tests := []struct {
+		name     string
+		apex     bool
+		patterns []string
+		domain   string
+		want     string // reported pattern; "" for no match
+	}{
+		{"off by default", false, []string{"*.ads.example"}, "ads.example", ""},
+		{"subdomains either way", false, []string{"*.ads.example"}, "x.ads.example", "*.ads.example"},
+		{"apex reports the wildcard", true, []string{"*.ads.example"}, "ads.example", "*.ads.example"},
+		{"case folded", true, []string{"*.Ads.Example"}, "ads.example", "*.ads.example"},
+		{"subdomains still match", true, []string{"*.ads.example"}, "x.ads.example", "*.ads.example"},
+		{"apex already listed", true, []string{"*.ads.example", "ads.example"}, "ads.example", "ads.example"},
+		{"not a parent", true, []string{"*.ads.example"}, "example", ""},
+		{"lookalike", true, []string{"*.ads.example"}, "bads.example", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withConfig(t, func(c *Config) { c.BlockApexWithWildcard = tt.apex })
+			bm := newTestBlocklistManager(t, map[string][]string{"ads": tt.patterns})
+			d, ok := bm.Match(tt.domain)
+			if ok != (tt.want != "") || d.Pattern != tt.want {
+				t.Errorf("Match(%q) = %+v, %v; want pattern %q", tt.domain, d, ok, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// durationMetric accumulates timings on the DNS hot path with atomics only, so
+// recording never contends on a lock
+type durationMetric struct {
+	count atomic.Int64
+	total atomic.Int64 // nanoseconds
+	max   atomic.Int64 // nanoseconds
+}
+
+// Observe records one timing
+func (m *durationMetric) Observe(d time.Duration) {
+	ns := int64(d)
+	m.count.Add(1)
+	m.total.Add(ns)
+	for {
+		cur := m.max.Load()
+		if ns <= cur || m.max.CompareAndSwap(cur, ns) {
+			return
+		}
+	}
+}
+
+// DurationStats summarizes a durationMetric
+type DurationStats struct {
+	Count     int64   `json:"count"`
+	AvgMicros float64 `json:"avg_us"`
+	MaxMicros float64 `json:"max_us"`
+}
+
+// Snapshot returns the current totals. The fields are read separately, so
+// under load the average may be off by the few queries recorded meanwhile.
+func (m *durationMetric) Snapshot() DurationStats {
+	s := DurationStats{Count: m.count.Load(), MaxMicros: float64(m.max.Load()) / 1e3}
+	if s.Count > 0 {
+		s.AvgMicros = float64(m.total.Load()) / float64(s.Count) / 1e3
+	}
+	return s
+}
+
+var (
+	// matchTiming is how long deciding whether a query is blocked takes
+	matchTiming durationMetric
+	// upstreamTiming is how long forwarded queries wait on the upstream
+	upstreamTiming durationMetric
+	// upstreamErrors counts forwarded queries that failed
+	upstreamErrors atomic.Int64
+	// upstreamSaturated counts queries refused because every upstream slot was taken
+	upstreamSaturated atomic.Int64
+	// upstreamCoalesced counts queries answered by joining an identical one already in flight
+	upstreamCoalesced atomic.Int64
+)
+
+// Metrics is the performance view served at /metrics
+type Metrics struct {
+	Match             DurationStats `json:"match"`
+	Upstream          DurationStats `json:"upstream"`
+	UpstreamErrors    int64         `json:"upstream_errors"`
+	UpstreamSaturated int64         `json:"upstream_saturated"`
+	UpstreamCoalesced int64         `json:"upstream_coalesced"`
+	// UpstreamHealth is the circuit-breaker state of every upstream used so far
+	UpstreamHealth []UpstreamHealth `json:"upstream_health"`
+}
+
+// currentMetrics snapshots every counter
+func currentMetrics() Metrics {
+	return Metrics{
+		Match:             matchTiming.Snapshot(),
+		Upstream:          upstreamTiming.Snapshot(),
+		UpstreamErrors:    upstreamErrors.Load(),
+		UpstreamSaturated: upstreamSaturated.Load(),
+		UpstreamCoalesced: upstreamCoalesced.Load(),
+		UpstreamHealth:    upstreamHealth.Snapshot(),
+	}
+}
+
+// handleMetrics serves matcher and upstream timings since startup
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "method not allowed")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(currentMetrics())
+}
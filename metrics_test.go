@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestDurationMetric(t *testing.T) {
+	tests := []struct {
+		name    string
+		observe []time.Duration
+		want    DurationStats
+	}{
+		{"empty", nil, DurationStats{}},
+		{"one", []time.Duration{1500 * time.Nanosecond}, DurationStats{Count: 1, AvgMicros: 1.5, MaxMicros: 1.5}},
+		{"several", []time.Duration{time.Microsecond, 5 * time.Microsecond, 3 * time.Microsecond}, DurationStats{Count: 3, AvgMicros: 3, MaxMicros: 5}},
+		{"max kept when smaller follow", []time.Duration{time.Millisecond, time.Microsecond}, DurationStats{Count: 2, AvgMicros: 500.5, MaxMicros: 1000}},
+	}
+	for _, tt := range tests {
+		var m durationMetric
+		for _, d := range tt.observe {
+			m.Observe(d)
+		}
+		if got := m.Snapshot(); got != tt.want {
+			t.Errorf("%s: Snapshot = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDurationMetricConcurrent(t *testing.T) {
+	var (
+		m  durationMetric
+		wg sync.WaitGroup
+	)
+	const goroutines, each = 8, 1000
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 1; i <= each; i++ {
+				m.Observe(time.Duration(g*each+i) * time.Microsecond)
+			}
+		}(g)
+	}
+	wg.Wait()
+	got := m.Snapshot()
+	const n = goroutines * each
+	want := DurationStats{Count: n, AvgMicros: float64(n+1) / 2, MaxMicros: n}
+	if got != want {
+		t.Errorf("Snapshot = %+v, want %+v", got, want)
+	}
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	const admin, user = "aa:00:00:00:11:34", "aa:00:00:00:11:35"
+	withConfig(t, func(c *Config) { c.AdminMACs = []string{admin}; c.BcryptCost = 4 })
+	am := newTestAccountManager(t, admin, user)
+	bm := newTestBlocklistManager(t, map[string][]string{"ads": {"ads.example"}})
+	mux := newTestAPI(bm, am)
+	adminSession := am.createSession(admin, false).ID
+	userSession := am.createSession(user, false).ID
+
+	get := func(session string) (Metrics, int) {
+		t.Helper()
+		w := callAPI(mux, http.MethodGet, "/metrics", session, "")
+		var m Metrics
+		if w.Code == http.StatusOK {
+			if err := json.Unmarshal(w.Body.Bytes(), &m); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return m, w.Code
+	}
+	if _, code := get(userSession); code != http.StatusForbidden {
+		t.Errorf("user got status %d, want %d", code, http.StatusForbidden)
+	}
+	if w := callAPI(mux, http.MethodPost, "/metrics", adminSession, ""); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST got status %d, want %d", w.Code, http.StatusMethodNotAllowed)
+	}
+
+	before, code := get(adminSession)
+	if code != http.StatusOK {
+		t.Fatalf("admin got status %d", code)
+	}
+	// a blocked query is matched but never goes upstream
+	h, err := newDNSHandler(bm, am)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serveQuery(h, "127.0.0.1", "ads.example", dns.TypeA)
+	after, _ := get(adminSession)
+	if after.Match.Count != before.Match.Count+1 {
+		t.Errorf("match count went from %d to %d, want one more", before.Match.Count, after.Match.Count)
+	}
+	if after.Upstream.Count != before.Upstream.Count {
+		t.Errorf("upstream count went from %d to %d for a blocked query", before.Upstream.Count, after.Upstream.Count)
+	}
+}
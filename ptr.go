@@ -0,0 +1,123 @@
+package main
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/miekg/dns"
+)
+
+// HostNameStore maps client IPs to hostnames for answering PTR queries
+type HostNameStore struct {
+	mu    sync.RWMutex
+	names map[string]string // IP -> hostname
+}
+
+var clientHostNames = &HostNameStore{
+	names: make(map[string]string),
+}
+
+// SetHostName stores the hostname for an IP
+func (s *HostNameStore) SetHostName(ip, name string) {
+	parsed := net.ParseIP(ip)
+	if parsed == nil || name == "" {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.names[parsed.String()] = strings.TrimSuffix(strings.ToLower(name), ".")
+}
+
+// ExplicitHostName returns a stored or configured hostname for an IP, without
+// synthesizing one
+func (s *HostNameStore) ExplicitHostName(cfg *Config, ip net.IP) (string, bool) {
+	key := ip.String()
+	s.mu.RLock()
+	name, ok := s.names[key]
+	s.mu.RUnlock()
+	if ok {
+		return name, true
+	}
+	if name, ok := cfg.LocalHostNames[key]; ok && name != "" {
+		return strings.TrimSuffix(strings.ToLower(name), "."), true
+	}
+	return "", false
+}
+
+// LookupHostName returns the hostname for an IP. Explicit names (stored or
+// configured) win; otherwise a name is synthesized for clients whose MAC is known.
+func (s *HostNameStore) LookupHostName(cfg *Config, ip net.IP) (string, bool) {
+	if name, ok := s.ExplicitHostName(cfg, ip); ok {
+		return name, true
+	}
+
+	key := ip.String()
+	mac, ok := ipMACCache.GetMAC(key)
+	if !ok || mac == "" || strings.HasPrefix(mac, "ip:") {
+		return "", false
+	}
+	host := "device-" + strings.ReplaceAll(mac, ":", "")
+	if domain := strings.Trim(cfg.LocalDomain, "."); domain != "" {
+		host += "." + domain
+	}
+	return host, true
+}
+
+// ptrToIP parses a reverse-lookup name (in-addr.arpa or ip6.arpa) into an IP
+func ptrToIP(qname string) net.IP {
+	name := strings.TrimSuffix(strings.ToLower(qname), ".")
+	switch {
+	case strings.HasSuffix(name, ".in-addr.arpa"):
+		labels := strings.Split(strings.TrimSuffix(name, ".in-addr.arpa"), ".")
+		if len(labels) != 4 {
+			return nil
+		}
+		octets := make([]string, 4)
+		for i, l := range labels {
+			n, err := strconv.Atoi(l)
+			if err != nil || n < 0 || n > 255 {
+				return nil
+			}
+			octets[3-i] = strconv.Itoa(n)
+		}
+		return net.ParseIP(strings.Join(octets, "."))
+	case strings.HasSuffix(name, ".ip6.arpa"):
+		nibbles := strings.Split(strings.TrimSuffix(name, ".ip6.arpa"), ".")
+		if len(nibbles) != 32 {
+			return nil
+		}
+		var b strings.Builder
+		for i := len(nibbles) - 1; i >= 0; i-- {
+			if len(nibbles[i]) != 1 || !strings.Contains("0123456789abcdef", nibbles[i]) {
+				return nil
+			}
+			b.WriteString(nibbles[i])
+			if i > 0 && i%4 == 0 {
+				b.WriteByte(':')
+			}
+		}
+		return net.ParseIP(b.String())
+	}
+	return nil
+}
+
+// answerPTR returns a PTR record for q if the queried address belongs to a known client
+func answerPTR(cfg *Config, q dns.Question) (dns.RR, bool) {
+	if q.Qtype != dns.TypePTR {
+		return nil, false
+	}
+	ip := ptrToIP(q.Name)
+	if ip == nil {
+		return nil, false
+	}
+	host, ok := clientHostNames.LookupHostName(cfg, ip)
+	if !ok {
+		return nil, false
+	}
+	return &dns.PTR{
+		Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypePTR, Class: dns.ClassINET, Ttl: 60},
+		Ptr: dns.Fqdn(host),
+	}, true
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestPtrToIP(t *testing.T) {
+	tests := []struct {
+		qname string
+		want  string // "" means not a reverse name
+	}{
+		{"10.1.168.192.in-addr.arpa.", "192.168.1.10"},
+		{"10.1.168.192.IN-ADDR.ARPA", "192.168.1.10"},
+		{"1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.ip6.arpa.", "::1"},
+		{"b.a.9.8.7.6.5.0.4.0.0.0.3.0.0.0.2.0.0.0.1.0.0.0.0.0.0.0.1.2.3.4.ip6.arpa.", "4321:0:1:2:3:4:567:89ab"},
+		{"1.168.192.in-addr.arpa.", ""},
+		{"256.1.168.192.in-addr.arpa.", ""},
+		{"x.1.168.192.in-addr.arpa.", ""},
+		{"1.0.ip6.arpa.", ""},
+		{"example.com.", ""},
+	}
+	for _, tt := range tests {
+		got := ptrToIP(tt.qname)
+		if tt.want == "" {
+			if got != nil {
+				t.Errorf("ptrToIP(%q) = %v, want nil", tt.qname, got)
+			}
+			continue
+		}
+		if !got.Equal(net.ParseIP(tt.want)) {
+			t.Errorf("ptrToIP(%q) = %v, want %s", tt.qname, got, tt.want)
+		}
+	}
+}
+
+func TestAnswerPTR(t *testing.T) {
+	withConfig(t, func(c *Config) {
+		c.LocalDomain = "home.arpa"
+		c.LocalHostNames = map[string]string{"192.168.70.2": "NAS.home.arpa."}
+	})
+	clientHostNames.SetHostName("192.168.70.1", "laptop.home.arpa")
+	ipMACCache.SetIPMAC("192.168.70.3", "aa:bb:cc:00:70:03")
+	t.Cleanup(func() {
+		clientHostNames.mu.Lock()
+		delete(clientHostNames.names, "192.168.70.1")
+		clientHostNames.mu.Unlock()
+	})
+	tests := []struct {
+		name  string
+		qname string
+		qtype uint16
+		want  string // "" means no local answer
+	}{
+		{"stored name", "1.70.168.192.in-addr.arpa.", dns.TypePTR, "laptop.home.arpa."},
+		{"configured name", "2.70.168.192.in-addr.arpa.", dns.TypePTR, "nas.home.arpa."},
+		{"synthesized from the MAC", "3.70.168.192.in-addr.arpa.", dns.TypePTR, "device-aabbcc007003.home.arpa."},
+		{"unknown client", "4.70.168.192.in-addr.arpa.", dns.TypePTR, ""},
+		{"not a PTR query", "1.70.168.192.in-addr.arpa.", dns.TypeA, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr, ok := answerPTR(AppConfig(), dns.Question{Name: tt.qname, Qtype: tt.qtype, Qclass: dns.ClassINET})
+			if tt.want == "" {
+				if ok {
+					t.Fatalf("answerPTR = %v, want no answer", rr)
+				}
+				return
+			}
+			ptr, isPTR := rr.(*dns.PTR)
+			if !ok || !isPTR || ptr.Ptr != tt.want || ptr.Hdr.Name != tt.qname {
+				t.Fatalf("answerPTR = %v, %t; want PTR %s", rr, ok, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// forwardQuery sends a copy of r to upstream. With cfg.RandomizeQueryCase
+// set, question names go out with randomized letter case (0x20 encoding), which
+// makes forged answers harder to land. The response must echo each question
+// name; resolvers that normalize case are tolerated, but an answer for a
+// different name is rejected. Answer names are restored to the client's case.
+func forwardQuery(ctx context.Context, cfg *Config, r *dns.Msg, upstream string) (*dns.Msg, error) {
+	randomize := cfg.RandomizeQueryCase
+	out := r.Copy()
+	if randomize {
+		for i := range out.Question {
+			out.Question[i].Name = randomizeCase(out.Question[i].Name)
+		}
+	}
+
+	resp, err := exchangeUpstream(ctx, cfg, out, upstream)
+	if err != nil || resp == nil || !randomize {
+		return resp, err
+	}
+
+	if err := checkEchoedQuestions(out.Question, resp.Question); err != nil {
+		return nil, err
+	}
+	restoreAnswerCase(resp, r.Question)
+	return resp, nil
+}
+
+// randomizeCase flips the case of each ASCII letter in name at random
+func randomizeCase(name string) string {
+	b := []byte(name)
+	for i, c := range b {
+		if ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') {
+			if rand.IntN(2) == 0 {
+				b[i] = c ^ 0x20
+			}
+		}
+	}
+	return string(b)
+}
+
+// checkEchoedQuestions verifies the response carries the questions that were
+// sent. An exact (case-preserving) echo is expected; a case-only difference is
+// accepted because some resolvers lowercase names.
+func checkEchoedQuestions(sent, got []dns.Question) error {
+	if len(got) != len(sent) {
+		return fmt.Errorf("upstream answered %d questions, sent %d", len(got), len(sent))
+	}
+	for i := range sent {
+		if got[i].Name == sent[i].Name {
+			continue
+		}
+		if !strings.EqualFold(got[i].Name, sent[i].Name) {
+			return fmt.Errorf("upstream answered for %q, asked %q", got[i].Name, sent[i].Name)
+		}
+		slog.Debug("upstream did not preserve query case", "sent", sent[i].Name, "got", got[i].Name)
+	}
+	return nil
+}
+
+// restoreAnswerCase rewrites answer owner names matching a question back to the
+// case the client used
+func restoreAnswerCase(resp *dns.Msg, questions []dns.Question) {
+	for _, rr := range resp.Answer {
+		h := rr.Header()
+		for _, q := range questions {
+			if strings.EqualFold(h.Name, q.Name) {
+				h.Name = q.Name
+				break
+			}
+		}
+	}
+}
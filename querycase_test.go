@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestCheckEchoedQuestions(t *testing.T) {
+	sent := []dns.Question{{Name: "wWw.ExAmPle.com.", Qtype: dns.TypeA, Qclass: dns.ClassINET}}
+	tests := []struct {
+		name string
+		got  []dns.Question
+		ok   bool
+	}{
+		{"exact echo", []dns.Question{{Name: "wWw.ExAmPle.com."}}, true},
+		{"case normalized", []dns.Question{{Name: "www.example.com."}}, true},
+		{"different name", []dns.Question{{Name: "www.example.net."}}, false},
+		{"no question", nil, false},
+		{"extra question", []dns.Question{{Name: "wWw.ExAmPle.com."}, {Name: "other.example."}}, false},
+	}
+	for _, tt := range tests {
+		if err := checkEchoedQuestions(sent, tt.got); (err == nil) != tt.ok {
+			t.Errorf("%s: checkEchoedQuestions = %v, want ok %v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestRandomizeCase(t *testing.T) {
+	const name = "www.some-long-name.example.com."
+	varied := false
+	for i := 0; i < 20; i++ {
+		got := randomizeCase(name)
+		if !strings.EqualFold(got, name) {
+			t.Fatalf("randomizeCase(%q) = %q, not the same name", name, got)
+		}
+		varied = varied || got != name
+	}
+	if !varied {
+		t.Error("randomizeCase never changed the case")
+	}
+}
+
+func TestForwardQueryCase(t *testing.T) {
+	// echo answers with the question as received; lower answers as a resolver
+	// that normalizes case would; spoof answers for a different name
+	tests := []struct {
+		name      string
+		randomize bool
+		reply     string
+		wantErr   bool
+	}{
+		{"off", false, "echo", false},
+		{"echoed", true, "echo", false},
+		{"lowercased by upstream", true, "lower", false},
+		{"answer for another name", true, "spoof", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				mu   sync.Mutex
+				seen string
+			)
+			upstream := startFakeUpstream(t, func(w dns.ResponseWriter, r *dns.Msg) {
+				mu.Lock()
+				seen = r.Question[0].Name
+				mu.Unlock()
+				m := new(dns.Msg)
+				m.SetReply(r)
+				switch tt.reply {
+				case "lower":
+					m.Question[0].Name = strings.ToLower(m.Question[0].Name)
+				case "spoof":
+					m.Question[0].Name = "evil.example."
+				}
+				rr, _ := dns.NewRR(m.Question[0].Name + " 60 IN A 192.0.2.1")
+				m.Answer = append(m.Answer, rr)
+				w.WriteMsg(m)
+			})
+			withConfig(t, func(c *Config) {
+				c.Upstream = upstream
+				c.UpstreamProtocol = "tcp"
+				c.RandomizeQueryCase = tt.randomize
+			})
+
+			const qname = "www.longer-name-for-case.example."
+			r := new(dns.Msg)
+			r.SetQuestion(qname, dns.TypeA)
+			resp, err := forwardQuery(context.Background(), AppConfig(), r, upstream)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("forwardQuery accepted an answer for another name: %v", resp)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if r.Question[0].Name != qname {
+				t.Errorf("client's question changed to %q", r.Question[0].Name)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if !strings.EqualFold(seen, qname) || (!tt.randomize && seen != qname) {
+				t.Errorf("upstream was asked %q for %q", seen, qname)
+			}
+			if len(resp.Answer) != 1 || resp.Answer[0].Header().Name != qname {
+				t.Errorf("answer %v, want owner name %q", resp.Answer, qname)
+			}
+		})
+	}
+}
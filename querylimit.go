@@ -0,0 +1,32 @@
+package main
+
+// queryLimiter caps how many queries are waiting on an upstream at once so a
+// flood can't exhaust sockets or memory. It never blocks: when every slot is
+// taken the caller fails the query instead of queueing it.
+type queryLimiter struct {
+	slots chan struct{}
+}
+
+func newQueryLimiter(n int) *queryLimiter {
+	return &queryLimiter{slots: make(chan struct{}, n)}
+}
+
+// TryAcquire takes a slot, reporting false when the limiter is saturated
+func (l *queryLimiter) TryAcquire() bool {
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// Release frees a slot taken by TryAcquire
+func (l *queryLimiter) Release() {
+	<-l.slots
+}
+
+// InFlight returns how many slots are taken
+func (l *queryLimiter) InFlight() int {
+	return len(l.slots)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestQueryLimiter(t *testing.T) {
+	l := newQueryLimiter(2)
+	// each step runs against the limiter the previous one left
+	steps := []struct {
+		op           string // "acquire" or "release"
+		wantAcquired bool
+		wantInFlight int
+	}{
+		{"acquire", true, 1},
+		{"acquire", true, 2},
+		{"acquire", false, 2},
+		{"release", false, 1},
+		{"acquire", true, 2},
+		{"release", false, 1},
+		{"release", false, 0},
+	}
+	for i, st := range steps {
+		if st.op == "acquire" {
+			if got := l.TryAcquire(); got != st.wantAcquired {
+				t.Fatalf("step %d: TryAcquire = %v, want %v", i, got, st.wantAcquired)
+			}
+		} else {
+			l.Release()
+		}
+		if got := l.InFlight(); got != st.wantInFlight {
+			t.Fatalf("step %d: InFlight = %d, want %d", i, got, st.wantInFlight)
+		}
+	}
+}
+
+func TestDNSHandlerCapsUpstreamQueries(t *testing.T) {
+	arrived, unblock := make(chan struct{}), make(chan struct{})
+	upstream := startFakeUpstream(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		if r.Question[0].Name == "slow.example." {
+			arrived <- struct{}{}
+			<-unblock
+		}
+		fakeZone(w, r)
+	})
+	withConfig(t, func(c *Config) {
+		c.Upstream, c.UpstreamProtocol = upstream, "tcp"
+		c.MaxConcurrentQueries = 1
+	})
+	h := newTestDNSHandler(t, map[string][]string{"ads": {"ads.example"}})
+
+	// hold the only upstream slot
+	done := make(chan *fakeResponseWriter)
+	go func() { done <- serveQuery(h, "127.0.0.1", "slow.example", dns.TypeA) }()
+	<-arrived
+
+	tests := []struct {
+		name      string
+		domain    string
+		wantRcode int
+	}{
+		{"forwarded query refused while saturated", "other.example", dns.RcodeServerFailure},
+		{"blocked query still answered", "ads.example", dns.RcodeSuccess},
+	}
+	for _, tt := range tests {
+		w := serveQuery(h, "127.0.0.1", tt.domain, dns.TypeA)
+		if w.msg == nil || w.msg.Rcode != tt.wantRcode {
+			t.Errorf("%s: reply %v, want %s", tt.name, w.msg, dns.RcodeToString[tt.wantRcode])
+		}
+	}
+
+	close(unblock)
+	if w := <-done; w.msg == nil || w.msg.Rcode != dns.RcodeSuccess || len(w.msg.Answer) != 1 {
+		t.Errorf("slow query: reply %v", w.msg)
+	}
+	// the slot is free again
+	if w := serveQuery(h, "127.0.0.1", "other.example", dns.TypeA); w.msg == nil || w.msg.Rcode != dns.RcodeSuccess {
+		t.Errorf("after the slow query: reply %v", w.msg)
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rustControlAddr is where the rust backend's control API listens
+const rustControlAddr = "127.0.0.1:9080"
+
+// rustReloadAttempts and rustReloadBackoff bound retries of a failed reload
+const (
+	rustReloadAttempts = 3
+	rustReloadBackoff  = 250 * time.Millisecond
+)
+
+var rustControlClient = &http.Client{Timeout: 2 * time.Second}
+
+// rustEventsAddr is where the rust backend posts query events: the API, reached
+// over loopback when it listens on every interface
+func rustEventsAddr() string {
+	host, port, err := net.SplitHostPort(AppConfig().APIBind)
+	if err != nil {
+		return AppConfig().APIBind
+	}
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		return net.JoinHostPort("127.0.0.1", port)
+	}
+	return AppConfig().APIBind
+}
+
+// rustEventsToken authenticates query events posted by the rust backend. It is
+// generated per run and handed to rust via RUSTDNS_EVENTS_TOKEN.
+var rustEventsToken = generateSessionID()
+
+// rustEventsEnv returns the environment that tells rust where to report query
+// events and where to read the list files from
+func rustEventsEnv() []string {
+	return []string{
+		"RUSTDNS_EVENTS_ADDR=" + rustEventsAddr(),
+		"RUSTDNS_EVENTS_TOKEN=" + rustEventsToken,
+		"RUSTDNS_BLOCKLIST_DIR=" + AppConfig().BlocklistDir,
+	}
+}
+
+// rustReload coalesces overlapping reload notifications: while one is in flight
+// further calls only mark a follow-up as pending.
+var rustReload struct {
+	mu      sync.Mutex
+	running bool
+	pending bool
+}
+
+// notifyRustReload tells the rust DNS backend to reload its blocklists. It is a
+// logged no-op when rust isn't the active backend. Callers run it in a goroutine
+// so HTTP handlers never wait on the control API.
+func notifyRustReload() {
+	switch backend := dnsBackend.Active(); backend {
+	case backendRustFFI, backendRustSubprocess:
+	default:
+		slog.Debug("skipping rust reload; rust backend not active", "backend", backend)
+		return
+	}
+
+	rustReload.mu.Lock()
+	if rustReload.running {
+		rustReload.pending = true
+		rustReload.mu.Unlock()
+		return
+	}
+	rustReload.running = true
+	rustReload.mu.Unlock()
+
+	for {
+		if err := postRustReload("http://" + rustControlAddr + "/reload"); err != nil {
+			slog.Error("notify rust reload failed", "err", err)
+		}
+		rustReload.mu.Lock()
+		if !rustReload.pending {
+			rustReload.running = false
+			rustReload.mu.Unlock()
+			return
+		}
+		rustReload.pending = false
+		rustReload.mu.Unlock()
+	}
+}
+
+// notifyRustBlocking mirrors the blocking kill-switch into the rust backend. Like
+// notifyRustReload it is a no-op when rust isn't the active backend.
+func notifyRustBlocking(enabled bool) {
+	switch dnsBackend.Active() {
+	case backendRustFFI, backendRustSubprocess:
+	default:
+		return
+	}
+	body := fmt.Sprintf(`{"enabled":%t}`, enabled)
+	resp, err := rustControlClient.Post("http://"+rustControlAddr+"/blocking", "application/json", strings.NewReader(body))
+	if err != nil {
+		slog.Error("notify rust blocking failed", "enabled", enabled, "err", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		slog.Error("notify rust blocking failed", "enabled", enabled, "status", resp.StatusCode)
+	}
+}
+
+// postRustReload POSTs to the rust control API reload endpoint, retrying with
+// backoff on failure
+func postRustReload(url string) error {
+	delay := rustReloadBackoff
+	var err error
+	for attempt := 1; attempt <= rustReloadAttempts; attempt++ {
+		if err = postRustReloadOnce(url); err == nil {
+			slog.Info("notified rust reload", "attempt", attempt)
+			return nil
+		}
+		if attempt < rustReloadAttempts {
+			time.Sleep(delay)
+			delay *= 2
+		}
+	}
+	return err
+}
+
+// postRustReloadOnce makes a single reload request. The control API reports
+// reload errors in the JSON body with a 200 status, so the body is checked too.
+func postRustReloadOnce(url string) error {
+	resp, err := rustControlClient.Post(url, "application/json", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("rust reload returned status %d: %s", resp.StatusCode, string(body))
+	}
+	var out struct {
+		Error string `json:"error"`
+	}
+	if json.Unmarshal(body, &out) == nil && out.Error != "" {
+		return fmt.Errorf("rust reload failed: %s", out.Error)
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestPostRustReload(t *testing.T) {
+	type reply struct {
+		status int
+		body   string
+	}
+	ok := reply{http.StatusOK, `{"ok":true}`}
+	tests := []struct {
+		name      string
+		replies   []reply // the last one repeats
+		wantCalls int32
+		wantErr   bool
+	}{
+		{"accepted", []reply{ok}, 1, false},
+		{"empty body accepted", []reply{{http.StatusOK, ""}}, 1, false},
+		{"error in a 200 body", []reply{{http.StatusOK, `{"error":"bad list"}`}}, rustReloadAttempts, true},
+		{"server error", []reply{{http.StatusInternalServerError, "oops"}}, rustReloadAttempts, true},
+		{"retried until it works", []reply{{http.StatusServiceUnavailable, ""}, ok}, 2, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls atomic.Int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := int(calls.Add(1))
+				rep := tt.replies[min(n, len(tt.replies))-1]
+				if r.Method != http.MethodPost {
+					rep = reply{http.StatusMethodNotAllowed, ""}
+				}
+				w.WriteHeader(rep.status)
+				w.Write([]byte(rep.body))
+			}))
+			defer srv.Close()
+
+			err := postRustReload(srv.URL + "/reload")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("postRustReload = %v, want error %t", err, tt.wantErr)
+			}
+			if n := calls.Load(); n != tt.wantCalls {
+				t.Errorf("made %d requests, want %d", n, tt.wantCalls)
+			}
+		})
+	}
+}
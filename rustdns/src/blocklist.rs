@@ -11,6 +11,7 @@ pub async fn load_blocklists_into(dir: &str, lists: &Arc<RwLock<HashSet<String>>
     for entry in glob(&pattern)? {
         if let Ok(path) = entry {
             if path.is_file() {
+                if !list_enabled(&path).await { continue }
                 if let Ok(s) = tokio::fs::read_to_string(&path).await {
                     for line in s.lines() {
                         let line = line.trim();
@@ -32,6 +33,18 @@ pub async fn load_blocklists_into(dir: &str, lists: &Arc<RwLock<HashSet<String>>
     Ok(n)
 }
 
+// A list is disabled by `"enabled": false` in its `<name>.meta.json` sidecar.
+async fn list_enabled(path: &std::path::Path) -> bool {
+    let meta = path.with_extension("meta.json");
+    match tokio::fs::read_to_string(&meta).await {
+        Ok(s) => serde_json::from_str::<serde_json::Value>(&s)
+            .ok()
+            .and_then(|v| v.get("enabled").and_then(|e| e.as_bool()))
+            .unwrap_or(true),
+        Err(_) => true,
+    }
+}
+
 // Very simple matching: exact match or prefix/suffix wildcard patterns used in the lists.
 pub fn is_blocked_domain(name: &str, lists: &HashSet<String>) -> bool {
     let name = name.trim_end_matches('.').to_lowercase();
@@ -5,7 +5,7 @@ use serde_json::Value;
 use std::sync::Arc;
 
 pub async fn http_reload(state: Arc<ServerState>) -> Json<Value> {
-    match load_blocklists_into("./blocklist", &state.lists).await {
+    match load_blocklists_into(&state.blocklist_dir, &state.lists).await {
         Ok(n) => {
             tracing::info!("reloaded {} domains", n);
             Json(serde_json::json!({ "loaded": n }))
@@ -49,6 +49,16 @@ pub async fn http_remove(state: Arc<ServerState>, Json(payload): Json<Value>) ->
     }
 }
 
+pub async fn http_blocking(state: Arc<ServerState>, Json(payload): Json<Value>) -> Json<Value> {
+    if let Some(enabled) = payload.get("enabled").and_then(|v| v.as_bool()) {
+        state.blocking_enabled.store(enabled, std::sync::atomic::Ordering::Relaxed);
+        tracing::info!("blocking {}", if enabled { "enabled" } else { "disabled" });
+        Json(serde_json::json!({ "ok": true, "enabled": enabled }))
+    } else {
+        Json(serde_json::json!({ "ok": false, "error": "missing enabled" }))
+    }
+}
+
 pub async fn http_mode(state: Arc<ServerState>, Json(payload): Json<Value>) -> Json<Value> {
     if let Some(m) = payload.get("mode").and_then(|s| s.as_str()) {
         let mut mode = state.mode.write().await;
@@ -0,0 +1,72 @@
+use crate::state::ServerState;
+use serde::Serialize;
+use std::net::SocketAddr;
+use std::time::Duration;
+use tokio::io::{AsyncReadExt, AsyncWriteExt};
+use tokio::net::TcpStream;
+use tokio::sync::mpsc;
+use trust_dns_proto::rr::RecordType;
+
+/// A single query decision reported back to the Go process for analytics/logs.
+#[derive(Serialize, Clone)]
+pub struct QueryEvent {
+    pub domain: String,
+    pub client: String,
+    pub qtype: String,
+    pub blocked: bool,
+}
+
+/// Spawns a task that batches query events and POSTs them to
+/// `http://{addr}/events/queries`. Events are dropped (not queued forever) if
+/// the Go side falls behind.
+pub fn spawn_reporter(addr: String, token: String) -> mpsc::Sender<QueryEvent> {
+    let (tx, mut rx) = mpsc::channel::<QueryEvent>(4096);
+    tokio::spawn(async move {
+        let mut batch = Vec::new();
+        loop {
+            match rx.recv().await {
+                Some(ev) => batch.push(ev),
+                None => return,
+            }
+            while batch.len() < 500 {
+                match rx.try_recv() {
+                    Ok(ev) => batch.push(ev),
+                    Err(_) => break,
+                }
+            }
+            if let Err(e) = post_events(&addr, &token, &batch).await {
+                tracing::debug!("query event report failed: {:?}", e);
+            }
+            batch.clear();
+        }
+    });
+    tx
+}
+
+async fn post_events(addr: &str, token: &str, events: &[QueryEvent]) -> anyhow::Result<()> {
+    let body = serde_json::to_vec(events)?;
+    let mut stream = tokio::time::timeout(Duration::from_secs(2), TcpStream::connect(addr)).await??;
+    let head = format!(
+        "POST /events/queries HTTP/1.1\r\nHost: {}\r\nContent-Type: application/json\r\nX-Events-Token: {}\r\nContent-Length: {}\r\nConnection: close\r\n\r\n",
+        addr,
+        token,
+        body.len()
+    );
+    stream.write_all(head.as_bytes()).await?;
+    stream.write_all(&body).await?;
+    let mut buf = [0u8; 64];
+    let _ = tokio::time::timeout(Duration::from_secs(2), stream.read(&mut buf)).await;
+    Ok(())
+}
+
+/// Queues a query event if reporting is configured; never blocks the DNS path.
+pub fn report_query(state: &ServerState, qname: &str, qtype: RecordType, src: &SocketAddr, blocked: bool) {
+    if let Some(tx) = &state.events {
+        let _ = tx.try_send(QueryEvent {
+            domain: qname.trim_end_matches('.').to_lowercase(),
+            client: src.to_string(),
+            qtype: qtype.to_string(),
+            blocked,
+        });
+    }
+}
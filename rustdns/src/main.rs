@@ -1,5 +1,6 @@
 mod blocklist;
 mod control;
+mod events;
 mod server;
 mod state;
 mod runner;
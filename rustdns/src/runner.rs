@@ -1,30 +1,39 @@
 use crate::state::ServerState;
 use crate::blocklist::load_blocklists_into;
-use crate::control::{http_reload, http_stats, http_lists, http_add, http_remove, http_mode};
+use crate::control::{http_reload, http_stats, http_lists, http_add, http_remove, http_mode, http_blocking};
 use crate::server::run_udp_server;
+use crate::events::spawn_reporter;
 use axum::{routing::get, routing::post, Router};
 use std::collections::HashSet;
 use std::net::SocketAddr;
 use std::sync::Arc;
 use tokio::sync::RwLock;
-use std::sync::atomic::AtomicU64;
+use std::sync::atomic::{AtomicBool, AtomicU64};
 use tracing::info;
 
 pub async fn run_server(http_addr: String, udp_bind: String, shutdown_rx: tokio::sync::watch::Receiver<bool>) {
     tracing_subscriber::fmt::init();
 
     let lists = Arc::new(RwLock::new(HashSet::new()));
+    let blocklist_dir = std::env::var("RUSTDNS_BLOCKLIST_DIR").unwrap_or_else(|_| "./blocklist".to_string());
     let state = Arc::new(ServerState {
         lists: lists.clone(),
         queries: Arc::new(AtomicU64::new(0)),
         blocked: Arc::new(AtomicU64::new(0)),
         upstream: "1.1.1.1:53".to_string(),
+        blocklist_dir: blocklist_dir.clone(),
         mode: Arc::new(RwLock::new("nx".to_string())),
         block_page_ip: Arc::new(RwLock::new(None)),
+        // report query decisions to the Go process when it tells us where
+        events: std::env::var("RUSTDNS_EVENTS_ADDR").ok().map(|addr| {
+            let token = std::env::var("RUSTDNS_EVENTS_TOKEN").unwrap_or_default();
+            spawn_reporter(addr, token)
+        }),
+        blocking_enabled: Arc::new(AtomicBool::new(true)),
     });
 
     // initial load
-    if let Ok(n) = load_blocklists_into("./blocklist", &lists).await {
+    if let Ok(n) = load_blocklists_into(&blocklist_dir, &lists).await {
         info!("initially loaded {} domains", n);
     }
 
@@ -35,13 +44,15 @@ pub async fn run_server(http_addr: String, udp_bind: String, shutdown_rx: tokio:
     let st_add = state.clone();
     let st_remove = state.clone();
     let st_mode = state.clone();
+    let st_blocking = state.clone();
     let app = Router::new()
         .route("/reload", post(move || http_reload(st_http.clone())))
         .route("/stats", get(move || http_stats(st_stats.clone())))
         .route("/lists", get(move || http_lists(st_lists.clone())))
         .route("/add", post(move |b| http_add(st_add.clone(), b)))
         .route("/remove", post(move |b| http_remove(st_remove.clone(), b)))
-        .route("/mode", post(move |b| http_mode(st_mode.clone(), b)));
+        .route("/mode", post(move |b| http_mode(st_mode.clone(), b)))
+        .route("/blocking", post(move |b| http_blocking(st_blocking.clone(), b)));
 
     let http_addr: SocketAddr = http_addr.parse().unwrap_or_else(|_| "127.0.0.1:9080".parse().unwrap());
     let server = axum::Server::bind(&http_addr).serve(app.into_make_service());
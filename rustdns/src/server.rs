@@ -7,6 +7,7 @@ use trust_dns_proto::rr::rdata::A as ARecord;
 use std::time::Duration;
 use crate::state::ServerState;
 use crate::blocklist::is_blocked_domain;
+use crate::events::report_query;
 use std::net::Ipv4Addr;
 use std::sync::atomic::Ordering;
 
@@ -28,8 +29,10 @@ pub async fn run_udp_server(state: Arc<ServerState>, bind_addr: String, udp_upst
                     if let Some(q) = msg.queries().first() {
                         let qname = q.name().to_string();
                         let lists = state_cl.lists.read().await.clone();
-                        if is_blocked_domain(&qname, &lists) {
+                        let blocking = state_cl.blocking_enabled.load(Ordering::Relaxed);
+                        if blocking && is_blocked_domain(&qname, &lists) {
                             state_cl.blocked.fetch_add(1, Ordering::Relaxed);
+                            report_query(&state_cl, &qname, q.query_type(), &src, true);
                             let mode = state_cl.mode.read().await.clone();
                             let block_ip_opt = state_cl.block_page_ip.read().await.clone();
                             match mode.as_str() {
@@ -85,6 +88,7 @@ pub async fn run_udp_server(state: Arc<ServerState>, bind_addr: String, udp_upst
                                 }
                             }
                         }
+                        report_query(&state_cl, &qname, q.query_type(), &src, false);
                     }
                     if let Ok(up_resp) = forward_udp_to_upstream(&packet, &upstream).await {
                         let _ = sock_cl.send_to(&up_resp, &src).await;
@@ -1,8 +1,9 @@
 use serde::Serialize;
 use std::collections::HashSet;
 use std::sync::Arc;
-use std::sync::atomic::AtomicU64;
-use tokio::sync::RwLock;
+use std::sync::atomic::{AtomicBool, AtomicU64};
+use tokio::sync::{mpsc, RwLock};
+use crate::events::QueryEvent;
 
 #[derive(Clone)]
 pub struct ServerState {
@@ -10,8 +11,13 @@ pub struct ServerState {
     pub queries: Arc<AtomicU64>,
     pub blocked: Arc<AtomicU64>,
     pub upstream: String,
+    // directory the list files are loaded from (RUSTDNS_BLOCKLIST_DIR, default ./blocklist)
+    pub blocklist_dir: String,
     pub mode: Arc<RwLock<String>>,
     pub block_page_ip: Arc<RwLock<Option<String>>>,
+    pub events: Option<mpsc::Sender<QueryEvent>>,
+    // kill-switch mirrored from the Go process; when false every query is forwarded
+    pub blocking_enabled: Arc<AtomicBool>,
 }
 
 #[derive(Serialize)]
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// safeSearchTarget returns the safe-search host configured for domain, if any.
+func safeSearchTarget(cfg *Config, domain string) (string, bool) {
+	d := strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".")
+	target, ok := cfg.SafeSearchTargets[d]
+	if !ok || target == "" {
+		return "", false
+	}
+	return strings.TrimSuffix(strings.ToLower(target), "."), true
+}
+
+// safeSearchEnabledFor reports whether safe search should be enforced for the
+// given user. Users without a stored preference get cfg.SafeSearch.
+func safeSearchEnabledFor(cfg *Config, macAddress string, am *AccountManager) bool {
+	if macAddress == "" || am == nil {
+		return cfg.SafeSearch
+	}
+	enabled, ok, err := am.GetSafeSearch(macAddress)
+	if err != nil {
+		slog.Error("failed to get safe search setting", "mac", macAddress, "err", err)
+		return cfg.SafeSearch
+	}
+	if !ok {
+		return cfg.SafeSearch
+	}
+	return enabled
+}
+
+// resolveSafeSearch answers q with a CNAME to target followed by the target's
+// records as returned by upstream. Only A and AAAA queries are rewritten.
+func resolveSafeSearch(ctx context.Context, cfg *Config, q dns.Question, target, upstream string) ([]dns.RR, error) {
+	if q.Qtype != dns.TypeA && q.Qtype != dns.TypeAAAA {
+		return nil, fmt.Errorf("safe search rewrite not supported for %s", dns.TypeToString[q.Qtype])
+	}
+	fqdn := dns.Fqdn(target)
+	cname := &dns.CNAME{
+		Hdr:    dns.RR_Header{Name: q.Name, Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: 300},
+		Target: fqdn,
+	}
+
+	m := new(dns.Msg)
+	m.SetQuestion(fqdn, q.Qtype)
+	m.RecursionDesired = true
+	resp, err := exchangeUpstream(ctx, cfg, m, upstream)
+	if err != nil {
+		return nil, err
+	}
+	if resp == nil || resp.Rcode != dns.RcodeSuccess {
+		return nil, fmt.Errorf("upstream failed to resolve %s", fqdn)
+	}
+	return append([]dns.RR{cname}, resp.Answer...), nil
+}
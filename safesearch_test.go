@@ -0,0 +1,103 @@
+package main
+
+import (
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestSafeSearchTarget(t *testing.T) {
+	tests := []struct {
+		domain string
+		want   string // "" means not rewritten
+	}{
+		{"www.google.com", "forcesafesearch.google.com"},
+		{"WWW.Google.COM.", "forcesafesearch.google.com"},
+		{"  bing.com ", "strict.bing.com"},
+		{"www.youtube.com", "restrict.youtube.com"},
+		{"mail.google.com", ""},
+		{"google.com.evil.example", ""},
+		{"example.com", ""},
+	}
+	for _, tt := range tests {
+		got, ok := safeSearchTarget(AppConfig(), tt.domain)
+		if ok != (tt.want != "") || got != tt.want {
+			t.Errorf("safeSearchTarget(%q) = %q, %t; want %q", tt.domain, got, ok, tt.want)
+		}
+	}
+}
+
+func TestSafeSearchEnabledFor(t *testing.T) {
+	const optedOut, optedIn, unset = "aa:aa:aa:aa:aa:01", "aa:aa:aa:aa:aa:02", "aa:aa:aa:aa:aa:03"
+	am := newTestAccountManager(t, optedOut, optedIn, unset)
+	if err := am.SetSafeSearch(optedOut, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := am.SetSafeSearch(optedIn, true); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		fallback bool
+		mac      string
+		want     bool
+	}{
+		{"unknown device gets the default (on)", true, "", true},
+		{"unknown device gets the default (off)", false, "", false},
+		{"no preference gets the default", true, unset, true},
+		{"opted out despite the default", true, optedOut, false},
+		{"opted in despite the default", false, optedIn, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withConfig(t, func(c *Config) { c.SafeSearch = tt.fallback })
+			if got := safeSearchEnabledFor(AppConfig(), tt.mac, am); got != tt.want {
+				t.Errorf("safeSearchEnabledFor(%q) = %t, want %t", tt.mac, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDNSHandlerSafeSearchRewrite(t *testing.T) {
+	upstream := startFakeUpstream(t, fakeZone)
+	tests := []struct {
+		name      string
+		enforced  bool
+		qname     string
+		qtype     uint16
+		wantCNAME string // "" means answered as is
+	}{
+		{"rewritten when enforced", true, "www.google.com", dns.TypeA, "forcesafesearch.google.com."},
+		{"AAAA rewritten too", true, "www.bing.com", dns.TypeAAAA, "strict.bing.com."},
+		{"left alone when not enforced", false, "www.google.com", dns.TypeA, ""},
+		{"other types left alone", true, "www.google.com", dns.TypeMX, ""},
+		{"other names left alone", true, "example.com", dns.TypeA, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withConfig(t, func(c *Config) {
+				c.Upstream = upstream
+				c.UpstreamProtocol = "tcp"
+				c.SafeSearch = tt.enforced
+			})
+			h := newTestDNSHandler(t, nil)
+			r := new(dns.Msg)
+			r.SetQuestion(dns.Fqdn(tt.qname), tt.qtype)
+			w := &fakeResponseWriter{remote: &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 40000}}
+			h(w, r)
+			if w.msg == nil || w.msg.Rcode != dns.RcodeSuccess {
+				t.Fatalf("reply = %v, want NOERROR", w.msg)
+			}
+			var cname string
+			for _, rr := range w.msg.Answer {
+				if c, ok := rr.(*dns.CNAME); ok {
+					cname = c.Target
+				}
+			}
+			if cname != tt.wantCNAME {
+				t.Errorf("CNAME = %q, want %q", cname, tt.wantCNAME)
+			}
+		})
+	}
+}
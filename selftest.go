@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// selfTestTimeout bounds the test query sent to each upstream
+const selfTestTimeout = 5 * time.Second
+
+// upstreamExchanger sends one query to one upstream. exchangeVia is the real
+// one; a stub can stand in for it to check the report without a network.
+type upstreamExchanger func(ctx context.Context, m *dns.Msg, upstream string) (*dns.Msg, error)
+
+// configuredExchange queries an upstream with the configured upstream protocol
+func configuredExchange(ctx context.Context, m *dns.Msg, upstream string) (*dns.Msg, error) {
+	return exchangeVia(ctx, AppConfig(), m, upstream, true)
+}
+
+// selfTestResult is one line of the -selftest report
+type selfTestResult struct {
+	Check string
+	Err   error
+}
+
+// runSelfTest checks what a fresh install most often gets wrong: that the DNS
+// address can be bound, the upstreams answer and the directories are
+// writable. It prints a PASS/FAIL line per check to w and reports whether
+// all of them passed.
+func runSelfTest(w io.Writer, c *Config, exchange upstreamExchanger) bool {
+	var results []selfTestResult
+	results = append(results, selfTestResult{"bind dns_bind " + c.DNSBind, checkDNSBind(c.DNSBind)})
+	upstreams := append([]string{defaultUpstream(c)}, c.FallbackUpstreams...)
+	for _, u := range upstreams {
+		results = append(results, selfTestResult{"query upstream " + u, checkUpstream(context.Background(), u, exchange)})
+	}
+	if c.InMemoryLists {
+		_, err := memStoreFromDir(c.BlocklistDir)
+		results = append(results, selfTestResult{"read blocklist_dir " + c.BlocklistDir, err})
+	} else {
+		results = append(results, selfTestResult{"write blocklist_dir " + c.BlocklistDir, ensureWritableDir("blocklist_dir", c.BlocklistDir)})
+	}
+	results = append(results, selfTestResult{"write data_dir " + c.DataDir, ensureWritableDir("data_dir", c.DataDir)})
+
+	ok := true
+	for _, r := range results {
+		if r.Err != nil {
+			ok = false
+			fmt.Fprintf(w, "FAIL  %s: %v\n", r.Check, r.Err)
+			continue
+		}
+		fmt.Fprintf(w, "PASS  %s\n", r.Check)
+	}
+	return ok
+}
+
+// checkDNSBind binds addr over UDP and TCP and lets go again
+func checkDNSBind(addr string) error {
+	pc, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return bindHint(err)
+	}
+	pc.Close()
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return bindHint(err)
+	}
+	return l.Close()
+}
+
+// bindHint adds the usual cause to a failed bind
+func bindHint(err error) error {
+	switch {
+	case errors.Is(err, syscall.EADDRINUSE):
+		return fmt.Errorf("%w (another DNS server, often systemd-resolved, holds the port)", err)
+	case errors.Is(err, syscall.EACCES):
+		return fmt.Errorf("%w (ports below 1024 need root or CAP_NET_BIND_SERVICE)", err)
+	}
+	return err
+}
+
+// checkUpstream asks upstream for the root name servers, which every
+// recursive resolver can answer
+func checkUpstream(ctx context.Context, upstream string, exchange upstreamExchanger) error {
+	ctx, cancel := context.WithTimeout(ctx, selfTestTimeout)
+	defer cancel()
+	m := new(dns.Msg)
+	m.SetQuestion(".", dns.TypeNS)
+	m.RecursionDesired = true
+	resp, err := exchange(ctx, m, upstream)
+	if err != nil {
+		return err
+	}
+	if resp == nil {
+		return errors.New("no response")
+	}
+	if resp.Rcode != dns.RcodeSuccess {
+		return fmt.Errorf("answered %s", dns.RcodeToString[resp.Rcode])
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// stubExchange answers every upstream with rcode, except that fail fails
+func stubExchange(rcode int, fail string) upstreamExchanger {
+	return func(_ context.Context, m *dns.Msg, upstream string) (*dns.Msg, error) {
+		if upstream == fail {
+			return nil, errors.New("i/o timeout")
+		}
+		resp := new(dns.Msg)
+		resp.SetRcode(m, rcode)
+		return resp, nil
+	}
+}
+
+func TestRunSelfTest(t *testing.T) {
+	base := t.TempDir()
+	file := filepath.Join(base, "a-file")
+	if err := os.WriteFile(file, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+	// a port nothing holds; dns_bind must name one, so :0 won't do
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	free := l.Addr().String()
+	l.Close()
+
+	const up, fallback = "192.0.2.53:53", "192.0.2.54:53"
+	tests := []struct {
+		name     string
+		edit     func(c *Config)
+		exchange upstreamExchanger
+		ok       bool
+		want     []string // lines, or the start of them, in order
+	}{
+		{"all pass", nil, stubExchange(dns.RcodeSuccess, ""), true, []string{
+			"PASS  bind dns_bind " + free,
+			"PASS  query upstream " + up,
+			"PASS  query upstream " + fallback,
+			"PASS  write blocklist_dir",
+			"PASS  write data_dir",
+		}},
+		{"port held", func(c *Config) { c.DNSBind = busy.Addr().String() }, stubExchange(dns.RcodeSuccess, ""), false, []string{
+			"FAIL  bind dns_bind " + busy.Addr().String() + ": ",
+		}},
+		{"upstream refuses", nil, stubExchange(dns.RcodeRefused, ""), false, []string{
+			"PASS  bind",
+			"FAIL  query upstream " + up + ": answered REFUSED",
+			"FAIL  query upstream " + fallback + ": answered REFUSED",
+		}},
+		{"fallback unreachable", nil, stubExchange(dns.RcodeSuccess, fallback), false, []string{
+			"PASS  bind",
+			"PASS  query upstream " + up,
+			"FAIL  query upstream " + fallback + ": i/o timeout",
+		}},
+		{"blocklist_dir is a file", func(c *Config) { c.BlocklistDir = file }, stubExchange(dns.RcodeSuccess, ""), false, []string{
+			"PASS  bind", "PASS  query", "PASS  query",
+			"FAIL  write blocklist_dir " + file + ": ",
+			"PASS  write data_dir",
+		}},
+		{"in-memory lists only read", func(c *Config) { c.InMemoryLists = true }, stubExchange(dns.RcodeSuccess, ""), true, []string{
+			"PASS  bind", "PASS  query", "PASS  query",
+			"PASS  read blocklist_dir",
+		}},
+		{"in-memory lists missing", func(c *Config) { c.InMemoryLists, c.BlocklistDir = true, filepath.Join(base, "missing") }, stubExchange(dns.RcodeSuccess, ""), false, []string{
+			"PASS  bind", "PASS  query", "PASS  query",
+			"FAIL  read blocklist_dir",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withConfig(t, func(c *Config) {
+				c.DNSBind = free
+				c.Upstream, c.FallbackUpstreams = up, []string{fallback}
+				c.BlocklistDir, c.DataDir = t.TempDir(), t.TempDir()
+				if tt.edit != nil {
+					tt.edit(c)
+				}
+			})
+			var out bytes.Buffer
+			if got := runSelfTest(&out, AppConfig(), tt.exchange); got != tt.ok {
+				t.Errorf("runSelfTest = %v, want %v", got, tt.ok)
+			}
+			lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+			if len(lines) != 5 {
+				t.Fatalf("report has %d lines, want 5:\n%s", len(lines), &out)
+			}
+			for i, want := range tt.want {
+				if !strings.HasPrefix(lines[i], want) {
+					t.Errorf("line %d = %q, want it to start with %q", i+1, lines[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestBindHint(t *testing.T) {
+	other := errors.New("no such host")
+	tests := []struct {
+		err  error
+		hint string // "" for none
+	}{
+		{&net.OpError{Op: "listen", Err: os.NewSyscallError("bind", syscall.EADDRINUSE)}, "systemd-resolved"},
+		{&net.OpError{Op: "listen", Err: os.NewSyscallError("bind", syscall.EACCES)}, "CAP_NET_BIND_SERVICE"},
+		{other, ""},
+	}
+	for _, tt := range tests {
+		got := bindHint(tt.err)
+		if !errors.Is(got, tt.err) {
+			t.Errorf("bindHint(%v) = %v, want it to wrap the error", tt.err, got)
+		}
+		if tt.hint == "" && got != tt.err || !strings.Contains(got.Error(), tt.hint) {
+			t.Errorf("bindHint(%v) = %v, want hint %q", tt.err, got, tt.hint)
+		}
+	}
+	if err := checkDNSBind("127.0.0.1:0"); err != nil {
+		t.Errorf("checkDNSBind on a free port: %v", err)
+	}
+}
+
+func TestCheckUpstreamWithConfiguredProtocol(t *testing.T) {
+	upstream := startFakeUpstream(t, fakeZone)
+	withConfig(t, func(c *Config) { c.UpstreamProtocol = "tcp" })
+	if err := checkUpstream(context.Background(), upstream, configuredExchange); err != nil {
+		t.Errorf("checkUpstream against a working upstream: %v", err)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+)
+
+// statsPushTimeout bounds one push so a slow sink can't pile up requests
+const statsPushTimeout = 10 * time.Second
+
+// statsPushTopN is how many blocked and allowed domains a push carries
+const statsPushTopN = 10
+
+var statsPushClient = &http.Client{Timeout: statsPushTimeout}
+
+// StatsPush is the JSON body POSTed to stats_push_url
+type StatsPush struct {
+	Host       string     `json:"host"` // this Pi's hostname, to tell senders apart
+	Time       time.Time  `json:"time"`
+	Queries    int        `json:"queries"`
+	Blocked    int        `json:"blocked"`
+	Clients    int        `json:"clients"`
+	TopBlocked []TopCount `json:"top_blocked"`
+	TopAllowed []TopCount `json:"top_allowed"`
+}
+
+// statsPushPayload summarizes the network-wide analytics
+func (b *BlocklistManager) statsPushPayload() StatsPush {
+	host, _ := os.Hostname()
+	s := b.GetStats()
+	p := StatsPush{
+		Host:    host,
+		Time:    time.Now().UTC(),
+		Queries: s.Queries,
+		Blocked: s.Blocked,
+		Clients: len(s.ClientHits),
+	}
+	p.TopBlocked, _ = b.GetTop("", "blocked", statsPushTopN)
+	p.TopAllowed, _ = b.GetTop("", "allowed", statsPushTopN)
+	return p
+}
+
+// pushStats POSTs the current analytics summary to url
+func (b *BlocklistManager) pushStats(ctx context.Context, url string) error {
+	body, err := json.Marshal(b.statsPushPayload())
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, statsPushTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := statsPushClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("sink returned %s", resp.Status)
+	}
+	return nil
+}
+
+// statsPusher pushes the analytics to url every interval until ctx is done.
+// Pushes are best-effort: a failure is logged and the next tick tries again.
+func (b *BlocklistManager) statsPusher(ctx context.Context, url string, interval time.Duration) {
+	log.Printf("pushing analytics to %s every %s", url, interval)
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if err := b.pushStats(ctx, url); err != nil {
+				slog.Warn("stats push failed", "url", url, "err", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPushStats(t *testing.T) {
+	bm := newTestBlocklistManager(t, nil)
+	for _, q := range []struct {
+		domain, client string
+		blocked        bool
+	}{
+		{"ads.example", "192.168.73.1:1000", true},
+		{"ads.example", "192.168.73.2:1000", true},
+		{"track.example", "192.168.73.1:1001", true},
+		{"news.example", "192.168.73.2:1000", false},
+	} {
+		bm.RecordQueryOfType(q.domain, q.client, "A", q.blocked)
+	}
+
+	tests := []struct {
+		name    string
+		status  int
+		wantErr bool
+	}{
+		{"accepted", http.StatusNoContent, false},
+		{"sink error", http.StatusInternalServerError, true},
+		{"not a 2xx", http.StatusNotModified, true},
+	}
+	for _, tt := range tests {
+		var got StatsPush
+		var contentType string
+		sink := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			contentType = r.Header.Get("Content-Type")
+			if r.Method != http.MethodPost || json.NewDecoder(r.Body).Decode(&got) != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			w.WriteHeader(tt.status)
+		}))
+		err := bm.pushStats(context.Background(), sink.URL)
+		sink.Close()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: pushStats = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+		if contentType != "application/json" {
+			t.Errorf("%s: content type %q", tt.name, contentType)
+		}
+		if got.Queries != 4 || got.Blocked != 3 || got.Clients != 2 || time.Since(got.Time) > time.Minute {
+			t.Errorf("%s: pushed %+v", tt.name, got)
+		}
+		wantBlocked := []TopCount{{"ads.example", 2}, {"track.example", 1}}
+		wantAllowed := []TopCount{{"news.example", 1}}
+		if !reflect.DeepEqual(got.TopBlocked, wantBlocked) || !reflect.DeepEqual(got.TopAllowed, wantAllowed) {
+			t.Errorf("%s: top blocked %v, allowed %v; want %v, %v", tt.name, got.TopBlocked, got.TopAllowed, wantBlocked, wantAllowed)
+		}
+	}
+}
+
+func TestStatsPusherRunsUntilCancelled(t *testing.T) {
+	pushes := make(chan struct{}, 10)
+	sink := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case pushes <- struct{}{}:
+		default:
+		}
+		// failures are logged and the next tick tries again
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer sink.Close()
+	bm := newTestBlocklistManager(t, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		bm.statsPusher(ctx, sink.URL, 10*time.Millisecond)
+		close(done)
+	}()
+	for i := 0; i < 2; i++ {
+		select {
+		case <-pushes:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("only %d pushes arrived", i)
+		}
+	}
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("statsPusher didn't return after cancel")
+	}
+}
+
+func TestStatsPushConfig(t *testing.T) {
+	tests := []struct {
+		url, interval string
+		ok            bool
+		every         time.Duration
+	}{
+		{"", "", true, defaultStatsPushInterval},
+		{"https://stats.example/ingest", "30s", true, 30 * time.Second},
+		{"http://10.0.0.5:8080/", "", true, defaultStatsPushInterval},
+		{"ftp://stats.example/", "", false, defaultStatsPushInterval},
+		{"stats.example/ingest", "", false, defaultStatsPushInterval},
+		{"https://", "", false, defaultStatsPushInterval},
+		{"", "often", false, defaultStatsPushInterval},
+		{"", "-1m", false, defaultStatsPushInterval},
+	}
+	for _, tt := range tests {
+		c := defaultConfig()
+		c.StatsPushURL, c.StatsPushInterval = tt.url, tt.interval
+		if err := c.Validate(); (err == nil) != tt.ok {
+			t.Errorf("Validate with url %q, interval %q = %v, want ok %v", tt.url, tt.interval, err, tt.ok)
+		}
+		if got := c.StatsPushEvery(); got != tt.every {
+			t.Errorf("StatsPushEvery(%q) = %v, want %v", tt.interval, got, tt.every)
+		}
+	}
+}
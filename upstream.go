@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// upstreamTimeout bounds dialing and each read/write to an upstream resolver
+const upstreamTimeout = 5 * time.Second
+
+// connPoolMaxIdle is how many idle TCP or DNS-over-TLS connections are kept per upstream
+const connPoolMaxIdle = 4
+
+// defaultUpstream is the resolver used when no conditional forwarder matches
+func defaultUpstream(cfg *Config) string {
+	if cfg.Upstream != "" {
+		return cfg.Upstream
+	}
+	if cfg.UpstreamProtocol == "dot" {
+		return "1.1.1.1:853"
+	}
+	return "1.1.1.1:53"
+}
+
+// udpClient is shared by every plain UDP exchange. It still dials a new socket
+// per query: a fresh source port for each one is what makes forged answers hard
+// to land, so UDP sockets are deliberately not pooled.
+var udpClient = &dns.Client{ReadTimeout: upstreamTimeout, WriteTimeout: upstreamTimeout}
+
+// exchangeUpstream sends m to upstream, giving up when ctx is done. Queries for
+// the default upstream fail over to cfg.FallbackUpstreams, skipping
+// upstreams that are failing (see exchangeWithFailover). Conditional forwarders
+// are usually LAN resolvers with no alternative and are always used directly.
+func exchangeUpstream(ctx context.Context, cfg *Config, m *dns.Msg, upstream string) (*dns.Msg, error) {
+	if upstream == defaultUpstream(cfg) {
+		return exchangeWithFailover(ctx, cfg, m)
+	}
+	resp, err := exchangeVia(ctx, cfg, m, upstream, false)
+	upstreamHealth.record(ctx, upstream, err)
+	return resp, err
+}
+
+// exchangeVia sends m to one upstream. The default and fallback upstreams
+// (configured set) use cfg.UpstreamProtocol; conditional forwarders
+// always use plain UDP. DNS-over-TLS failures are returned as errors rather
+// than retried in plaintext, which would defeat the point of using TLS.
+func exchangeVia(ctx context.Context, cfg *Config, m *dns.Msg, upstream string, configured bool) (*dns.Msg, error) {
+	if configured {
+		switch cfg.UpstreamProtocol {
+		case "dot":
+			return dotConns.exchange(ctx, cfg, m, upstream)
+		case "tcp":
+			return tcpConns.exchange(ctx, cfg, m, upstream)
+		}
+	}
+	resp, _, err := udpClient.ExchangeContext(ctx, m, upstream)
+	return resp, err
+}
+
+// connPool keeps idle stream (TCP or DNS-over-TLS) connections so each query
+// doesn't pay for a new handshake. It is safe for concurrent use; a connection
+// is only ever used by one exchange at a time.
+type connPool struct {
+	client *dns.Client
+	dial   func(ctx context.Context, cfg *Config, upstream string) (*dns.Conn, error)
+
+	mu   sync.Mutex
+	idle map[string][]*dns.Conn
+}
+
+var (
+	tcpConns = &connPool{
+		client: &dns.Client{Net: "tcp", ReadTimeout: upstreamTimeout, WriteTimeout: upstreamTimeout},
+		dial:   dialTCP,
+		idle:   make(map[string][]*dns.Conn),
+	}
+	dotConns = &connPool{
+		client: &dns.Client{Net: "tcp-tls", ReadTimeout: upstreamTimeout, WriteTimeout: upstreamTimeout},
+		dial:   dialDoT,
+		idle:   make(map[string][]*dns.Conn),
+	}
+)
+
+// exchange sends m over a pooled connection. Servers close idle connections, so
+// a failure on a pooled connection is retried once on a fresh one, unless ctx
+// is already done.
+func (p *connPool) exchange(ctx context.Context, cfg *Config, m *dns.Msg, upstream string) (*dns.Msg, error) {
+	conn, pooled, err := p.get(ctx, cfg, upstream, false)
+	for {
+		if err != nil {
+			return nil, err
+		}
+		resp, _, xerr := p.client.ExchangeWithConnContext(ctx, m, conn)
+		if xerr == nil {
+			p.put(upstream, conn)
+			return resp, nil
+		}
+		conn.Close()
+		if !pooled || ctx.Err() != nil {
+			return nil, xerr
+		}
+		conn, pooled, err = p.get(ctx, cfg, upstream, true)
+	}
+}
+
+// get returns an idle connection for upstream, or dials one when none is idle or fresh is set
+func (p *connPool) get(ctx context.Context, cfg *Config, upstream string, fresh bool) (*dns.Conn, bool, error) {
+	if !fresh {
+		p.mu.Lock()
+		if conns := p.idle[upstream]; len(conns) > 0 {
+			conn := conns[len(conns)-1]
+			p.idle[upstream] = conns[:len(conns)-1]
+			p.mu.Unlock()
+			return conn, true, nil
+		}
+		p.mu.Unlock()
+	}
+	conn, err := p.dial(ctx, cfg, upstream)
+	return conn, false, err
+}
+
+// put returns a healthy connection to the pool, closing it if the pool is full
+func (p *connPool) put(upstream string, conn *dns.Conn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.idle[upstream]) >= connPoolMaxIdle {
+		conn.Close()
+		return
+	}
+	p.idle[upstream] = append(p.idle[upstream], conn)
+}
+
+// dialTCP opens a plain TCP connection to upstream
+func dialTCP(ctx context.Context, _ *Config, upstream string) (*dns.Conn, error) {
+	d := &net.Dialer{Timeout: upstreamTimeout}
+	conn, err := d.DialContext(ctx, "tcp", upstream)
+	if err != nil {
+		return nil, err
+	}
+	return &dns.Conn{Conn: conn}, nil
+}
+
+// dialDoT opens a TLS connection to upstream, verifying the certificate against
+// cfg.UpstreamTLSServerName for the default upstream or, when unset and
+// for fallbacks, the upstream's host (IP addresses are checked against the
+// certificate's IP SANs).
+func dialDoT(ctx context.Context, cfg *Config, upstream string) (*dns.Conn, error) {
+	serverName := ""
+	if upstream == defaultUpstream(cfg) {
+		serverName = cfg.UpstreamTLSServerName
+	}
+	if serverName == "" {
+		host, _, err := net.SplitHostPort(upstream)
+		if err != nil {
+			return nil, err
+		}
+		serverName = host
+	}
+	d := &tls.Dialer{
+		NetDialer: &net.Dialer{Timeout: upstreamTimeout},
+		Config:    &tls.Config{ServerName: serverName, MinVersion: tls.VersionTLS12},
+	}
+	ctx, cancel := context.WithTimeout(ctx, upstreamTimeout)
+	defer cancel()
+	conn, err := d.DialContext(ctx, "tcp", upstream)
+	if err != nil {
+		return nil, err
+	}
+	return &dns.Conn{Conn: conn}, nil
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// startFakeDoTUpstream serves fakeZone over TLS on a loopback port with a
+// certificate valid for 127.0.0.1 and example.com. It returns the address, a
+// pool trusting that certificate and a count of the connections accepted.
+func startFakeDoTUpstream(t *testing.T) (string, *x509.CertPool, func() int) {
+	t.Helper()
+	// borrow httptest's self-signed certificate
+	cert := httptest.NewTLSServer(nil)
+	cert.Close()
+	roots := x509.NewCertPool()
+	roots.AddCert(cert.Certificate())
+
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: cert.TLS.Certificates})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		mu      sync.Mutex
+		remotes = make(map[string]bool)
+	)
+	srv := &dns.Server{Listener: l, Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		mu.Lock()
+		remotes[w.RemoteAddr().String()] = true
+		mu.Unlock()
+		fakeZone(w, r)
+	})}
+	go srv.ActivateAndServe()
+	t.Cleanup(func() { srv.Shutdown() })
+	conns := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(remotes)
+	}
+	return l.Addr().String(), roots, conns
+}
+
+func TestConnPoolOverTLS(t *testing.T) {
+	upstream, roots, conns := startFakeDoTUpstream(t)
+	pool := &connPool{
+		client: dotConns.client,
+		dial: func(ctx context.Context, _ *Config, upstream string) (*dns.Conn, error) {
+			d := &tls.Dialer{Config: &tls.Config{ServerName: "example.com", RootCAs: roots}}
+			conn, err := d.DialContext(ctx, "tcp", upstream)
+			if err != nil {
+				return nil, err
+			}
+			return &dns.Conn{Conn: conn}, nil
+		},
+		idle: make(map[string][]*dns.Conn),
+	}
+	// each step runs against the pool the previous one left
+	steps := []struct {
+		name      string
+		before    func()
+		wantConns int
+	}{
+		{"first query dials", func() {}, 1},
+		{"second query reuses the connection", func() {}, 1},
+		{"stale pooled connection is replaced", func() {
+			for _, c := range pool.idle[upstream] {
+				c.Close()
+			}
+		}, 2},
+		{"replacement is pooled", func() {}, 2},
+	}
+	for _, st := range steps {
+		st.before()
+		m := new(dns.Msg)
+		m.SetQuestion("www.example.", dns.TypeA)
+		resp, err := pool.exchange(context.Background(), AppConfig(), m, upstream)
+		if err != nil {
+			t.Fatalf("%s: %v", st.name, err)
+		}
+		if len(resp.Answer) != 1 || resp.Id != m.Id {
+			t.Fatalf("%s: reply %v", st.name, resp)
+		}
+		if n := conns(); n != st.wantConns {
+			t.Errorf("%s: upstream saw %d connections, want %d", st.name, n, st.wantConns)
+		}
+	}
+}
+
+func TestDialDoTVerifiesCertificate(t *testing.T) {
+	upstream, _, _ := startFakeDoTUpstream(t)
+	tests := []struct {
+		name       string
+		serverName string
+	}{
+		{"upstream host", ""},
+		{"configured name", "example.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withConfig(t, func(c *Config) {
+				c.Upstream = upstream
+				c.UpstreamProtocol = "dot"
+				c.UpstreamTLSServerName = tt.serverName
+			})
+			// the stub's certificate isn't signed by a system root
+			conn, err := dialDoT(context.Background(), AppConfig(), upstream)
+			if err == nil {
+				conn.Close()
+				t.Fatal("dialDoT accepted an untrusted certificate")
+			}
+			var unknown x509.UnknownAuthorityError
+			if !errors.As(err, &unknown) {
+				t.Errorf("dialDoT = %v, want an unknown authority error", err)
+			}
+		})
+	}
+}
+
+func TestConnPoolOverTCP(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		remotes = make(map[string]bool)
+	)
+	upstream := startFakeUpstream(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		mu.Lock()
+		remotes[w.RemoteAddr().String()] = true
+		mu.Unlock()
+		// hold the reply so concurrent queries can't share a connection
+		time.Sleep(20 * time.Millisecond)
+		fakeZone(w, r)
+	})
+	conns := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(remotes)
+	}
+	pool := &connPool{client: tcpConns.client, dial: dialTCP, idle: make(map[string][]*dns.Conn)}
+	t.Cleanup(func() {
+		for _, c := range pool.idle[upstream] {
+			c.Close()
+		}
+	})
+	// each step runs against the pool the previous one left
+	const burst = 2 * connPoolMaxIdle
+	steps := []struct {
+		name       string
+		concurrent int
+		wantConns  int
+		wantIdle   int
+	}{
+		{"burst dials a connection per query", burst, burst, connPoolMaxIdle},
+		{"idle connections are reused", 1, burst, connPoolMaxIdle},
+		{"a second burst dials only past the idle ones", burst, burst + connPoolMaxIdle, connPoolMaxIdle},
+	}
+	for _, st := range steps {
+		var wg sync.WaitGroup
+		errs := make(chan error, st.concurrent)
+		for i := 0; i < st.concurrent; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				m := new(dns.Msg)
+				m.SetQuestion("www.example.", dns.TypeA)
+				resp, err := pool.exchange(context.Background(), AppConfig(), m, upstream)
+				if err == nil && (len(resp.Answer) != 1 || resp.Id != m.Id) {
+					err = fmt.Errorf("reply %v", resp)
+				}
+				errs <- err
+			}()
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			if err != nil {
+				t.Fatalf("%s: %v", st.name, err)
+			}
+		}
+		if n := conns(); n != st.wantConns {
+			t.Errorf("%s: upstream saw %d connections, want %d", st.name, n, st.wantConns)
+		}
+		if n := len(pool.idle[upstream]); n != st.wantIdle {
+			t.Errorf("%s: %d idle connections, want %d", st.name, n, st.wantIdle)
+		}
+	}
+}
+
+// The two benchmarks forward the same query over TCP, the first with a new
+// client and connection per query as before, the second through the shared
+// client and pooled connections. Compare them with -benchmem.
+func BenchmarkForwardPerQueryClient(b *testing.B) {
+	upstream := startFakeUpstream(b, fakeZone)
+	m := new(dns.Msg)
+	m.SetQuestion("a.example.", dns.TypeA)
+	b.ReportAllocs()
+	for b.Loop() {
+		c := &dns.Client{Net: "tcp", ReadTimeout: upstreamTimeout, WriteTimeout: upstreamTimeout}
+		if _, _, err := c.ExchangeContext(context.Background(), m, upstream); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkForwardSharedClient(b *testing.B) {
+	upstream := startFakeUpstream(b, fakeZone)
+	m := new(dns.Msg)
+	m.SetQuestion("a.example.", dns.TypeA)
+	b.ReportAllocs()
+	for b.Loop() {
+		if _, err := tcpConns.exchange(context.Background(), AppConfig(), m, upstream); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// An upstream is marked unhealthy after upstreamFailThreshold consecutive
+// failed exchanges and skipped for upstreamCooldown. After the cooldown a
+// single query is let through as a probe: success marks it healthy again,
+// failure restarts the cooldown.
+const (
+	upstreamFailThreshold = 3
+	upstreamCooldown      = 30 * time.Second
+)
+
+// upstreamBreaker is the health of one upstream address
+type upstreamBreaker struct {
+	consecutive int
+	openUntil   time.Time // zero while healthy
+	probing     bool      // a probe is in flight after the cooldown
+	successes   int64
+	failures    int64
+	lastError   string
+}
+
+// upstreamHealthTracker keeps a breaker per upstream address. It is safe for
+// concurrent use.
+type upstreamHealthTracker struct {
+	mu       sync.Mutex
+	breakers map[string]*upstreamBreaker
+	now      func() time.Time
+}
+
+var upstreamHealth = newUpstreamHealthTracker()
+
+func newUpstreamHealthTracker() *upstreamHealthTracker {
+	return &upstreamHealthTracker{breakers: make(map[string]*upstreamBreaker), now: time.Now}
+}
+
+// breaker returns the breaker for addr, creating it. Caller holds mu.
+func (t *upstreamHealthTracker) breaker(addr string) *upstreamBreaker {
+	b, ok := t.breakers[addr]
+	if !ok {
+		b = &upstreamBreaker{}
+		t.breakers[addr] = b
+	}
+	return b
+}
+
+// Allow reports whether a query may be sent to addr: it is healthy, or its
+// cooldown is over and no other probe is in flight
+func (t *upstreamHealthTracker) Allow(addr string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	b := t.breaker(addr)
+	if b.openUntil.IsZero() {
+		return true
+	}
+	if b.probing || t.now().Before(b.openUntil) {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+// Success records a completed exchange with addr, closing its breaker
+func (t *upstreamHealthTracker) Success(addr string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	b := t.breaker(addr)
+	b.successes++
+	b.consecutive = 0
+	b.openUntil = time.Time{}
+	b.probing = false
+}
+
+// Failure records a failed exchange with addr, opening its breaker once the
+// failures reach upstreamFailThreshold in a row
+func (t *upstreamHealthTracker) Failure(addr string, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	b := t.breaker(addr)
+	b.failures++
+	b.consecutive++
+	b.probing = false
+	b.lastError = err.Error()
+	if b.consecutive >= upstreamFailThreshold {
+		b.openUntil = t.now().Add(upstreamCooldown)
+	}
+}
+
+// record updates addr's health from the outcome of an exchange. Failures
+// caused by the caller giving up (not the upstream being slow) don't count.
+func (t *upstreamHealthTracker) record(ctx context.Context, addr string, err error) {
+	switch {
+	case err == nil:
+		t.Success(addr)
+	case errors.Is(ctx.Err(), context.Canceled):
+		// inconclusive; let the next query probe instead
+		t.mu.Lock()
+		t.breaker(addr).probing = false
+		t.mu.Unlock()
+	default:
+		t.Failure(addr, err)
+	}
+}
+
+// UpstreamHealth is one upstream's entry in /metrics
+type UpstreamHealth struct {
+	Address             string     `json:"address"`
+	Healthy             bool       `json:"healthy"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	Successes           int64      `json:"successes"`
+	Failures            int64      `json:"failures"`
+	LastError           string     `json:"last_error,omitempty"`
+	RetryAt             *time.Time `json:"retry_at,omitempty"` // when an unhealthy upstream is probed again
+}
+
+// Snapshot returns the health of every upstream used so far, by address
+func (t *upstreamHealthTracker) Snapshot() []UpstreamHealth {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	out := make([]UpstreamHealth, 0, len(t.breakers))
+	for addr, b := range t.breakers {
+		h := UpstreamHealth{
+			Address:             addr,
+			Healthy:             b.openUntil.IsZero(),
+			ConsecutiveFailures: b.consecutive,
+			Successes:           b.successes,
+			Failures:            b.failures,
+			LastError:           b.lastError,
+		}
+		if !h.Healthy {
+			retry := b.openUntil
+			h.RetryAt = &retry
+		}
+		out = append(out, h)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Address < out[j].Address })
+	return out
+}
+
+// upstreamCandidates returns the default upstream followed by the fallbacks
+func upstreamCandidates(cfg *Config) []string {
+	candidates := []string{defaultUpstream(cfg)}
+	for _, u := range cfg.FallbackUpstreams {
+		if u != "" && u != candidates[0] {
+			candidates = append(candidates, u)
+		}
+	}
+	return candidates
+}
+
+// exchangeWithFailover sends m to the first healthy default or fallback
+// upstream, moving on to the next when an exchange fails. Unhealthy upstreams
+// are skipped; when every one is unhealthy they are all tried in order anyway,
+// since failing every query outright is worse than a slow answer.
+func exchangeWithFailover(ctx context.Context, cfg *Config, m *dns.Msg) (*dns.Msg, error) {
+	candidates := upstreamCandidates(cfg)
+	var err error
+	tried := false
+	for _, u := range candidates {
+		// checked one at a time: Allow claims the probe of a recovering upstream
+		if !upstreamHealth.Allow(u) {
+			continue
+		}
+		tried = true
+		resp, xerr := exchangeTracked(ctx, cfg, m, u)
+		if xerr == nil || ctx.Err() != nil {
+			return resp, xerr
+		}
+		err = xerr
+	}
+	if tried {
+		return nil, err
+	}
+	for _, u := range candidates {
+		resp, xerr := exchangeTracked(ctx, cfg, m, u)
+		if xerr == nil || ctx.Err() != nil {
+			return resp, xerr
+		}
+		err = xerr
+	}
+	return nil, err
+}
+
+// exchangeTracked sends m to one configured upstream and records the outcome
+func exchangeTracked(ctx context.Context, cfg *Config, m *dns.Msg, upstream string) (*dns.Msg, error) {
+	resp, err := exchangeVia(ctx, cfg, m, upstream, true)
+	upstreamHealth.record(ctx, upstream, err)
+	return resp, err
+}
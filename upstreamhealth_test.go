@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestUpstreamBreaker(t *testing.T) {
+	const addr = "192.0.2.53:53"
+	now := time.Unix(1000, 0)
+	tr := newUpstreamHealthTracker()
+	tr.now = func() time.Time { return now }
+	fail := errors.New("i/o timeout")
+
+	// each step runs against the state the previous one left
+	steps := []struct {
+		name      string
+		advance   time.Duration
+		outcome   string // "fail", "ok" or "" for none
+		wantAllow bool
+		healthy   bool
+	}{
+		{"new upstream", 0, "", true, true},
+		{"one failure", 0, "fail", true, true},
+		{"two failures", 0, "fail", true, true},
+		{"third failure opens", 0, "fail", false, false},
+		{"still cooling down", upstreamCooldown - time.Second, "", false, false},
+		{"cooldown over: one probe", time.Second, "", true, false},
+		{"probe in flight", 0, "", false, false},
+		{"failed probe reopens", 0, "fail", false, false},
+		{"next probe", upstreamCooldown, "", true, false},
+		{"successful probe closes", 0, "ok", true, true},
+		{"failures counted afresh", 0, "fail", true, true},
+	}
+	for _, st := range steps {
+		now = now.Add(st.advance)
+		switch st.outcome {
+		case "fail":
+			tr.Failure(addr, fail)
+		case "ok":
+			tr.Success(addr)
+		}
+		if got := tr.Allow(addr); got != st.wantAllow {
+			t.Errorf("%s: Allow = %v, want %v", st.name, got, st.wantAllow)
+		}
+		snap := tr.Snapshot()
+		if len(snap) != 1 || snap[0].Healthy != st.healthy || (snap[0].RetryAt != nil) == st.healthy {
+			t.Errorf("%s: Snapshot = %+v, want healthy %v", st.name, snap, st.healthy)
+		}
+	}
+	if h := tr.Snapshot()[0]; h.Successes != 1 || h.Failures != 5 || h.ConsecutiveFailures != 1 || h.LastError != fail.Error() {
+		t.Errorf("counters %+v", h)
+	}
+}
+
+func TestUpstreamHealthIgnoresCancelledQueries(t *testing.T) {
+	const addr = "192.0.2.53:53"
+	tr := newUpstreamHealthTracker()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for i := 0; i < upstreamFailThreshold; i++ {
+		tr.record(ctx, addr, context.Canceled)
+	}
+	if h := tr.Snapshot()[0]; !h.Healthy || h.Failures != 0 {
+		t.Errorf("after cancelled queries: %+v, want healthy with no failures", h)
+	}
+	// a deadline is the upstream being slow, so it counts
+	ctx, cancel = context.WithTimeout(context.Background(), 0)
+	defer cancel()
+	tr.record(ctx, addr, context.DeadlineExceeded)
+	if h := tr.Snapshot()[0]; h.Failures != 1 {
+		t.Errorf("after a timed-out query: %+v, want one failure", h)
+	}
+}
+
+func TestExchangeFailsOverToFallbacks(t *testing.T) {
+	prev := upstreamHealth
+	upstreamHealth = newUpstreamHealthTracker()
+	t.Cleanup(func() { upstreamHealth = prev })
+
+	// nothing listens on dead once its listener is closed
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dead := l.Addr().String()
+	l.Close()
+	fallback := startFakeUpstream(t, fakeZone)
+	withConfig(t, func(c *Config) {
+		c.Upstream, c.UpstreamProtocol = dead, "tcp"
+		c.FallbackUpstreams = []string{dead, fallback} // a repeat of the default is ignored
+	})
+
+	health := func() (deadFailures int64, fallbackOK int64) {
+		for _, h := range upstreamHealth.Snapshot() {
+			switch h.Address {
+			case dead:
+				deadFailures = h.Failures
+			case fallback:
+				fallbackOK = h.Successes
+			}
+		}
+		return
+	}
+	for i := 1; i <= upstreamFailThreshold+2; i++ {
+		m := new(dns.Msg)
+		m.SetQuestion("a.example.", dns.TypeA)
+		resp, err := exchangeUpstream(context.Background(), AppConfig(), m, defaultUpstream(AppConfig()))
+		if err != nil || len(resp.Answer) != 1 {
+			t.Fatalf("query %d: %v, %v", i, resp, err)
+		}
+		// once open, the dead upstream is skipped rather than tried first
+		deadFailures, fallbackOK := health()
+		if want := int64(min(i, upstreamFailThreshold)); deadFailures != want || fallbackOK != int64(i) {
+			t.Errorf("query %d: dead upstream failed %d times, fallback answered %d; want %d, %d", i, deadFailures, fallbackOK, want, i)
+		}
+	}
+
+	// with every upstream unhealthy they are still tried
+	withConfig(t, func(c *Config) { c.Upstream, c.UpstreamProtocol = dead, "tcp" })
+	m := new(dns.Msg)
+	m.SetQuestion("a.example.", dns.TypeA)
+	if _, err := exchangeUpstream(context.Background(), AppConfig(), m, defaultUpstream(AppConfig())); err == nil {
+		t.Error("query with only a dead upstream succeeded")
+	}
+	if deadFailures, _ := health(); deadFailures != upstreamFailThreshold+1 {
+		t.Errorf("dead upstream failed %d times, want it tried despite being open", deadFailures)
+	}
+}
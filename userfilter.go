@@ -1,7 +1,7 @@
 package main
 
 import (
-	"log"
+	"log/slog"
 	"net"
 	"strings"
 	"sync"
@@ -22,7 +22,7 @@ func (c *IPToMACCache) SetIPMAC(ip, mac string) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	c.ipToMAC[ip] = mac
-	log.Printf("Cached IP %s -> MAC %s", ip, mac)
+	slog.Debug("cached IP to MAC", "ip", ip, "mac", mac)
 }
 
 // GetMAC retrieves the MAC address for an IP
@@ -33,49 +33,115 @@ func (c *IPToMACCache) GetMAC(ip string) (string, bool) {
 	return mac, ok
 }
 
-// IsBlockedForUser checks if a domain is blocked for a specific user
+// Snapshot returns a copy of every IP to MAC mapping
+func (c *IPToMACCache) Snapshot() map[string]string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	out := make(map[string]string, len(c.ipToMAC))
+	for ip, mac := range c.ipToMAC {
+		out[ip] = mac
+	}
+	return out
+}
+
+// IsBlockedForUser checks if a domain is blocked for a specific user. domain may
+// be a URL or host:port; only its host is matched.
 func (bm *BlocklistManager) IsBlockedForUser(domain, macAddress string, am *AccountManager) bool {
+	host, err := hostFromInput(domain)
+	if err != nil {
+		return false
+	}
+	_, ok := bm.MatchForUser(host, 0, macAddress, am)
+	return ok
+}
+
+// MatchForUser returns the user's list and pattern that match a query for domain
+// of type qtype (0 for any), if any. Global lists are checked after the user's
+// own. Nothing matches while the blocking kill-switch is off.
+func (bm *BlocklistManager) MatchForUser(domain string, qtype uint16, macAddress string, am *AccountManager) (MatchDetail, bool) {
+	if !blockingSwitch.Enabled() {
+		return MatchDetail{}, false
+	}
 	if macAddress == "" {
 		// No user identified, block nothing (or use default behavior)
-		return false
+		return MatchDetail{}, false
 	}
 
 	// Get user's blocklists
 	userLists, err := am.GetUserBlocklists(macAddress)
 	if err != nil {
-		log.Printf("Failed to get user blocklists for %s: %v", macAddress, err)
-		return false
-	}
-
-	if len(userLists) == 0 {
-		// User has no blocklists, nothing is blocked
-		return false
+		slog.Error("failed to get user blocklists", "mac", macAddress, "err", err)
+		return MatchDetail{}, false
 	}
 
 	// Check if domain matches any pattern in user's lists
-	d := strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".")
+	return bm.matchLists(domain, qtype, userLists)
+}
+
+// matchLists returns the first pattern matching a qtype query for domain in the
+// named lists, then in the global lists
+func (bm *BlocklistManager) matchLists(domain string, qtype uint16, lists []string) (MatchDetail, bool) {
+	d := canonicalDomain(strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), "."))
 	bm.mu.RLock()
 	defer bm.mu.RUnlock()
 
-	for _, listName := range userLists {
-		patterns, ok := bm.lists[listName]
-		if !ok {
-			continue
-		}
-
-		for _, pattern := range patterns {
-			if pattern == "" {
+	for _, names := range [][]string{lists, bm.global} {
+		for _, listName := range names {
+			lm, ok := bm.matchers[listName]
+			if !ok || !lm.appliesTo(qtype) {
 				continue
 			}
-			// Check if it matches (using simple string matching or compile on-the-fly)
-			re, err := patternToRegexp(pattern)
-			if err == nil && re != nil && re.MatchString(d) {
-				return true
+			if p, ok := lm.Match(d); ok {
+				return MatchDetail{List: listName, Pattern: p}, true
 			}
 		}
 	}
 
-	return false
+	return MatchDetail{}, false
+}
+
+// Values of AppConfig.UnidentifiedClients
+const (
+	unidentifiedAllLists     = "all-lists"
+	unidentifiedBlock        = "block"
+	unidentifiedAllow        = "allow"
+	unidentifiedDefaultLists = "use-default-user-list"
+)
+
+// unidentifiedClientList is reported as the matching list when the "block"
+// policy refuses a query from an unidentified client
+const unidentifiedClientList = "unidentified-client"
+
+// MatchUnidentified filters a qtype query for domain from a client whose MAC
+// (and so account) is unknown, following cfg.UnidentifiedClients.
+func (bm *BlocklistManager) MatchUnidentified(cfg *Config, domain string, qtype uint16) (MatchDetail, bool) {
+	if !blockingSwitch.Enabled() {
+		return MatchDetail{}, false
+	}
+	var lists []string
+	switch cfg.UnidentifiedClients {
+	case unidentifiedBlock:
+		return MatchDetail{List: unidentifiedClientList}, true
+	case unidentifiedAllow:
+	case unidentifiedDefaultLists:
+		lists = cfg.DefaultLists
+	default:
+		return bm.MatchType(domain, qtype)
+	}
+	return bm.matchLists(domain, qtype, lists)
+}
+
+// clientOwner maps a DNS client address to the identity its account uses: the
+// cached MAC, or the same ip: fallback GetClientMAC assigns when no MAC is known
+func clientOwner(client string) string {
+	ip := GetClientIP(client)
+	if net.ParseIP(ip) == nil {
+		return ""
+	}
+	if mac, ok := ipMACCache.GetMAC(ip); ok && mac != "" {
+		return mac
+	}
+	return "ip:" + ip
 }
 
 // GetClientIP extracts IP from address string
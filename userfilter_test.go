@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestMatchUnidentified(t *testing.T) {
+	bm := newTestBlocklistManager(t, map[string][]string{
+		"ads":       {"ads.example"},
+		"trackers":  {"tracker.example"},
+		"household": {"household.example"},
+	})
+	if err := bm.SetListMeta("household", ListMeta{Enabled: true, Global: true}); err != nil {
+		t.Fatal(err)
+	}
+	// the list each domain is blocked by under a policy; "" means allowed
+	tests := []struct {
+		policy string
+		want   map[string]string
+	}{
+		{"", map[string]string{"ads.example": "ads", "tracker.example": "trackers", "household.example": "household", "fine.example": ""}},
+		{unidentifiedAllLists, map[string]string{"ads.example": "ads", "tracker.example": "trackers", "household.example": "household", "fine.example": ""}},
+		{unidentifiedBlock, map[string]string{"ads.example": unidentifiedClientList, "fine.example": unidentifiedClientList}},
+		{unidentifiedAllow, map[string]string{"ads.example": "", "tracker.example": "", "household.example": "household", "fine.example": ""}},
+		{unidentifiedDefaultLists, map[string]string{"ads.example": "ads", "tracker.example": "", "household.example": "household", "fine.example": ""}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			withConfig(t, func(c *Config) {
+				c.UnidentifiedClients = tt.policy
+				c.DefaultLists = []string{"ads"}
+			})
+			for domain, want := range tt.want {
+				detail, blocked := bm.MatchUnidentified(AppConfig(), domain, dns.TypeA)
+				if blocked != (want != "") || detail.List != want {
+					t.Errorf("MatchUnidentified(%q) = %+v, %v; want list %q", domain, detail, blocked, want)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateUnidentifiedClients(t *testing.T) {
+	tests := []struct {
+		policy string
+		ok     bool
+	}{
+		{"", true},
+		{unidentifiedAllLists, true},
+		{unidentifiedBlock, true},
+		{unidentifiedAllow, true},
+		{unidentifiedDefaultLists, true},
+		{"deny", false},
+		{"Block", false},
+	}
+	for _, tt := range tests {
+		c := defaultConfig()
+		c.UnidentifiedClients = tt.policy
+		if err := c.Validate(); (err == nil) != tt.ok {
+			t.Errorf("Validate with unidentified_clients %q = %v, want ok %v", tt.policy, err, tt.ok)
+		}
+	}
+}
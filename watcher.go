@@ -0,0 +1,93 @@
+package main
+
+import (
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// blocklistWatchDebounce is how long the watcher waits after the last change
+// before reloading, so editors and bulk copies trigger a single reload.
+const blocklistWatchDebounce = 500 * time.Millisecond
+
+// isBlocklistFile reports whether a changed path affects the loaded lists
+func isBlocklistFile(name string) bool {
+	lower := strings.ToLower(name)
+	return strings.HasSuffix(lower, ".txt") || strings.HasSuffix(lower, ".meta.json")
+}
+
+// startBlocklistWatcher reloads bm when list files in its directory are created,
+// changed, removed or renamed. Changes already picked up by a reload (such as
+// API writes, which reload themselves) don't trigger another one.
+func startBlocklistWatcher(bm *BlocklistManager, debounce time.Duration) (func(), error) {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	if err := w.Add(bm.dir); err != nil {
+		w.Close()
+		return nil, err
+	}
+
+	var (
+		mu        sync.Mutex
+		timer     *time.Timer
+		lastEvent time.Time
+	)
+	reload := func() {
+		mu.Lock()
+		changedAt := lastEvent
+		mu.Unlock()
+		if !bm.LoadedAt().Before(changedAt) {
+			slog.Debug("blocklist watcher: changes already loaded")
+			return
+		}
+		if err := bm.LoadAll(); err != nil {
+			slog.Error("blocklist watcher: reload failed", "err", err)
+			return
+		}
+		slog.Info("blocklist watcher: reloaded lists after on-disk change")
+		go notifyRustReload()
+	}
+
+	go func() {
+		for {
+			select {
+			case ev, ok := <-w.Events:
+				if !ok {
+					return
+				}
+				if !isBlocklistFile(ev.Name) || ev.Op == fsnotify.Chmod {
+					continue
+				}
+				slog.Debug("blocklist watcher: change", "file", ev.Name, "op", ev.Op.String())
+				mu.Lock()
+				lastEvent = time.Now()
+				if timer == nil {
+					timer = time.AfterFunc(debounce, reload)
+				} else {
+					timer.Reset(debounce)
+				}
+				mu.Unlock()
+			case err, ok := <-w.Errors:
+				if !ok {
+					return
+				}
+				slog.Warn("blocklist watcher error", "err", err)
+			}
+		}
+	}()
+
+	stop := func() {
+		w.Close()
+		mu.Lock()
+		if timer != nil {
+			timer.Stop()
+		}
+		mu.Unlock()
+	}
+	return stop, nil
+}
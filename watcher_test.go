@@ -0,0 +1,98 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestIsBlocklistFile(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"/lists/ads.txt", true},
+		{"/lists/ADS.TXT", true},
+		{"/lists/ads.meta.json", true},
+		{"/lists/logs.jsonl", false},
+		{"/lists/.ads.txt.swp", false},
+		{"/lists/ads.txt~", false},
+		{"/lists/accounts.db", false},
+	}
+	for _, tt := range tests {
+		if got := isBlocklistFile(tt.name); got != tt.want {
+			t.Errorf("isBlocklistFile(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestBlocklistWatcherPicksUpChanges(t *testing.T) {
+	dir := t.TempDir()
+	bm, err := NewBlocklistManager(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop, err := startBlocklistWatcher(bm, 20*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+
+	ads := filepath.Join(dir, "ads.txt")
+	// each step runs against the state the previous one left
+	tests := []struct {
+		name    string
+		change  func() error
+		blocked map[string]bool
+	}{
+		{
+			name:    "create",
+			change:  func() error { return os.WriteFile(ads, []byte("ads.example\n"), 0o644) },
+			blocked: map[string]bool{"ads.example": true},
+		},
+		{
+			name: "edit",
+			change: func() error {
+				return os.WriteFile(ads, []byte("ads.example\ntrack.example\n"), 0o644)
+			},
+			blocked: map[string]bool{"ads.example": true, "track.example": true},
+		},
+		{
+			name:    "rename away from .txt",
+			change:  func() error { return os.Rename(ads, ads+".bak") },
+			blocked: map[string]bool{"ads.example": false, "track.example": false},
+		},
+		{
+			name:    "rename back",
+			change:  func() error { return os.Rename(ads+".bak", ads) },
+			blocked: map[string]bool{"ads.example": true, "track.example": true},
+		},
+		{
+			name:    "delete",
+			change:  func() error { return os.Remove(ads) },
+			blocked: map[string]bool{"ads.example": false, "track.example": false},
+		},
+	}
+	for _, tt := range tests {
+		if err := tt.change(); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			settled := true
+			for domain, want := range tt.blocked {
+				if bm.IsBlocked(domain) != want {
+					settled = false
+				}
+			}
+			if settled {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("%s: lists not reloaded, want blocked %v", tt.name, tt.blocked)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
@@ -6,9 +6,12 @@ const app = express()
 const PORT = process.env.PORT || 3000
 // where Go internal API is expected to run
 const GO_API = process.env.GO_API || 'http://127.0.0.1:8081'
-const AUTH_API = process.env.AUTH_API || 'http://127.0.0.1:8082'
+// auth routes are served by the same Go API; AUTH_API only needs setting for split deployments
+const AUTH_API = process.env.AUTH_API || GO_API
 
 app.use(express.json())
+// backup archives are uploaded as-is
+app.use('/restore', express.raw({ type: 'application/gzip', limit: '512mb' }))
 
 // Prevent caching in browsers: set strict no-cache headers on all responses.
 app.use((req, res, next) => {
@@ -30,7 +33,9 @@ async function proxyHandler(req, res, targetAPI = GO_API) {
     opts.headers = Object.assign({}, req.headers)
     delete opts.headers.host
     // If body parser produced a body, forward it. For non-JSON content types this may need extension.
-    if (req.body && Object.keys(req.body).length) {
+    if (Buffer.isBuffer(req.body)) {
+      opts.body = req.body
+    } else if (req.body && Object.keys(req.body).length) {
       try {
         opts.body = JSON.stringify(req.body)
         if (!opts.headers['content-type']) opts.headers['content-type'] = 'application/json'
@@ -55,7 +60,8 @@ async function proxyHandler(req, res, targetAPI = GO_API) {
     const buf = Buffer.from(arrayBuf)
     res.status(response.status).send(buf)
   } catch (err) {
-    res.status(500).send(err.message)
+    // same envelope the API uses for its own errors
+    res.status(502).json({ error: { code: 'bad_gateway', message: err.message } })
   }
 }
 
@@ -65,7 +71,7 @@ authRoutes.forEach(p => app.use(p, (req, res) => proxyHandler(req, res, AUTH_API
 
 // Proxy the routes used by the frontend directly so existing fetch calls
 // (e.g. fetch('/lists')) work without changing the frontend.
-const apiRoutes = ['/lists', '/lists/*', '/analytics', '/validate', '/reload', '/check', '/logs']
+const apiRoutes = ['/lists', '/lists/*', '/analytics', '/analytics/*', '/validate', '/reload', '/check', '/logs', '/logs/*', '/categories', '/categories/*', '/blocking/*', '/global/*', '/search', '/devices', '/devices/*', '/clients', '/records', '/records/*', '/metrics', '/backup', '/restore']
 apiRoutes.forEach(p => app.use(p, proxyHandler))
 
 // keep legacy /api prefix support
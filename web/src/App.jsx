@@ -1,5 +1,6 @@
 import React, { useEffect, useState } from 'react'
 import './styles.scss'
+import { errorText } from './apiError'
 // Removed react-bootstrap dependency; using lightweight custom CSS and native elements
 import { BsPlus, BsTrash, BsDownload, BsGear, BsList, BsBarChart, BsFileEarmarkText } from 'react-icons/bs'
 
@@ -14,7 +15,7 @@ function ListsPanel({ onNavigate }) {
     if (!name || !url) { window.alert('Please provide name and url'); return }
     try{
       const resp = await fetch('/lists/create', { method:'POST', headers:{'Content-Type':'application/json'}, body: JSON.stringify({ name, url }) })
-      if (!resp.ok) { const t = await resp.text(); throw new Error(t) }
+      if (!resp.ok) { const t = await errorText(resp); throw new Error(t) }
       const txt = await resp.text()
       window.alert(txt)
       setName(''); setUrl(''); refresh()
@@ -25,7 +26,7 @@ function ListsPanel({ onNavigate }) {
     try{
       // send items as a single string; server will split on commas/spaces/newlines
       const resp = await fetch('/lists/create', { method:'POST', headers:{'Content-Type':'application/json'}, body: JSON.stringify({ name, items: itemsText }) })
-      if (!resp.ok) { const t = await resp.text(); throw new Error(t) }
+      if (!resp.ok) { const t = await errorText(resp); throw new Error(t) }
       const txt = await resp.text()
       window.alert(txt)
       setName(''); setItemsText(''); refresh()
@@ -89,7 +90,7 @@ function ListDetail({ name, onClose, onRemoved }) {
       method: 'DELETE', headers: {'Content-Type':'application/json'}, body: JSON.stringify({ domain })
     }).then(r => {
       if (r.ok) { onRemoved && onRemoved(); fetchPage(); }
-      else r.text().then(t => window.alert('Error: '+t))
+      else errorText(r).then(t => window.alert('Error: '+t))
     }).catch(e => window.alert('Error: '+e))
   }
   const showingFrom = Math.min(total, offset+1)
@@ -104,7 +105,7 @@ function ListDetail({ name, onClose, onRemoved }) {
             if (!window.confirm(`Delete entire list ${name}? This will remove the file from disk.`)) return
             try{
               const r = await fetch(`/lists/${encodeURIComponent(name)}/delete`, { method: 'DELETE' })
-              if (!r.ok) { const t = await r.text(); throw new Error(t) }
+              if (!r.ok) { const t = await errorText(r); throw new Error(t) }
               window.alert('Deleted')
               onClose()
             }catch(e){ window.alert('Failed to delete list: '+e) }
@@ -151,7 +152,7 @@ function ListDetail({ name, onClose, onRemoved }) {
             const v = document.getElementById(`append-${name}`).value
             if (!v) { window.alert('Enter domains to append'); return }
             fetch(`/lists/${encodeURIComponent(name)}/append`, { method:'POST', headers:{'Content-Type':'application/json'}, body: JSON.stringify({ items: v }) })
-              .then(r=>{ if (r.ok) { window.alert('Appended'); fetchPage(); document.getElementById(`append-${name}`).value=''} else r.text().then(t=>window.alert('Error: '+t)) })
+              .then(r=>{ if (r.ok) { window.alert('Appended'); fetchPage(); document.getElementById(`append-${name}`).value=''} else errorText(r).then(t=>window.alert('Error: '+t)) })
               .catch(e=>window.alert('Error: '+e))
           }}>Append</button>
         </div>
@@ -288,7 +289,7 @@ function LogsPanel(){
     if (!window.confirm('Clear persistent logs and recent in-memory logs?')) return
     fetch('/logs', { method:'DELETE' }).then(r=>{
       if (r.ok) { refresh(); window.alert('Logs cleared') }
-      else r.text().then(t=>window.alert('Failed: '+t))
+      else errorText(r).then(t=>window.alert('Failed: '+t))
     }).catch(e=>window.alert('Error: '+e))
   }
 
@@ -512,7 +513,7 @@ function SettingsPanel(){
         setNewPasscode('')
         setConfirmNewPasscode('')
       } else {
-        const text = await resp.text()
+        const text = await errorText(resp)
         setChangePasscodeMsg('Error: ' + text)
       }
     } catch (e) {
@@ -1,4 +1,5 @@
 import React, { useState, useEffect } from 'react'
+import { errorText } from './apiError'
 
 // Utility to get the MAC address from the browser (simplified approach)
 // NOTE: This is a demonstration implementation with known limitations:
@@ -151,7 +152,7 @@ export default function AuthGuard({ children }) {
         setIsGuest(false)
         setShowSetup(false)
       } else {
-        const text = await resp.text()
+        const text = await errorText(resp)
         setError('Failed to create account: ' + text)
       }
     } catch (err) {
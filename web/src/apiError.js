@@ -0,0 +1,11 @@
+// errorText returns the message of an API error response. The API answers
+// errors with {"error":{"code":"...","message":"..."}}; anything else (e.g. a
+// proxy error page) is returned as-is.
+export async function errorText(resp) {
+  const text = await resp.text()
+  try {
+    const body = JSON.parse(text)
+    if (body && body.error && body.error.message) return body.error.message
+  } catch (e) {}
+  return text
+}